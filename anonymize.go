@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ipRe matches candidate IPv4 and IPv6 addresses, which are verified
+// with net.ParseIP before being replaced.
+var ipRe = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|[0-9a-fA-F]*:[0-9a-fA-F:]*:[0-9a-fA-F]*[0-9a-fA-F]`)
+
+// pseudonyms map hostnames and IP addresses to stable stand-ins, e.g.
+// web3.ams1.example.com to host-7, so that reports may be shared without
+// leaking infrastructure details. The mapping is kept locally, so the
+// same host gets the same pseudonym across reports.
+type pseudonyms struct {
+	Names map[string]string `json:"names"`
+	next  map[string]int
+}
+
+func pseudonymsPath() string {
+	return filepath.Join(filepath.Dir(runsDir()), "pseudonyms.json")
+}
+
+func loadPseudonyms(path string) (*pseudonyms, error) {
+	p := &pseudonyms{Names: make(map[string]string)}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read pseudonyms")
+	}
+	if err == nil {
+		if err := json.Unmarshal(bs, p); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse pseudonyms %s", path)
+		}
+	}
+
+	p.next = make(map[string]int)
+	for _, name := range p.Names {
+		var kind string
+		var n int
+		if _, err := fmt.Sscanf(strings.Replace(name, "-", " ", 1), "%s %d", &kind, &n); err == nil && n > p.next[kind] {
+			p.next[kind] = n
+		}
+	}
+	return p, nil
+}
+
+func (p *pseudonyms) save(path string) error {
+	bs, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode pseudonyms")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create pseudonyms directory")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, bs, 0600), "failed to write pseudonyms")
+}
+
+// name returns the pseudonym of real, e.g. host-3 or ip-12.
+func (p *pseudonyms) name(kind, real string) string {
+	if name, exists := p.Names[real]; exists {
+		return name
+	}
+	p.next[kind]++
+	name := fmt.Sprintf("%s-%d", kind, p.next[kind])
+	p.Names[real] = name
+	return name
+}
+
+// text replaces hosts (and their short names) and IP addresses in s.
+func (p *pseudonyms) text(s string, hosts *regexp.Regexp) string {
+	if hosts != nil {
+		s = hosts.ReplaceAllStringFunc(s, func(host string) string {
+			return p.name("host", host)
+		})
+	}
+	return ipRe.ReplaceAllStringFunc(s, func(ip string) string {
+		if net.ParseIP(ip) == nil {
+			return ip
+		}
+		return p.name("ip", ip)
+	})
+}
+
+// hostsRe matches any of hosts or their short names, longest first so
+// that e.g. web10 is not matched as web1.
+func hostsRe(hosts []string) *regexp.Regexp {
+	seen := make(map[string]bool)
+	var names []string
+	for _, host := range hosts {
+		for _, name := range []string{host, strings.SplitN(host, ".", 2)[0]} {
+			if !seen[name] && net.ParseIP(name) == nil {
+				seen[name] = true
+				names = append(names, regexp.QuoteMeta(name))
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	return regexp.MustCompile(`\b(?:` + strings.Join(names, "|") + `)\b`)
+}
+
+// report returns rpt with every hostname and IP address in it replaced.
+func (p *pseudonyms) report(rpt report) report {
+	var hosts []string
+	for _, res := range rpt.Results {
+		hosts = append(hosts, res.Host)
+	}
+	re := hostsRe(hosts)
+	text := func(s string) string { return p.text(s, re) }
+
+	results := make([]result, 0, len(rpt.Results))
+	for _, res := range rpt.Results {
+		res.Host = text(res.Host)
+		res.Command = text(res.Command)
+		res.Output = text(res.Output)
+		res.Error = text(res.Error)
+		res.Skipped = text(res.Skipped)
+
+		var failed []string
+		for _, f := range res.Failed {
+			failed = append(failed, text(f))
+		}
+		res.Failed = failed
+
+		var tracked []trackedFile
+		for _, t := range res.Tracked {
+			t.Diff = text(t.Diff)
+			t.Error = text(t.Error)
+			tracked = append(tracked, t)
+		}
+		res.Tracked = tracked
+
+		var md metadata
+		if res.Metadata != nil {
+			md = make(metadata, len(res.Metadata))
+			for key, value := range res.Metadata {
+				md[key] = text(value)
+			}
+		}
+		res.Metadata = md
+		results = append(results, res)
+	}
+	rpt.Results = results
+
+	variants := make([]variant, 0, len(rpt.Variants))
+	for _, v := range rpt.Variants {
+		v.Command = text(v.Command)
+		v.Output = text(v.Output)
+		var vhosts []string
+		for _, host := range v.Hosts {
+			vhosts = append(vhosts, text(host))
+		}
+		v.Hosts = vhosts
+		variants = append(variants, v)
+	}
+	if rpt.Variants != nil {
+		rpt.Variants = variants
+	}
+
+	reboots := make([]reboot, 0, len(rpt.Reboots))
+	for _, rb := range rpt.Reboots {
+		rb.Host = text(rb.Host)
+		reboots = append(reboots, rb)
+	}
+	if rpt.Reboots != nil {
+		rpt.Reboots = reboots
+	}
+
+	if rpt.Probe != nil {
+		probe := probePhase{Command: text(rpt.Probe.Command)}
+		for _, host := range rpt.Probe.Matched {
+			probe.Matched = append(probe.Matched, text(host))
+		}
+		for _, host := range rpt.Probe.Unmatched {
+			probe.Unmatched = append(probe.Unmatched, text(host))
+		}
+		for _, host := range rpt.Probe.Failed {
+			probe.Failed = append(probe.Failed, text(host))
+		}
+		rpt.Probe = &probe
+	}
+	return rpt
+}
+
+// anonymize replaces the hostnames and IP addresses of rpt with the
+// pseudonyms saved locally, adding any new ones.
+func anonymize(rpt report) (report, error) {
+	path := pseudonymsPath()
+	p, err := loadPseudonyms(path)
+	if err != nil {
+		return rpt, err
+	}
+	rpt = p.report(rpt)
+	return rpt, p.save(path)
+}
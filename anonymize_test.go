@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pseudonyms_report(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pseudonyms")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pseudonyms.json")
+
+	p, err := loadPseudonyms(path)
+	require.NoError(t, err)
+
+	rpt := p.report(report{Results: []result{
+		{Host: "web1.ams1.example.com", Command: "ping -c1 10.0.0.12", Output: "web1 reached 10.0.0.12 via fe80::1, web10 did not"},
+		{Host: "web10.ams1.example.com", Error: "dial tcp 10.0.0.13:22: i/o timeout", Metadata: metadata{"dc": "ams1"}},
+	}})
+	require.Equal(t, "host-1", rpt.Results[0].Host)
+	require.Equal(t, "ping -c1 ip-1", rpt.Results[0].Command)
+	require.Equal(t, "host-2 reached ip-1 via ip-2, host-3 did not", rpt.Results[0].Output)
+	require.Equal(t, "host-4", rpt.Results[1].Host)
+	require.Equal(t, "dial tcp ip-3:22: i/o timeout", rpt.Results[1].Error)
+	require.Equal(t, metadata{"dc": "ams1"}, rpt.Results[1].Metadata)
+	require.NoError(t, p.save(path))
+
+	// pseudonyms are stable across reports, and new ones do not collide
+	p, err = loadPseudonyms(path)
+	require.NoError(t, err)
+	rpt = p.report(report{Results: []result{
+		{Host: "web10.ams1.example.com"},
+		{Host: "db1.ams1.example.com"},
+	}})
+	require.Equal(t, "host-4", rpt.Results[0].Host)
+	require.Equal(t, "host-5", rpt.Results[1].Host)
+}
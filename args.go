@@ -3,56 +3,423 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// stringsFlag is a flag that may be set more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 type args struct {
-	user       string
-	hostList   string
-	scriptDir  string
-	command    string
-	pw         bool
-	noPassword bool
-	verbose    bool
+	user         string
+	userSet      bool
+	becomeUser   string
+	hostList     string
+	exclude      stringsFlag
+	selector     string
+	limit        string
+	shuffle      bool
+	orderBy      string
+	scriptDirs   stringsFlag
+	script       string
+	command      string
+	pw           bool
+	noPassword   bool
+	verbose      bool
+	env          stringsFlag
+	vars         stringsFlag
+	envFiles     stringsFlag
+	inventory    string
+	report       string
+	anonymize    bool
+	groupBy      string
+	diff         bool
+	timeout      time.Duration
+	keepalive    time.Duration
+	shell        string
+	shellHistory string
+	locale       string
+	output       string
+	hostColors   bool
+	theme        string
+	wide         bool
+	vaultPath    string
+
+	secretPlugin  string
+	key           string
+	cert          string
+	hostCA        string
+	otpCommand    string
+	askpass       string
+	passwordFrom  string
+	passwordCmd   string
+	proxy         string
+	sshConfig     string
+	noResolve     bool
+	resolveReport bool
+	controlPath   string
+	noCache       bool
+	cacheTTL      time.Duration
+	retry         string
+	minFree       stringsFlag
+	heavyLimits   stringsFlag
+	forwards      stringsFlag
+	forwardVia    string
+	maxSkew       time.Duration
+	skewAction    string
+
+	noShellWrapper bool
+	noPTY          bool
+	noFrame        bool
+	singleSession  bool
+	suFallback     bool
+	noSudoCheck    bool
+	awsRegion      string
+	awsAddress     string
+
+	consulPassing bool
+
+	webhooks         stringsFlag
+	webhookTemplates stringsFlag
+	notifiers        stringsFlag
+	sinks            stringsFlag
+	statsd           string
+	statsdTags       stringsFlag
+	silences         stringsFlag
+	silenceFor       time.Duration
+	silenceTarget    string
+	silenceLabel     string
+
+	flushInterval time.Duration
+	maxOutput     string
+	truncate      string
+	spillOutput   string
+	parallel      int
+	progress      bool
+	usage         bool
+
+	allowSunset    bool
+	maxHosts       int
+	maxDuration    time.Duration
+	overrideBudget bool
+
+	precheck          string
+	requireProbe      string
+	confirm           string
+	rebootReport      bool
+	rebootNow         bool
+	canary            string
+	batch             string
+	canaryAuto        bool
+	canaryMaxFailures float64
 }
 
-func arguments() args {
+// parseArguments parses the flags of a run, e.g. os.Args[1:].
+func parseArguments(arguments []string) args {
 	var args args
 
 	flag.StringVar(&args.user, "user", os.Getenv("USER"), "ssh username")
+	flag.StringVar(&args.becomeUser, "become-user", "", "run commands as this user with sudo, unless set per host with become= in the inventory")
 	flag.StringVar(&args.hostList, "hosts", "", "the list of hosts")
-	flag.StringVar(&args.scriptDir, "scripts", "", "the directory full of scripts")
+	flag.Var(&args.exclude, "exclude", "skip hosts matching these globs or host expressions, or listed in this file (may be repeated)")
+	flag.StringVar(&args.selector, "select", "", "run on only the hosts whose inventory labels match this selector, e.g. 'role=web,env!=prod,dc in (ams1,fra1)'")
+	flag.StringVar(&args.limit, "limit", "", "run on only this many hosts (or percent of hosts), e.g. 3 or 10%")
+	flag.BoolVar(&args.shuffle, "shuffle", false, "run on hosts in a random order")
+	flag.StringVar(&args.orderBy, "order-by", "", "run on hosts sorted by these keys, host or vars.NAME of their metadata (- for descending), e.g. vars.rack,vars.index")
+	flag.StringVar(&args.script, "script", "", "run this one script file, or the script file read from stdin if -")
+	flag.Var(&args.scriptDirs, "scripts", "the directory full of scripts (may be repeated, later directories override scripts of the same path)")
 	flag.StringVar(&args.command, "command", "", "the command to run")
 	flag.BoolVar(&args.pw, "pw", false, "send password on stdin after running --command")
 	flag.BoolVar(&args.noPassword, "no-password", false, "no-password skips password prompt")
 	flag.BoolVar(&args.verbose, "verbose", false, "verbose mode")
+	flag.StringVar(&args.inventory, "inventory", "", "file listing hosts and their metadata")
+	flag.StringVar(&args.report, "report", "", "write a report of all results to this file, as JUnit XML if it ends in .xml, Markdown if in .md, else JSON")
+	flag.BoolVar(&args.anonymize, "anonymize", false, "replace hostnames and IP addresses in the report with stable pseudonyms")
+	flag.StringVar(&args.groupBy, "group-by", "", "summarize successes and failures by this inventory label")
+	flag.BoolVar(&args.diff, "diff", false, "summarize by grouping hosts with identical output of each script, outliers last")
+	flag.BoolVar(&args.allowSunset, "allow-sunset", false, "run scripts past the date of their # sunset, which are otherwise refused")
+	flag.IntVar(&args.maxHosts, "max-hosts", 0, "refuse to run on more than this many hosts (default $"+maxHostsKey+" of env files or the environment, 0 for no cap)")
+	flag.DurationVar(&args.maxDuration, "max-duration", 0, "refuse to run if history estimates the run to take longer than this, and stop starting hosts once it has (default $"+maxDurationKey+", 0 for no cap)")
+	flag.BoolVar(&args.overrideBudget, "override-budget", false, "run even if the run exceeds --max-hosts or --max-duration")
+	flag.StringVar(&args.requireProbe, "require-probe", "", "run this command on every host first, and the scripts only on hosts where it exits 0")
+	flag.StringVar(&args.precheck, "precheck", "", "connect to every host before running anything, and if any is unreachable "+strings.Join(precheckActions, ", ")+" (default to not check)")
+	flag.StringVar(&args.confirm, "confirm", "", "ask before running steps on each host, one of all, dangerous (only steps marked # dangerous)")
+	flag.BoolVar(&args.rebootReport, "reboot-report", false, "check which hosts require a reboot once their scripts have run, and list them at the end")
+	flag.BoolVar(&args.rebootNow, "reboot-now", false, "once the run succeeds, reboot the hosts which require it, after confirmation (implies --reboot-report)")
+	flag.StringVar(&args.canary, "canary", "", "run on this many hosts (or percent of hosts) first, e.g. 3 or 5%")
+	flag.StringVar(&args.batch, "batch", "", "run on this many hosts (or percent of hosts) at a time, each batch after the last, e.g. 10 or 25%")
+	flag.BoolVar(&args.canaryAuto, "canary-auto", false, "continue after the canary without confirmation")
+	flag.Float64Var(&args.canaryMaxFailures, "canary-max-failures", 0, "fraction of canary hosts allowed to fail before aborting")
+	flag.DurationVar(&args.timeout, "timeout", 0, "terminate scripts which run longer than this (0 for no timeout)")
+	flag.DurationVar(&args.keepalive, "keepalive", 30*time.Second, "send keepalives this often, closing connections of servers which miss 3 in a row (0 to disable)")
+	flag.DurationVar(&args.flushInterval, "flush-interval", 0, "stream output of each host, flushed this often (0 prints output once a script completes)")
+	flag.StringVar(&args.maxOutput, "max-output", "", "keep at most this much output of each script, e.g. 1MB, truncated as --truncate says (default to keep all of it)")
+	flag.StringVar(&args.truncate, "truncate", truncateTail, "which output of scripts exceeding --max-output to keep, one of "+strings.Join(truncatePolicies, ", "))
+	flag.StringVar(&args.spillOutput, "spill-output", "", "write the full output of each script to a file in this directory, for scripts exceeding --max-output")
+	flag.IntVar(&args.parallel, "parallel", 1, "run on this many hosts at a time")
+	flag.BoolVar(&args.progress, "progress", false, "show a progress bar on stderr, and print the results of each host once it completes")
+	flag.BoolVar(&args.usage, "usage", false, "report max RSS, CPU and wall time of each script, measured with /usr/bin/time -v")
+	flag.StringVar(&args.shell, "shell", "sh", "shell of remote hosts, one of sh, powershell, cmd")
+	flag.StringVar(&args.shellHistory, "shell-history", historySpace, "keep commands out of the shell history of hosts, one of "+strings.Join(historyModes, ", ")+" (see README)")
+	flag.StringVar(&args.locale, "locale", "C", "set LC_ALL to this locale on sh hosts, so output parses the same everywhere (empty to keep the locale of each host)")
+	flag.BoolVar(&args.noFrame, "no-frame", false, "do not print a marker before each command, after which output is captured (so banners and MOTDs printed before it are dropped)")
+	flag.BoolVar(&args.noShellWrapper, "no-shell-wrapper", false, "run commands as is, without setting the environment or wrapping them for --shell")
+	flag.BoolVar(&args.noPTY, "no-pty", false, "never request a PTY, for hosts which reject them")
+	flag.BoolVar(&args.singleSession, "single-session", false, "open a new connection for every command, for hosts allowing one exec channel per connection")
+	flag.StringVar(&args.theme, "theme", "", "colors of output, one of dark, light, mono, or a theme file (default $COMMANDO_THEME, else ~/.commando/theme, else dark)")
+	flag.BoolVar(&args.wide, "wide", false, "do not truncate or wrap summaries to fit the terminal")
+	flag.BoolVar(&args.hostColors, "host-colors", false, "prefix each line of console output with its host, in a color of its own")
+	flag.StringVar(&args.output, "output", "console", "how to render output, one of console, quiet, json, gha, tui, junit, markdown, summary")
+	flag.StringVar(&args.vaultPath, "vault-path", "", "fetch ssh password and/or private key from this HashiCorp Vault path")
+	flag.StringVar(&args.secretPlugin, "secret-plugin", "", "fetch ssh password and/or private key from this plugin")
+	flag.StringVar(&args.awsRegion, "aws-region", "", "region of EC2 instances discovered by aws: hosts (default per aws cli config)")
+	flag.StringVar(&args.awsAddress, "aws-address", "private", "address of EC2 instances to target, one of private, public, private-dns, public-dns")
+	flag.BoolVar(&args.consulPassing, "consul-passing", false, "only target instances of consul: services which pass their health checks")
+	flag.BoolVar(&args.noSudoCheck, "no-sudo-check", false, "do not validate the sudo password on the first host before running on the rest")
+	flag.BoolVar(&args.suFallback, "su-fallback", false, "run scripts marked with sudo using su on hosts without sudo")
+	flag.StringVar(&args.key, "key", "", "private key to authenticate with")
+	flag.StringVar(&args.cert, "cert", "", "signed OpenSSH certificate of --key to authenticate with")
+	flag.StringVar(&args.hostCA, "host-ca", "", "file of certificate authorities which must have signed the certificates of hosts")
+	flag.StringVar(&args.proxy, "proxy", "", "connect to hosts through this socks5:// or http:// proxy (default $ALL_PROXY)")
+	flag.StringVar(&args.askpass, "askpass", os.Getenv("COMMANDO_ASKPASS"), "read passwords with this program (like ssh-askpass), or systemd for systemd-ask-password")
+	flag.StringVar(&args.passwordFrom, "password-from", "", "read the password from prompt, env:NAME, keychain[:SERVICE] (macOS Keychain or Secret Service) or command:COMMAND (default prompt)")
+	flag.StringVar(&args.passwordCmd, "password-command", "", "read the password from the first line printed by this command, e.g. 'pass show ssh/prod' (like --password-from command:...)")
+	flag.StringVar(&args.otpCommand, "otp-command", "", "command printing one-time passwords for hosts requiring keyboard-interactive auth")
+	flag.Var(&args.webhooks, "webhook", "post EVENT=URL, for events "+strings.Join(webhookEvents, ", ")+" (may be repeated)")
+	flag.Var(&args.webhookTemplates, "webhook-template", "render the payload of EVENT=FILE with a Go template, instead of as JSON (may be repeated)")
+	flag.Var(&args.notifiers, "notify", "send EVENT=PLUGIN to a notifier plugin (may be repeated)")
+	flag.Var(&args.sinks, "sink", "write every result to a jsonl:FILE, plugin:NAME, kafka:URL or nats:URL result sink as it is recorded (may be repeated)")
+	flag.Var(&args.minFree, "min-free", "skip hosts with less than SIZE free on PATH, given as PATH=SIZE, e.g. /var=2G (may be repeated)")
+	flag.Var(&args.heavyLimits, "heavy-limit", "run at most N steps marked # heavy at once across hosts of the inventory class=CLASS, given as CLASS=N, e.g. small=1 (may be repeated)")
+	flag.Var(&args.forwards, "forward", "forward [BIND:]PORT:HOST:HOSTPORT to HOST:HOSTPORT of the host running, or of --forward-via, for the duration of the run (may be repeated)")
+	flag.StringVar(&args.forwardVia, "forward-via", "", "forward the ports of --forward through this host, rather than each host (which requires --parallel 1)")
+	flag.DurationVar(&args.maxSkew, "max-skew", 0, "check the clock of each host is within this of ours before running scripts (0 to not check)")
+	flag.StringVar(&args.skewAction, "skew-action", "warn", "what to do with hosts whose clock is skewed by more than --max-skew, one of warn, fail")
+	flag.Var(&args.silences, "silence", "silence hosts in KIND=URL while running on them, for kinds alertmanager, nagios (may be repeated)")
+	flag.DurationVar(&args.silenceFor, "silence-for", time.Hour, "how long silences last, unless cleared once the run (or batch) completes")
+	flag.StringVar(&args.silenceTarget, "silence-target", "{{.host}}", "what identifies each host in monitoring, with its variables, e.g. {{.host}}:9100 or {{.nagios_name}}")
+	flag.StringVar(&args.silenceLabel, "silence-label", "instance", "alert label matched against --silence-target by alertmanager silences")
+	flag.Var(&args.env, "env", "set KEY=VALUE in the remote environment (may be repeated)")
+	flag.Var(&args.vars, "var", "set a script param or placeholder variable, as NAME=VALUE (may be repeated)")
+	flag.Var(&args.envFiles, "env-file", "load variables from a dotenv file, in addition to "+defaultEnvFile+" (may be repeated)")
+
+	flag.BoolVar(&args.noResolve, "no-resolve", false, "do not resolve hosts before the run, to warn about those which do not resolve and skip duplicates")
+	flag.BoolVar(&args.resolveReport, "resolve-report", false, "print the addresses (and their names) each host resolves to before the run")
+	flag.StringVar(&args.sshConfig, "ssh-config", "", "apply HostName, User, Port, IdentityFile and ProxyJump of hosts from this ssh_config (default ~/.ssh/config, none to ignore)")
+
+	flag.StringVar(&args.statsd, "statsd", "", "send metrics of the run to this dogstatsd://host:port or statsd://host:port")
+	flag.Var(&args.statsdTags, "statsd-tag", "tag every metric with this key:value, for dogstatsd (may be repeated)")
 
-	flag.Parse()
+	flag.StringVar(&args.retry, "retry", "", "retry policy, e.g. attempts=3,base=1s,multiplier=2,max=30s,jitter=0.2,on=connect+timeout+exit+assert")
+	flag.StringVar(&args.controlPath, "use-control-master", "", "reuse the connections of ssh ControlMasters listening at this ControlPath, e.g. ~/.ssh/cm-%r@%h:%p")
+	flag.BoolVar(&args.noCache, "no-cache", false, "resolve hosts and their addresses again, instead of using those cached by earlier runs")
+	flag.DurationVar(&args.cacheTTL, "cache-ttl", 10*time.Minute, "how long hosts discovered by providers (e.g. aws:, consul:) are cached")
+
+	_ = flag.CommandLine.Parse(arguments)
+	flag.Visit(func(f *flag.Flag) {
+		// ssh_config may set the user of hosts, unless --user is given
+		args.userSet = args.userSet || f.Name == "user"
+	})
 
 	return args
 }
 
 func validate(args args) error {
-	if args.hostList == "" {
-		return errors.Errorf("--hosts is required")
+	if args.hostList == "" && args.inventory == "" {
+		return errors.Errorf("--hosts or --inventory is required")
 	}
 
 	if args.user == "" {
 		return errors.Errorf("--user or $USER must be set")
 	}
 
-	if args.scriptDir == "" && args.command == "" {
-		return errors.Errorf("--scripts or --command is required")
+	sources := 0
+	for _, set := range []bool{len(args.scriptDirs) > 0, args.script != "", args.command != ""} {
+		if set {
+			sources++
+		}
 	}
-
-	if args.scriptDir != "" && args.command != "" {
-		return errors.Errorf("only one of --scripts or --command allowed")
+	if sources == 0 {
+		return errors.Errorf("--scripts, --script or --command is required")
+	}
+	if sources > 1 {
+		return errors.Errorf("only one of --scripts, --script or --command allowed")
 	}
 
 	if args.command == "" && args.pw {
 		return errors.Errorf("--pw only allowed in conjunction with --command")
 	}
 
+	if _, err := parseShell(args.shell); err != nil {
+		return errors.Wrap(err, "--shell is invalid")
+	}
+
+	if _, err := parseHistoryMode(args.shellHistory); err != nil {
+		return errors.Wrap(err, "--shell-history is invalid")
+	}
+
+	switch args.awsAddress {
+	case "private", "public", "private-dns", "public-dns":
+	default:
+		return errors.Errorf("--aws-address must be one of private, public, private-dns, public-dns")
+	}
+
+	if _, err := parseRetry(args.retry, defaultRetry); err != nil {
+		return errors.Wrap(err, "--retry is invalid")
+	}
+
+	if _, err := parseVars(args.vars); err != nil {
+		return err
+	}
+
+	if args.anonymize && args.report == "" {
+		return errors.Errorf("--anonymize requires --report")
+	}
+
+	if args.passwordFrom != "" && args.passwordCmd != "" {
+		return errors.Errorf("only one of --password-from or --password-command allowed")
+	}
+	if _, err := passwordFrom(args); err != nil {
+		return errors.Wrap(err, "--password-from is invalid")
+	}
+
+	if args.cert != "" && args.key == "" {
+		return errors.Errorf("--cert requires --key")
+	}
+
+	if args.maxOutput != "" {
+		if _, err := parseSize(args.maxOutput); err != nil {
+			return errors.Wrap(err, "--max-output is invalid")
+		}
+	}
+	if args.truncate != truncateHead && args.truncate != truncateTail {
+		return errors.Errorf("--truncate must be one of %s", strings.Join(truncatePolicies, ", "))
+	}
+	if args.spillOutput != "" && args.maxOutput == "" {
+		return errors.Errorf("--spill-output requires --max-output")
+	}
+	if args.noResolve && args.resolveReport {
+		return errors.Errorf("only one of --no-resolve or --resolve-report allowed")
+	}
+
+	if args.flushInterval < 0 {
+		return errors.Errorf("--flush-interval must not be negative")
+	}
+
+	switch args.precheck {
+	case "", precheckAbort, precheckSkip, precheckConfirm:
+	default:
+		return errors.Errorf("--precheck must be one of %s", strings.Join(precheckActions, ", "))
+	}
+
+	if args.confirm != "" {
+		if args.confirm != confirmAll && args.confirm != confirmDangerous {
+			return errors.Errorf("--confirm must be one of %s", strings.Join(confirmModes, ", "))
+		}
+		if !stdinIsTerminal() {
+			return errors.Errorf("--confirm requires a terminal to answer on")
+		}
+	}
+
+	if args.rebootNow && !stdinIsTerminal() {
+		return errors.Errorf("--reboot-now requires a terminal to confirm on")
+	}
+
+	if args.selector != "" {
+		if args.inventory == "" {
+			return errors.Errorf("--select requires --inventory")
+		}
+		if _, err := parseSelector(args.selector); err != nil {
+			return errors.Wrap(err, "--select is invalid")
+		}
+	}
+
+	if args.shuffle && args.orderBy != "" {
+		return errors.Errorf("only one of --shuffle or --order-by allowed")
+	}
+
+	if args.maxHosts < 0 || args.maxDuration < 0 {
+		return errors.Errorf("--max-hosts and --max-duration must not be negative")
+	}
+
+	if args.parallel < 1 {
+		return errors.Errorf("--parallel must be at least 1")
+	}
+
+	if args.canaryMaxFailures < 0 || args.canaryMaxFailures > 1 {
+		return errors.Errorf("--canary-max-failures must be between 0 and 1")
+	}
+
+	if args.batch != "" {
+		if _, err := hostCount(args.batch, 1); err != nil {
+			return errors.Wrap(err, "--batch is invalid")
+		}
+	}
+
+	for _, value := range args.minFree {
+		if _, err := parseSpaceCheck(value, "="); err != nil {
+			return errors.Wrap(err, "--min-free is invalid")
+		}
+	}
+
+	if _, err := parseHeavyLimits(args.heavyLimits); err != nil {
+		return errors.Wrap(err, "--heavy-limit is invalid")
+	}
+
+	for _, value := range args.forwards {
+		if _, err := parseForward(value); err != nil {
+			return errors.Wrap(err, "--forward is invalid")
+		}
+	}
+	if args.forwardVia != "" && len(args.forwards) == 0 {
+		return errors.Errorf("--forward-via requires --forward")
+	}
+	if len(args.forwards) > 0 && args.forwardVia == "" && args.parallel > 1 {
+		return errors.Errorf("--forward through each host requires --parallel 1, or --forward-via")
+	}
+
+	if args.skewAction != "warn" && args.skewAction != "fail" {
+		return errors.Errorf("--skew-action must be one of warn, fail")
+	}
+
+	for _, spec := range args.silences {
+		if _, err := newSilencer(spec, args.silenceLabel); err != nil {
+			return errors.Wrap(err, "--silence is invalid")
+		}
+	}
+
+	if args.vaultPath != "" && args.secretPlugin != "" {
+		return errors.Errorf("only one of --vault-path or --secret-plugin allowed")
+	}
+
+	for _, kv := range append(append(args.webhooks, args.webhookTemplates...), args.notifiers...) {
+		if _, _, err := splitEvent(kv); err != nil {
+			return errors.Wrap(err, "--webhook is invalid")
+		}
+	}
+
+	for _, spec := range args.sinks {
+		if _, _, err := parseSinkSpec(spec); err != nil {
+			return errors.Wrap(err, "--sink is invalid")
+		}
+	}
+
+	for _, kv := range args.env {
+		if _, _, err := splitEnv(kv); err != nil {
+			return errors.Wrap(err, "--env is invalid")
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var assertRe = regexp.MustCompile(`^\.([[:word:].-]+)\s*(<=|>=|==|!=|<|>)\s*(.+)$`)
+
+// An assertion is a threshold check on one field of the parsed output
+// of a script, declared in a script file like "# assert: .disk_used_pct < 90".
+type assertion struct {
+	field    string
+	operator string
+	expected string
+}
+
+func (a assertion) String() string {
+	return fmt.Sprintf(".%s %s %s", a.field, a.operator, a.expected)
+}
+
+func parseAssertion(s string) (assertion, error) {
+	matches := assertRe.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return assertion{}, errors.Errorf("malformed assertion %q", s)
+	}
+	return assertion{
+		field:    matches[1],
+		operator: matches[2],
+		expected: strings.Trim(strings.TrimSpace(matches[3]), `"`),
+	}, nil
+}
+
+// check evaluates the assertion against the parsed output of a script,
+// returning the actual value that was compared and whether the assertion held.
+func (a assertion) check(parsed map[string]string) (string, bool) {
+	actual, exists := parsed[a.field]
+	if !exists {
+		return "<missing>", false
+	}
+
+	x, xErr := number(actual)
+	y, yErr := number(a.expected)
+	if xErr == nil && yErr == nil {
+		return actual, compare(a.operator, x, y)
+	}
+
+	switch a.operator {
+	case "==":
+		return actual, actual == a.expected
+	case "!=":
+		return actual, actual != a.expected
+	}
+	// ordering of non-numeric values is meaningless
+	return actual, false
+}
+
+func number(s string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+}
+
+func compare(operator string, x, y float64) bool {
+	switch operator {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	case "==":
+		return x == y
+	case "!=":
+		return x != y
+	}
+	return false
+}
+
+// A failure records an assertion or expectation that did not hold on a host.
+type failure struct {
+	host   string
+	file   string
+	check  fmt.Stringer
+	actual string
+}
+
+func (f failure) String() string {
+	return fmt.Sprintf("%s: %s: %s (got %s)", f.host, f.file, f.check, f.actual)
+}
+
+// failures is the error returned by a script whose assertions did not hold.
+type failures []failure
+
+func (f failures) Error() string {
+	return fmt.Sprintf("%d assertion(s) failed", len(f))
+}
+
+func evaluate(host string, sc script, output string, code int) failures {
+	var failed failures
+
+	if len(sc.expectExit) > 0 && !sc.expectExit.check(code) {
+		failed = append(failed, failure{host: host, check: sc.expectExit, actual: strconv.Itoa(code)})
+	}
+
+	for _, e := range sc.expects {
+		if !e.check(output) {
+			failed = append(failed, failure{host: host, check: e, actual: "no match"})
+		}
+	}
+
+	if len(sc.asserts) == 0 {
+		return failed
+	}
+
+	parsed := fields(output)
+	for _, a := range sc.asserts {
+		if actual, ok := a.check(parsed); !ok {
+			failed = append(failed, failure{host: host, check: a, actual: actual})
+		}
+	}
+	return failed
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fields(t *testing.T) {
+	tests := []struct {
+		output string
+		exp    map[string]string
+	}{
+		{
+			output: "disk_used_pct=87\nload: 0.5\nnot a field",
+			exp:    map[string]string{"disk_used_pct": "87", "load": "0.5"},
+		},
+		{
+			output: `{"disk": {"used_pct": 93}, "mount": "/var"}`,
+			exp:    map[string]string{"disk.used_pct": "93", "mount": "/var"},
+		},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.exp, fields(test.output))
+	}
+}
+
+func Test_assertion(t *testing.T) {
+	parsed := map[string]string{
+		"disk_used_pct": "93%",
+		"state":         "active",
+	}
+
+	tests := []struct {
+		raw    string
+		actual string
+		ok     bool
+	}{
+		{raw: ".disk_used_pct < 90", actual: "93%", ok: false},
+		{raw: ".disk_used_pct >= 90", actual: "93%", ok: true},
+		{raw: `.state == "active"`, actual: "active", ok: true},
+		{raw: ".state != active", actual: "active", ok: false},
+		{raw: ".missing > 1", actual: "<missing>", ok: false},
+	}
+
+	for _, test := range tests {
+		a, err := parseAssertion(test.raw)
+		require.NoError(t, err)
+		actual, ok := a.check(parsed)
+		require.Equal(t, test.actual, actual, test.raw)
+		require.Equal(t, test.ok, ok, test.raw)
+	}
+
+	_, err := parseAssertion("disk_used_pct < 90")
+	require.Error(t, err)
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"net"
 	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 func sshAgentAuth() ssh.AuthMethod {
@@ -20,3 +27,103 @@ func PasswordCallback(user string) ssh.AuthMethod {
 		return easyPrompt(user)
 	})
 }
+
+// keyboardInteractive answers the challenges of hosts which require
+// keyboard-interactive authentication, such as bastions asking for a
+// one-time password. Password prompts are answered with the password if
+// known, and other prompts with the output of otpCommand if set, or are
+// relayed to the terminal.
+func keyboardInteractive(creds credentials) ssh.AuthMethod {
+	return ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		if instruction != "" {
+			colors.muted.println("  %s", instruction)
+		}
+
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			answer, err := creds.answer(user, question, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	})
+}
+
+func (creds credentials) answer(user, question string, echo bool) (string, error) {
+	isPassword := strings.Contains(strings.ToLower(question), "password")
+	switch {
+	case isPassword && creds.password != "":
+		return creds.password, nil
+	case isPassword:
+		return easyPrompt(user)
+	case creds.otpCommand != "":
+		return otp(creds.otpCommand)
+	}
+
+	colors.muted.println("  %s", question)
+	if !echo {
+		bs, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		return string(bs), errors.Wrap(err, "failed to read answer")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line), errors.Wrap(err, "failed to read answer")
+}
+
+// otp runs the --otp-command helper, which prints a one-time password.
+func otp(command string) (string, error) {
+	bs, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to run --otp-command")
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+// credentials used to authenticate with a host, in addition to the ssh agent.
+type credentials struct {
+	password   string
+	otpCommand string
+	signers    []ssh.Signer
+}
+
+// A secretSource provides the credentials of hosts, which may contain
+// a "password" and/or a "private_key".
+type secretSource interface {
+	fmt.Stringer
+	secret(host string) (map[string]string, error)
+}
+
+// credentials returns the credentials to use for host, which come from
+// vault if --vault-path is set, a plugin if --secret-plugin is set, or
+// are the password typed in.
+func (r *runner) credentials(host string) (credentials, error) {
+	r.lock.Lock()
+	signers := r.signers // which may be renewed by the daemon
+	r.lock.Unlock()
+
+	creds := credentials{password: r.pass, otpCommand: r.otpCommand, signers: signers}
+	if r.source == nil {
+		return creds, nil
+	}
+
+	secret, err := r.source.secret(host)
+	if err != nil {
+		return creds, errors.Wrapf(err, "failed to fetch credentials for %s from %s", host, r.source)
+	}
+
+	if password := secret["password"]; password != "" {
+		creds.password = password
+	}
+
+	if key := secret["private_key"]; key != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(key))
+		if err != nil {
+			return creds, errors.Wrapf(err, "failed to parse private key for %s from %s", host, r.source)
+		}
+		creds.signers = append([]ssh.Signer{signer}, creds.signers...)
+	}
+
+	return creds, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_credentials_answer(t *testing.T) {
+	creds := credentials{password: "hunter2", otpCommand: "echo ' 123456 '"}
+
+	answer, err := creds.answer("bob", "Password: ", false)
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", answer)
+
+	answer, err = creds.answer("bob", "Verification code: ", false)
+	require.NoError(t, err)
+	require.Equal(t, "123456", answer)
+
+	creds.otpCommand = "exit 1"
+	_, err = creds.answer("bob", "Verification code: ", false)
+	require.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// awsHosts discovers the running EC2 instances matching query, which is one
+// or more EC2 filters joined by '+', e.g. "tag:Role=web+instance-type=m5.large".
+//
+// The aws command line tool is used to query EC2, so that every means of
+// configuring AWS credentials it supports (profiles, SSO, etc.) works as usual.
+func awsHosts(args args, query string) ([]string, error) {
+	filters, err := awsFilters(query)
+	if err != nil {
+		return nil, err
+	}
+
+	cmdArgs := []string{"ec2", "describe-instances", "--output", "json", "--filters"}
+	cmdArgs = append(cmdArgs, filters...)
+	if args.awsRegion != "" {
+		cmdArgs = append(cmdArgs, "--region", args.awsRegion)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("aws", cmdArgs...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "aws ec2 describe-instances failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return awsAddresses(stdout.Bytes(), args.awsAddress)
+}
+
+// awsFilters converts query into arguments for --filters.
+func awsFilters(query string) ([]string, error) {
+	filters := []string{"Name=instance-state-name,Values=running"}
+	for _, filter := range strings.Split(query, "+") {
+		idx := strings.LastIndex(filter, "=")
+		if idx < 1 {
+			return nil, errors.Errorf("malformed aws filter %q, expected name=value", filter)
+		}
+		name, value := filter[:idx], filter[idx+1:]
+		filters = append(filters, "Name="+name+",Values="+value)
+	}
+	return filters, nil
+}
+
+type ec2Instances struct {
+	Reservations []struct {
+		Instances []struct {
+			PrivateIPAddress string `json:"PrivateIpAddress"`
+			PublicIPAddress  string `json:"PublicIpAddress"`
+			PrivateDNSName   string `json:"PrivateDnsName"`
+			PublicDNSName    string `json:"PublicDnsName"`
+		} `json:"Instances"`
+	} `json:"Reservations"`
+}
+
+// awsAddresses extracts the addresses of kind (private, public, private-dns,
+// or public-dns) of the instances described by the output of describe-instances.
+func awsAddresses(output []byte, kind string) ([]string, error) {
+	var described ec2Instances
+	if err := json.Unmarshal(output, &described); err != nil {
+		return nil, errors.Wrap(err, "failed to decode aws ec2 describe-instances output")
+	}
+
+	var addresses []string
+	for _, reservation := range described.Reservations {
+		for _, instance := range reservation.Instances {
+			var address string
+			switch kind {
+			case "public":
+				address = instance.PublicIPAddress
+			case "private-dns":
+				address = instance.PrivateDNSName
+			case "public-dns":
+				address = instance.PublicDNSName
+			default:
+				address = instance.PrivateIPAddress
+			}
+			if address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses, nil
+}
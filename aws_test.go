@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const describeInstances = `{
+  "Reservations": [
+    {"Instances": [
+      {"PrivateIpAddress": "10.0.1.5", "PublicIpAddress": "54.1.2.3", "PrivateDnsName": "ip-10-0-1-5.ec2.internal"},
+      {"PrivateIpAddress": "10.0.1.6"}
+    ]}
+  ]
+}`
+
+func Test_awsAddresses(t *testing.T) {
+	private, err := awsAddresses([]byte(describeInstances), "private")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.1.5", "10.0.1.6"}, private)
+
+	public, err := awsAddresses([]byte(describeInstances), "public")
+	require.NoError(t, err)
+	require.Equal(t, []string{"54.1.2.3"}, public)
+}
+
+func Test_awsFilters(t *testing.T) {
+	filters, err := awsFilters("tag:Role=web+instance-type=m5.large")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"Name=instance-state-name,Values=running",
+		"Name=tag:Role,Values=web",
+		"Name=instance-type,Values=m5.large",
+	}, filters)
+
+	_, err = awsFilters("tag:Role")
+	require.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+)
+
+// frameMarker, followed by the run id, is printed before each command,
+// so that anything printed before it (e.g. login banners, MOTDs or
+// noisy shell profiles) can be told apart from the output of the command.
+const frameMarker = "commando-output-begins-"
+
+// framed returns command preceded by printing marker on a line of its own.
+func framed(command, marker string) string {
+	return "printf '%s\\n' " + quote(marker) + "; " + command
+}
+
+// unframe returns the output after marker, or all of it if the
+// marker was never printed (e.g. the command could not be started).
+func unframe(output, marker string) string {
+	i := strings.Index(output, marker)
+	if marker == "" || i < 0 {
+		return output
+	}
+	return strings.TrimLeft(output[i+len(marker):], "\r\n")
+}
+
+// A framer drops streamed output until the marker has been printed.
+type framer struct {
+	marker string
+	seen   bool
+}
+
+func (f *framer) filter(text string) string {
+	if f.seen || f.marker == "" {
+		return text
+	}
+	i := strings.Index(text, f.marker)
+	if i < 0 {
+		return ""
+	}
+	f.seen = true
+	return strings.TrimLeft(text[i+len(f.marker):], "\r\n")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_unframe(t *testing.T) {
+	marker := frameMarker + "run-1"
+	output := "Welcome to Ubuntu 18.04\n * Support: https://ubuntu.com/advantage\n" + marker + "\nactive\n"
+	require.Equal(t, "active\n", unframe(output, marker))
+	require.Equal(t, "sh: 1: foo: not found", unframe("sh: 1: foo: not found", marker))
+	require.Equal(t, "Welcome\nactive", unframe("Welcome\nactive", ""))
+	require.Equal(t, `printf '%s\n' 'commando-output-begins-run-1'; uptime`, framed("uptime", marker))
+
+	f := &framer{marker: marker}
+	require.Equal(t, "", f.filter("Welcome to Ubuntu 18.04"))
+	require.Equal(t, "active", f.filter(marker+"\nactive"))
+	require.Equal(t, "running", f.filter("running"))
+}
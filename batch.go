@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A batch is a chunk of the hosts of a run, which are run on before the
+// next chunk starts: the hosts of the canary, then the rest, each split
+// into chunks of --batch hosts (if set).
+type batch struct {
+	Number int      `json:"number"`
+	Hosts  hostList `json:"hosts"`
+}
+
+// hostList renders as its hosts separated by spaces in templates, e.g.
+// {{.Batch.Hosts}}, so it may be passed to a command as is.
+type hostList []string
+
+func (l hostList) String() string {
+	return strings.Join(l, " ")
+}
+
+// batches splits hosts into chunks of size hosts (or percent of hosts),
+// or returns them as a single chunk if size is empty.
+func batches(hosts []string, size string) ([][]string, error) {
+	if size == "" || len(hosts) == 0 {
+		return [][]string{hosts}, nil
+	}
+	n, err := hostCount(size, len(hosts))
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks [][]string
+	for len(hosts) > n {
+		chunks = append(chunks, hosts[:n])
+		hosts = hosts[n:]
+	}
+	return append(chunks, hosts), nil
+}
+
+// batched calls runFn with each batch of hosts in turn, silenced in
+// monitoring while it runs, stopping at the first batch which fails.
+func batched(args args, r *runner, hosts []string, runFn func([]string) error) error {
+	chunks, err := batches(hosts, args.batch)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		b := r.startBatch(chunk)
+		if args.batch != "" {
+			r.out.message("batch %d: %v", b.Number, chunk)
+		}
+		clear, err := r.silence(chunk)
+		if err != nil {
+			return errors.Wrap(err, "failed to silence hosts")
+		}
+		err = runFn(chunk)
+		clear()
+		if err != nil {
+			return err
+		}
+		if r.isCancelled() {
+			return errCancelled
+		}
+	}
+	return nil
+}
+
+// startBatch makes hosts the current batch, posting it to the
+// batch-started webhooks.
+func (r *runner) startBatch(hosts []string) batch {
+	r.lock.Lock()
+	r.batch = batch{Number: r.batch.Number + 1, Hosts: hosts}
+	b := r.batch
+	r.lock.Unlock()
+
+	if r.hooks != nil {
+		r.hooks.batch(b)
+	}
+	return b
+}
+
+// batchVars returns the variables of the current batch, Batch.Number
+// and Batch.Hosts (separated by spaces).
+func (r *runner) batchVars() map[string]string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.batch.Number == 0 {
+		return nil
+	}
+	return map[string]string{
+		"Batch.Number": strconv.Itoa(r.batch.Number),
+		"Batch.Hosts":  r.batch.Hosts.String(),
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_batches(t *testing.T) {
+	hosts := []string{"h1", "h2", "h3", "h4", "h5"}
+
+	chunks, err := batches(hosts, "")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{hosts}, chunks)
+
+	chunks, err = batches(hosts, "2")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"h1", "h2"}, {"h3", "h4"}, {"h5"}}, chunks)
+
+	chunks, err = batches(hosts, "50%")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"h1", "h2", "h3"}, {"h4", "h5"}}, chunks)
+
+	_, err = batches(hosts, "none")
+	require.Error(t, err)
+}
+
+func Test_batched(t *testing.T) {
+	r := &runner{out: &quiet{}}
+	var seen []string
+	err := batched(args{batch: "2"}, r, []string{"h1", "h2", "h3"}, func(hosts []string) error {
+		seen = append(seen, r.globalVars()["Batch.Hosts"])
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"h1 h2", "h3"}, seen)
+	require.Equal(t, "after {{.Batch.Number}}", expandVars("after {{.Batch.Number}}", nil))
+	require.Equal(t, "batch 2: h3", expandVars("batch {{ .Batch.Number }}: {{.Batch.Hosts}}", r.globalVars()))
+
+	tmpl := template.Must(template.New("silence").Parse(`{{.Batch.Hosts}}`))
+	var b bytes.Buffer
+	require.NoError(t, tmpl.Execute(&b, event{Batch: &r.batch}))
+	require.Equal(t, "h3", b.String())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// loginUser returns the user to connect to host as: that of the "user"
+// label in the inventory, else --user if given, else the User of its
+// ssh_config, else --user by default.
+func (r *runner) loginUser(host string, cfg sshHost) string {
+	if user := r.inventory.metadata(host)["user"]; user != "" {
+		return user
+	}
+	if cfg.user != "" && !r.userSet {
+		return cfg.user
+	}
+	return r.user
+}
+
+// becomeUser returns the user to run commands as on host, with sudo, as
+// set by the "become" label in the inventory, overriding --become-user.
+// Commands run as the login user if it is empty.
+func (r *runner) becomeUser(host string) string {
+	if user, exists := r.inventory.metadata(host)["become"]; exists {
+		return user
+	}
+	return r.becomeAs
+}
+
+// becomes returns whether commands run as another user with sudo on any
+// of hosts.
+func (r *runner) becomes(hosts []string) bool {
+	for _, host := range hosts {
+		if r.becomeUser(host) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// become returns command run as user with sudo on host, and the input
+// sudo reads before that of command: the password of host, unless sudo
+// does not require one there.
+func (r *runner) become(client *ssh.Client, host, user, command string) (string, string, error) {
+	facts, err := r.sudoFacts(client, host)
+	if err != nil {
+		return "", "", err
+	}
+
+	as := " -H -u " + quote(user) + " -- sh -c " + quote(command)
+	switch {
+	case facts["sudo"] == "missing":
+		return "", "", errors.Errorf("sudo is not installed on %s, to become %s", host, user)
+	case facts["nopasswd"] == "yes":
+		return "sudo -n" + as, "", nil
+	}
+	return "sudo -S -p ''" + as, r.password(host) + "\n", nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runner_loginUser(t *testing.T) {
+	inv, err := parseInventory("web1 user=ubuntu\nweb2 become=postgres\n")
+	require.NoError(t, err)
+	cfg := sshHost{user: "admin"}
+
+	r := &runner{user: "alice", inventory: inv}
+	require.Equal(t, "ubuntu", r.loginUser("web1", cfg))
+	require.Equal(t, "admin", r.loginUser("web2", cfg))
+	require.Equal(t, "alice", r.loginUser("web2", sshHost{}))
+
+	r.userSet = true
+	require.Equal(t, "ubuntu", r.loginUser("web1", cfg), "inventory overrides --user")
+	require.Equal(t, "alice", r.loginUser("web2", cfg))
+}
+
+func Test_runner_becomeUser(t *testing.T) {
+	inv, err := parseInventory("web1\nweb2 become=postgres\nweb3 become=\n")
+	require.NoError(t, err)
+
+	r := &runner{inventory: inv}
+	require.Equal(t, "", r.becomeUser("web1"))
+	require.Equal(t, "postgres", r.becomeUser("web2"))
+
+	r.becomeAs = "deploy"
+	require.Equal(t, "deploy", r.becomeUser("web1"))
+	require.Equal(t, "postgres", r.becomeUser("web2"))
+	require.Equal(t, "", r.becomeUser("web3"), "become= logs in as is")
+}
+
+func Test_runner_becomes(t *testing.T) {
+	inv, err := parseInventory("web1\nweb2 become=postgres\nweb3 become=\n")
+	require.NoError(t, err)
+
+	// the sudo password is checked before running as another user anywhere
+	r := &runner{inventory: inv}
+	require.False(t, r.becomes([]string{"web1", "web3"}))
+	require.True(t, r.becomes([]string{"web1", "web2"}))
+
+	r.becomeAs = "deploy"
+	require.True(t, r.becomes([]string{"web1", "web3"}))
+	require.False(t, r.becomes([]string{"web3"}))
+}
+
+func Test_runner_become(t *testing.T) {
+	r := &runner{
+		passwords: map[string]string{"web1": "hunter2", "web2": "hunter2", "web3": "hunter2"},
+		sudo: map[string]map[string]string{
+			"web1": {"sudo": "present", "nopasswd": "no"},
+			"web2": {"sudo": "present", "nopasswd": "yes"},
+			"web3": {"sudo": "missing"},
+		},
+	}
+
+	command, stdin, err := r.become(nil, "web1", "postgres", "psql -c 'select 1'")
+	require.NoError(t, err)
+	require.Equal(t, `sudo -S -p '' -H -u 'postgres' -- sh -c 'psql -c '\''select 1'\'''`, command)
+	require.Equal(t, "hunter2\n", stdin)
+
+	command, stdin, err = r.become(nil, "web2", "postgres", "whoami")
+	require.NoError(t, err)
+	require.Equal(t, `sudo -n -H -u 'postgres' -- sh -c 'whoami'`, command)
+	require.Equal(t, "", stdin)
+
+	_, _, err = r.become(nil, "web3", "postgres", "whoami")
+	require.EqualError(t, err, "sudo is not installed on web3, to become postgres")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Keys of env files (or the environment) which set --max-hosts and
+// --max-duration, so that each environment may have caps of its own.
+const (
+	maxHostsKey    = "COMMANDO_MAX_HOSTS"
+	maxDurationKey = "COMMANDO_MAX_DURATION"
+)
+
+// A budget caps how many hosts a run may target, and how long it may take,
+// so that a mistyped group does not start a fleet-wide run. Zero is no cap.
+type budget struct {
+	hosts    int
+	duration time.Duration
+}
+
+// loadBudget returns the caps of --max-hosts and --max-duration, which
+// default to those set in env files, else in the environment.
+func loadBudget(args args, dot dotenv) (budget, error) {
+	b := budget{hosts: args.maxHosts, duration: args.maxDuration}
+	setting := func(key string) string {
+		if value, exists := dot.budget[key]; exists {
+			return value
+		}
+		return os.Getenv(key)
+	}
+
+	if value := setting(maxHostsKey); b.hosts == 0 && value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return b, errors.Errorf("%s must be a number of hosts, got %q", maxHostsKey, value)
+		}
+		b.hosts = n
+	}
+	if value := setting(maxDurationKey); b.duration == 0 && value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return b, errors.Errorf("%s must be a duration, got %q", maxDurationKey, value)
+		}
+		b.duration = d
+	}
+	return b, nil
+}
+
+// check returns why a run of items on hosts, parallel at a time, exceeds
+// the budget, if it does. Its duration is estimated from history.
+func (b budget) check(entries []historyEntry, kind string, items []string, hosts, parallel int) error {
+	if b.hosts > 0 && hosts > b.hosts {
+		return errors.Errorf("run targets %d hosts, more than --max-hosts %d", hosts, b.hosts)
+	}
+	if b.duration > 0 {
+		if d := estimate(entries, kind, items, hosts, parallel); d > b.duration {
+			return errors.Errorf("run is estimated to take %s from history, longer than --max-duration %s", d, b.duration)
+		}
+	}
+	return nil
+}
+
+// estimate returns how long a run of items on hosts, parallel at a time, is
+// expected to take, from the hosts of the last completed run of the same
+// items, or 0 if none is in history.
+func estimate(entries []historyEntry, kind string, items []string, hosts, parallel int) time.Duration {
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Kind != kind || e.Status != "completed" || !reflect.DeepEqual(e.Items, items) {
+			continue
+		}
+
+		seconds := make(map[string]float64)
+		for _, res := range e.Results {
+			seconds[res.Host] += res.Seconds
+		}
+		if len(seconds) == 0 {
+			continue
+		}
+		var total float64
+		for _, s := range seconds {
+			total += s
+		}
+
+		rounds := (hosts + parallel - 1) / parallel
+		perHost := total / float64(len(seconds))
+		return time.Duration(perHost * float64(rounds) * float64(time.Second)).Round(time.Second)
+	}
+	return 0
+}
+
+// enforce stops the run from starting on any more hosts once it has run
+// for longer than the budget allows, returning a func which stops enforcing.
+func (r *runner) enforce(b budget) func() {
+	if b.duration == 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(b.duration, func() {
+		r.out.warning("run %s exceeded --max-duration %s", r.id, b.duration)
+		r.cancel(false)
+	})
+	return func() { timer.Stop() }
+}
+
+// withinBudget exits unless the run of items on hosts is within budget,
+// returning the budget to enforce while it runs, none with --override-budget.
+func withinBudget(args args, b budget, kind string, items, hosts []string) budget {
+	entries, err := readHistory(historyPath())
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "not estimating the duration of the run: %v\n", err)
+	}
+	err = b.check(entries, kind, items, len(hosts), args.parallel)
+	switch {
+	case args.overrideBudget && err != nil:
+		_, _ = fmt.Fprintf(os.Stderr, "overriding budget: %v\n", err)
+		return budget{}
+	case args.overrideBudget:
+		return budget{}
+	case err != nil:
+		dief("refusing to run: %v (use --override-budget to run anyway)", err)
+	}
+	return b
+}
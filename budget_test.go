@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_estimate(t *testing.T) {
+	entries := []historyEntry{
+		{Kind: "scripts", Items: []string{"upgrade"}, Status: "completed", Results: []hostOutcome{
+			{Host: "web1", Seconds: 10}, {Host: "web1", Seconds: 20}, {Host: "web2", Seconds: 10},
+		}},
+		{Kind: "scripts", Items: []string{"upgrade"}, Status: "failed", Results: []hostOutcome{
+			{Host: "web1", Seconds: 1000},
+		}},
+		{Kind: "command", Items: []string{"uptime"}, Status: "completed", Results: []hostOutcome{
+			{Host: "web1", Seconds: 1},
+		}},
+	}
+
+	// (30s + 10s) / 2 hosts, for 3 rounds of 4 hosts
+	require.Equal(t, time.Minute, estimate(entries, "scripts", []string{"upgrade"}, 10, 4))
+	require.Equal(t, 100*time.Second, estimate(entries, "command", []string{"uptime"}, 100, 1))
+	require.Zero(t, estimate(entries, "scripts", []string{"deploy"}, 10, 1))
+}
+
+func Test_budget_check(t *testing.T) {
+	entries := []historyEntry{
+		{Kind: "scripts", Items: []string{"upgrade"}, Status: "completed", Results: []hostOutcome{{Host: "web1", Seconds: 60}}},
+	}
+
+	b := budget{hosts: 50, duration: 10 * time.Minute}
+	require.NoError(t, b.check(entries, "scripts", []string{"upgrade"}, 10, 1))
+	require.EqualError(t, b.check(entries, "scripts", []string{"upgrade"}, 51, 10), "run targets 51 hosts, more than --max-hosts 50")
+	require.EqualError(t, b.check(entries, "scripts", []string{"upgrade"}, 20, 1), "run is estimated to take 20m0s from history, longer than --max-duration 10m0s")
+	require.NoError(t, b.check(entries, "scripts", []string{"deploy"}, 20, 1))
+	require.NoError(t, budget{}.check(entries, "scripts", []string{"upgrade"}, 1000, 1))
+}
+
+func Test_loadBudget(t *testing.T) {
+	dot := dotenv{budget: map[string]string{maxHostsKey: "20", maxDurationKey: "1h"}}
+
+	b, err := loadBudget(args{}, dot)
+	require.NoError(t, err)
+	require.Equal(t, budget{hosts: 20, duration: time.Hour}, b)
+
+	b, err = loadBudget(args{maxHosts: 200}, dot)
+	require.NoError(t, err)
+	require.Equal(t, budget{hosts: 200, duration: time.Hour}, b)
+
+	_, err = loadBudget(args{}, dotenv{budget: map[string]string{maxHostsKey: "many"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// hostCache is what was learned connecting to a host, cached in the
+// state file so that later runs need not resolve it again.
+type hostCache struct {
+	Target  string    `json:"target"`             // host:port as configured
+	Address string    `json:"address"`            // ip:port it resolved to
+	Proxy   string    `json:"proxy,omitempty"`    // proxy or jump hosts connected through
+	HostKey string    `json:"host_key,omitempty"` // in authorized_keys format
+	Updated time.Time `json:"updated"`
+}
+
+// discovery is a cached result of a host provider.
+type discovery struct {
+	Hosts   []string  `json:"hosts"`
+	Updated time.Time `json:"updated"`
+}
+
+// updateState applies fn to the state file at path. The file is read
+// again first, so that changes made by other commando processes since
+// this one started are kept.
+func updateState(path string, fn func(st *state)) error {
+	st, err := loadState(path)
+	if err != nil {
+		return err
+	}
+	fn(&st)
+	return writeState(path, st)
+}
+
+// cachedDiscover discovers hosts with a provider, unless the same host
+// expression was discovered within --cache-ttl.
+func cachedDiscover(args args, expression string, discover func() ([]string, error)) ([]string, error) {
+	if args.noCache || args.cacheTTL <= 0 {
+		return discover()
+	}
+
+	path := statePath()
+	if st, err := loadState(path); err == nil {
+		if d, exists := st.Discovered[expression]; exists && time.Since(d.Updated) < args.cacheTTL {
+			return d.Hosts, nil
+		}
+	}
+
+	hosts, err := discover()
+	if err != nil {
+		return nil, err
+	}
+
+	// caching is best effort, the hosts are discovered again next time
+	_ = updateState(path, func(st *state) {
+		if st.Discovered == nil {
+			st.Discovered = make(map[string]discovery)
+		}
+		st.Discovered[expression] = discovery{Hosts: hosts, Updated: time.Now().UTC()}
+	})
+	return hosts, nil
+}
+
+func (r *runner) cached(host string) (hostCache, bool) {
+	if !r.cache {
+		return hostCache{}, false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	c, exists := r.state.Hosts[host]
+	return c, exists
+}
+
+func (r *runner) updateCache(host string, fn func(c *hostCache)) {
+	if !r.cache {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.state.Hosts == nil {
+		r.state.Hosts = make(map[string]hostCache)
+	}
+	c := r.state.Hosts[host]
+	fn(&c)
+	c.Updated = time.Now().UTC()
+	r.state.Hosts[host] = c
+	if r.cacheUpdated == nil {
+		r.cacheUpdated = make(map[string]bool)
+	}
+	r.cacheUpdated[host] = true
+}
+
+// cachedDial returns dial, connecting to the address host resolved to
+// on an earlier run instead of resolving it again, unless connecting
+// through proxy. If the cached address cannot be connected to, it is
+// resolved again.
+func (r *runner) cachedDial(host, proxy string, dial dialer) dialer {
+	return func(network, address string) (net.Conn, error) {
+		if c, ok := r.cached(host); ok && proxy == "" && c.Proxy == "" && c.Target == address && c.Address != "" {
+			if conn, err := dial(network, c.Address); err == nil {
+				return conn, nil
+			}
+		}
+
+		conn, err := dial(network, address)
+		if err == nil {
+			r.updateCache(host, func(c *hostCache) {
+				c.Target, c.Proxy, c.Address = address, proxy, ""
+				if proxy == "" {
+					c.Address = conn.RemoteAddr().String()
+				}
+			})
+		}
+		return conn, err
+	}
+}
+
+// cachedHostKeys returns check, caching the host key of host once
+// checked, and rejecting keys which differ from the one cached before,
+// as the host may be impersonated. Of host certificates (of --host-ca),
+// the key certified is cached, as certificates are renewed. Hosts whose key
+// changed for a reason are forgotten with "commando forget", or connected
+// to with --no-cache.
+func (r *runner) cachedHostKeys(host string, check ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := check(hostname, remote, key); err != nil {
+			return err
+		}
+
+		pinned := key
+		if cert, ok := key.(*ssh.Certificate); ok {
+			pinned = cert.Key
+		}
+		line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pinned)))
+		if c, ok := r.cached(host); ok && c.HostKey != "" && c.HostKey != line {
+			return errors.Errorf("host key of %s changed since it was cached (if expected, run commando forget %s, or use --no-cache)", host, host)
+		}
+		r.updateCache(host, func(c *hostCache) { c.HostKey = line })
+		return nil
+	}
+}
+
+const forgetUsage = "usage: commando forget <host>..."
+
+// forgetCmd implements "commando forget", which drops what was cached
+// connecting to hosts, e.g. once they were reinstalled with new host keys.
+func forgetCmd(arguments []string) error {
+	if len(arguments) == 0 {
+		return errors.New(forgetUsage)
+	}
+
+	var unknown []string
+	err := updateState(statePath(), func(st *state) {
+		unknown = st.forget(arguments)
+	})
+	if err != nil {
+		return err
+	}
+	if len(unknown) > 0 {
+		return errors.Errorf("no connection cached for %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// forget drops the connections cached for hosts, returning those of hosts
+// of which none was cached.
+func (st *state) forget(hosts []string) []string {
+	var unknown []string
+	for _, host := range hosts {
+		if _, exists := st.Hosts[host]; !exists {
+			unknown = append(unknown, host)
+			continue
+		}
+		delete(st.Hosts, host)
+	}
+	return unknown
+}
+
+// saveCache writes what was learned connecting to hosts to the state file.
+func (r *runner) saveCache() {
+	r.lock.Lock()
+	hosts := make(map[string]hostCache)
+	for host := range r.cacheUpdated {
+		hosts[host] = r.state.Hosts[host]
+	}
+	r.lock.Unlock()
+	if len(hosts) == 0 {
+		return
+	}
+
+	err := updateState(statePath(), func(st *state) {
+		if st.Hosts == nil {
+			st.Hosts = make(map[string]hostCache)
+		}
+		for host, c := range hosts {
+			st.Hosts[host] = c
+		}
+	})
+	if err != nil {
+		r.out.warning("failed to cache connections: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_runner_cachedDial(t *testing.T) {
+	var dialed []string
+	dial := func(network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	}
+
+	r := &runner{cache: true}
+	r.state.Hosts = map[string]hostCache{
+		"web1": {Target: "web1:22", Address: "10.0.0.1:22"},
+		"web2": {Target: "web2:22", Address: "10.0.0.2:22", Proxy: "socks5://proxy:1080"},
+	}
+
+	_, err := r.cachedDial("web1", "", dial)("tcp", "web1:22")
+	require.NoError(t, err)
+	_, err = r.cachedDial("web2", "", dial)("tcp", "web2:22")
+	require.NoError(t, err)
+	_, err = r.cachedDial("web3", "socks5://proxy:1080", dial)("tcp", "web3:22")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1:22", "web2:22", "web3:22"}, dialed)
+
+	require.Equal(t, "socks5://proxy:1080", r.state.Hosts["web3"].Proxy)
+	require.Equal(t, "", r.state.Hosts["web3"].Address, "addresses are resolved by the proxy")
+	require.True(t, r.cacheUpdated["web2"])
+
+	r = &runner{}
+	_, err = r.cachedDial("web1", "", dial)("tcp", "web1:22")
+	require.NoError(t, err)
+	require.Empty(t, r.state.Hosts, "--no-cache caches nothing")
+}
+
+// newHostKey returns a new ed25519 host key.
+func newHostKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(public)
+	require.NoError(t, err)
+	return key
+}
+
+func Test_runner_cachedHostKeys(t *testing.T) {
+	accept := func(string, net.Addr, ssh.PublicKey) error { return nil }
+	key, other := newHostKey(t), newHostKey(t)
+
+	r := &runner{cache: true}
+	check := r.cachedHostKeys("web1", accept)
+	require.NoError(t, check("web1:22", nil, key))
+	require.NoError(t, check("web1:22", nil, key))
+	err := check("web1:22", nil, other)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "host key of web1 changed since it was cached")
+
+	require.Empty(t, r.state.forget([]string{"web1"}))
+	require.NoError(t, check("web1:22", nil, other), "forgotten hosts are cached again")
+	require.Equal(t, []string{"web2"}, r.state.forget([]string{"web2"}))
+
+	r = &runner{}
+	check = r.cachedHostKeys("web1", accept)
+	require.NoError(t, check("web1:22", nil, key))
+	require.NoError(t, check("web1:22", nil, other), "--no-cache checks no cached key")
+}
+
+func Test_runner_cachedHostKeys_certificate(t *testing.T) {
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	require.NoError(t, err)
+	certify := func(key ssh.PublicKey, serial uint64) ssh.PublicKey {
+		cert := &ssh.Certificate{Key: key, Serial: serial, CertType: ssh.HostCert,
+			ValidPrincipals: []string{"web1"}, ValidBefore: ssh.CertTimeInfinity}
+		require.NoError(t, cert.SignCert(rand.Reader, ca))
+		return cert
+	}
+	checker := &ssh.CertChecker{IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+		return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+	}}
+	key := newHostKey(t)
+
+	r := &runner{cache: true}
+	check := r.cachedHostKeys("web1", checker.CheckHostKey)
+	require.NoError(t, check("web1:22", nil, certify(key, 1)))
+	require.NoError(t, check("web1:22", nil, certify(key, 2)), "certificates of the host key are renewed")
+
+	err = check("web1:22", nil, certify(newHostKey(t), 3))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "host key of web1 changed since it was cached")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// hostCount parses the value of --canary or --limit, which is either a
+// number of hosts or a percentage of hosts, into a number of hosts (at
+// least one).
+func hostCount(value string, total int) (int, error) {
+	var n int
+	if strings.HasSuffix(value, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return 0, errors.Errorf("malformed percentage of hosts %q", value)
+		}
+		n = int(math.Ceil(float64(total) * pct / 100))
+	} else {
+		count, err := strconv.Atoi(value)
+		if err != nil || count <= 0 {
+			return 0, errors.Errorf("malformed number of hosts %q", value)
+		}
+		n = count
+	}
+
+	if n > total {
+		n = total
+	}
+	return n, nil
+}
+
+// canaried executes runFn against a small subset of hosts first, and only
+// proceeds against the rest of hosts if the failure rate of the canary hosts
+// is acceptable and the operator confirms (unless --canary-auto is set).
+// Canary hosts failing to dial count towards the failure rate, like
+// canary hosts failing assertions.
+func canaried(args args, r *runner, hosts []string, runFn func([]string) error) error {
+	if args.canary == "" {
+		return runFn(hosts)
+	}
+
+	n, err := hostCount(args.canary, len(hosts))
+	if err != nil {
+		return err
+	}
+
+	canaries, rest := hosts[:n], hosts[n:]
+	r.out.message("running canary on %d of %d hosts %v", len(canaries), len(hosts), canaries)
+
+	r.canarying = true
+	canaryErr := runFn(canaries)
+	r.canarying = false
+	if _, ok := canaryErr.(failures); canaryErr != nil && !ok && !failedToDial(canaryErr) {
+		return errors.Wrap(canaryErr, "canary failed")
+	}
+
+	// results of other hosts, e.g. failing prechecks before the canary,
+	// don't count towards the failure rate of the canary hosts
+	canary := make(map[string]bool)
+	for _, host := range canaries {
+		canary[host] = true
+	}
+	var canaryResults []result
+	for _, res := range r.results {
+		if canary[res.Host] {
+			canaryResults = append(canaryResults, res)
+		}
+	}
+
+	ok, order := hostsOK(canaryResults)
+	failed := 0
+	for _, host := range order {
+		if !ok[host] {
+			failed++
+		}
+	}
+	rate := float64(failed) / float64(len(canaries))
+	if rate > args.canaryMaxFailures {
+		return errors.Errorf("canary failed on %d of %d hosts", failed, len(canaries))
+	}
+
+	if len(rest) == 0 {
+		return canaryErr
+	}
+
+	if !args.canaryAuto {
+		proceed, err := confirm(fmt.Sprintf("canary complete (%d of %d failed), continue with %d remaining hosts?", failed, len(canaries), len(rest)))
+		if err != nil {
+			return err
+		}
+		if !proceed {
+			return errors.Errorf("run aborted after canary")
+		}
+	}
+
+	err = runFn(rest)
+	if f, ok := err.(failures); ok {
+		if cf, ok := canaryErr.(failures); ok {
+			return append(cf, f...)
+		}
+		if canaryErr != nil {
+			return canaryErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return canaryErr
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_hostCount(t *testing.T) {
+	tests := []struct {
+		value string
+		total int
+		exp   int
+	}{
+		{value: "3", total: 10, exp: 3},
+		{value: "30", total: 10, exp: 10},
+		{value: "5%", total: 100, exp: 5},
+		{value: "5%", total: 10, exp: 1},
+		{value: "50%", total: 5, exp: 3},
+	}
+
+	for _, test := range tests {
+		n, err := hostCount(test.value, test.total)
+		require.NoError(t, err)
+		require.Equal(t, test.exp, n, test.value)
+	}
+
+	for _, bad := range []string{"0", "-1", "abc", "0%", "101%"} {
+		_, err := hostCount(bad, 10)
+		require.Error(t, err, bad)
+	}
+}
+
+func Test_canaried_dialFailure(t *testing.T) {
+	refused := func(string, string) (net.Conn, error) { return nil, errors.New("connection refused") }
+	hosts := []string{"web1", "web2", "web3", "web4"}
+	run := func(r *runner) func([]string) error {
+		return func(hosts []string) error {
+			return r.runCmd(hosts, "uptime", false, nil)
+		}
+	}
+
+	r := &runner{out: &quiet{}, dialer: refused, parallel: 1, passwords: make(map[string]string)}
+	err := canaried(args{canary: "2"}, r, hosts, run(r))
+	require.Error(t, err)
+	require.Equal(t, "canary failed on 2 of 2 hosts", err.Error())
+	require.Len(t, r.results, 2)
+
+	r = &runner{out: &quiet{}, dialer: refused, parallel: 1, passwords: make(map[string]string)}
+	err = canaried(args{canary: "2", canaryMaxFailures: 1, canaryAuto: true}, r, hosts, run(r))
+	require.Error(t, err)
+	require.Equal(t, reasonDial, reason(err))
+	require.Len(t, r.results, 3) // the rest stop at the first host failing to dial
+	require.False(t, r.canarying)
+}
+
+func Test_canaried_nonCanaryFailures(t *testing.T) {
+	hosts := []string{"web1", "web2", "web3"}
+	r := &runner{out: &quiet{}, parallel: 1, passwords: make(map[string]string)}
+	r.results = append(r.results, result{Host: "web3", ExitCode: -1, Error: "unreachable"})
+
+	var ran []string
+	run := func(hosts []string) error {
+		for _, host := range hosts {
+			ran = append(ran, host)
+			r.results = append(r.results, result{Host: host, Command: "uptime"})
+		}
+		return nil
+	}
+
+	// failures recorded before the canary, of hosts outside of it, don't fail it
+	err := canaried(args{canary: "2", canaryAuto: true}, r, hosts, run)
+	require.NoError(t, err)
+	require.Equal(t, hosts, ran)
+}
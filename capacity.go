@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// classLabel is the inventory label declaring the size class of a host,
+// e.g. "db1 class=small".
+const classLabel = "class"
+
+// heavyLimits limits how many "# heavy" steps run at once across the
+// hosts of each size class, so that fleet jobs such as backups do not
+// overload the weakest machines. Classes without a limit, and hosts
+// without a class, are limited by --parallel only.
+type heavyLimits map[string]chan struct{}
+
+// parseHeavyLimits parses the CLASS=N of every --heavy-limit.
+func parseHeavyLimits(specs []string) (heavyLimits, error) {
+	limits := make(heavyLimits)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("malformed heavy limit %q, expected CLASS=N", spec)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return nil, errors.Errorf("malformed heavy limit %q, expected a positive number of steps", spec)
+		}
+		if _, exists := limits[parts[0]]; exists {
+			return nil, errors.Errorf("heavy limit of class %s given twice", parts[0])
+		}
+		limits[parts[0]] = make(chan struct{}, n)
+	}
+	return limits, nil
+}
+
+// heavySlot waits for a heavy step to be allowed to run on host, and
+// returns the function releasing its slot once it has run.
+func (r *runner) heavySlot(host, command string) func() {
+	class := r.inventory.metadata(host)[classLabel]
+	slots, limited := r.heavy[class]
+	if !limited {
+		return func() {}
+	}
+
+	select {
+	case slots <- struct{}{}:
+	default:
+		r.out.message("waiting to run `%s` on %s, as %d heavy steps are running on %s hosts", command, host, cap(slots), class)
+		slots <- struct{}{}
+	}
+	return func() { <-slots }
+}
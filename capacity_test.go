@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseHeavyLimits(t *testing.T) {
+	limits, err := parseHeavyLimits([]string{"small=1", "large=4"})
+	require.NoError(t, err)
+	require.Equal(t, 1, cap(limits["small"]))
+	require.Equal(t, 4, cap(limits["large"]))
+
+	_, err = parseHeavyLimits([]string{"small"})
+	require.EqualError(t, err, `malformed heavy limit "small", expected CLASS=N`)
+	_, err = parseHeavyLimits([]string{"small=0"})
+	require.EqualError(t, err, `malformed heavy limit "small=0", expected a positive number of steps`)
+	_, err = parseHeavyLimits([]string{"small=1", "small=2"})
+	require.EqualError(t, err, "heavy limit of class small given twice")
+}
+
+func Test_heavyDirective(t *testing.T) {
+	sf, err := parse("backup", "# heavy\npg_dump app > /backup/app.sql\n---\n# heavy: false\nls /backup")
+	require.NoError(t, err)
+	require.True(t, sf.scripts[0].heavy)
+	require.False(t, sf.scripts[1].heavy)
+
+	_, err = parse("bad", "# heavy\n@local make")
+	require.EqualError(t, err, "bad directive in script bad: heavy does not apply to local steps")
+}
+
+func Test_runner_heavySlot(t *testing.T) {
+	inv, err := parseInventory("db{1..3} class=small\nweb{1..3} class=large\nlb1\n")
+	require.NoError(t, err)
+	limits, err := parseHeavyLimits([]string{"small=1"})
+	require.NoError(t, err)
+	r := &runner{inventory: inv, heavy: limits, out: &quiet{}}
+
+	var (
+		lock              sync.Mutex
+		running, maxSmall int
+		wg                sync.WaitGroup
+	)
+	for _, host := range []string{"db1", "db2", "db3", "web1", "web2", "lb1"} {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			release := r.heavySlot(host, "pg_dump")
+			defer release()
+			if inv.metadata(host)[classLabel] != "small" {
+				return
+			}
+
+			lock.Lock()
+			running++
+			if running > maxSmall {
+				maxSmall = running
+			}
+			lock.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}(host)
+	}
+	wg.Wait()
+	require.Equal(t, 1, maxSmall)
+}
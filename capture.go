@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	truncateHead = "head" // keep the first --max-output of output
+	truncateTail = "tail" // keep the last --max-output of output
+)
+
+var truncatePolicies = []string{truncateHead, truncateTail}
+
+// A capture collects the output of a script, keeping at most max bytes of
+// it in memory (all of it if max is 0) as policy says, and writing all of
+// it to spill, if set, so that runaway commands do not exhaust memory.
+type capture struct {
+	max    int64
+	policy string
+	spill  *os.File
+
+	lock  sync.Mutex // stdout and stderr are written concurrently
+	kept  []byte
+	total int64
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.total += int64(len(p))
+	if c.spill != nil {
+		if _, err := c.spill.Write(p); err != nil {
+			_ = c.spill.Close()
+			c.spill = nil
+		}
+	}
+
+	switch {
+	case c.max == 0:
+		c.kept = append(c.kept, p...)
+	case c.policy == truncateTail:
+		c.kept = append(c.kept, p...)
+		if int64(len(c.kept)) > 2*c.max {
+			c.kept = append([]byte(nil), c.kept[int64(len(c.kept))-c.max:]...)
+		}
+	default:
+		// the excess is dropped, but reported as written, lest io.Copy fail with a short write
+		if room := c.max - int64(len(c.kept)); room > 0 {
+			kept := p
+			if int64(len(kept)) > room {
+				kept = kept[:room]
+			}
+			c.kept = append(c.kept, kept...)
+		}
+	}
+	return len(p), nil
+}
+
+// output returns the output kept, and how many bytes of it were dropped.
+func (c *capture) output() (string, int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	kept := c.kept
+	if c.max > 0 && int64(len(kept)) > c.max {
+		kept = kept[int64(len(kept))-c.max:]
+	}
+	return string(kept), c.total - int64(len(kept))
+}
+
+// String returns the output kept, marked where output was dropped.
+func (c *capture) String() string {
+	kept, dropped := c.output()
+	if dropped == 0 {
+		return kept
+	}
+	mark := fmt.Sprintf("[%sB of output truncated]", formatSize(dropped))
+	if c.policy == truncateTail {
+		return mark + "\n" + kept
+	}
+	return kept + "\n" + mark
+}
+
+// note returns the note of the result of a script whose output was
+// truncated, or "" if it was not.
+func (c *capture) note() string {
+	kept, dropped := c.output()
+	if dropped == 0 {
+		return ""
+	}
+	policy := "first"
+	if c.policy == truncateTail {
+		policy = "last"
+	}
+	note := fmt.Sprintf("output truncated to the %s %sB of %sB", policy, formatSize(int64(len(kept))), formatSize(c.total))
+	if c.spill != nil {
+		note += ", in full in " + c.spill.Name()
+	}
+	return note
+}
+
+// close the spill file, if any, which is removed unless output was
+// truncated, so that only the full outputs of runaway scripts are kept.
+func (c *capture) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.spill == nil {
+		return
+	}
+	_ = c.spill.Close()
+	if c.max == 0 || c.total <= c.max {
+		_ = os.Remove(c.spill.Name())
+	}
+}
+
+// newCapture returns a capture of the output of a script on host, as
+// configured by --max-output, --truncate and --spill-output.
+func (r *runner) newCapture(host string) *capture {
+	c := &capture{max: r.maxOutput, policy: r.truncate}
+	if r.spillDir == "" {
+		return c
+	}
+
+	n := atomic.AddInt32(&r.spills, 1)
+	path := filepath.Join(r.spillDir, r.id, fmt.Sprintf("%s-%d.log", host, n))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		r.out.warning("not spilling output of %s: %v", host, err)
+		return c
+	}
+	spill, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		r.out.warning("not spilling output of %s: %v", host, err)
+		return c
+	}
+	c.spill = spill
+	return c
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_capture(t *testing.T) {
+	all := &capture{}
+	_, _ = all.Write([]byte("hello "))
+	_, _ = all.Write([]byte("world"))
+	require.Equal(t, "hello world", all.String())
+	require.Empty(t, all.note())
+
+	head := &capture{max: 4, policy: truncateHead}
+	_, _ = head.Write([]byte("abc"))
+	_, _ = head.Write([]byte("defgh"))
+	require.Equal(t, "abcd\n[4B of output truncated]", head.String())
+	require.Equal(t, "output truncated to the first 4B of 8B", head.note())
+
+	tail := &capture{max: 4, policy: truncateTail}
+	for _, s := range []string{"abc", "defgh", "ij"} {
+		_, _ = tail.Write([]byte(s))
+	}
+	require.Equal(t, "[6B of output truncated]\nghij", tail.String())
+	require.Equal(t, "output truncated to the last 4B of 10B", tail.note())
+}
+
+func Test_capture_copy(t *testing.T) {
+	head := &capture{max: 4, policy: truncateHead}
+	n, err := io.Copy(head, strings.NewReader("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, int64(10), n)
+	require.Equal(t, "0123\n[6B of output truncated]", head.String())
+}
+
+func Test_capture_spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	r := &runner{id: "run1", maxOutput: 4, truncate: truncateTail, spillDir: dir, out: &quiet{}}
+	c := r.newCapture("web1")
+	_, _ = c.Write([]byte("0123456789"))
+	c.close()
+	path := filepath.Join(dir, "run1", "web1-1.log")
+	require.Equal(t, "output truncated to the last 4B of 10B, in full in "+path, c.note())
+	bs, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(bs))
+
+	// spills of output which was not truncated are removed
+	c = r.newCapture("web1")
+	_, _ = c.Write([]byte("ok"))
+	c.close()
+	_, err = os.Stat(filepath.Join(dir, "run1", "web1-2.log"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Danger levels of script files, declared with "# danger:" in their
+// header. Every step of a script file of high danger is confirmed with
+// --confirm dangerous, as if marked "# dangerous".
+const (
+	dangerLow    = "low"
+	dangerMedium = "medium"
+	dangerHigh   = "high"
+)
+
+var dangerLevels = []string{dangerLow, dangerMedium, dangerHigh}
+
+func isDangerLevel(level string) bool {
+	for _, l := range dangerLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// catalog returns a table of scripts, listing the name, owner and danger
+// of each along with its description, from their metadata headers.
+func catalog(scripts []scriptfile) *table {
+	t := &table{}
+	t.add(colors.muted, "script", "name", "owner", "danger", "steps", "description")
+	for _, sf := range scripts {
+		style := colors.info
+		switch {
+		case sf.deprecated != "" || !sf.sunset.IsZero():
+			style = colors.muted
+		case sf.meta["danger"] == dangerHigh:
+			style = colors.failure
+		}
+
+		description := sf.meta["description"]
+		warning, err := sf.deprecation(time.Now())
+		if err != nil {
+			warning = err.Error()
+		}
+		if warning != "" {
+			description = strings.TrimSpace(description + " (" + strings.TrimPrefix(warning, "script "+sf.name+" ") + ")")
+		}
+		t.add(style, sf.name, orDash(sf.meta["name"]), orDash(sf.meta["owner"]), orDash(sf.meta["danger"]),
+			fmt.Sprint(len(sf.scripts)), orDash(description))
+	}
+	return t
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// listCmd implements "commando list --scripts dir", which prints a catalog
+// of the scripts of the directories, as they would be run.
+func listCmd(arguments []string) error {
+	var args args
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	flags.Var(&args.scriptDirs, "scripts", "the directory full of scripts to list (may be repeated)")
+	wide := flags.Bool("wide", false, "do not truncate or wrap descriptions to fit the terminal")
+	_ = flags.Parse(arguments)
+
+	if len(args.scriptDirs) == 0 {
+		return errors.New("usage: commando list --scripts dir")
+	}
+
+	scripts, err := load(args)
+	if err != nil {
+		return err
+	}
+	if *wide {
+		tableWidth = 0
+	}
+	catalog(scripts).print()
+	return nil
+}
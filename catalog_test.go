@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_metadataHeader(t *testing.T) {
+	sf, err := parse("10-failover.sh", `# name: Fail over the database
+# description: promote the replica, and point the app at it
+# owner: team-data
+# danger: high
+pg_ctl promote
+---
+systemctl restart app`)
+	require.NoError(t, err)
+	require.Equal(t, metadata{
+		"name":        "Fail over the database",
+		"description": "promote the replica, and point the app at it",
+		"owner":       "team-data",
+		"danger":      "high",
+	}, sf.meta)
+	require.True(t, sf.scripts[0].dangerous)
+	require.True(t, sf.scripts[1].dangerous, "high danger confirms every step")
+
+	sf, err = parse("20-status.sh", "# danger: low\nsystemctl status app")
+	require.NoError(t, err)
+	require.False(t, sf.scripts[0].dangerous)
+
+	_, err = parse("bad", "# danger: extreme\nrm -rf /tmp/x")
+	require.EqualError(t, err, `bad directive in script bad: malformed danger "extreme", must be one of low, medium, high`)
+
+	// metadata is only of the header, and without a value is a comment
+	sf, err = parse("30-deploy.sh", "# owner: team-web\n# description:\ndeploy\n---\n# owner: team-data\n# name: Migrate\nmigrate")
+	require.NoError(t, err)
+	require.Equal(t, metadata{"owner": "team-web"}, sf.meta)
+}
+
+func Test_catalog(t *testing.T) {
+	failover, err := parse("10-failover.sh", "# name: Fail over\n# owner: team-data\n# danger: high\npg_ctl promote")
+	require.NoError(t, err)
+	old, err := parse("20-restart.sh", "# description: restart the app\n# deprecated: use 25-rolling-restart\nsystemctl restart app")
+	require.NoError(t, err)
+
+	rows := catalog([]scriptfile{failover, old}).render(0)
+	require.Len(t, rows, 3)
+	require.Equal(t, []string{"10-failover.sh  Fail over  team-data  high    1      -"}, rows[1])
+	require.Equal(t, []string{"20-restart.sh   -          -          -       1      restart the app (is deprecated: use 25-rolling-restart)"}, rows[2])
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var sha256Re = regexp.MustCompile(`^[[:xdigit:]]{64}$`)
+
+// checksumCommand prints the SHA-256 of the file at path as a sha256=
+// field (along with a path= field), hashing it with sum, a command which hashes its stdin and
+// prints the hash first.
+func checksumCommand(sum, path string) string {
+	return fmt.Sprintf(`h=$(%s < %s) && printf 'sha256=%%s\npath=%%s\n' "${h%%%% *}" %s`, sum, quote(path), quote(path))
+}
+
+// markChecksum configures the script of a @checksum module: with a hash,
+// to assert the file has that hash, and otherwise to check the file has
+// the same hash on every host of the run.
+func (s *script) markChecksum() error {
+	tokens := strings.Fields(strings.TrimPrefix(s.command, "sudo "))
+	if len(tokens) == 0 || tokens[0] != "@checksum" {
+		return nil
+	}
+
+	switch len(tokens) {
+	case 2:
+		s.checksum = tokens[1]
+	case 3:
+		if !sha256Re.MatchString(tokens[2]) {
+			return errors.Errorf("malformed sha256 %q, expected 64 hex digits", tokens[2])
+		}
+		s.asserts = append(s.asserts, assertion{field: "sha256", operator: "==", expected: strings.ToLower(tokens[2])})
+	default:
+		return errors.Errorf("malformed module %q, expected @checksum <path> [sha256]", s.command)
+	}
+	return nil
+}
+
+// sawChecksum remembers the hash of path in file on host, to compare
+// it with that of other hosts once the run completes.
+func (r *runner) sawChecksum(host, file, path, hash string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := file + ": " + path
+	if r.checksums == nil {
+		r.checksums = make(map[string]map[string]string)
+	}
+	if r.checksums[key] == nil {
+		r.checksums[key] = make(map[string]string)
+	}
+	r.checksums[key][host] = hash
+}
+
+// checksumCheck is the check of a file having the same hash on every host.
+type checksumCheck struct {
+	path   string
+	hash   string
+	agreed int
+	total  int
+}
+
+func (c checksumCheck) String() string {
+	return fmt.Sprintf("sha256 of %s matches %d of %d hosts (%.12s)", c.path, c.agreed, c.total, c.hash)
+}
+
+// checksumDrift compares the hashes of the files checked by @checksum
+// modules without a hash, returning a failure for each of hosts whose file
+// differs from the hash most hosts have, which is also added to its result.
+func (r *runner) checksumDrift(hosts []string) failures {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	keys := make([]string, 0, len(r.checksums))
+	for key := range r.checksums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var failed failures
+	for _, key := range keys {
+		byHost := r.checksums[key]
+		common, agreed := majority(byHost)
+		parts := strings.SplitN(key, ": ", 2)
+		file, path := parts[0], parts[1]
+		check := checksumCheck{path: path, hash: common, agreed: agreed, total: len(byHost)}
+
+		for _, host := range hosts {
+			hash, exists := byHost[host]
+			if !exists || hash == common {
+				continue
+			}
+			failed = append(failed, failure{host: host, file: file, check: check, actual: hash})
+			for i, res := range r.results {
+				if res.Host == host && res.File == file && fields(res.Output)["path"] == path {
+					r.results[i].Failed = append(r.results[i].Failed, fmt.Sprintf("%s (got %.12s)", check, hash))
+				}
+			}
+		}
+	}
+	return failed
+}
+
+// majority returns the hash most hosts have, and how many have it.
+func majority(byHost map[string]string) (string, int) {
+	counts := make(map[string]int)
+	for _, hash := range byHost {
+		counts[hash]++
+	}
+
+	var common string
+	for hash, n := range counts {
+		if n > counts[common] || (n == counts[common] && hash < common) {
+			common = hash
+		}
+	}
+	return common, counts[common]
+}
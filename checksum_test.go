@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checksumCommand(t *testing.T) {
+	command, err := linux.module("@checksum /etc/hosts")
+	require.NoError(t, err)
+	require.Equal(t, `h=$(sha256sum < '/etc/hosts') && printf 'sha256=%s\npath=%s\n' "${h%% *}" '/etc/hosts'`, command)
+
+	if _, err := exec.LookPath("sha256sum"); err != nil {
+		t.Skip("sha256sum is not installed")
+	}
+	bs, err := exec.Command("sh", "-c", strings.Replace(command, "/etc/hosts", "/dev/null", -1)).Output()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"path":   "/dev/null",
+	}, fields(string(bs)))
+}
+
+func Test_markChecksum(t *testing.T) {
+	sf, err := parse("10-checksum", "@checksum /etc/app.conf\n---\nsudo @checksum /etc/sudoers E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855")
+	require.NoError(t, err)
+	require.Equal(t, "/etc/app.conf", sf.scripts[0].checksum)
+	require.Empty(t, sf.scripts[1].checksum)
+	require.Equal(t, []assertion{{field: "sha256", operator: "==", expected: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}, sf.scripts[1].asserts)
+
+	_, err = parse("bad", "@checksum /etc/app.conf abc123")
+	require.Error(t, err)
+}
+
+func Test_checksumDrift(t *testing.T) {
+	r := &runner{}
+	for _, host := range []string{"h1", "h2", "h3"} {
+		hash := "aaa"
+		if host == "h2" {
+			hash = "bbb"
+		}
+		r.results = append(r.results, result{Host: host, File: "conf", Output: "sha256=" + hash + "\npath=/etc/app.conf"})
+		r.sawChecksum(host, "conf", "/etc/app.conf", hash)
+	}
+
+	drift := r.checksumDrift([]string{"h1", "h2", "h3"})
+	require.Len(t, drift, 1)
+	require.Equal(t, "h2: conf: sha256 of /etc/app.conf matches 2 of 3 hosts (aaa) (got bbb)", drift[0].String())
+	require.Empty(t, r.results[0].Failed)
+	require.Equal(t, []string{"sha256 of /etc/app.conf matches 2 of 3 hosts (aaa) (got bbb)"}, r.results[1].Failed)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	confirmAll       = "all"       // confirm every step
+	confirmDangerous = "dangerous" // confirm steps marked # dangerous
+)
+
+var confirmModes = []string{confirmAll, confirmDangerous}
+
+// errDeclined is the reason steps declined at confirmation are skipped.
+var errDeclined = errors.New("declined at confirmation")
+
+// A confirmer asks the operator before each step of a run (or each step
+// marked # dangerous) whether to run it on a host, one step at a time.
+type confirmer struct {
+	mode string
+	in   *bufio.Reader
+
+	yesToAll bool
+	quit     bool
+}
+
+func newConfirmer(mode string, in io.Reader) *confirmer {
+	if mode == "" {
+		return nil
+	}
+	return &confirmer{mode: mode, in: bufio.NewReader(in)}
+}
+
+// ask whether to run command of sc on host, returning errDeclined if the
+// operator declines it, or errCancelled if they quit the run.
+func (c *confirmer) ask(host, file, command string, sc script) error {
+	switch {
+	case c.quit:
+		return errCancelled
+	case c.yesToAll, c.mode == confirmDangerous && !sc.dangerous:
+		return nil
+	}
+
+	heading := "about to run on " + host
+	if file != "" {
+		heading += " (" + file + ")"
+	}
+	if sc.dangerous {
+		colors.failure.println("%s, marked dangerous:", heading)
+	} else {
+		colors.notice.println("%s:", heading)
+	}
+	for _, line := range strings.Split(command, "\n") {
+		colors.output.println("    %s", line)
+	}
+
+	for {
+		colors.muted.println("  run it? [y]es, [n]o, [a]ll, [q]uit --> ")
+		line, err := c.in.ReadString('\n')
+		if err != nil && line == "" {
+			c.quit = true
+			return errors.Wrap(err, "failed to read confirmation")
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return nil
+		case "n", "no":
+			return errDeclined
+		case "a", "all":
+			c.yesToAll = true
+			return nil
+		case "q", "quit":
+			c.quit = true
+			return errCancelled
+		}
+	}
+}
+
+// confirm asks the operator whether to run command of sc on host, if
+// --confirm is set. Steps of hosts running concurrently await their turn.
+func (r *runner) confirm(host, file, command string, sc script) error {
+	if r.confirmer == nil {
+		return nil
+	}
+	r.confirming.Lock()
+	defer r.confirming.Unlock()
+
+	err := r.confirmer.ask(host, file, command, sc)
+	if err != nil && err != errDeclined {
+		r.cancel(false)
+	}
+	return err
+}
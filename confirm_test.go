@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_confirmer_ask(t *testing.T) {
+	c := newConfirmer(confirmAll, strings.NewReader("maybe\nn\ny\nall\n"))
+	require.Equal(t, errDeclined, c.ask("web1", "upgrade.sh", "apt-get -y upgrade", script{}))
+	require.NoError(t, c.ask("web2", "upgrade.sh", "apt-get -y upgrade", script{}))
+	require.NoError(t, c.ask("web3", "upgrade.sh", "apt-get -y upgrade", script{}))
+	require.NoError(t, c.ask("web4", "upgrade.sh", "reboot", script{}), "all runs later steps without asking")
+
+	c = newConfirmer(confirmDangerous, strings.NewReader("q\n"))
+	require.NoError(t, c.ask("web1", "upgrade.sh", "apt-get update", script{}))
+	require.Equal(t, errCancelled, c.ask("web1", "upgrade.sh", "reboot", script{dangerous: true}))
+	require.Equal(t, errCancelled, c.ask("web2", "upgrade.sh", "apt-get update", script{}))
+
+	require.Nil(t, newConfirmer("", strings.NewReader("")))
+}
+
+func Test_parse_dangerous(t *testing.T) {
+	sf, err := parse("upgrade.sh", "apt-get update\n---\n# dangerous\nreboot\n---\n# dangerous: false\nuptime\n")
+	require.NoError(t, err)
+	require.Len(t, sf.scripts, 3)
+	require.False(t, sf.scripts[0].dangerous)
+	require.True(t, sf.scripts[1].dangerous)
+	require.Equal(t, "reboot", sf.scripts[1].command)
+	require.False(t, sf.scripts[2].dangerous)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultConsulAddr = "http://127.0.0.1:8500"
+
+// consulHosts discovers the addresses of the instances of the service
+// named by query registered in the Consul catalog, optionally limited to
+// instances passing their health checks (--consul-passing).
+//
+// The address of consul is read from $CONSUL_HTTP_ADDR, and the
+// token (if any) from $CONSUL_HTTP_TOKEN.
+func consulHosts(args args, query string) ([]string, error) {
+	if query == "" {
+		return nil, errors.Errorf("no consul service name")
+	}
+
+	address := os.Getenv("CONSUL_HTTP_ADDR")
+	if address == "" {
+		address = defaultConsulAddr
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	endpoint := strings.TrimSuffix(address, "/") + "/v1/health/service/" + url.PathEscape(query)
+	if args.consulPassing {
+		endpoint += "?passing=true"
+	}
+
+	request, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create consul request")
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		request.Header.Set("X-Consul-Token", token)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reach consul")
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		bs, _ := ioutil.ReadAll(response.Body)
+		return nil, errors.Errorf("consul returned %s: %s", response.Status, strings.TrimSpace(string(bs)))
+	}
+
+	return consulAddresses(response.Body)
+}
+
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+	} `json:"Service"`
+}
+
+// consulAddresses extracts the address of each service instance in the response
+// of the health endpoint, which is the address of its node unless overridden.
+func consulAddresses(body io.Reader) ([]string, error) {
+	var entries []consulEntry
+	if err := json.NewDecoder(body).Decode(&entries); err != nil {
+		return nil, errors.Wrap(err, "failed to decode consul response")
+	}
+
+	var addresses []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		if address != "" && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_consulHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/health/service/web", r.URL.Path)
+		require.Equal(t, "true", r.URL.Query().Get("passing"))
+		_, _ = w.Write([]byte(`[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": ""}},
+			{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "10.1.0.2"}},
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": ""}}
+		]`))
+	}))
+	defer server.Close()
+
+	os.Setenv("CONSUL_HTTP_ADDR", server.URL)
+	defer os.Unsetenv("CONSUL_HTTP_ADDR")
+
+	hosts, err := consulHosts(args{consulPassing: true}, "web")
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.1", "10.1.0.2"}, hosts)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errCancelled is returned by a run which was cancelled with "commando cancel",
+// or by SIGINT or SIGTERM.
+var errCancelled = errors.New("run was cancelled")
+
+const (
+	cancelFile = "cancel"
+	pidFile    = "pid"
+)
+
+// A control is the on-disk handle through which other commando processes
+// may act on a run in progress, e.g. with "commando cancel <run-id>".
+type control struct {
+	id  string
+	dir string
+}
+
+func runsDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".commando", "runs")
+}
+
+func newRunID() string {
+	bs := make([]byte, 3)
+	_, _ = rand.Read(bs)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(bs)
+}
+
+func openControl(id string) (*control, error) {
+	dir := filepath.Join(runsDir(), id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create run control directory")
+	}
+
+	pid := []byte(fmt.Sprintf("%d\n", os.Getpid()))
+	if err := ioutil.WriteFile(filepath.Join(dir, pidFile), pid, 0600); err != nil {
+		return nil, errors.Wrap(err, "failed to write run control pid")
+	}
+
+	return &control{id: id, dir: dir}, nil
+}
+
+func (c *control) close() {
+	_ = os.RemoveAll(c.dir)
+}
+
+// cancelled returns whether cancellation of the run has been requested,
+// and if so whether in-flight sessions should be interrupted.
+func (c *control) cancelled() (bool, bool) {
+	bs, err := ioutil.ReadFile(filepath.Join(c.dir, cancelFile))
+	if err != nil {
+		return false, false
+	}
+	return true, strings.TrimSpace(string(bs)) == "interrupt"
+}
+
+// controlled runs fn as a run which may be controlled from other commando
+// processes through its run id, and cancelled by SIGINT or SIGTERM.
+func (r *runner) controlled(fn func() error) error {
+	done := make(chan struct{})
+	defer close(done)
+	go r.handleSignals(done)
+
+	c, err := openControl(r.id)
+	if err != nil {
+		r.out.warning("run %s cannot be controlled: %v", r.id, err)
+		return r.cancellable(fn)
+	}
+	defer c.close()
+
+	r.out.message("run id %s", r.id)
+	go r.watch(c, done)
+
+	return r.cancellable(fn)
+}
+
+func (r *runner) cancellable(fn func() error) error {
+	err := fn()
+	if r.isCancelled() {
+		return errCancelled
+	}
+	return err
+}
+
+func (r *runner) watch(c *control, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if requested, interrupt := c.cancelled(); requested {
+				r.cancel(interrupt)
+			}
+			r.follow(c)
+		}
+	}
+}
+
+// cancel stops the runner from executing against any more hosts,
+// and terminates any in-flight sessions if interrupt is set.
+func (r *runner) cancel(interrupt bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.cancelled {
+		r.out.warning("run %s cancelled", r.id)
+	}
+	r.cancelled = true
+	if r.resumed != nil {
+		// paused hosts and scripts give up rather than wait for a resume
+		close(r.resumed)
+		r.resumed = nil
+	}
+
+	if interrupt {
+		for session, exited := range r.sessions {
+			go terminate(session, exited)
+		}
+	}
+}
+
+func (r *runner) isCancelled() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.cancelled
+}
+
+// cancelCmd implements "commando cancel [--interrupt] <run-id>".
+func cancelCmd(arguments []string) error {
+	flags := flag.NewFlagSet("cancel", flag.ExitOnError)
+	interrupt := flags.Bool("interrupt", false, "also interrupt sessions which are in-flight")
+	_ = flags.Parse(arguments)
+
+	if flags.NArg() != 1 {
+		return errors.Errorf("usage: commando cancel [--interrupt] <run-id>")
+	}
+
+	id := flags.Arg(0)
+	dir := filepath.Join(runsDir(), id)
+	if _, err := os.Stat(dir); err != nil {
+		return errors.Errorf("no run in progress with id %s", id)
+	}
+
+	mode := ""
+	if *interrupt {
+		mode = "interrupt"
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, cancelFile), []byte(mode+"\n"), 0600); err != nil {
+		return errors.Wrap(err, "failed to cancel run")
+	}
+	colors.info.println("cancelling run %s", id)
+	return nil
+}
@@ -0,0 +1,734 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Messages of the OpenSSH multiplexing protocol (see PROTOCOL.mux of
+// OpenSSH), of which only the hello and proxy requests are used.
+const (
+	muxHello        = 0x00000001
+	muxProxy        = 0x1000000f
+	muxProxyReply   = 0x8000000f
+	muxDenied       = 0x80000002
+	muxFailure      = 0x80000003
+	muxVersion      = 4
+	muxMaxMessage   = 256 * 1024
+	muxWindow       = 2 * 1024 * 1024
+	muxMaxPacket    = 32 * 1024
+	muxRequestQueue = 16
+)
+
+// expandControlPath expands the tokens of a ControlPath pattern of ssh,
+// e.g. ~/.ssh/cm-%r@%h:%p, for alias connecting to cfg as user.
+func expandControlPath(pattern, alias string, cfg sshHost, remoteUser string) string {
+	local, _ := os.Hostname()
+	operator, uid := os.Getenv("USER"), ""
+	if u, err := user.Current(); err == nil {
+		operator, uid = u.Username, u.Uid
+	}
+
+	hash := sha1.Sum([]byte(local + cfg.hostName + cfg.port + remoteUser))
+	tokens := map[byte]string{
+		'%': "%",
+		'C': hex.EncodeToString(hash[:]),
+		'h': cfg.hostName,
+		'i': uid,
+		'L': strings.SplitN(local, ".", 2)[0],
+		'l': local,
+		'n': alias,
+		'p': cfg.port,
+		'r': remoteUser,
+		'u': operator,
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '%' && i+1 < len(pattern) {
+			if value, exists := tokens[pattern[i+1]]; exists {
+				_, _ = b.WriteString(value)
+				i++
+				continue
+			}
+		}
+		_ = b.WriteByte(pattern[i])
+	}
+
+	path := b.String()
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// controlMaster returns a client of host multiplexed over the connection
+// of its ControlMaster of ssh, as found with --use-control-master, if it
+// has one, so that no handshake (or second factor) is needed.
+func (r *runner) controlMaster(host string) (*ssh.Client, bool) {
+	if r.controlPath == "" {
+		return nil, false
+	}
+
+	cfg := r.sshConfig.lookup(host)
+	user := r.loginUser(host, cfg)
+	path := expandControlPath(r.controlPath, host, cfg, user)
+	if _, err := os.Stat(path); err != nil {
+		return nil, false
+	}
+
+	conn, err := dialControlMaster(path, user)
+	if err != nil {
+		r.out.warning("not reusing the ControlMaster of %s: %v", host, err)
+		return nil, false
+	}
+	return ssh.NewClient(conn, conn.chans, conn.reqs), true
+}
+
+// dialControlMaster connects to the ControlMaster listening at path, and
+// asks it to proxy the SSH connection protocol (as ssh -O proxy does).
+func dialControlMaster(path, user string) (*muxConn, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to ControlMaster")
+	}
+
+	if err := muxHandshake(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	c := &muxConn{
+		conn:     conn,
+		user:     user,
+		channels: make(map[uint32]*muxChannel),
+		closed:   make(chan struct{}),
+		chans:    make(chan ssh.NewChannel),
+		reqs:     make(chan *ssh.Request),
+	}
+	go c.loop()
+	return c, nil
+}
+
+// muxHandshake exchanges hellos with the ControlMaster of conn, and
+// switches it to proxy mode.
+func muxHandshake(conn net.Conn) error {
+	if err := writeMuxMessage(conn, muxHello, muxVersion); err != nil {
+		return err
+	}
+	hello, err := readMuxMessage(conn)
+	if err != nil {
+		return err
+	}
+	if len(hello) < 8 || binary.BigEndian.Uint32(hello) != muxHello {
+		return errors.New("ControlMaster did not say hello")
+	}
+	if version := binary.BigEndian.Uint32(hello[4:]); version != muxVersion {
+		return errors.Errorf("ControlMaster speaks multiplexing protocol version %d, not %d", version, muxVersion)
+	}
+
+	const requestID = 1
+	if err := writeMuxMessage(conn, muxProxy, requestID); err != nil {
+		return err
+	}
+	reply, err := readMuxMessage(conn)
+	if err != nil {
+		return err
+	}
+	if len(reply) < 4 {
+		return errors.New("malformed reply of ControlMaster")
+	}
+	switch binary.BigEndian.Uint32(reply) {
+	case muxProxyReply:
+		return nil
+	case muxDenied, muxFailure:
+		var reason string
+		if len(reply) > 12 {
+			reason = ": " + string(reply[12:])
+		}
+		return errors.Errorf("ControlMaster refused to proxy%s", reason)
+	}
+	return errors.New("ControlMaster does not support proxying, which requires OpenSSH 7.4 or later")
+}
+
+func writeMuxMessage(w io.Writer, values ...uint32) error {
+	bs := make([]byte, 4+4*len(values))
+	binary.BigEndian.PutUint32(bs, uint32(4*len(values)))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(bs[4+4*i:], v)
+	}
+	_, err := w.Write(bs)
+	return errors.Wrap(err, "failed to write to ControlMaster")
+}
+
+func readMuxMessage(r io.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, errors.Wrap(err, "failed to read from ControlMaster")
+	}
+	if n > muxMaxMessage {
+		return nil, errors.Errorf("message of ControlMaster is too long (%d bytes)", n)
+	}
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(r, bs); err != nil {
+		return nil, errors.Wrap(err, "failed to read from ControlMaster")
+	}
+	return bs, nil
+}
+
+// Messages of the SSH connection protocol (RFC 4254), marshalled with
+// ssh.Marshal.
+type (
+	channelOpenMsg struct {
+		Type      string `sshtype:"90"`
+		SenderID  uint32
+		Window    uint32
+		MaxPacket uint32
+		Data      []byte `ssh:"rest"`
+	}
+	channelOpenConfirmMsg struct {
+		PeersID   uint32 `sshtype:"91"`
+		MyID      uint32
+		Window    uint32
+		MaxPacket uint32
+		Data      []byte `ssh:"rest"`
+	}
+	channelOpenFailureMsg struct {
+		PeersID  uint32 `sshtype:"92"`
+		Reason   uint32
+		Message  string
+		Language string
+	}
+	windowAdjustMsg struct {
+		PeersID uint32 `sshtype:"93"`
+		Bytes   uint32
+	}
+	channelDataMsg struct {
+		PeersID uint32 `sshtype:"94"`
+		Data    []byte
+	}
+	channelExtendedDataMsg struct {
+		PeersID uint32 `sshtype:"95"`
+		Code    uint32
+		Data    []byte
+	}
+	channelEOFMsg struct {
+		PeersID uint32 `sshtype:"96"`
+	}
+	channelCloseMsg struct {
+		PeersID uint32 `sshtype:"97"`
+	}
+	channelRequestMsg struct {
+		PeersID   uint32 `sshtype:"98"`
+		Request   string
+		WantReply bool
+		Payload   []byte `ssh:"rest"`
+	}
+	channelReplyMsg struct {
+		PeersID uint32 `sshtype:"99|100"`
+	}
+	globalRequestMsg struct {
+		Type      string `sshtype:"80"`
+		WantReply bool
+		Data      []byte `ssh:"rest"`
+	}
+)
+
+const (
+	msgGlobalRequest  = 80
+	msgRequestFailure = 82
+	msgChannelOpen    = 90
+	msgChannelSuccess = 99
+)
+
+// A muxConn is an ssh.Conn of channels multiplexed over the connection of
+// a ControlMaster in proxy mode, which relays the messages of the SSH
+// connection protocol in plain packets: the ControlMaster takes care of
+// encryption and authentication, and of keeping its connection alive.
+type muxConn struct {
+	conn net.Conn
+	user string
+
+	writeLock sync.Mutex
+
+	lock     sync.Mutex
+	channels map[uint32]*muxChannel
+	nextID   uint32
+	err      error
+	closed   chan struct{}
+
+	// of the server, which are never passed on, but closed with the connection
+	chans chan ssh.NewChannel
+	reqs  chan *ssh.Request
+}
+
+func (c *muxConn) User() string          { return c.user }
+func (c *muxConn) SessionID() []byte     { return nil }
+func (c *muxConn) ClientVersion() []byte { return []byte("SSH-2.0-commando") }
+func (c *muxConn) ServerVersion() []byte { return nil }
+func (c *muxConn) RemoteAddr() net.Addr  { return c.conn.RemoteAddr() }
+func (c *muxConn) LocalAddr() net.Addr   { return c.conn.LocalAddr() }
+
+// SendRequest answers global requests itself: ControlMasters only relay
+// requests to forward ports, and keep their connections alive themselves.
+func (c *muxConn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	select {
+	case <-c.closed:
+		return false, nil, io.EOF
+	default:
+	}
+	return name == keepaliveRequest, nil, nil
+}
+
+func (c *muxConn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, nil, c.err
+	}
+	ch := newMuxChannel(c, c.nextID)
+	c.channels[ch.id] = ch
+	c.nextID++
+	c.lock.Unlock()
+
+	err := c.write(ssh.Marshal(channelOpenMsg{
+		Type:      name,
+		SenderID:  ch.id,
+		Window:    muxWindow,
+		MaxPacket: muxMaxPacket,
+		Data:      data,
+	}))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	select {
+	case err = <-ch.opened:
+	case <-c.closed:
+		err = c.Wait()
+	}
+	if err != nil {
+		c.forget(ch.id)
+		return nil, nil, err
+	}
+	return ch, ch.requests, nil
+}
+
+func (c *muxConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *muxConn) Wait() error {
+	<-c.closed
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// write a packet of payload, as framed by OpenSSH in proxy mode: its
+// length, no padding, and the payload.
+func (c *muxConn) write(payload []byte) error {
+	bs := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(bs, uint32(1+len(payload)))
+	copy(bs[5:], payload)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(bs)
+	return err
+}
+
+func (c *muxConn) read() ([]byte, error) {
+	bs, err := readMuxMessage(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) < 2 || int(bs[0]) > len(bs)-2 {
+		return nil, errors.New("malformed packet from ControlMaster")
+	}
+	return bs[1 : len(bs)-int(bs[0])], nil
+}
+
+func (c *muxConn) channel(id uint32) *muxChannel {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.channels[id]
+}
+
+func (c *muxConn) forget(id uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.channels, id)
+}
+
+// loop dispatches the packets relayed by the ControlMaster to channels
+// until the connection closes.
+func (c *muxConn) loop() {
+	var err error
+	for err == nil {
+		var packet []byte
+		if packet, err = c.read(); err == nil {
+			err = c.dispatch(packet)
+		}
+	}
+
+	c.lock.Lock()
+	c.err = err
+	channels := c.channels
+	c.channels = make(map[uint32]*muxChannel)
+	c.lock.Unlock()
+
+	for _, ch := range channels {
+		ch.closed()
+	}
+	close(c.closed)
+	close(c.chans)
+	close(c.reqs)
+	_ = c.conn.Close()
+}
+
+func (c *muxConn) dispatch(packet []byte) error {
+	switch packet[0] {
+	case msgGlobalRequest:
+		var msg globalRequestMsg
+		if err := ssh.Unmarshal(packet, &msg); err != nil {
+			return err
+		}
+		if msg.WantReply {
+			return c.write([]byte{msgRequestFailure})
+		}
+		return nil
+	case msgChannelOpen:
+		var msg channelOpenMsg
+		if err := ssh.Unmarshal(packet, &msg); err != nil {
+			return err
+		}
+		return c.write(ssh.Marshal(channelOpenFailureMsg{
+			PeersID: msg.SenderID,
+			Reason:  uint32(ssh.Prohibited),
+			Message: "commando accepts no channels",
+		}))
+	}
+
+	if len(packet) < 5 {
+		return errors.Errorf("malformed message %d from ControlMaster", packet[0])
+	}
+	ch := c.channel(binary.BigEndian.Uint32(packet[1:]))
+	if ch == nil {
+		return nil // of a channel which was already forgotten
+	}
+	return ch.dispatch(packet)
+}
+
+// A muxChannel is a channel of a muxConn.
+type muxChannel struct {
+	conn     *muxConn
+	id       uint32
+	opened   chan error
+	requests chan *ssh.Request
+
+	lock      sync.Mutex
+	cond      *sync.Cond
+	peerID    uint32
+	window    uint32 // which the peer allows to be sent
+	maxPacket uint32
+	replies   []chan bool
+	sentEOF   bool
+	sentClose bool
+	done      bool
+
+	stdout *muxBuffer
+	stderr *muxBuffer
+}
+
+func newMuxChannel(conn *muxConn, id uint32) *muxChannel {
+	ch := &muxChannel{
+		conn:     conn,
+		id:       id,
+		opened:   make(chan error, 1),
+		requests: make(chan *ssh.Request, muxRequestQueue),
+	}
+	ch.cond = sync.NewCond(&ch.lock)
+	ch.stdout = newMuxBuffer(ch)
+	ch.stderr = newMuxBuffer(ch)
+	return ch
+}
+
+func (ch *muxChannel) dispatch(packet []byte) error {
+	switch msg := packet[0]; msg {
+	case 91:
+		var m channelOpenConfirmMsg
+		if err := ssh.Unmarshal(packet, &m); err != nil {
+			return err
+		}
+		ch.lock.Lock()
+		ch.peerID, ch.window, ch.maxPacket = m.MyID, m.Window, m.MaxPacket
+		ch.lock.Unlock()
+		ch.opened <- nil
+	case 92:
+		var m channelOpenFailureMsg
+		if err := ssh.Unmarshal(packet, &m); err != nil {
+			return err
+		}
+		ch.conn.forget(ch.id)
+		ch.opened <- &ssh.OpenChannelError{Reason: ssh.RejectionReason(m.Reason), Message: m.Message}
+	case 93:
+		var m windowAdjustMsg
+		if err := ssh.Unmarshal(packet, &m); err != nil {
+			return err
+		}
+		ch.lock.Lock()
+		ch.window += m.Bytes
+		ch.cond.Broadcast()
+		ch.lock.Unlock()
+	case 94:
+		var m channelDataMsg
+		if err := ssh.Unmarshal(packet, &m); err != nil {
+			return err
+		}
+		ch.stdout.write(m.Data)
+	case 95:
+		var m channelExtendedDataMsg
+		if err := ssh.Unmarshal(packet, &m); err != nil {
+			return err
+		}
+		if m.Code == 1 {
+			ch.stderr.write(m.Data)
+		}
+	case 96:
+		ch.stdout.eof()
+		ch.stderr.eof()
+	case 97:
+		ch.conn.forget(ch.id)
+		err := ch.send(channelCloseMsg{}, func() bool {
+			sent := ch.sentClose
+			ch.sentClose = true
+			return !sent
+		})
+		ch.closed()
+		return err
+	case 98:
+		var m channelRequestMsg
+		if err := ssh.Unmarshal(packet, &m); err != nil {
+			return err
+		}
+		if m.WantReply {
+			// which sessions would decline anyway, e.g. keepalives
+			if err := ch.send(channelReplyMsg{}, nil, 100); err != nil {
+				return err
+			}
+		}
+		ch.requests <- &ssh.Request{Type: m.Request, Payload: m.Payload}
+	case 99, 100:
+		ch.lock.Lock()
+		if len(ch.replies) > 0 {
+			ch.replies[0] <- msg == msgChannelSuccess
+			ch.replies = ch.replies[1:]
+		}
+		ch.lock.Unlock()
+	}
+	return nil
+}
+
+// send msg to the peer of ch, as message type (or that of msg if none),
+// if once (if set) returns true while holding the lock of ch.
+func (ch *muxChannel) send(msg interface{}, once func() bool, msgType ...byte) error {
+	ch.lock.Lock()
+	if once != nil && !once() {
+		ch.lock.Unlock()
+		return nil
+	}
+	bs := ssh.Marshal(msg)
+	binary.BigEndian.PutUint32(bs[1:], ch.peerID)
+	ch.lock.Unlock()
+
+	if len(msgType) > 0 {
+		bs[0] = msgType[0]
+	}
+	return ch.conn.write(bs)
+}
+
+// closed releases everything waiting on ch, once it is closed.
+func (ch *muxChannel) closed() {
+	ch.lock.Lock()
+	if ch.done {
+		ch.lock.Unlock()
+		return
+	}
+	ch.done = true
+	for _, reply := range ch.replies {
+		close(reply)
+	}
+	ch.replies = nil
+	ch.cond.Broadcast()
+	ch.lock.Unlock()
+
+	ch.stdout.eof()
+	ch.stderr.eof()
+	close(ch.requests)
+	select {
+	case ch.opened <- io.EOF:
+	default:
+	}
+}
+
+func (ch *muxChannel) Read(data []byte) (int, error) {
+	return ch.stdout.Read(data)
+}
+
+func (ch *muxChannel) Write(data []byte) (int, error) {
+	return ch.write(data, 0)
+}
+
+// write data as extended data of code (or plain data if 0), in packets
+// of at most the size and window allowed by the peer.
+func (ch *muxChannel) write(data []byte, code uint32) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		ch.lock.Lock()
+		for ch.window == 0 && !ch.done && !ch.sentEOF {
+			ch.cond.Wait()
+		}
+		if ch.done || ch.sentEOF {
+			ch.lock.Unlock()
+			return written, io.EOF
+		}
+		n := uint32(len(data))
+		if n > ch.window {
+			n = ch.window
+		}
+		if ch.maxPacket > 0 && n > ch.maxPacket {
+			n = ch.maxPacket
+		}
+		ch.window -= n
+		ch.lock.Unlock()
+
+		var msg interface{} = channelDataMsg{Data: data[:n]}
+		if code != 0 {
+			msg = channelExtendedDataMsg{Code: code, Data: data[:n]}
+		}
+		if err := ch.send(msg, nil); err != nil {
+			return written, err
+		}
+		written += int(n)
+		data = data[n:]
+	}
+	return written, nil
+}
+
+func (ch *muxChannel) Close() error {
+	return ch.send(channelCloseMsg{}, func() bool {
+		sent := ch.sentClose || ch.done
+		ch.sentClose = true
+		return !sent
+	})
+}
+
+func (ch *muxChannel) CloseWrite() error {
+	return ch.send(channelEOFMsg{}, func() bool {
+		sent := ch.sentEOF || ch.done
+		ch.sentEOF = true
+		ch.cond.Broadcast()
+		return !sent
+	})
+}
+
+func (ch *muxChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	var reply chan bool
+	err := ch.send(channelRequestMsg{Request: name, WantReply: wantReply, Payload: payload}, func() bool {
+		if wantReply && !ch.done {
+			reply = make(chan bool, 1)
+			ch.replies = append(ch.replies, reply)
+		}
+		return !ch.done
+	})
+	if err != nil || !wantReply {
+		return false, err
+	}
+	if reply == nil {
+		return false, io.EOF
+	}
+	ok, replied := <-reply
+	if !replied {
+		return false, io.EOF
+	}
+	return ok, nil
+}
+
+func (ch *muxChannel) Stderr() io.ReadWriter {
+	return muxStderr{ch}
+}
+
+type muxStderr struct {
+	ch *muxChannel
+}
+
+func (s muxStderr) Read(data []byte) (int, error) {
+	return s.ch.stderr.Read(data)
+}
+
+func (s muxStderr) Write(data []byte) (int, error) {
+	return s.ch.write(data, 1)
+}
+
+// A muxBuffer holds data received on a channel until it is read, opening
+// the window of the channel by as much as is read.
+type muxBuffer struct {
+	ch    *muxChannel
+	lock  sync.Mutex
+	cond  *sync.Cond
+	data  []byte
+	ended bool
+}
+
+func newMuxBuffer(ch *muxChannel) *muxBuffer {
+	b := &muxBuffer{ch: ch}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+func (b *muxBuffer) write(data []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.data = append(b.data, data...)
+	b.cond.Broadcast()
+}
+
+func (b *muxBuffer) eof() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.ended = true
+	b.cond.Broadcast()
+}
+
+func (b *muxBuffer) Read(data []byte) (int, error) {
+	b.lock.Lock()
+	for len(b.data) == 0 && !b.ended {
+		b.cond.Wait()
+	}
+	if len(b.data) == 0 {
+		b.lock.Unlock()
+		return 0, io.EOF
+	}
+	n := copy(data, b.data)
+	b.data = b.data[n:]
+	b.lock.Unlock()
+
+	_ = b.ch.send(windowAdjustMsg{Bytes: uint32(n)}, func() bool {
+		return !b.ch.done && !b.ch.sentClose
+	})
+	return n, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_expandControlPath(t *testing.T) {
+	cfg := sshHost{hostName: "web1.example.com", port: "2222"}
+	require.Equal(t, "/tmp/cm-deploy@web1.example.com:2222 (web1) 100%", expandControlPath("/tmp/cm-%r@%h:%p (%n) 100%%", "web1", cfg, "deploy"))
+	require.Len(t, filepath.Base(expandControlPath("/tmp/%C", "web1", cfg, "deploy")), 40)
+
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(home, ".ssh", "cm-web1"), expandControlPath("~/.ssh/cm-%n", "web1", cfg, "deploy"))
+}
+
+// fakeControlMaster accepts a connection at path, and answers exec
+// requests of sessions on it with output, as a ControlMaster in proxy
+// mode relays the server would.
+func fakeControlMaster(t *testing.T, path, output string) {
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, err := readMuxMessage(conn); err != nil {
+			return
+		}
+		_ = writeMuxMessage(conn, muxHello, muxVersion)
+		proxy, err := readMuxMessage(conn)
+		if err != nil {
+			return
+		}
+		_ = writeMuxMessage(conn, muxProxyReply, binary.BigEndian.Uint32(proxy[4:]))
+
+		const serverID = 7
+		var clientID uint32
+		packets := &muxConn{conn: conn}
+		send := func(msg interface{}) {
+			_ = packets.write(ssh.Marshal(msg))
+		}
+		for {
+			packet, err := packets.read()
+			if err != nil {
+				return
+			}
+			switch packet[0] {
+			case 90:
+				var open channelOpenMsg
+				_ = ssh.Unmarshal(packet, &open)
+				clientID = open.SenderID
+				send(channelOpenConfirmMsg{PeersID: clientID, MyID: serverID, Window: 1 << 20, MaxPacket: 1 << 15})
+			case 98:
+				var req channelRequestMsg
+				_ = ssh.Unmarshal(packet, &req)
+				if req.WantReply {
+					send(channelReplyMsg{PeersID: clientID})
+				}
+				if req.Request == "exec" {
+					send(channelDataMsg{PeersID: clientID, Data: []byte(output)})
+					send(channelRequestMsg{PeersID: clientID, Request: "exit-status", Payload: []byte{0, 0, 0, 3}})
+					send(channelEOFMsg{PeersID: clientID})
+					send(channelCloseMsg{PeersID: clientID})
+				}
+			}
+		}
+	}()
+}
+
+func Test_dialControlMaster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cm-web1")
+	fakeControlMaster(t, path, "hello from web1\n")
+
+	conn, err := dialControlMaster(path, "deploy")
+	require.NoError(t, err)
+	client := ssh.NewClient(conn, conn.chans, conn.reqs)
+	defer client.Close()
+	require.Equal(t, "deploy", client.User())
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	output, err := session.Output("hostname")
+	require.Equal(t, "hello from web1\n", string(output))
+	exit, ok := err.(*ssh.ExitError)
+	require.True(t, ok, "%v", err)
+	require.Equal(t, 3, exit.ExitStatus())
+
+	ok, _, err = client.SendRequest(keepaliveRequest, true, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
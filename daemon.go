@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveInterval is how often pooled connections are checked.
+const keepaliveInterval = 30 * time.Second
+
+func defaultSocket() string {
+	return filepath.Join(filepath.Dir(runsDir()), "daemon.sock")
+}
+
+// A daemonRequest is submitted to the daemon over its socket, which
+// responds with one line of JSON per result, and closes the connection.
+type daemonRequest struct {
+	Command string `json:"command"`
+	Hosts   string `json:"hosts,omitempty"` // all hosts of the daemon if empty
+}
+
+// A pool keeps connections to hosts open, so that commands submitted
+// to the daemon run without the overhead of dialing and authenticating.
+type pool struct {
+	r *runner
+
+	lock    sync.Mutex
+	clients map[string]*ssh.Client
+}
+
+func newPool(r *runner) *pool {
+	return &pool{r: r, clients: make(map[string]*ssh.Client)}
+}
+
+// client returns the pooled connection to host, dialing it if necessary.
+func (p *pool) client(host string) (*ssh.Client, error) {
+	p.lock.Lock()
+	client, exists := p.clients[host]
+	p.lock.Unlock()
+	if exists {
+		return client, nil
+	}
+
+	client, err := p.r.dial(host)
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if existing, exists := p.clients[host]; exists {
+		_ = client.Close() // dialed concurrently
+		return existing, nil
+	}
+	p.clients[host] = client
+	return client, nil
+}
+
+// drop the pooled connection to host, e.g. because it broke.
+func (p *pool) drop(host string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if client, exists := p.clients[host]; exists {
+		_ = client.Close()
+		delete(p.clients, host)
+	}
+}
+
+// keepalive checks the pooled connections periodically, dropping broken
+// ones and dialing them again, so they are warm when a command arrives.
+func (p *pool) keepalive(hosts []string) {
+	for range time.Tick(keepaliveInterval) {
+		for _, host := range hosts {
+			client, err := p.client(host)
+			if err != nil {
+				continue
+			}
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				p.drop(host)
+				_, _ = p.client(host)
+			}
+		}
+	}
+}
+
+// execute command on hosts over the pooled connections, at most parallel
+// hosts at a time, retrying once on a fresh connection if a pooled one broke.
+func (p *pool) execute(args args, hosts []string, command string) []result {
+	run := p.runner(args, hosts, &quiet{})
+	p.each(run, hosts, args.parallel, func(client *ssh.Client, host string) error {
+		return run.executeCommand(client, host, command, false, nil)
+	})
+	return run.results
+}
+
+// runner returns a runner of a request to the pool, rendering to out.
+func (p *pool) runner(args args, hosts []string, out renderer) *runner {
+	run := newRunner(args, p.r.pass, p.r.inventory, out)
+	run.id, run.index, run.source = p.r.id, indexes(hosts), p.r.source
+	return run
+}
+
+// each calls fn with the pooled connection to each of hosts, at most
+// parallel hosts at a time, returning the failures of fn (if any).
+func (p *pool) each(run *runner, hosts []string, parallel int, fn func(client *ssh.Client, host string) error) error {
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		failed failures
+		fatal  error
+	)
+	slots := make(chan struct{}, parallel)
+	for _, host := range hosts {
+		slots <- struct{}{}
+		p.r.waitIfPaused()
+		if run.waitIfPaused(); run.isCancelled() {
+			break
+		}
+		wg.Add(1)
+		go func(host string) {
+			defer func() { <-slots; wg.Done() }()
+			err := p.executeOn(run, host, fn)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if f, ok := err.(failures); ok {
+				failed = append(failed, f...)
+			} else if err != nil && fatal == nil {
+				fatal = err
+			}
+		}(host)
+	}
+	wg.Wait()
+
+	if run.isCancelled() {
+		return errCancelled
+	}
+	if fatal != nil {
+		return fatal
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+func (p *pool) executeOn(run *runner, host string, fn func(client *ssh.Client, host string) error) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		client, err := p.client(host)
+		if err != nil {
+			run.record(result{Host: host, ExitCode: -1, Error: err.Error()})
+			return err
+		}
+
+		// a broken connection fails to open a session, so nothing has run yet
+		session, err := client.NewSession()
+		if err != nil {
+			p.drop(host)
+			continue
+		}
+		_ = session.Close()
+
+		password := p.r.password(host)
+		run.lock.Lock()
+		run.passwords[host] = password
+		run.lock.Unlock()
+
+		return fn(client, host)
+	}
+	run.record(result{Host: host, ExitCode: -1, Error: "connection lost"})
+	return errors.Errorf("connection to %s lost", host)
+}
+
+// serveDaemon accepts requests on listener, running each with execute.
+func serveDaemon(listener net.Listener, execute func(daemonRequest) ([]result, error)) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func(conn net.Conn) {
+			defer func() { _ = conn.Close() }()
+			encoder := json.NewEncoder(conn)
+
+			var request daemonRequest
+			if err := json.NewDecoder(conn).Decode(&request); err != nil {
+				_ = encoder.Encode(result{ExitCode: -1, Error: "malformed request: " + err.Error()})
+				return
+			}
+
+			results, err := execute(request)
+			if err != nil {
+				_ = encoder.Encode(result{ExitCode: -1, Error: err.Error()})
+				return
+			}
+			for _, res := range results {
+				_ = encoder.Encode(res)
+			}
+		}(conn)
+	}
+}
+
+// daemonCmd implements "commando daemon", which keeps connections to the
+// hosts of a fleet open, and runs commands submitted over a unix socket
+// with "commando submit".
+func daemonCmd(arguments []string) error {
+	var args args
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	refresh := poolFlags(flags, &args)
+	socket := flags.String("socket", defaultSocket(), "unix socket to accept commands on")
+	_ = flags.Parse(arguments)
+
+	p, hosts, err := startPool(args, *refresh)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*socket), 0700); err != nil {
+		return errors.Wrap(err, "failed to create socket directory")
+	}
+	_ = os.Remove(*socket) // left behind by a previous daemon
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen on socket")
+	}
+	defer func() { _ = listener.Close() }()
+	if err := os.Chmod(*socket, 0600); err != nil {
+		return errors.Wrap(err, "failed to restrict socket")
+	}
+
+	// requests may be paused (but not cancelled) through the id of the daemon
+	if c, err := openControl(p.r.id); err != nil {
+		colors.failure.println("daemon cannot be paused: %v", err)
+	} else {
+		defer c.close()
+		go p.r.watchPauses(c, nil)
+	}
+
+	colors.info.println("daemon of %d hosts listening on %s, run id %s", len(hosts), *socket, p.r.id)
+	return serveDaemon(listener, func(request daemonRequest) ([]result, error) {
+		targeted := hosts
+		if request.Hosts != "" {
+			var err error
+			if targeted, err = hostsOf(request.Hosts, p.r.inventory, hosts); err != nil {
+				return nil, err
+			}
+		}
+		return p.execute(args, targeted, request.Command), nil
+	})
+}
+
+// hostsOf resolves a host expression of a request, which may include the
+// groups of the inventory, to hosts of pool, which are the only hosts
+// requests may run on. Hosts are not discovered for requests, as they
+// would be dialed with the credentials of the pool.
+func hostsOf(expression string, inv inventory, pool []string) ([]string, error) {
+	pooled := make(map[string]bool, len(pool))
+	for _, host := range pool {
+		pooled[host] = true
+	}
+
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, raw := range strings.Split(expression, ",") {
+		raw = strings.TrimSpace(raw)
+
+		var resolved []string
+		if idx := strings.Index(raw, ":"); idx > 0 {
+			if _, exists := providers[raw[:idx]]; exists {
+				return nil, errors.Errorf("hosts of requests may not be discovered, as %q is", raw)
+			}
+		}
+		if strings.HasPrefix(raw, "group:") {
+			resolved = inv.group(strings.TrimPrefix(raw, "group:"))
+		} else {
+			resolved = expand(raw)
+		}
+
+		for _, host := range resolved {
+			if !pooled[host] {
+				return nil, errors.Errorf("%s is not one of the hosts served", host)
+			}
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errors.Errorf("no hosts match %q", expression)
+	}
+	return hosts, nil
+}
+
+// submitCmd implements "commando submit", which runs a command on the
+// hosts of a running daemon.
+func submitCmd(arguments []string) error {
+	var request daemonRequest
+	flags := flag.NewFlagSet("submit", flag.ExitOnError)
+	flags.StringVar(&request.Hosts, "hosts", "", "run on only these of the daemon's hosts")
+	socket := flags.String("socket", defaultSocket(), "unix socket of the daemon")
+	positional := parseInterspersed(flags, arguments)
+
+	if len(positional) != 1 {
+		return errors.Errorf("usage: commando submit [--hosts hosts] <command>")
+	}
+	request.Command = positional[0]
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to daemon")
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return errors.Wrap(err, "failed to submit command")
+	}
+
+	out := &console{}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	failed := 0
+	for scanner.Scan() {
+		var res result
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			return errors.Wrap(err, "malformed response from daemon")
+		}
+		if res.Host == "" {
+			return errors.New(res.Error)
+		}
+
+		out.begin(res.Host, "")
+		if res.Output != "" {
+			out.output(res.Host, res.Output)
+		}
+		if res.Error != "" {
+			failed++
+			out.warning("%s", res.Error)
+		}
+		out.result(res)
+		out.end(res.Host, "")
+	}
+
+	if failed > 0 {
+		return errors.Errorf("command failed on %d hosts", failed)
+	}
+	return scanner.Err()
+}
+
+// poolFlags declares the flags of the hosts and credentials of a pool on
+// flags, returning how often the pool is refreshed.
+func poolFlags(flags *flag.FlagSet, args *args) *time.Duration {
+	flags.StringVar(&args.user, "user", os.Getenv("USER"), "ssh username")
+	flags.StringVar(&args.hostList, "hosts", "", "the list of hosts")
+	flags.StringVar(&args.inventory, "inventory", "", "file listing hosts and their metadata")
+	flags.BoolVar(&args.pw, "pw", false, "prompt for a password")
+	flags.IntVar(&args.parallel, "parallel", 20, "run on this many hosts at a time")
+	flags.StringVar(&args.locale, "locale", "C", "set LC_ALL to this locale for commands (empty to keep the locale of each host)")
+	flags.StringVar(&args.key, "key", "", "private key to authenticate with")
+	flags.StringVar(&args.cert, "cert", "", "signed OpenSSH certificate of --key, reloaded on every refresh")
+	flags.StringVar(&args.hostCA, "host-ca", "", "file of certificate authorities which must have signed the certificates of hosts")
+	flags.StringVar(&args.vaultPath, "vault-path", "", "fetch ssh password and/or private key from this HashiCorp Vault path")
+	flags.StringVar(&args.secretPlugin, "secret-plugin", "", "fetch ssh password and/or private key from this plugin")
+	return flags.Duration("refresh", 10*time.Minute, "renew credentials and connections this often (0 to never)")
+}
+
+// startPool connects to the hosts of args (of the daemon or server) in
+// the background, keeping the connections alive and renewing them and
+// their credentials every refresh.
+func startPool(args args, refresh time.Duration) (*pool, []string, error) {
+	if args.hostList == "" && args.inventory == "" {
+		return nil, nil, errors.Errorf("--hosts or --inventory is required")
+	}
+	if args.parallel < 1 {
+		return nil, nil, errors.Errorf("--parallel must be at least 1")
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		var err error
+		if inv, err = loadInventory(args.inventory); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	hosts, err := targets(args, inv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pswd string
+	if args.pw {
+		if pswd, err = easyPrompt(args.user); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	r := newRunner(args, pswd, inv, &quiet{})
+	if r.hostKeys, err = hostKeyCallback(args.hostCA); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case args.vaultPath != "":
+		if r.source, err = newVault(args.vaultPath); err != nil {
+			return nil, nil, err
+		}
+	case args.secretPlugin != "":
+		if r.source, err = findPlugin(args.secretPlugin); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if args.key != "" {
+		signer, err := loadSigner(args.key, args.cert)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.signers = []ssh.Signer{signer}
+	}
+
+	p := newPool(r)
+	for _, host := range hosts {
+		go func(host string) {
+			if _, err := p.client(host); err != nil {
+				colors.failure.println("%s: %v", host, err)
+			}
+		}(host)
+	}
+	go p.keepalive(hosts)
+	if refresh > 0 {
+		go p.refresh(args, hosts, refresh)
+	}
+	return p, hosts, nil
+}
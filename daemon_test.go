@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_serveDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	socket := filepath.Join(dir, "daemon.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		_ = serveDaemon(listener, func(request daemonRequest) ([]result, error) {
+			hosts, err := hostsOf(request.Hosts, inventory{}, []string{"web1", "web2", "web3"})
+			if err != nil {
+				return nil, err
+			}
+			results := make([]result, 0, len(hosts))
+			for _, host := range hosts {
+				results = append(results, result{Host: host, Command: request.Command, Output: "ok"})
+			}
+			return results, nil
+		})
+	}()
+
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	require.NoError(t, json.NewEncoder(conn).Encode(daemonRequest{Command: "uptime", Hosts: "web{1..2}"}))
+
+	var results []result
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var res result
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &res))
+		results = append(results, res)
+	}
+	require.Equal(t, []result{
+		{Host: "web1", Command: "uptime", Output: "ok"},
+		{Host: "web2", Command: "uptime", Output: "ok"},
+	}, results)
+}
+
+func Test_hostsOf(t *testing.T) {
+	inv, err := parseInventory("web{1..2} role=web\ndb1 role=db\nadmin1 role=admin\n")
+	require.NoError(t, err)
+	pool := []string{"web1", "web2", "db1"}
+
+	hosts, err := hostsOf("group:web, db1, web1", inv, pool)
+	require.NoError(t, err)
+	require.Equal(t, []string{"web1", "web2", "db1"}, hosts)
+
+	_, err = hostsOf("group:admin", inv, pool)
+	require.EqualError(t, err, "admin1 is not one of the hosts served")
+	_, err = hostsOf("web{1..3}", inv, pool)
+	require.EqualError(t, err, "web3 is not one of the hosts served")
+	_, err = hostsOf("aws:tag:role=web", inv, pool)
+	require.EqualError(t, err, `hosts of requests may not be discovered, as "aws:tag:role=web" is`)
+	_, err = hostsOf("group:none", inv, pool)
+	require.EqualError(t, err, `no hosts match "group:none"`)
+}
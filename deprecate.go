@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sunsetLayout is the layout of the dates of "# sunset:".
+const sunsetLayout = "2006-01-02"
+
+// configure sets the directives of sf which apply to the whole script file
+// rather than to the step declaring them: "# deprecated: use 05-new-restart"
+// and "# sunset: 2025-06-30", after which the script no longer runs, and
+// those of its metadata header, listed by "commando list", if ds are of
+// the header, i.e. the first step. Metadata without a value, or of later
+// steps, is left as a comment.
+func (sf *scriptfile) configure(ds []directive, header bool) error {
+	for _, d := range ds {
+		switch d.key {
+		case "name", "description", "owner", "danger":
+			if !header || d.value == "" {
+				continue
+			}
+		}
+
+		switch d.key {
+		case "name", "description", "owner":
+			sf.meta[d.key] = d.value
+		case "danger":
+			if !isDangerLevel(d.value) {
+				return errors.Errorf("malformed danger %q, must be one of %s", d.value, strings.Join(dangerLevels, ", "))
+			}
+			sf.meta[d.key] = d.value
+		case "deprecated":
+			if d.value == "" {
+				return errors.Errorf("deprecated requires a reason, e.g. use 05-new-restart")
+			}
+			sf.deprecated = d.value
+		case "sunset":
+			sunset, err := time.Parse(sunsetLayout, d.value)
+			if err != nil {
+				return errors.Errorf("malformed sunset %q, expected a date e.g. 2025-06-30", d.value)
+			}
+			sf.sunset = sunset
+		}
+	}
+	return nil
+}
+
+// deprecation returns a warning if sf is deprecated, or an error once the
+// day of its sunset has passed.
+func (sf scriptfile) deprecation(now time.Time) (string, error) {
+	if sf.deprecated == "" && sf.sunset.IsZero() {
+		return "", nil
+	}
+
+	reason := ""
+	if sf.deprecated != "" {
+		reason = ": " + sf.deprecated
+	}
+	if sf.sunset.IsZero() {
+		return "script " + sf.name + " is deprecated" + reason, nil
+	}
+
+	sunset := sf.sunset.Format(sunsetLayout)
+	if now.Format(sunsetLayout) > sunset {
+		return "", errors.Errorf("script %s was sunset on %s%s", sf.name, sunset, reason)
+	}
+	return "script " + sf.name + " is deprecated, until " + sunset + reason, nil
+}
+
+// deprecations returns warnings of the deprecated scripts among scripts, or
+// an error if any is past its sunset, unless allowSunset is set.
+func deprecations(scripts []scriptfile, now time.Time, allowSunset bool) ([]string, error) {
+	var warnings []string
+	for _, sf := range scripts {
+		warning, err := sf.deprecation(now)
+		switch {
+		case err != nil && !allowSunset:
+			return nil, errors.Errorf("%v (use --allow-sunset to run it anyway)", err)
+		case err != nil:
+			warnings = append(warnings, err.Error())
+		case warning != "":
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings, nil
+}
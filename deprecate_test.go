@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parse_deprecated(t *testing.T) {
+	sf, err := parse("03-restart", "# deprecated: use 05-new-restart\n# sunset: 2025-06-30\nservice app restart\n---\nuptime")
+	require.NoError(t, err)
+	require.Equal(t, "use 05-new-restart", sf.deprecated)
+	require.Equal(t, "2025-06-30", sf.sunset.Format(sunsetLayout))
+	require.Len(t, sf.scripts, 2)
+
+	_, err = parse("bad", "# sunset: next year\nuptime")
+	require.Error(t, err)
+}
+
+func Test_deprecations(t *testing.T) {
+	now := time.Date(2025, 6, 30, 18, 0, 0, 0, time.UTC)
+	scripts := []scriptfile{
+		{name: "01-check"},
+		{name: "02-upgrade", deprecated: "use 06-upgrade"},
+		{name: "03-restart", deprecated: "use 05-new-restart", sunset: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)},
+	}
+
+	warnings, err := deprecations(scripts, now, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"script 02-upgrade is deprecated: use 06-upgrade",
+		"script 03-restart is deprecated, until 2025-06-30: use 05-new-restart",
+	}, warnings)
+
+	_, err = deprecations(scripts, now.AddDate(0, 0, 1), false)
+	require.EqualError(t, err, "script 03-restart was sunset on 2025-06-30: use 05-new-restart (use --allow-sunset to run it anyway)")
+
+	warnings, err = deprecations(scripts, now.AddDate(0, 0, 1), true)
+	require.NoError(t, err)
+	require.Equal(t, "script 03-restart was sunset on 2025-06-30: use 05-new-restart", warnings[1])
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A variant is an output of a script shared by a group of hosts.
+type variant struct {
+	File    string   `json:"file,omitempty"`
+	Command string   `json:"command"`
+	Output  string   `json:"output"`
+	Hosts   []string `json:"hosts"`
+}
+
+// diff groups the hosts of results by identical output of each script.
+// The variants of each script are ordered from the most to the least
+// hosts, so that outliers come last.
+func diff(results []result) []variant {
+	var variants []variant
+	index := make(map[[3]string]int)
+	order := make(map[[2]string]int) // of scripts, as they first ran
+	for _, res := range results {
+		if res.Command == "" || res.Skipped != "" {
+			continue // the host could not be dialed, or the script did not run
+		}
+		script := [2]string{res.File, res.Command}
+		if _, exists := order[script]; !exists {
+			order[script] = len(order)
+		}
+		key := [3]string{res.File, res.Command, res.Output}
+		i, exists := index[key]
+		if !exists {
+			i = len(variants)
+			index[key] = i
+			variants = append(variants, variant{File: res.File, Command: res.Command, Output: res.Output})
+		}
+		variants[i].Hosts = append(variants[i].Hosts, res.Host)
+	}
+
+	sort.SliceStable(variants, func(i, j int) bool {
+		a := order[[2]string{variants[i].File, variants[i].Command}]
+		b := order[[2]string{variants[j].File, variants[j].Command}]
+		if a != b {
+			return a < b
+		}
+		return len(variants[i].Hosts) > len(variants[j].Hosts)
+	})
+	return variants
+}
+
+func printVariants(variants []variant) {
+	for i, v := range variants {
+		first := i == 0 || variants[i-1].File != v.File || variants[i-1].Command != v.Command
+		if first {
+			colors.info.println("=== %s `%s`", v.File, v.Command)
+		}
+
+		s := colors.success
+		if !first {
+			s = colors.failure // an outlier
+		}
+		hosts := &table{indent: "  "}
+		hosts.add(s, fmt.Sprintf("%d hosts:", len(v.Hosts)), strings.Join(v.Hosts, ", "))
+		hosts.print()
+
+		output := v.Output
+		if output == "" {
+			output = "<no output>"
+		}
+		for _, line := range strings.Split(output, "\n") {
+			colors.output.println("    %s", line)
+		}
+	}
+}
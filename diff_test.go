@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_diff(t *testing.T) {
+	results := []result{
+		{Host: "h1", File: "a", Command: "cat /etc/app.conf", Output: "x=1"},
+		{Host: "h1", File: "b", Command: "rpm -q app", Output: "app-1.2"},
+		{Host: "h2", File: "a", Command: "cat /etc/app.conf", Output: "x=2"},
+		{Host: "h2", File: "b", Command: "rpm -q app", Output: "app-1.2"},
+		{Host: "h3", File: "a", Command: "cat /etc/app.conf", Output: "x=2"},
+		{Host: "h3", File: "b", Command: "rpm -q app", Output: "app-1.2"},
+		{Host: "h4", ExitCode: -1, Error: "connection refused"},
+	}
+
+	require.Equal(t, []variant{
+		{File: "a", Command: "cat /etc/app.conf", Output: "x=2", Hosts: []string{"h2", "h3"}},
+		{File: "a", Command: "cat /etc/app.conf", Output: "x=1", Hosts: []string{"h1"}},
+		{File: "b", Command: "rpm -q app", Output: "app-1.2", Hosts: []string{"h1", "h2", "h3"}},
+	}, diff(results))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// filesPerHost is roughly how many files are open for each host run on
+// at a time: its connection, one to ssh-agent, and one per proxy jump.
+const filesPerHost = 3
+
+// filesReserved are the files open regardless of how many hosts are run
+// on, e.g. stdio, the state and history files, and webhooks.
+const filesReserved = 32
+
+// A diagnosis is the outcome of a check of "commando doctor": healthy
+// if it found no problem, or else the problem and how to fix it.
+// Warnings are problems which may not matter for every run.
+type diagnosis struct {
+	check   string
+	problem string
+	fix     string
+	warning bool
+}
+
+func (d diagnosis) healthy() bool {
+	return d.problem == ""
+}
+
+func (d diagnosis) String() string {
+	switch {
+	case d.healthy():
+		return "ok    " + d.check
+	case d.warning:
+		return fmt.Sprintf("warn  %s: %s\n      fix: %s", d.check, d.problem, d.fix)
+	}
+	return fmt.Sprintf("FAIL  %s: %s\n      fix: %s", d.check, d.problem, d.fix)
+}
+
+// diagnoseAgent checks ssh-agent is reachable at sock, and holds keys.
+func diagnoseAgent(sock string) diagnosis {
+	d := diagnosis{check: "ssh-agent"}
+	if sock == "" {
+		d.problem, d.warning = "SSH_AUTH_SOCK is not set", true
+		d.fix = `start an agent with eval "$(ssh-agent)" and add keys to it with ssh-add, or use --key`
+		return d
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		d.problem = fmt.Sprintf("agent at %s is unreachable: %v", sock, err)
+		d.fix = `start a new agent with eval "$(ssh-agent)", or unset SSH_AUTH_SOCK`
+		return d
+	}
+	defer func() { _ = conn.Close() }()
+
+	keys, err := agent.NewClient(conn).List()
+	switch {
+	case err != nil:
+		d.problem = fmt.Sprintf("failed to list keys of agent: %v", err)
+		d.fix = `start a new agent with eval "$(ssh-agent)"`
+	case len(keys) == 0:
+		d.problem, d.warning = "agent holds no keys", true
+		d.fix = "add keys to it with ssh-add, or use --key"
+	}
+	return d
+}
+
+// diagnoseKnownHosts checks the known_hosts file at path is readable and
+// well formed, if it exists.
+func diagnoseKnownHosts(path string) diagnosis {
+	d := diagnosis{check: "known_hosts " + path}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return d
+	}
+	if _, err := knownhosts.New(path); err != nil {
+		d.problem = err.Error()
+		d.fix = "fix or remove the offending line, e.g. with ssh-keygen -R <host>"
+	}
+	return d
+}
+
+// diagnoseFile checks the file at path loads, with load.
+func diagnoseFile(check, path, fix string, load func(string) error) diagnosis {
+	d := diagnosis{check: check + " " + path}
+	if err := load(path); err != nil {
+		d.problem, d.fix = err.Error(), fix
+	}
+	return d
+}
+
+// diagnoseProvider checks the hosts of expression raw can be discovered,
+// bypassing any cache of them.
+func diagnoseProvider(args args, inv inventory, raw string) diagnosis {
+	d := diagnosis{check: "hosts " + raw}
+	if strings.HasPrefix(raw, "aws:") {
+		if _, err := exec.LookPath("aws"); err != nil {
+			d.problem = "aws is not installed"
+			d.fix = "install the aws cli, and configure it with aws configure"
+			return d
+		}
+	}
+
+	args.hostList, args.noCache = raw, true
+	discovered, err := targets(args, inv)
+	switch {
+	case err != nil:
+		d.problem = err.Error()
+		d.fix = "check the provider is reachable and its credentials are set (see Host discovery in the README)"
+	case len(discovered) == 0:
+		d.problem, d.warning = "no hosts found", true
+		d.fix = "check the query matches any hosts"
+	}
+	return d
+}
+
+// diagnoseOpenFiles checks limit of open files is enough to run on
+// parallel hosts at a time.
+func diagnoseOpenFiles(limit uint64, parallel int) diagnosis {
+	d := diagnosis{check: fmt.Sprintf("open files limit %d for --parallel %d", limit, parallel)}
+	need := uint64(parallel*filesPerHost + filesReserved)
+	if limit >= need {
+		return d
+	}
+
+	d.problem = fmt.Sprintf("needs about %d open files", need)
+	d.fix = fmt.Sprintf("raise the limit with ulimit -n %d", need)
+	if limit > filesReserved {
+		d.fix += fmt.Sprintf(", or lower --parallel to %d", (limit-filesReserved)/filesPerHost)
+	}
+	return d
+}
+
+// doctorCmd implements "commando doctor", which checks the local
+// environment is ready to run on hosts, printing how to fix any problems.
+func doctorCmd(arguments []string) error {
+	var args args
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	flags.StringVar(&args.hostList, "hosts", "", "check the providers of these host expressions are reachable")
+	flags.StringVar(&args.inventory, "inventory", "", "check this inventory is valid")
+	flags.IntVar(&args.parallel, "parallel", 1, "check the limit of open files allows running on this many hosts at a time")
+	flags.StringVar(&args.key, "key", "", "check this private key loads")
+	flags.StringVar(&args.cert, "cert", "", "check this certificate of --key loads")
+	flags.StringVar(&args.hostCA, "host-ca", "", "check this file of host certificate authorities is valid")
+	flags.StringVar(&args.sshConfig, "ssh-config", "", "check this ssh_config is valid (default ~/.ssh/config)")
+	flags.StringVar(&args.awsRegion, "aws-region", "", "region of EC2 instances discovered by aws: hosts")
+	flags.StringVar(&args.awsAddress, "aws-address", "private", "address of EC2 instances discovered by aws: hosts")
+	_ = flags.Parse(arguments)
+
+	if args.cert != "" && args.key == "" {
+		return errors.New("--cert requires --key")
+	}
+
+	diagnoses := []diagnosis{diagnoseAgent(os.Getenv("SSH_AUTH_SOCK"))}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		diagnoses = append(diagnoses, diagnoseKnownHosts(filepath.Join(home, ".ssh", "known_hosts")))
+	}
+
+	if args.sshConfig == "" {
+		args.sshConfig = defaultSSHConfig()
+	}
+	if args.sshConfig != "none" {
+		diagnoses = append(diagnoses, diagnoseFile("ssh config", args.sshConfig, "fix the line, or use --ssh-config none", func(path string) error {
+			_, err := loadSSHConfig(path)
+			return err
+		}))
+	}
+
+	diagnoses = append(diagnoses, diagnoseFile("state", statePath(), "fix or remove the file, which loses any skips", func(path string) error {
+		_, err := loadState(path)
+		return err
+	}))
+
+	if args.key != "" {
+		diagnoses = append(diagnoses, diagnoseFile("key", args.key, "add encrypted keys to ssh-agent instead, with ssh-add", func(path string) error {
+			_, err := loadSigner(path, args.cert)
+			return err
+		}))
+	}
+
+	if args.hostCA != "" {
+		diagnoses = append(diagnoses, diagnoseFile("host certificate authorities", args.hostCA, "list authorities in authorized_keys format, or as @cert-authority lines", func(path string) error {
+			_, err := hostKeyCallback(path)
+			return err
+		}))
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		diagnoses = append(diagnoses, diagnoseFile("inventory", args.inventory, "fix the inventory (see Inventory in the README)", func(path string) error {
+			var err error
+			inv, err = loadInventory(path)
+			return err
+		}))
+	}
+
+	for _, raw := range strings.Split(args.hostList, ",") {
+		raw = strings.TrimSpace(raw)
+		if i := strings.Index(raw, ":"); i > 0 {
+			if _, exists := providers[raw[:i]]; exists {
+				diagnoses = append(diagnoses, diagnoseProvider(args, inv, raw))
+			}
+		}
+	}
+
+	if limit, ok := openFilesLimit(); ok {
+		diagnoses = append(diagnoses, diagnoseOpenFiles(limit, args.parallel))
+	}
+
+	problems := 0
+	for _, d := range diagnoses {
+		switch {
+		case d.healthy():
+			colors.success.println("%s", d)
+		case d.warning:
+			colors.notice.println("%s", d)
+		default:
+			problems++
+			colors.failure.println("%s", d)
+		}
+	}
+
+	if problems > 0 {
+		return errors.Errorf("found %d problems", problems)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_diagnoseAgent(t *testing.T) {
+	d := diagnoseAgent("")
+	require.False(t, d.healthy())
+	require.True(t, d.warning)
+
+	d = diagnoseAgent("/nonexistent/agent.sock")
+	require.False(t, d.healthy())
+	require.False(t, d.warning)
+}
+
+func Test_diagnoseKnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doctor")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "known_hosts")
+	require.True(t, diagnoseKnownHosts(path).healthy())
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("host1 ssh-ed25519 notbase64\n"), 0600))
+	d := diagnoseKnownHosts(path)
+	require.False(t, d.healthy())
+	require.NotEmpty(t, d.fix)
+}
+
+func Test_diagnoseOpenFiles(t *testing.T) {
+	require.True(t, diagnoseOpenFiles(1024, 100).healthy())
+
+	d := diagnoseOpenFiles(256, 100)
+	require.False(t, d.healthy())
+	require.Equal(t, "needs about 332 open files", d.problem)
+	require.Equal(t, "raise the limit with ulimit -n 332, or lower --parallel to 74", d.fix)
+}
+
+func Test_diagnoseFile(t *testing.T) {
+	d := diagnoseFile("inventory", "/nonexistent/fleet.txt", "fix it", func(path string) error {
+		_, err := loadInventory(path)
+		return err
+	})
+	require.False(t, d.healthy())
+	require.Equal(t, "fix it", d.fix)
+	require.Contains(t, d.String(), "FAIL  inventory /nonexistent/fleet.txt: ")
+}
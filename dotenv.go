@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultEnvFile is loaded automatically if it exists in the working directory.
+const defaultEnvFile = ".commando.env"
+
+// passwordKey is the dotenv key which sets the password, instead of prompting for it.
+const passwordKey = "COMMANDO_PASSWORD"
+
+// mask replaces secret values in output.
+const mask = "********"
+
+// secretSuffixes mark dotenv keys whose values are secrets,
+// which are masked wherever they appear in output.
+var secretSuffixes = []string{"_PASSWORD", "_SECRET", "_TOKEN", "_KEY"}
+
+func isSecret(key string) bool {
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// A dotenv holds the variables loaded from dotenv files.
+type dotenv struct {
+	env      []string
+	secrets  []string
+	password string
+	budget   map[string]string // settings of --max-hosts and --max-duration
+}
+
+// loadDotenv loads .commando.env (if it exists) and each --env-file, in order.
+func loadDotenv(args args) (dotenv, error) {
+	var dot dotenv
+
+	paths := []string(args.envFiles)
+	if _, err := os.Stat(defaultEnvFile); err == nil {
+		paths = append([]string{defaultEnvFile}, paths...)
+	}
+
+	for _, path := range paths {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return dot, errors.Wrap(err, "failed to read env file")
+		}
+
+		vars, err := parseDotenv(string(bs))
+		if err != nil {
+			return dot, errors.Wrapf(err, "failed to parse env file %s", path)
+		}
+
+		for _, kv := range vars {
+			key, value, _ := splitEnv(kv)
+			if isSecret(key) && value != "" {
+				dot.secrets = append(dot.secrets, value)
+			}
+			if key == passwordKey {
+				dot.password = value
+				continue
+			}
+			if key == maxHostsKey || key == maxDurationKey {
+				if dot.budget == nil {
+					dot.budget = make(map[string]string)
+				}
+				dot.budget[key] = value
+				continue
+			}
+			dot.env = append(dot.env, kv)
+		}
+	}
+
+	return dot, nil
+}
+
+// parseDotenv parses the KEY=VALUE lines of a dotenv file, which may be
+// prefixed with "export", and have values in single or double quotes.
+// References to variables in values which are not single quoted are
+// interpolated, with those of the file above them or of the environment.
+func parseDotenv(content string) ([]string, error) {
+	var vars []string
+	parsed := make(map[string]string)
+	lookup := func(key string) (string, bool) {
+		if value, exists := parsed[key]; exists {
+			return value, true
+		}
+		return os.LookupEnv(key)
+	}
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		key, value, err := splitEnv(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", i+1)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		literal := false
+		switch {
+		case strings.HasPrefix(value, `"`):
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, errors.Errorf("malformed quoted value on line %d", i+1)
+			}
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, errors.Errorf("malformed quoted value on line %d", i+1)
+			}
+			value, literal = value[1:len(value)-1], true
+		default:
+			// strip trailing comments from unquoted values
+			if idx := strings.Index(value, " #"); idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+		}
+
+		if !literal {
+			if value, err = interpolate(value, lookup); err != nil {
+				return nil, errors.Wrapf(err, "line %d", i+1)
+			}
+		}
+		parsed[key] = value
+		vars = append(vars, key+"="+value)
+	}
+	return vars, nil
+}
+
+// redact masks each of the secrets wherever they appear in s.
+func redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.Replace(s, secret, mask, -1)
+		}
+	}
+	return s
+}
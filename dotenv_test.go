@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const dotenv1 = `
+# database
+export DB_HOST=db1.example.com
+DB_PASSWORD="s3cret \"quoted\""
+GREETING='hello world'
+REGION=us-east-1 # trailing comment
+`
+
+func Test_parseDotenv(t *testing.T) {
+	vars, err := parseDotenv(dotenv1)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"DB_HOST=db1.example.com",
+		`DB_PASSWORD=s3cret "quoted"`,
+		"GREETING=hello world",
+		"REGION=us-east-1",
+	}, vars)
+
+	vars, err = parseDotenv("DB_HOST=db1\nDB_URL=\"postgres://${DB_HOST}:${DB_PORT:-5432}\"\nRAW='${DB_HOST}'")
+	require.NoError(t, err)
+	require.Equal(t, []string{"DB_HOST=db1", "DB_URL=postgres://db1:5432", "RAW=${DB_HOST}"}, vars)
+
+	_, err = parseDotenv("NOT A VARIABLE")
+	require.Error(t, err)
+}
+
+func Test_redact(t *testing.T) {
+	require.True(t, isSecret("DB_PASSWORD"))
+	require.False(t, isSecret("DB_HOST"))
+	require.Equal(t, "password is ********", redact("password is hunter2", []string{"hunter2"}))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+func splitEnv(kv string) (string, string, error) {
+	idx := strings.Index(kv, "=")
+	if idx < 1 {
+		return "", "", errors.Errorf("malformed environment variable %q, expected KEY=VALUE", kv)
+	}
+	return kv[:idx], kv[idx+1:], nil
+}
+
+// withEnv prepends the globally set environment variables to those
+// declared by each script, so that scripts may override global values.
+func withEnv(files []scriptfile, env []string) []scriptfile {
+	if len(env) == 0 {
+		return files
+	}
+	for i := range files {
+		for j := range files[i].scripts {
+			s := &files[i].scripts[j]
+			s.env = append(append([]string{}, env...), s.env...)
+		}
+	}
+	return files
+}
+
+// withLocale prepends LC_ALL to env for posix shells, so that tools
+// print untranslated output (and numbers) regardless of the locale of
+// each host. Scripts may still override it with an env directive.
+func withLocale(env []string, sh shell, locale string) []string {
+	if locale == "" || sh != shellSh {
+		return env
+	}
+	return append([]string{"LC_ALL=" + locale}, env...)
+}
+
+// setenv sets the environment of the session, returning the export
+// statements needed for any variables the server refused to accept
+// (e.g. because of a restrictive AcceptEnv in sshd_config).
+func setenv(session *ssh.Session, sh shell, env []string) []string {
+	var exports []string
+	for _, kv := range env {
+		key, value, err := splitEnv(kv)
+		if err != nil {
+			continue
+		}
+		if err := session.Setenv(key, value); err != nil {
+			exports = append(exports, sh.export(key, value))
+		}
+	}
+	return exports
+}
+
+// quote s so that it is interpreted literally by a posix shell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const execUsage = "usage: commando exec [flags] <command> --hosts hosts"
+
+// execCmd implements "commando exec", which runs a command across hosts,
+// and then opens a viewer of the result of every host, in which results
+// may be paged through, filtered, searched, and run again. Unless output
+// is a terminal, results are printed as usual instead.
+func execCmd(arguments []string) error {
+	var args args
+	flags := flag.NewFlagSet("exec", flag.ExitOnError)
+	flags.StringVar(&args.user, "user", os.Getenv("USER"), "ssh username")
+	flags.StringVar(&args.hostList, "hosts", "", "the list of hosts")
+	flags.StringVar(&args.inventory, "inventory", "", "file listing hosts and their metadata")
+	flags.BoolVar(&args.pw, "pw", false, "prompt for a password, and send it to the command on stdin")
+	flags.IntVar(&args.parallel, "parallel", 10, "run on this many hosts at a time")
+	flags.DurationVar(&args.timeout, "timeout", 0, "terminate the command if it runs longer than this (0 for no timeout)")
+	positional := parseInterspersed(flags, arguments)
+
+	if len(positional) == 0 {
+		return errors.New(execUsage)
+	}
+	command := strings.Join(positional, " ")
+
+	if args.hostList == "" && args.inventory == "" {
+		return errors.Errorf("--hosts or --inventory is required")
+	}
+	if args.parallel < 1 {
+		return errors.Errorf("--parallel must be at least 1")
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		var err error
+		if inv, err = loadInventory(args.inventory); err != nil {
+			return err
+		}
+	}
+
+	hosts, err := targets(args, inv)
+	if err != nil {
+		return err
+	}
+
+	var pswd string
+	if args.pw {
+		if pswd, err = easyPrompt(args.user); err != nil {
+			return err
+		}
+	}
+
+	interactive := terminal.IsTerminal(int(os.Stdin.Fd())) && terminal.IsTerminal(int(os.Stdout.Fd()))
+	var out renderer = &console{}
+	if interactive {
+		out = &quiet{}
+		colors.info.println("running `%s` on %d hosts", command, len(hosts))
+	}
+	r := newRunner(args, pswd, inv, out)
+	err = execOn(r, hosts, command, args.pw)
+	if !interactive {
+		return err
+	}
+
+	v := newViewer(command, r.results)
+	return v.loop(os.Stdin, os.Stdout, func(hosts []string) []result {
+		r.results = nil
+		_ = execOn(r, hosts, command, args.pw)
+		return r.results
+	})
+}
+
+// execOn runs command on hosts, on up to --parallel at a time. Unlike
+// scripts, a host on which the command fails does not stop the rest, and
+// the worst of the failures of hosts (of the highest exit status) is
+// returned once all have run.
+func execOn(r *runner, hosts []string, command string, pw bool) error {
+	var (
+		lock  sync.Mutex
+		wg    sync.WaitGroup
+		worst error
+	)
+	slots := make(chan struct{}, r.parallel)
+	for _, host := range hosts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(host string) {
+			defer func() { <-slots; wg.Done() }()
+			err := r.onHost(host, func(client *ssh.Client, host string) error {
+				return r.executeCommand(client, host, command, pw, nil)
+			})
+			if err == nil {
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if exitStatus(err) > exitStatus(worst) {
+				worst = errors.Wrapf(err, "failed to run %s on %s", command, host)
+			}
+		}(host)
+	}
+	wg.Wait()
+	return worst
+}
+
+// A viewer pages through the results of "commando exec", one per host,
+// listing hosts and showing the output of the host at the cursor.
+type viewer struct {
+	command  string
+	results  []result
+	selected map[string]bool
+
+	failedOnly bool
+	search     string
+	searching  bool   // while the search is typed
+	typed      string // of the search being typed
+	status     string // shown once, e.g. after running again
+
+	cursor int  // of the visible results
+	top    int  // first visible result listed
+	detail bool // whether the output of the host at the cursor is shown
+	scroll int  // first line of the output shown
+
+	width, height int
+}
+
+func newViewer(command string, results []result) *viewer {
+	v := &viewer{command: command, selected: make(map[string]bool), width: 80, height: 24}
+	v.merge(results)
+	return v
+}
+
+// merge replaces the results of the hosts of results, keeping hosts sorted.
+func (v *viewer) merge(results []result) {
+	byHost := make(map[string]int)
+	for i, res := range v.results {
+		byHost[res.Host] = i
+	}
+	for _, res := range results {
+		if i, exists := byHost[res.Host]; exists {
+			v.results[i] = res
+			continue
+		}
+		byHost[res.Host] = len(v.results)
+		v.results = append(v.results, res)
+	}
+	sort.SliceStable(v.results, func(i, j int) bool { return v.results[i].Host < v.results[j].Host })
+}
+
+// visible returns the results shown, with the filter and search applied.
+func (v *viewer) visible() []result {
+	var shown []result
+	for _, res := range v.results {
+		if v.failedOnly && res.ok() && res.ExitCode == 0 {
+			continue
+		}
+		if v.search != "" && !strings.Contains(strings.ToLower(res.Host+"\n"+res.Output+"\n"+res.Error), strings.ToLower(v.search)) {
+			continue
+		}
+		shown = append(shown, res)
+	}
+	return shown
+}
+
+// page is how many lines of hosts or output fit between the header and footer.
+func (v *viewer) page() int {
+	if v.height < 5 {
+		return 1
+	}
+	return v.height - 4
+}
+
+// key handles a key press, returning the hosts to run the command on
+// again, if any, and whether to quit.
+func (v *viewer) key(k string) ([]string, bool) {
+	v.status = ""
+	if v.searching {
+		switch k {
+		case "\r", "\n":
+			v.search, v.searching, v.cursor, v.top = v.typed, false, 0, 0
+		case "\x1b", "\x03":
+			v.searching = false
+		case "\x7f", "\b":
+			if v.typed != "" {
+				v.typed = v.typed[:len(v.typed)-1]
+			}
+		default:
+			if len(k) == 1 && k[0] >= ' ' {
+				v.typed += k
+			}
+		}
+		return nil, false
+	}
+
+	shown := v.visible()
+	switch k {
+	case "q", "\x03":
+		if v.detail && k == "q" {
+			v.detail = false
+			return nil, false
+		}
+		return nil, true
+	case "\x1b":
+		v.detail = false
+	case "j", "\x1b[B":
+		v.move(1, shown)
+	case "k", "\x1b[A":
+		v.move(-1, shown)
+	case " ", "\x1b[6~":
+		v.move(v.page(), shown)
+	case "b", "\x1b[5~":
+		v.move(-v.page(), shown)
+	case "g":
+		v.move(-1<<30, shown)
+	case "G":
+		v.move(1<<30, shown)
+	case "\r", "\n":
+		if len(shown) > 0 {
+			v.detail, v.scroll = !v.detail, 0
+		}
+	case "f":
+		v.failedOnly, v.cursor, v.top = !v.failedOnly, 0, 0
+	case "/":
+		v.searching, v.typed = true, v.search
+	case "x":
+		if v.cursor < len(shown) {
+			host := shown[v.cursor].Host
+			v.selected[host] = !v.selected[host]
+		}
+	case "a":
+		all := true
+		for _, res := range shown {
+			all = all && v.selected[res.Host]
+		}
+		for _, res := range shown {
+			v.selected[res.Host] = !all
+		}
+	case "r":
+		var hosts []string
+		for _, res := range v.results {
+			if v.selected[res.Host] {
+				hosts = append(hosts, res.Host)
+			}
+		}
+		if len(hosts) == 0 && v.cursor < len(shown) {
+			hosts = []string{shown[v.cursor].Host}
+		}
+		return hosts, false
+	}
+	return nil, false
+}
+
+// lines returns how many lines of output the host at the cursor has.
+func (v *viewer) lines(shown []result) int {
+	if v.cursor >= len(shown) {
+		return 0
+	}
+	return len(outputLines(shown[v.cursor]))
+}
+
+// move the cursor, or the output shown, by n lines.
+func (v *viewer) move(n int, shown []result) {
+	if v.detail {
+		v.scroll = clamp(v.scroll+n, 0, v.lines(shown)-v.page())
+		return
+	}
+	v.cursor = clamp(v.cursor+n, 0, len(shown)-1)
+	switch {
+	case v.cursor < v.top:
+		v.top = v.cursor
+	case v.cursor >= v.top+v.page():
+		v.top = v.cursor - v.page() + 1
+	}
+}
+
+func clamp(n, low, high int) int {
+	if n > high {
+		n = high
+	}
+	if n < low {
+		n = low
+	}
+	return n
+}
+
+func outputLines(res result) []string {
+	text := res.Output
+	if res.Error != "" {
+		text = strings.TrimSpace(text + "\n" + res.Error)
+	}
+	if text == "" {
+		return []string{"<no output>"}
+	}
+	return strings.Split(text, "\n")
+}
+
+// outcomeOf summarizes res in a few words, e.g. "exit 1".
+func outcomeOf(res result) string {
+	switch {
+	case res.Reason == reasonExec || (res.Error == "" && res.ExitCode != 0):
+		return fmt.Sprintf("exit %d", res.ExitCode)
+	case res.Error != "":
+		return res.Reason
+	case len(res.Failed) > 0:
+		return "failed"
+	}
+	return "ok"
+}
+
+// render draws the viewer onto a cleared screen, in raw mode.
+func (v *viewer) render(w io.Writer) {
+	shown := v.visible()
+	var lines []string
+
+	failed := 0
+	for _, res := range v.results {
+		if !res.ok() || res.ExitCode != 0 {
+			failed++
+		}
+	}
+	header := fmt.Sprintf("`%s` on %d hosts, %d failed", v.command, len(v.results), failed)
+	if v.failedOnly {
+		header += " [failed only]"
+	}
+	if v.search != "" {
+		header += fmt.Sprintf(" [matching %q: %d]", v.search, len(shown))
+	}
+	lines = append(lines, colors.info.sprint(header), "")
+
+	switch {
+	case len(shown) == 0:
+		lines = append(lines, colors.muted.sprint("no hosts"))
+	case v.detail:
+		res := shown[v.cursor]
+		lines[1] = colors.accent.sprint(fmt.Sprintf("--- %s (%s) ---", res.Host, outcomeOf(res)))
+		output := outputLines(res)
+		end := v.scroll + v.page()
+		if end > len(output) {
+			end = len(output)
+		}
+		for _, line := range output[v.scroll:end] {
+			lines = append(lines, truncate(line, v.width))
+		}
+	default:
+		end := v.top + v.page()
+		if end > len(shown) {
+			end = len(shown)
+		}
+		for i := v.top; i < end; i++ {
+			res := shown[i]
+			mark, cursor := " ", " "
+			if v.selected[res.Host] {
+				mark = "*"
+			}
+			if i == v.cursor {
+				cursor = ">"
+			}
+			first := outputLines(res)[0]
+			line := truncate(fmt.Sprintf("%s%s %-24s %-8s %s", cursor, mark, res.Host, outcomeOf(res), first), v.width)
+			style := colors.success
+			if outcomeOf(res) != "ok" {
+				style = colors.failure
+			}
+			lines = append(lines, style.sprint(line))
+		}
+	}
+
+	for len(lines) < v.height-1 {
+		lines = append(lines, "")
+	}
+	footer := "j/k move  space/b page  enter output  f failed  / search  x select  a all  r run again  q quit"
+	switch {
+	case v.searching:
+		footer = "search: " + v.typed
+	case v.status != "":
+		footer = v.status
+	}
+	lines = append(lines, colors.muted.sprint(truncate(footer, v.width)))
+
+	_, _ = io.WriteString(w, "\x1b[H\x1b[2J"+strings.Join(lines, "\r\n"))
+}
+
+// loop reads keys from in and draws onto out, which must be a terminal,
+// until quit, calling again to run the command on hosts again.
+func (v *viewer) loop(in *os.File, out *os.File, again func(hosts []string) []result) error {
+	fd := int(in.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return errors.Wrap(err, "failed to open viewer")
+	}
+	restore := func() { _ = terminal.Restore(fd, state) }
+	defer restore()
+	defer func() { _, _ = io.WriteString(out, "\x1b[H\x1b[2J") }()
+
+	buf := make([]byte, 16)
+	for {
+		if width, height, err := terminal.GetSize(int(out.Fd())); err == nil {
+			v.width, v.height = width, height
+		}
+		v.render(out)
+
+		n, err := in.Read(buf)
+		if err != nil {
+			return err
+		}
+		hosts, quit := v.key(string(buf[:n]))
+		if quit {
+			return nil
+		}
+		if len(hosts) > 0 {
+			restore()
+			_, _ = io.WriteString(out, "\x1b[H\x1b[2J")
+			colors.info.println("running `%s` again on %s", v.command, strings.Join(hosts, ", "))
+			v.merge(again(hosts))
+			v.status = fmt.Sprintf("ran again on %d hosts", len(hosts))
+			if state, err = terminal.MakeRaw(fd); err != nil {
+				return errors.Wrap(err, "failed to open viewer")
+			}
+		}
+	}
+}
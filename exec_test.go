@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func testViewer() *viewer {
+	var results []result
+	for i := 12; i >= 1; i-- {
+		res := result{Host: fmt.Sprintf("web%02d", i), Command: "systemctl is-active app", Output: "active"}
+		if i%4 == 0 {
+			res.Output, res.ExitCode, res.Error, res.Reason = "inactive", 3, "Process exited with status 3", reasonExec
+		}
+		results = append(results, res)
+	}
+	v := newViewer("systemctl is-active app", results)
+	v.height = 8 // pages of 4 hosts
+	return v
+}
+
+func Test_viewer_paging(t *testing.T) {
+	v := testViewer()
+	require.Equal(t, "web01", v.results[0].Host)
+
+	v.key(" ")
+	require.Equal(t, 4, v.cursor)
+	require.Equal(t, 1, v.top)
+	v.key("G")
+	require.Equal(t, 11, v.cursor)
+	require.Equal(t, 8, v.top)
+	v.key("\x1b[A")
+	v.key("g")
+	require.Equal(t, 0, v.cursor)
+	require.Equal(t, 0, v.top)
+
+	v.key("\r")
+	require.True(t, v.detail)
+	_, quit := v.key("q")
+	require.False(t, quit)
+	require.False(t, v.detail)
+	_, quit = v.key("q")
+	require.True(t, quit)
+}
+
+func Test_viewer_filters(t *testing.T) {
+	v := testViewer()
+	v.key("f")
+	var hosts []string
+	for _, res := range v.visible() {
+		hosts = append(hosts, res.Host)
+	}
+	require.Equal(t, []string{"web04", "web08", "web12"}, hosts)
+	v.key("f")
+
+	for _, k := range []string{"/", "w", "e", "b", "1", "x", "\x7f", "\r"} {
+		v.key(k)
+	}
+	require.Equal(t, "web1", v.search)
+	require.Len(t, v.visible(), 3)
+
+	var b bytes.Buffer
+	v.render(&b)
+	require.Contains(t, b.String(), `[matching "web1": 3]`)
+	require.Contains(t, b.String(), "web12")
+	require.NotContains(t, b.String(), "web02")
+}
+
+func Test_viewer_again(t *testing.T) {
+	v := testViewer()
+	hosts, _ := v.key("r")
+	require.Equal(t, []string{"web01"}, hosts)
+
+	v.key("f")
+	v.key("a")
+	v.key("j")
+	v.key("x")
+	hosts, _ = v.key("r")
+	require.Equal(t, []string{"web04", "web12"}, hosts)
+
+	v.merge([]result{{Host: "web04", Command: "systemctl is-active app", Output: "active"}})
+	require.Len(t, v.results, 12)
+	require.Len(t, v.visible(), 2)
+}
+
+func Test_execOn_failures(t *testing.T) {
+	refused := func(string, string) (net.Conn, error) { return nil, errors.New("connection refused") }
+	r := &runner{out: &quiet{}, dialer: refused, parallel: 2, passwords: make(map[string]string)}
+
+	// hosts failing do not stop the rest, and fail the run once all ran
+	err := execOn(r, []string{"web1", "web2", "web3"}, "uptime", false)
+	require.Error(t, err)
+	require.Equal(t, reasonDial, reason(err))
+	require.Equal(t, 4, exitStatus(err))
+	require.Len(t, r.results, 3)
+}
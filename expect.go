@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// An expectation is a string or /regex/ which the output of a script must
+// contain, declared in a script file like "# expect: status=active".
+type expectation struct {
+	text string
+	re   *regexp.Regexp
+}
+
+func parseExpectation(s string) (expectation, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return expectation{}, errors.Errorf("empty expectation")
+	}
+
+	if len(s) > 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		if err != nil {
+			return expectation{}, errors.Wrapf(err, "malformed expectation %q", s)
+		}
+		return expectation{re: re}, nil
+	}
+
+	return expectation{text: s}, nil
+}
+
+func (e expectation) String() string {
+	if e.re != nil {
+		return fmt.Sprintf("output matches /%s/", e.re)
+	}
+	return fmt.Sprintf("output contains %q", e.text)
+}
+
+func (e expectation) check(output string) bool {
+	if e.re != nil {
+		return e.re.MatchString(output)
+	}
+	return strings.Contains(output, e.text)
+}
+
+// exitCodes are the exit codes a script is expected to exit with,
+// declared in a script file like "# expect-exit: 0, 3".
+type exitCodes []int
+
+func parseExitCodes(s string) (exitCodes, error) {
+	var codes exitCodes
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		code, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, errors.Errorf("malformed exit code %q", field)
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return nil, errors.Errorf("no exit codes in %q", s)
+	}
+	return codes, nil
+}
+
+func (c exitCodes) String() string {
+	codes := make([]string, 0, len(c))
+	for _, code := range c {
+		codes = append(codes, strconv.Itoa(code))
+	}
+	return fmt.Sprintf("exit code in [%s]", strings.Join(codes, ", "))
+}
+
+func (c exitCodes) check(code int) bool {
+	for _, expected := range c {
+		if code == expected {
+			return true
+		}
+	}
+	return false
+}
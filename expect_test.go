@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_expectation(t *testing.T) {
+	output := "Active: active (running)\nstatus=active"
+
+	tests := []struct {
+		raw string
+		ok  bool
+	}{
+		{raw: "status=active", ok: true},
+		{raw: "status=failed", ok: false},
+		{raw: `/Active: active \(running\)/`, ok: true},
+		{raw: `/^status=(failed|dead)$/`, ok: false},
+	}
+
+	for _, test := range tests {
+		e, err := parseExpectation(test.raw)
+		require.NoError(t, err)
+		require.Equal(t, test.ok, e.check(output), test.raw)
+	}
+}
+
+func Test_exitCodes(t *testing.T) {
+	codes, err := parseExitCodes("0, 3")
+	require.NoError(t, err)
+	require.Equal(t, exitCodes{0, 3}, codes)
+	require.True(t, codes.check(3))
+	require.False(t, codes.check(1))
+
+	_, err = parseExitCodes("zero")
+	require.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// factsCheck gathers facts about a host as key=value lines.
+const factsCheck = `echo os=$(uname -s)
+echo release=$(uname -r)
+if [ -r /etc/os-release ]; then . /etc/os-release; echo distro=$ID; fi`
+
+// facts returns what is known about host, gathering it the first time.
+// Facts include the os (e.g. linux, freebsd, sunos, aix), its release,
+// and the distribution of linux hosts (e.g. debian, centos).
+func (r *runner) facts(client *ssh.Client, host string) (map[string]string, error) {
+	r.lock.Lock()
+	facts, exists := r.hostFacts[host]
+	r.lock.Unlock()
+	if exists {
+		return facts, nil
+	}
+
+	output, err := r.probeRemote(client, host, factsCheck)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to gather facts")
+	}
+
+	facts = fields(output)
+	for key, value := range facts {
+		facts[key] = strings.ToLower(value)
+	}
+
+	r.lock.Lock()
+	r.hostFacts[host] = facts
+	r.lock.Unlock()
+	return facts, nil
+}
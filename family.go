@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// An osFamily knows the commands for managing services and packages, and
+// inspecting files, which differ between operating systems.
+type osFamily struct {
+	name    string
+	service func(name, action string) string
+	install func(packages string) string
+	remove  func(packages string) string
+	stat    func(path string) string
+	sha256  string // hashes its stdin
+}
+
+var linux = osFamily{
+	name: "linux",
+	service: func(name, action string) string {
+		return fmt.Sprintf("systemctl %s %s", action, name)
+	},
+	install: func(packages string) string {
+		return linuxPackages("install", packages)
+	},
+	remove: func(packages string) string {
+		return linuxPackages("remove", packages)
+	},
+	stat: func(path string) string {
+		return fmt.Sprintf("stat -c 'size=%%s mode=%%a owner=%%U group=%%G' %s", quote(path))
+	},
+	sha256: "sha256sum",
+}
+
+// linuxPackages runs whichever package manager is installed.
+func linuxPackages(action, packages string) string {
+	managers := []string{
+		"apt-get -y %s %s",
+		"dnf -y %s %s",
+		"yum -y %s %s",
+		"zypper -n %s %s",
+	}
+	var b strings.Builder
+	for _, manager := range managers {
+		binary := strings.Fields(manager)[0]
+		fmt.Fprintf(&b, "if command -v %s >/dev/null 2>&1; then %s; el", binary, fmt.Sprintf(manager, action, packages))
+	}
+	b.WriteString("se echo 'no supported package manager' >&2; exit 1; fi")
+	return b.String()
+}
+
+var freebsd = osFamily{
+	name: "freebsd",
+	service: func(name, action string) string {
+		return fmt.Sprintf("service %s %s", name, bsdAction(action))
+	},
+	install: func(packages string) string {
+		return "pkg install -y " + packages
+	},
+	remove: func(packages string) string {
+		return "pkg delete -y " + packages
+	},
+	stat:   bsdStat,
+	sha256: "sha256 -q",
+}
+
+var openbsd = osFamily{
+	name: "openbsd",
+	service: func(name, action string) string {
+		return fmt.Sprintf("rcctl %s %s", action, name)
+	},
+	install: func(packages string) string {
+		return "pkg_add " + packages
+	},
+	remove: func(packages string) string {
+		return "pkg_delete " + packages
+	},
+	stat:   bsdStat,
+	sha256: "sha256 -q",
+}
+
+var solaris = osFamily{
+	name: "solaris",
+	service: func(name, action string) string {
+		switch action {
+		case "start":
+			action = "enable -t"
+		case "stop":
+			action = "disable -t"
+		case "reload":
+			action = "refresh"
+		case "status":
+			return "svcs -l " + name
+		}
+		return fmt.Sprintf("svcadm %s %s", action, name)
+	},
+	install: func(packages string) string {
+		return "pkg install " + packages
+	},
+	remove: func(packages string) string {
+		return "pkg uninstall " + packages
+	},
+	stat: func(path string) string {
+		return "ls -ld " + quote(path)
+	},
+	sha256: "digest -a sha256",
+}
+
+var aix = osFamily{
+	name: "aix",
+	service: func(name, action string) string {
+		switch action {
+		case "start":
+			return "startsrc -s " + name
+		case "stop":
+			return "stopsrc -s " + name
+		case "restart":
+			return fmt.Sprintf("stopsrc -s %s; startsrc -s %s", name, name)
+		case "reload":
+			return "refresh -s " + name
+		}
+		return "lssrc -s " + name
+	},
+	install: func(packages string) string {
+		return "yum -y install " + packages
+	},
+	remove: func(packages string) string {
+		return "yum -y remove " + packages
+	},
+	stat: func(path string) string {
+		return "istat " + quote(path)
+	},
+	sha256: "openssl dgst -sha256 -r",
+}
+
+func bsdAction(action string) string {
+	switch action {
+	case "enable":
+		return "enable"
+	case "disable":
+		return "disable"
+	}
+	return "one" + action
+}
+
+func bsdStat(path string) string {
+	return fmt.Sprintf("stat -f 'size=%%z mode=%%Lp owner=%%Su group=%%Sg' %s", quote(path))
+}
+
+// families by the os fact of hosts (i.e. `uname -s`, lowercased)
+var families = map[string]osFamily{
+	"linux":   linux,
+	"freebsd": freebsd,
+	"openbsd": openbsd,
+	"sunos":   solaris,
+	"aix":     aix,
+}
+
+func familyOf(os string) (osFamily, error) {
+	family, exists := families[os]
+	if !exists {
+		return osFamily{}, errors.Errorf("unsupported os %q", os)
+	}
+	return family, nil
+}
+
+// module translates a built-in module command into the command for family.
+// Built-in modules are:
+//
+//	@service <name> <start|stop|restart|reload|status|enable|disable>
+//	@package <install|remove> <packages...>
+//	@stat <path>
+//	@checksum <path> [sha256]
+func (family osFamily) module(command string) (string, error) {
+	tokens := strings.Fields(command)
+	switch {
+	case tokens[0] == "@service" && len(tokens) == 3:
+		switch tokens[2] {
+		case "start", "stop", "restart", "reload", "status", "enable", "disable":
+			return family.service(tokens[1], tokens[2]), nil
+		}
+		return "", errors.Errorf("unknown @service action %q", tokens[2])
+	case tokens[0] == "@package" && len(tokens) >= 3:
+		packages := strings.Join(tokens[2:], " ")
+		switch tokens[1] {
+		case "install":
+			return family.install(packages), nil
+		case "remove":
+			return family.remove(packages), nil
+		}
+		return "", errors.Errorf("unknown @package action %q", tokens[1])
+	case tokens[0] == "@stat" && len(tokens) == 2:
+		return family.stat(tokens[1]), nil
+	case tokens[0] == "@checksum" && (len(tokens) == 2 || len(tokens) == 3):
+		return checksumCommand(family.sha256, tokens[1]), nil
+	}
+	return "", errors.Errorf("malformed module %q", command)
+}
+
+// isModule returns whether command invokes a built-in module,
+// optionally with sudo, e.g. "sudo @service nginx restart".
+func isModule(command string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(command, "sudo "), "@")
+}
+
+// expandModule replaces a built-in module command of sc with the
+// command for the os family of host.
+func (r *runner) expandModule(client *ssh.Client, host string, sc script) (script, error) {
+	if !isModule(sc.command) {
+		return sc, nil
+	}
+
+	facts, err := r.facts(client, host)
+	if err != nil {
+		return sc, err
+	}
+
+	family, err := familyOf(facts["os"])
+	if err != nil {
+		return sc, errors.Wrapf(err, "cannot run %q on %s", sc.command, host)
+	}
+
+	prefix := ""
+	command := sc.command
+	if strings.HasPrefix(command, "sudo ") {
+		prefix, command = "sudo ", strings.TrimPrefix(command, "sudo ")
+	}
+
+	expanded, err := family.module(command)
+	if err != nil {
+		return sc, err
+	}
+
+	if prefix != "" && strings.ContainsAny(expanded, ";&$") {
+		expanded = "sh -c " + quote(expanded)
+	}
+	sc.command = prefix + expanded
+	return sc, nil
+}
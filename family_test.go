@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_osFamily_module(t *testing.T) {
+	tests := []struct {
+		os      string
+		command string
+		exp     string
+	}{
+		{"linux", "@service nginx restart", "systemctl restart nginx"},
+		{"freebsd", "@service nginx restart", "service nginx onerestart"},
+		{"freebsd", "@service nginx enable", "service nginx enable"},
+		{"sunos", "@service ssh start", "svcadm enable -t ssh"},
+		{"aix", "@service sshd restart", "stopsrc -s sshd; startsrc -s sshd"},
+		{"freebsd", "@package install curl jq", "pkg install -y curl jq"},
+		{"openbsd", "@package remove curl", "pkg_delete curl"},
+		{"freebsd", "@stat /etc/hosts", "stat -f 'size=%z mode=%Lp owner=%Su group=%Sg' '/etc/hosts'"},
+	}
+
+	for _, test := range tests {
+		family, err := familyOf(test.os)
+		require.NoError(t, err)
+		command, err := family.module(test.command)
+		require.NoError(t, err)
+		require.Equal(t, test.exp, command)
+	}
+}
+
+func Test_osFamily_module_invalid(t *testing.T) {
+	_, err := familyOf("plan9")
+	require.Error(t, err)
+
+	_, err = linux.module("@service nginx explode")
+	require.Error(t, err)
+
+	_, err = linux.module("@stat")
+	require.Error(t, err)
+}
+
+func Test_isModule(t *testing.T) {
+	require.True(t, isModule("@stat /etc/hosts"))
+	require.True(t, isModule("sudo @service nginx restart"))
+	require.False(t, isModule("sudo service nginx restart"))
+}
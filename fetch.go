@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const fetchUsage = "usage: commando fetch [flags] <files...> --hosts hosts"
+
+// manifestName is the name of the manifest written alongside fetched files.
+const manifestName = "manifest.json"
+
+// A fetched file is an entry of the manifest of "commando fetch": a file
+// of a host, its size, hash and modification time on the host, and where
+// it was downloaded to. Files (and hosts) which could not be fetched have
+// an error instead, so that nothing is skipped silently.
+type fetched struct {
+	Host   string    `json:"host"`
+	Path   string    `json:"path,omitempty"`
+	File   string    `json:"file,omitempty"` // relative to the manifest
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256,omitempty"`
+	Mtime  time.Time `json:"mtime"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// fetchList lists the files matching the patterns on a host, one per line
+// as "size mtime sha256 path", "unreadable path", "notfile path" for those
+// which are not regular files (e.g. directories), or "missing pattern" for
+// those matching none. Patterns are not quoted, so globs expand remotely.
+func fetchList(patterns []string) string {
+	return `for f in ` + strings.Join(patterns, " ") + `; do
+  if [ -f "$f" ] && [ ! -r "$f" ]; then
+    echo "unreadable $f"
+  elif [ -f "$f" ]; then
+    echo "$(stat -c '%s %Y' -- "$f") $(sha256sum < "$f" | cut -d' ' -f1) $f"
+  elif [ ! -e "$f" ]; then
+    echo "missing $f"
+  else
+    echo "notfile $f"
+  fi
+done`
+}
+
+// parseFetchList parses the output of fetchList on host.
+func parseFetchList(host, output string) ([]fetched, error) {
+	var files []fetched
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line = strings.TrimRight(line, "\r"); line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "missing ") {
+			files = append(files, fetched{Host: host, Path: strings.TrimPrefix(line, "missing "), Error: "no such file"})
+			continue
+		}
+		if strings.HasPrefix(line, "unreadable ") {
+			files = append(files, fetched{Host: host, Path: strings.TrimPrefix(line, "unreadable "), Error: "permission denied"})
+			continue
+		}
+		if strings.HasPrefix(line, "notfile ") {
+			files = append(files, fetched{Host: host, Path: strings.TrimPrefix(line, "notfile "), Error: "not a regular file"})
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 {
+			return nil, errors.Errorf("malformed listing %q", line)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("malformed size in listing %q", line)
+		}
+		mtime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("malformed mtime in listing %q", line)
+		}
+		files = append(files, fetched{Host: host, Path: fields[3], Size: size, SHA256: fields[2], Mtime: time.Unix(mtime, 0).UTC()})
+	}
+	// every pattern is listed, even if it matches nothing
+	if len(files) == 0 {
+		return nil, errors.New("nothing was listed")
+	}
+	return files, nil
+}
+
+// localPath returns where path of host is downloaded to, relative to the
+// destination, which paths (e.g. of "../") may not escape.
+func localPath(host, path string) (string, error) {
+	local := filepath.Join(host, filepath.FromSlash(strings.TrimPrefix(path, "/")))
+	if !strings.HasPrefix(local, host+string(filepath.Separator)) {
+		return "", errors.Errorf("path %s escapes the directory of %s", path, host)
+	}
+	return local, nil
+}
+
+// A fetcher downloads files from hosts, collecting the manifest.
+type fetcher struct {
+	patterns []string
+	dest     string
+
+	lock    sync.Mutex
+	entries []fetched
+}
+
+func (f *fetcher) add(entries ...fetched) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.entries = append(f.entries, entries...)
+}
+
+// fetch downloads the files matching the patterns on host.
+func (f *fetcher) fetch(r *runner, host string) error {
+	client, err := r.dial(host)
+	if err != nil {
+		return errors.Wrap(err, "failed to dial host")
+	}
+	defer func() { _ = client.Close() }()
+
+	output, err := remote(client, fetchList(f.patterns), "")
+	if err != nil {
+		return errors.Wrap(err, "failed to list files")
+	}
+	files, err := parseFetchList(host, output)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.Error == "" {
+			if err := f.download(client, &file); err != nil {
+				file.Error = err.Error()
+				colors.failure.println("%s: %s: %v", host, file.Path, err)
+			} else {
+				colors.success.println("%s: %s (%d bytes)", host, file.Path, file.Size)
+			}
+		}
+		f.add(file)
+	}
+	return nil
+}
+
+// download fetches the file listed, up to the size it was listed with (as
+// logs may grow meanwhile), checking it has the hash it was listed with.
+func (f *fetcher) download(client *ssh.Client, file *fetched) error {
+	local, err := localPath(file.Host, file.Path)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(f.dest, local)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create directory")
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create file")
+	}
+	defer func() { _ = out.Close() }()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "failed to create session")
+	}
+	defer func() { _ = session.Close() }()
+
+	hash := sha256.New()
+	session.Stdout = io.MultiWriter(out, hash)
+	if err := session.Run(fmt.Sprintf("head -c %d -- %s", file.Size, quote(file.Path))); err != nil {
+		return errors.Wrap(err, "failed to download")
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != file.SHA256 {
+		return errors.Errorf("sha256 %s differs from %s listed (was it rewritten?)", sum, file.SHA256)
+	}
+	file.File = local
+	return nil
+}
+
+// verifyManifest checks every entry of the manifest in dest was fetched,
+// and that its file is there with the size and hash of the manifest,
+// returning the problems found.
+func verifyManifest(dest string, entries []fetched) []string {
+	var problems []string
+	for _, e := range entries {
+		if e.Error != "" && e.Path == "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", e.Host, e.Error))
+			continue
+		}
+		if e.Error != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s: %s", e.Host, e.Path, e.Error))
+			continue
+		}
+		size, sum, err := hashFile(filepath.Join(dest, e.File))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s: %v", e.Host, e.Path, err))
+			continue
+		}
+		if size != e.Size || sum != e.SHA256 {
+			problems = append(problems, fmt.Sprintf("%s: %s: %s does not match the manifest", e.Host, e.Path, e.File))
+		}
+	}
+	return problems
+}
+
+// hashFile returns the size and SHA-256 of the file at path.
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	return size, hex.EncodeToString(hash.Sum(nil)), err
+}
+
+// fetchCmd implements "commando fetch", which downloads files from hosts
+// into a directory per host, along with a manifest of them.
+func fetchCmd(arguments []string) error {
+	var args args
+	var dest string
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	flags.StringVar(&args.user, "user", os.Getenv("USER"), "ssh username")
+	flags.StringVar(&args.hostList, "hosts", "", "the list of hosts")
+	flags.StringVar(&args.inventory, "inventory", "", "file listing hosts and their metadata")
+	flags.BoolVar(&args.pw, "pw", false, "prompt for a password")
+	flags.IntVar(&args.parallel, "parallel", 10, "fetch from this many hosts at a time")
+	flags.StringVar(&dest, "dest", "fetched", "download into a directory per host in this directory, with "+manifestName)
+	patterns := parseInterspersed(flags, arguments)
+
+	if len(patterns) == 0 {
+		return errors.New(fetchUsage)
+	}
+	if args.hostList == "" && args.inventory == "" {
+		return errors.Errorf("--hosts or --inventory is required")
+	}
+	if args.parallel < 1 {
+		return errors.Errorf("--parallel must be at least 1")
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		var err error
+		if inv, err = loadInventory(args.inventory); err != nil {
+			return err
+		}
+	}
+	hosts, err := targets(args, inv)
+	if err != nil {
+		return err
+	}
+
+	var pswd string
+	if args.pw {
+		if pswd, err = easyPrompt(args.user); err != nil {
+			return err
+		}
+	}
+
+	// like grep, hosts which cannot be fetched from do not stop the rest
+	r := newRunner(args, pswd, inv, &quiet{})
+	f := &fetcher{patterns: patterns, dest: dest}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, args.parallel)
+	for _, host := range hosts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(host string) {
+			defer func() { <-slots; wg.Done() }()
+			if err := f.fetch(r, host); err != nil {
+				colors.failure.println("%s: %v", host, err)
+				f.add(fetched{Host: host, Error: err.Error()})
+			}
+		}(host)
+	}
+	wg.Wait()
+
+	sort.Slice(f.entries, func(i, j int) bool {
+		if f.entries[i].Host != f.entries[j].Host {
+			return f.entries[i].Host < f.entries[j].Host
+		}
+		return f.entries[i].Path < f.entries[j].Path
+	})
+	bs, err := json.MarshalIndent(f.entries, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode manifest")
+	}
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return errors.Wrap(err, "failed to create destination")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dest, manifestName), append(bs, '\n'), 0600); err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+
+	problems := verifyManifest(dest, f.entries)
+	for _, problem := range problems {
+		colors.failure.println("incomplete: %s", problem)
+	}
+	fetchedFrom := make(map[string]bool)
+	for _, e := range f.entries {
+		if e.Error == "" {
+			fetchedFrom[e.Host] = true
+		}
+	}
+	colors.info.println("fetched %d of %d files, from %d of %d hosts, into %s", len(f.entries)-len(problems), len(f.entries), len(fetchedFrom), len(hosts), dest)
+	if len(problems) > 0 {
+		return errors.Errorf("%d files or hosts were not fetched, see %s", len(problems), filepath.Join(dest, manifestName))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseFetchList(t *testing.T) {
+	files, err := parseFetchList("web1", "5 1700000000 f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2 /var/log/app/my app.log\r\nmissing /var/log/app/*.gz\nunreadable /var/log/secure\nnotfile /var/log/nginx\n")
+	require.NoError(t, err)
+	require.Equal(t, []fetched{
+		{Host: "web1", Path: "/var/log/app/my app.log", Size: 5, SHA256: "f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2", Mtime: time.Unix(1700000000, 0).UTC()},
+		{Host: "web1", Path: "/var/log/app/*.gz", Error: "no such file"},
+		{Host: "web1", Path: "/var/log/secure", Error: "permission denied"},
+		{Host: "web1", Path: "/var/log/nginx", Error: "not a regular file"},
+	}, files)
+
+	_, err = parseFetchList("web1", "lots /var/log/x")
+	require.Error(t, err)
+
+	// hosts listing nothing are incomplete, rather than fetched from
+	_, err = parseFetchList("web1", "\n")
+	require.EqualError(t, err, "nothing was listed")
+}
+
+func Test_localPath(t *testing.T) {
+	local, err := localPath("web1", "/var/log/app.log")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("web1", "var", "log", "app.log"), local)
+
+	local, err = localPath("web1", "app.log")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("web1", "app.log"), local)
+
+	_, err = localPath("web1", "../../etc/passwd")
+	require.Error(t, err)
+}
+
+func Test_verifyManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "web1"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "web1", "a.log"), []byte("hello"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "web1", "b.log"), []byte("hello!"), 0600))
+
+	hello := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	problems := verifyManifest(dir, []fetched{
+		{Host: "web1", Path: "/a.log", File: filepath.Join("web1", "a.log"), Size: 5, SHA256: hello},
+		{Host: "web1", Path: "/b.log", File: filepath.Join("web1", "b.log"), Size: 5, SHA256: hello},
+		{Host: "web1", Path: "/c.log", File: filepath.Join("web1", "c.log"), Size: 5, SHA256: hello},
+		{Host: "web2", Error: "failed to dial host: connection refused"},
+	})
+	require.Len(t, problems, 3)
+	require.Equal(t, "web1: /b.log: "+filepath.Join("web1", "b.log")+" does not match the manifest", problems[0])
+	require.Contains(t, problems[1], "web1: /c.log: ")
+	require.Equal(t, "web2: failed to dial host: connection refused", problems[2])
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var fieldRe = regexp.MustCompile(`^([[:word:].-]+)\s*[=:]\s*(.*)$`)
+
+// fields parses the output of a script into a flat set of named values.
+//
+// Output that is a JSON object is flattened, with nested keys joined by '.'.
+// Otherwise each line of the form "key=value" or "key: value" becomes a field.
+func fields(output string) map[string]string {
+	parsed := make(map[string]string)
+	output = strings.TrimSpace(output)
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &obj); err == nil {
+		flatten("", obj, parsed)
+		return parsed
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		matches := fieldRe.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			continue
+		}
+		parsed[matches[1]] = strings.TrimSpace(matches[2])
+	}
+	return parsed
+}
+
+func flatten(prefix string, obj map[string]interface{}, into map[string]string) {
+	for key, value := range obj {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flatten(key, v, into)
+		case nil:
+			into[key] = ""
+		case string:
+			into[key] = v
+		default:
+			into[key] = fmt.Sprintf("%v", v)
+		}
+	}
+}
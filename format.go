@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// document renders the whole report at once when the run completes, in
+// a format suited to attaching to tickets or CI jobs. Until then only
+// messages and warnings are rendered, to stderr.
+type document struct {
+	lock  sync.Mutex
+	w     io.Writer
+	write func(io.Writer, report) error
+}
+
+func (d *document) plan(string, []string, []string) {}
+func (d *document) begin(string, string)            {}
+func (d *document) command(string, string)          {}
+func (d *document) output(string, string)           {}
+func (d *document) result(result)                   {}
+func (d *document) end(string, string)              {}
+
+func (d *document) message(format string, args ...interface{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func (d *document) warning(format string, args ...interface{}) {
+	d.message(format, args...)
+}
+
+func (d *document) summary(rpt report) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.write(d.w, rpt); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to render report: %v\n", err)
+	}
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	Output    string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport renders rpt as JUnit XML, with a test suite per host and
+// a test case per script (or command) run on it.
+func junitReport(rpt report) junitSuites {
+	var suites junitSuites
+	index := make(map[string]int)
+	var seconds []float64
+	for _, res := range rpt.Results {
+		i, exists := index[res.Host]
+		if !exists {
+			i = len(suites.Suites)
+			index[res.Host] = i
+			suites.Suites = append(suites.Suites, junitSuite{Name: res.Host})
+			seconds = append(seconds, 0)
+		}
+		suite := &suites.Suites[i]
+		seconds[i] += res.Seconds
+
+		name := res.Command
+		if res.File != "" {
+			name = res.File + ": " + res.Command
+		}
+		tc := junitCase{
+			ClassName: res.Host,
+			Name:      name,
+			Time:      fmt.Sprintf("%.3f", res.Seconds),
+			Output:    res.Output,
+		}
+		switch {
+		case res.Skipped != "":
+			tc.Skipped = &junitMessage{Message: res.Skipped}
+			suite.Skipped++
+		case res.Error != "":
+			tc.Error = &junitMessage{Message: res.Error, Text: res.Output}
+			suite.Errors++
+		case len(res.Failed) > 0:
+			tc.Failure = &junitMessage{Message: strings.Join(res.Failed, "; "), Text: strings.Join(res.Failed, "\n")}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	for i := range suites.Suites {
+		suites.Suites[i].Time = fmt.Sprintf("%.3f", seconds[i])
+	}
+	return suites
+}
+
+func writeJUnit(w io.Writer, rpt report) error {
+	bs, err := xml.MarshalIndent(junitReport(rpt), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, bs)
+	return err
+}
+
+// writeMarkdown renders rpt as a Markdown summary: a table of the result
+// of each script on each host, followed by any notes, and the output of
+// any failures.
+func writeMarkdown(w io.Writer, rpt report) error {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "## commando run %s: %s\n\n", rpt.ID, rpt.Status)
+	_, _ = b.WriteString("| host | script | command | exit | seconds | result |\n")
+	_, _ = b.WriteString("|------|--------|---------|------|---------|--------|\n")
+
+	var failures []result
+	for _, res := range rpt.Results {
+		outcome := "ok"
+		switch {
+		case res.Skipped != "":
+			outcome = "skipped: " + res.Skipped
+		case res.Error != "":
+			outcome = "error: " + res.Error
+		case len(res.Failed) > 0:
+			outcome = "failed: " + strings.Join(res.Failed, "; ")
+		}
+		if !res.ok() {
+			failures = append(failures, res)
+		}
+		_, _ = fmt.Fprintf(&b, "| %s | %s | %s | %d | %.1f | %s |\n",
+			markdownCell(res.Host), markdownCell(res.File), markdownCell("`"+res.Command+"`"),
+			res.ExitCode, res.Seconds, markdownCell(outcome))
+	}
+
+	var notes []string
+	for _, res := range rpt.Results {
+		for _, note := range res.Notes {
+			notes = append(notes, fmt.Sprintf("- %s %s: %s\n", res.Host, res.File, note))
+		}
+	}
+	if len(notes) > 0 {
+		_, _ = b.WriteString("\n### Notes\n\n" + strings.Join(notes, ""))
+	}
+
+	if len(failures) > 0 {
+		_, _ = b.WriteString("\n### Failures\n")
+		for _, res := range failures {
+			_, _ = fmt.Fprintf(&b, "\n#### %s %s\n\n", res.Host, res.File)
+			fence := "```"
+			for strings.Contains(res.Output, fence) {
+				fence += "`"
+			}
+			_, _ = fmt.Fprintf(&b, "%s\n%s\n%s\n", fence, res.Output, fence)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell escapes s for use in a cell of a Markdown table.
+func markdownCell(s string) string {
+	s = strings.Replace(s, "|", `\|`, -1)
+	return strings.Replace(strings.Replace(s, "\r", "", -1), "\n", " ", -1)
+}
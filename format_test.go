@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var formatReport = report{ID: "run-1", Status: "completed", Results: []result{
+	{Host: "web1", File: "1-check", Command: "df -h", Output: "ok", Seconds: 1.5, Notes: []string{"reboot required"}},
+	{Host: "web1", File: "2-disk", Command: "df | grep /", Output: "95%", Failed: []string{".disk_used_pct < 90 (got 95)"}},
+	{Host: "web2", File: "1-check", Command: "df -h", ExitCode: -1, Error: "connection refused"},
+	{Host: "web2", File: "2-disk", Command: "df | grep /", Skipped: "creates /etc/done"},
+}}
+
+func Test_writeJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeJUnit(&buf, formatReport))
+
+	var suites junitSuites
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &suites))
+	require.Equal(t, 2, len(suites.Suites))
+	require.Equal(t, "web1", suites.Suites[0].Name)
+	require.Equal(t, 2, suites.Suites[0].Tests)
+	require.Equal(t, 1, suites.Suites[0].Failures)
+	require.Equal(t, "1.500", suites.Suites[0].Time)
+	require.Equal(t, "1-check: df -h", suites.Suites[0].Cases[0].Name)
+	require.Equal(t, 1, suites.Suites[1].Errors)
+	require.Equal(t, 1, suites.Suites[1].Skipped)
+	require.Equal(t, "creates /etc/done", suites.Suites[1].Cases[1].Skipped.Message)
+}
+
+func Test_writeMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeMarkdown(&buf, formatReport))
+	md := buf.String()
+
+	require.Contains(t, md, "## commando run run-1: completed\n")
+	require.Contains(t, md, "| web1 | 1-check | `df -h` | 0 | 1.5 | ok |\n")
+	require.Contains(t, md, "| web1 | 2-disk | `df \\| grep /` | 0 | 0.0 | failed: .disk_used_pct < 90 (got 95) |\n")
+	require.Contains(t, md, "| web2 | 2-disk | `df \\| grep /` | 0 | 0.0 | skipped: creates /etc/done |\n")
+	require.Contains(t, md, "### Notes\n\n- web1 1-check: reboot required\n")
+	require.Contains(t, md, "#### web1 2-disk\n\n```\n95%\n```\n")
+	require.Contains(t, md, "#### web2 1-check\n")
+}
+
+func Test_writeReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, prefix := range map[string]string{
+		"report.json": `{`,
+		"junit.xml":   xml.Header + "<testsuites>",
+		"report.md":   "## commando run run-1",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, writeReport(path, formatReport))
+		bs, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(bs), prefix), name)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// A forwardSpec is a local port forward of --forward, given as
+// [BIND:]PORT:HOST:HOSTPORT like ssh -L, e.g. 127.0.0.1:15432:localhost:5432.
+type forwardSpec struct {
+	local  string // address listened on
+	remote string // address dialed from the host
+}
+
+func parseForward(s string) (forwardSpec, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) == 3 {
+		parts = append([]string{"127.0.0.1"}, parts...)
+	}
+	if len(parts) != 4 || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+		return forwardSpec{}, errors.Errorf("malformed forward %q, expected [BIND:]PORT:HOST:HOSTPORT", s)
+	}
+	return forwardSpec{
+		local:  net.JoinHostPort(parts[0], parts[1]),
+		remote: net.JoinHostPort(parts[2], parts[3]),
+	}, nil
+}
+
+// A forwarder listens on the local address of a forward for the duration
+// of a run, forwarding each connection to its remote address through the
+// host it currently goes through. Connections made while it goes through
+// no host are refused.
+type forwarder struct {
+	spec     forwardSpec
+	listener net.Listener
+
+	lock   sync.Mutex
+	host   string
+	client *ssh.Client
+}
+
+// through makes connections go through host, until undone with nil.
+func (f *forwarder) through(host string, client *ssh.Client) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.host, f.client = host, client
+}
+
+func (f *forwarder) serve(warning func(string, ...interface{})) {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return // closed
+		}
+
+		f.lock.Lock()
+		host, client := f.host, f.client
+		f.lock.Unlock()
+		if client == nil {
+			_ = conn.Close()
+			continue
+		}
+
+		go func() {
+			if err := pipe(conn, client, f.spec.remote); err != nil {
+				warning("failed to forward %s to %s through %s: %v", f.spec.local, f.spec.remote, host, err)
+			}
+		}()
+	}
+}
+
+// pipe copies between conn and a connection to address made by client,
+// until either side closes.
+func pipe(conn net.Conn, client *ssh.Client, address string) error {
+	defer func() { _ = conn.Close() }()
+	remote, err := client.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = remote.Close() }()
+
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(remote, conn); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(conn, remote); done <- struct{}{} }()
+	<-done
+	return nil
+}
+
+// forward listens on the local address of every --forward, forwarding
+// connections through via for the whole run, or else through each host
+// while it runs (which --parallel 1 ensures is one at a time). The
+// returned function stops forwarding, closing the connections through via.
+func (r *runner) forward(specs []string, via string) (func(), error) {
+	if len(specs) == 0 {
+		return func() {}, nil
+	}
+
+	var client *ssh.Client
+	if via != "" {
+		var err error
+		if client, err = r.dial(via); err != nil {
+			return nil, errors.Wrapf(err, "failed to dial %s to forward through", via)
+		}
+	}
+
+	stop := func() {
+		for _, f := range r.forwards {
+			_ = f.listener.Close()
+			f.through("", nil)
+		}
+		r.forwards = nil
+		if client != nil {
+			_ = client.Close()
+		}
+	}
+
+	for _, s := range specs {
+		spec, err := parseForward(s)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		l, err := net.Listen("tcp", spec.local)
+		if err != nil {
+			stop()
+			return nil, errors.Wrapf(err, "failed to forward %s", s)
+		}
+		f := &forwarder{spec: spec, listener: l}
+		if client != nil {
+			f.through(via, client)
+			r.out.message("forwarding %s to %s through %s", spec.local, spec.remote, via)
+		} else {
+			r.out.message("forwarding %s to %s through each host", spec.local, spec.remote)
+		}
+		r.forwards = append(r.forwards, f)
+		go f.serve(r.out.warning)
+	}
+	r.forwardEach = client == nil
+	return stop, nil
+}
+
+// forwardThrough makes the forwards go through host, unless they go
+// through --forward-via, returning the function undoing it.
+func (r *runner) forwardThrough(host string, client *ssh.Client) func() {
+	if !r.forwardEach {
+		return func() {}
+	}
+	for _, f := range r.forwards {
+		f.through(host, client)
+	}
+	return func() {
+		for _, f := range r.forwards {
+			f.through("", nil)
+		}
+	}
+}
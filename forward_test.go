@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseForward(t *testing.T) {
+	spec, err := parseForward("127.0.0.1:15432:localhost:5432")
+	require.NoError(t, err)
+	require.Equal(t, forwardSpec{local: "127.0.0.1:15432", remote: "localhost:5432"}, spec)
+
+	spec, err = parseForward("8080:api.internal:80")
+	require.NoError(t, err)
+	require.Equal(t, forwardSpec{local: "127.0.0.1:8080", remote: "api.internal:80"}, spec)
+
+	_, err = parseForward("15432:localhost")
+	require.EqualError(t, err, `malformed forward "15432:localhost", expected [BIND:]PORT:HOST:HOSTPORT`)
+	_, err = parseForward("127.0.0.1::localhost:5432")
+	require.Error(t, err)
+}
+
+func Test_runner_forward(t *testing.T) {
+	r := &runner{out: &quiet{}}
+	stop, err := r.forward([]string{"127.0.0.1:0:localhost:5432"}, "")
+	require.NoError(t, err)
+	require.True(t, r.forwardEach)
+	require.Len(t, r.forwards, 1)
+	address := r.forwards[0].listener.Addr().String()
+
+	// going through no host, connections are refused
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "timeout")
+	_ = conn.Close()
+
+	stop()
+	require.Empty(t, r.forwards)
+	_, err = net.Dial("tcp", address)
+	require.Error(t, err)
+
+	_, err = r.forward([]string{"15432"}, "")
+	require.Error(t, err)
+}
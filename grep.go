@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const grepUsage = "usage: commando grep [flags] <pattern> <files...> --hosts hosts"
+
+// grepOptions configure the remote grep run by "commando grep".
+type grepOptions struct {
+	pattern          string
+	files            []string
+	ignoreCase       bool
+	fixedStrings     bool
+	filesWithMatches bool
+}
+
+// command returns the grep command line to run on each host. Files are
+// not quoted, so that globs like /var/log/app/*.log expand remotely.
+func (o grepOptions) command() string {
+	flags := "-H -n"
+	if o.filesWithMatches {
+		flags = "-l"
+	}
+	if o.ignoreCase {
+		flags += " -i"
+	}
+	if o.fixedStrings {
+		flags += " -F"
+	} else {
+		flags += " -E"
+	}
+	// the C locale makes grep much faster on large logs
+	return fmt.Sprintf("LC_ALL=C grep %s -e %s -- %s", flags, quote(o.pattern), strings.Join(o.files, " "))
+}
+
+// parseInterspersed parses flags which may appear before, between,
+// or after the positional arguments, returning the latter.
+func parseInterspersed(flags *flag.FlagSet, arguments []string) []string {
+	var positional []string
+	for {
+		_ = flags.Parse(arguments)
+		arguments = flags.Args()
+		if len(arguments) == 0 {
+			return positional
+		}
+		positional = append(positional, arguments[0])
+		arguments = arguments[1:]
+	}
+}
+
+// grepCmd implements "commando grep", which greps files across hosts,
+// streaming matches prefixed by host as they are found.
+func grepCmd(arguments []string) error {
+	var args args
+	var opts grepOptions
+	flags := flag.NewFlagSet("grep", flag.ExitOnError)
+	flags.StringVar(&args.user, "user", os.Getenv("USER"), "ssh username")
+	flags.StringVar(&args.hostList, "hosts", "", "the list of hosts")
+	flags.StringVar(&args.inventory, "inventory", "", "file listing hosts and their metadata")
+	flags.BoolVar(&args.pw, "pw", false, "prompt for a password")
+	flags.IntVar(&args.parallel, "parallel", 10, "grep on this many hosts at a time")
+	flags.BoolVar(&opts.ignoreCase, "i", false, "ignore case")
+	flags.BoolVar(&opts.fixedStrings, "F", false, "match the pattern as a fixed string, instead of an extended regex")
+	flags.BoolVar(&opts.filesWithMatches, "files-with-matches", false, "list the matching files of all hosts, instead of the matches")
+	positional := parseInterspersed(flags, arguments)
+
+	if len(positional) < 2 {
+		return errors.New(grepUsage)
+	}
+	opts.pattern, opts.files = positional[0], positional[1:]
+
+	if args.hostList == "" && args.inventory == "" {
+		return errors.Errorf("--hosts or --inventory is required")
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		var err error
+		if inv, err = loadInventory(args.inventory); err != nil {
+			return err
+		}
+	}
+
+	hosts, err := targets(args, inv)
+	if err != nil {
+		return err
+	}
+
+	var pswd string
+	if args.pw {
+		if pswd, err = easyPrompt(args.user); err != nil {
+			return err
+		}
+	}
+
+	if args.parallel < 1 {
+		return errors.Errorf("--parallel must be at least 1")
+	}
+
+	// unlike scripts, hosts which cannot be grepped do not stop the rest
+	r := newRunner(args, pswd, inv, &quiet{})
+	g := &grepper{options: opts, matched: make(map[string][]string)}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, args.parallel)
+	for _, host := range hosts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(host string) {
+			defer func() { <-slots; wg.Done() }()
+			if err := g.grep(r, host); err != nil {
+				colors.failure.println("%s: %v", host, err)
+			}
+		}(host)
+	}
+	wg.Wait()
+
+	if opts.filesWithMatches {
+		g.printMatched()
+	}
+	return nil
+}
+
+// A grepper runs a grep on hosts, printing matches as they are found.
+type grepper struct {
+	options grepOptions
+
+	lock    sync.Mutex
+	matched map[string][]string // hosts by file, with --files-with-matches
+}
+
+func (g *grepper) grep(r *runner, host string) error {
+	client, err := r.dial(host)
+	if err != nil {
+		return errors.Wrap(err, "failed to dial host")
+	}
+	defer func() { _ = client.Close() }()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "failed to create session")
+	}
+	defer func() { _ = session.Close() }()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to read output")
+	}
+	var stderr strings.Builder
+	session.Stderr = &stderr
+
+	if err := session.Start(g.options.command()); err != nil {
+		return errors.Wrap(err, "failed to start grep")
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		g.match(host, scanner.Text())
+	}
+
+	err = session.Wait()
+	if e, ok := err.(*ssh.ExitError); ok && e.ExitStatus() == 1 {
+		return nil // grep found no matches
+	}
+	if err != nil {
+		return errors.Errorf("grep failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (g *grepper) match(host, line string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.options.filesWithMatches {
+		g.matched[line] = append(g.matched[line], host)
+		return
+	}
+
+	// lines are of the form file:number:text
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) != 3 {
+		fmt.Printf("%s:%s\n", colors.info.sprint(host), line)
+		return
+	}
+	fmt.Printf("%s:%s:%s:%s\n",
+		colors.info.sprint(host), colors.accent.sprint(parts[0]), colors.success.sprint(parts[1]), parts[2])
+}
+
+// printMatched prints each file matched on any host, with the hosts it
+// matched on.
+func (g *grepper) printMatched() {
+	files := make([]string, 0, len(g.matched))
+	for file := range g.matched {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		hosts := g.matched[file]
+		sort.Strings(hosts)
+		fmt.Printf("%s (%d hosts): %s\n", colors.accent.sprint(file), len(hosts), strings.Join(hosts, ", "))
+	}
+}
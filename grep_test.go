@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_grepOptions_command(t *testing.T) {
+	opts := grepOptions{pattern: "it's down", files: []string{"/var/log/app/*.log"}}
+	require.Equal(t, `LC_ALL=C grep -H -n -E -e 'it'\''s down' -- /var/log/app/*.log`, opts.command())
+
+	opts = grepOptions{pattern: "a.b", files: []string{"x", "y"}, ignoreCase: true, fixedStrings: true, filesWithMatches: true}
+	require.Equal(t, `LC_ALL=C grep -l -i -F -e 'a.b' -- x y`, opts.command())
+}
+
+func Test_parseInterspersed(t *testing.T) {
+	flags := flag.NewFlagSet("grep", flag.ContinueOnError)
+	hosts := flags.String("hosts", "", "")
+	ignoreCase := flags.Bool("i", false, "")
+
+	positional := parseInterspersed(flags, []string{"-i", "error", "/var/log/*.log", "--hosts", "web{1..3}"})
+	require.Equal(t, []string{"error", "/var/log/*.log"}, positional)
+	require.Equal(t, "web{1..3}", *hosts)
+	require.True(t, *ignoreCase)
+}
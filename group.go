@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxGroupSessions is how many steps of a parallel group run at a time on
+// a host, below the limit of sessions per connection of sshd (MaxSessions,
+// 10 by default).
+const maxGroupSessions = 8
+
+// parallelGroups splits scripts into runs of consecutive steps of the
+// same "# parallel-group:", and single steps in between them.
+func parallelGroups(scripts []script) [][]script {
+	var groups [][]script
+	for i := 0; i < len(scripts); {
+		j := i + 1
+		if name := scripts[i].group; name != "" {
+			for j < len(scripts) && scripts[j].group == name {
+				j++
+			}
+		}
+		groups = append(groups, scripts[i:j])
+		i = j
+	}
+	return groups
+}
+
+// executeGroup executes the steps of a parallel group of file on host
+// concurrently, each in a session of its own, returning the failures of
+// all of them, or else the first error of any.
+func (r *runner) executeGroup(client *ssh.Client, host, file string, group []script) error {
+	var (
+		wg       sync.WaitGroup
+		sessions = make(chan struct{}, maxGroupSessions)
+		errs     = make([]error, len(group))
+	)
+	for i, sc := range group {
+		wg.Add(1)
+		go func(i int, sc script) {
+			defer wg.Done()
+			sessions <- struct{}{}
+			defer func() { <-sessions }()
+			errs[i] = r.executeScript(client, host, file, sc)
+		}(i, sc)
+	}
+	wg.Wait()
+
+	var failed failures
+	for _, err := range errs {
+		if f, ok := err.(failures); ok {
+			failed = append(failed, f...)
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
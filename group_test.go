@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parallelGroups(t *testing.T) {
+	sf, err := parse("1-setup", `apt-get update
+---
+# parallel-group: fetch
+curl -fsSO https://example.com/a.tgz
+---
+# parallel_group: fetch
+curl -fsSO https://example.com/b.tgz
+---
+tar xzf a.tgz
+---
+# parallel-group: fetch
+tar xzf b.tgz`)
+	require.NoError(t, err)
+
+	var sizes []int
+	for _, group := range parallelGroups(sf.scripts) {
+		sizes = append(sizes, len(group))
+	}
+	require.Equal(t, []int{1, 2, 1, 1}, sizes)
+
+	_, err = parse("bad", "# parallel-group: fetch\n@local make")
+	require.EqualError(t, err, "bad directive in script bad: parallel-group does not apply to local steps")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	krb5creds "github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	kerberosOnce   sync.Once
+	kerberosClient *client.Client // nil without a usable credential cache
+)
+
+// kerberos returns the client of the Kerberos tickets of the system
+// credential cache, loaded once, or nil if there are none (e.g. kinit
+// was not run, or the cache is not a file).
+func kerberos() *client.Client {
+	kerberosOnce.Do(func() {
+		path := ccachePath(os.Getenv("KRB5CCNAME"), os.Getuid())
+		if path == "" {
+			return
+		}
+		ccache, err := krb5creds.LoadCCache(path)
+		if err != nil {
+			return
+		}
+
+		// without krb5.conf, the KDCs of the realm are looked up in DNS
+		conf := os.Getenv("KRB5_CONFIG")
+		if conf == "" {
+			conf = "/etc/krb5.conf"
+		}
+		cfg, err := config.Load(conf)
+		if err != nil {
+			cfg = config.New()
+			cfg.LibDefaults.DNSLookupKDC = true
+		}
+
+		if cl, err := client.NewFromCCache(ccache, cfg, client.DisablePAFXFAST(true)); err == nil {
+			kerberosClient = cl
+		}
+	})
+	return kerberosClient
+}
+
+// ccachePath returns the path of the credential cache named by
+// $KRB5CCNAME, or else of the default one of the user uid, or "" if it is
+// not a file (e.g. KCM: or KEYRING:, which cannot be read).
+func ccachePath(name string, uid int) string {
+	switch {
+	case name == "":
+		return fmt.Sprintf("/tmp/krb5cc_%d", uid)
+	case strings.HasPrefix(name, "FILE:"):
+		return strings.TrimPrefix(name, "FILE:")
+	case strings.HasPrefix(name, "/"):
+		return name
+	}
+	return ""
+}
+
+// serviceTicket gets the ticket of the Kerberos service spn, replaced in
+// tests.
+var serviceTicket = func(cl *client.Client, spn string) (messages.Ticket, types.EncryptionKey, error) {
+	return cl.GetServiceTicket(spn)
+}
+
+// gssapiAuth returns gssapi-with-mic authentication (RFC 4462) to host
+// with the Kerberos tickets of the system credential cache, or nil if
+// there are none or no ticket of host can be got (e.g. they expired).
+// The ticket is got up front, as failing to in the handshake aborts it
+// before keys or passwords are tried.
+func gssapiAuth(host string) ssh.AuthMethod {
+	cl := kerberos()
+	if cl == nil || host == "" {
+		return nil
+	}
+	ticket, key, err := serviceTicket(cl, "host/"+host)
+	if err != nil {
+		return nil
+	}
+	return ssh.GSSAPIWithMICAuthMethod(&gssapiClient{client: cl, ticket: ticket, key: key}, host)
+}
+
+// A gssapiClient establishes the Kerberos security context of
+// gssapi-with-mic, with mutual authentication.
+type gssapiClient struct {
+	client *client.Client
+	ticket messages.Ticket     // of the host
+	key    types.EncryptionKey // of the ticket, then of the context once established
+	flags  byte                // of MIC tokens, i.e. whether key is a subkey of the host
+}
+
+func (g *gssapiClient) InitSecContext(target string, token []byte, isGSSDelegCreds bool) ([]byte, bool, error) {
+	if token == nil {
+		contextFlags := []int{gssapi.ContextFlagInteg, gssapi.ContextFlagMutual}
+		if isGSSDelegCreds {
+			contextFlags = append(contextFlags, gssapi.ContextFlagDeleg)
+		}
+		request, err := spnego.NewKRB5TokenAPREQ(g.client, g.ticket, g.key, contextFlags, []int{flags.APOptionMutualRequired})
+		if err != nil {
+			return nil, false, errors.Wrap(err, "failed to create Kerberos request")
+		}
+		b, err := request.Marshal()
+		return b, true, errors.Wrap(err, "failed to create Kerberos request")
+	}
+
+	// the host authenticates itself in reply, possibly with a subkey
+	var reply spnego.KRB5Token
+	if err := reply.Unmarshal(token); err != nil {
+		return nil, false, errors.Wrap(err, "malformed Kerberos reply")
+	}
+	switch {
+	case reply.IsKRBError():
+		return nil, false, errors.Errorf("Kerberos ticket rejected: %s", reply.KRBError.EText)
+	case !reply.IsAPRep():
+		return nil, false, errors.New("malformed Kerberos reply, expected AP-REP")
+	}
+	b, err := crypto.DecryptEncPart(reply.APRep.EncPart, g.key, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to authenticate host with Kerberos")
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(b); err != nil {
+		return nil, false, errors.Wrap(err, "malformed Kerberos reply")
+	}
+	if len(part.Subkey.KeyValue) > 0 {
+		g.key, g.flags = part.Subkey, gssapi.MICTokenFlagAcceptorSubkey
+	}
+	return nil, false, nil
+}
+
+func (g *gssapiClient) GetMIC(micField []byte) ([]byte, error) {
+	token := gssapi.MICToken{Flags: g.flags, Payload: micField}
+	if err := token.SetChecksum(g.key, keyusage.GSSAPI_INITIATOR_SIGN); err != nil {
+		return nil, errors.Wrap(err, "failed to sign with Kerberos")
+	}
+	return token.Marshal()
+}
+
+func (g *gssapiClient) DeleteSecContext() error {
+	g.key, g.flags = types.EncryptionKey{}, 0
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ccachePath(t *testing.T) {
+	require.Equal(t, "/tmp/krb5cc_1000", ccachePath("", 1000))
+	require.Equal(t, "/tmp/tickets", ccachePath("FILE:/tmp/tickets", 1000))
+	require.Equal(t, "/tmp/tickets", ccachePath("/tmp/tickets", 1000))
+	require.Equal(t, "", ccachePath("KCM:", 1000))
+	require.Equal(t, "", ccachePath("KEYRING:persistent:1000", 1000))
+}
+
+func Test_gssapiClient_GetMIC(t *testing.T) {
+	key := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	for _, flags := range []byte{0, gssapi.MICTokenFlagAcceptorSubkey} {
+		g := &gssapiClient{key: key, flags: flags}
+		b, err := g.GetMIC([]byte("session"))
+		require.NoError(t, err)
+
+		var token gssapi.MICToken
+		require.NoError(t, token.Unmarshal(b, false))
+		token.Payload = []byte("session")
+		require.Equal(t, flags, token.Flags)
+		ok, err := token.Verify(key, keyusage.GSSAPI_INITIATOR_SIGN)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+}
+
+func Test_gssapiAuth(t *testing.T) {
+	kerberosOnce.Do(func() {})
+	kerberosClient = new(client.Client)
+	defer func() { kerberosClient = nil }()
+
+	var spns []string
+	expired := errors.New("ticket expired")
+	defer func(get func(*client.Client, string) (messages.Ticket, types.EncryptionKey, error)) {
+		serviceTicket = get
+	}(serviceTicket)
+	serviceTicket = func(cl *client.Client, spn string) (messages.Ticket, types.EncryptionKey, error) {
+		spns = append(spns, spn)
+		if spn == "host/web2" {
+			return messages.Ticket{}, types.EncryptionKey{}, expired
+		}
+		return messages.Ticket{}, types.EncryptionKey{}, nil
+	}
+
+	methods := 2 // the password, and keyboard-interactive
+	if sshAgentAuth() != nil {
+		methods++
+	}
+	require.NotNil(t, gssapiAuth("web1"))
+	require.Len(t, newSSHAuth("root", "web1", credentials{password: "secret"}), methods+1)
+
+	// without a ticket of the host, keys and passwords are tried instead
+	require.Nil(t, gssapiAuth("web2"))
+	require.Len(t, newSSHAuth("root", "web2", credentials{password: "secret"}), methods)
+	require.Equal(t, []string{"host/web1", "host/web1", "host/web2", "host/web2"}, spns)
+}
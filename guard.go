@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// A guard is a check of remote state declared by a script, e.g.
+// "# creates: /etc/app/installed" or "# unless: dpkg -s app", which
+// when it holds means the script was already applied to the host.
+type guard struct {
+	directive string // creates or unless
+	value     string
+}
+
+func (g guard) String() string {
+	return g.directive + " " + g.value
+}
+
+// command returns the remote command which succeeds if the guard holds.
+func (g guard) command() string {
+	if g.directive == "creates" {
+		return "test -e " + quote(g.value)
+	}
+	return g.value
+}
+
+// guarded returns which of the guards of sc holds on host, if any,
+// in which case the script is to be skipped.
+func (r *runner) guarded(client *ssh.Client, host string, sc script) (guard, bool, error) {
+	for _, g := range sc.guards {
+		_, err := r.probeRemote(client, host, g.command())
+		switch err.(type) {
+		case nil:
+			return g, true, nil
+		case *ssh.ExitError:
+			continue // the guard does not hold, so the script applies
+		default:
+			return g, false, errors.Wrapf(err, "failed to check %s", g)
+		}
+	}
+	return guard{}, false, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const file7 = `
+# creates: /etc/my app/installed
+# unless: dpkg -s myapp
+apt-get install -y myapp && touch '/etc/my app/installed'
+`
+
+func Test_parse_guards(t *testing.T) {
+	sf, err := parse("7-guarded", file7)
+	require.NoError(t, err)
+	require.Equal(t, []guard{
+		{directive: "creates", value: "/etc/my app/installed"},
+		{directive: "unless", value: "dpkg -s myapp"},
+	}, sf.scripts[0].guards)
+
+	require.Equal(t, `test -e '/etc/my app/installed'`, sf.scripts[0].guards[0].command())
+	require.Equal(t, "dpkg -s myapp", sf.scripts[0].guards[1].command())
+	require.Equal(t, "creates /etc/my app/installed", sf.scripts[0].guards[0].String())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// how commands are kept out of the shell history of hosts, in which the
+// values of variables (e.g. secrets) substituted into them would persist
+const (
+	historyOff   = "off"   // commands are run as they are
+	historySpace = "space" // commands are prefixed with a space, which HISTCONTROL=ignorespace (or ignoreboth) leaves out
+	historyUnset = "unset" // as space, and posix shells started by commands (e.g. bash -i) write no history file
+)
+
+var historyModes = []string{historyOff, historySpace, historyUnset}
+
+func parseHistoryMode(s string) (string, error) {
+	for _, mode := range historyModes {
+		if s == mode {
+			return s, nil
+		}
+	}
+	return "", errors.Errorf("unknown shell history mode %q, must be one of %s", s, strings.Join(historyModes, ", "))
+}
+
+// historyMode returns how commands are kept out of the shell history of
+// host, which may be set per host with the "shell-history" label in the
+// inventory, overriding --shell-history.
+func (r *runner) historyMode(host string) (string, error) {
+	if s, exists := r.inventory.metadata(host)["shell-history"]; exists {
+		return parseHistoryMode(s)
+	}
+	return r.shellHistory, nil
+}
+
+// historyStatement returns the statement which keeps shells started by a
+// command from writing history, if any.
+func historyStatement(mode string, sh shell) string {
+	if mode != historyUnset || sh != shellSh {
+		return ""
+	}
+	return sh.export("HISTFILE", "/dev/null")
+}
+
+// withoutHistory returns command as run in mode.
+func withoutHistory(mode, command string) string {
+	if mode == historyOff {
+		return command
+	}
+	return " " + command
+}
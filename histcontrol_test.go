@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runner_historyMode(t *testing.T) {
+	inv, err := parseInventory("web1\nlegacy1 shell-history=unset\nbad1 shell-history=never\n")
+	require.NoError(t, err)
+	r := &runner{inventory: inv, shellHistory: historySpace}
+
+	mode, err := r.historyMode("web1")
+	require.NoError(t, err)
+	require.Equal(t, historySpace, mode)
+
+	mode, err = r.historyMode("legacy1")
+	require.NoError(t, err)
+	require.Equal(t, historyUnset, mode)
+
+	_, err = r.historyMode("bad1")
+	require.EqualError(t, err, `unknown shell history mode "never", must be one of off, space, unset`)
+}
+
+func Test_withoutHistory(t *testing.T) {
+	require.Equal(t, "uptime", withoutHistory(historyOff, "uptime"))
+	require.Equal(t, " uptime", withoutHistory(historySpace, "uptime"))
+	require.Equal(t, "", historyStatement(historySpace, shellSh))
+	require.Equal(t, "HISTFILE='/dev/null'; export HISTFILE;", historyStatement(historyUnset, shellSh))
+	require.Equal(t, "", historyStatement(historyUnset, shellPowershell))
+}
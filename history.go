@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A historyEntry is the audit record of one run, appended to the history
+// log once the run completes. Outputs are left out, to keep it small.
+type historyEntry struct {
+	ID       string        `json:"id"`
+	Started  time.Time     `json:"started"`
+	Seconds  float64       `json:"seconds"`
+	Operator string        `json:"operator"`
+	Status   string        `json:"status"`
+	Kind     string        `json:"kind"`
+	Items    []string      `json:"items"`
+	Hosts    []string      `json:"hosts"`
+	Results  []hostOutcome `json:"results"`
+}
+
+// A hostOutcome is the result of a script on a host, without its output.
+type hostOutcome struct {
+	Host     string        `json:"host"`
+	File     string        `json:"file,omitempty"`
+	Command  string        `json:"command,omitempty"`
+	ExitCode int           `json:"exit_code"`
+	Seconds  float64       `json:"seconds"`
+	Error    string        `json:"error,omitempty"`
+	Reason   string        `json:"reason,omitempty"`
+	Failed   []string      `json:"failed_assertions,omitempty"`
+	Skipped  string        `json:"skipped,omitempty"`
+	Tracked  []trackedFile `json:"tracked,omitempty"` // without their diffs
+}
+
+func historyPath() string {
+	return filepath.Join(filepath.Dir(runsDir()), "history", "runs.jsonl")
+}
+
+func newHistoryEntry(r *runner, started time.Time, kind string, items, hosts []string, status string) historyEntry {
+	e := historyEntry{
+		ID:       r.id,
+		Started:  started.UTC(),
+		Seconds:  time.Since(started).Seconds(),
+		Operator: os.Getenv("USER"),
+		Status:   status,
+		Kind:     kind,
+		Items:    items,
+		Hosts:    hosts,
+	}
+	for _, res := range r.results {
+		var tracked []trackedFile
+		for _, t := range res.Tracked {
+			t.Diff = ""
+			tracked = append(tracked, t)
+		}
+		e.Results = append(e.Results, hostOutcome{
+			Host:     res.Host,
+			File:     res.File,
+			Command:  res.Command,
+			ExitCode: res.ExitCode,
+			Seconds:  res.Seconds,
+			Error:    res.Error,
+			Reason:   res.Reason,
+			Failed:   res.Failed,
+			Skipped:  res.Skipped,
+			Tracked:  tracked,
+		})
+	}
+	return e
+}
+
+// remember appends the run to the history log, once it has completed.
+func (r *runner) remember(started time.Time, kind string, items, hosts []string, err error) {
+	e := newHistoryEntry(r, started, kind, items, hosts, status(err))
+	if err := appendHistory(historyPath(), e); err != nil {
+		r.out.warning("run %s is missing from history: %v", r.id, err)
+	}
+}
+
+// failures returns how many of the hosts of the run failed.
+func (e historyEntry) failures() int {
+	failed := make(map[string]bool)
+	for _, res := range e.Results {
+		if res.Error != "" || len(res.Failed) > 0 {
+			failed[res.Host] = true
+		}
+	}
+	return len(failed)
+}
+
+// appendHistory appends e to the history log at path. The log is only
+// ever appended to, so that it may serve as an audit trail.
+func appendHistory(path string, e historyEntry) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode history")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create history directory")
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open history")
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Write(append(bs, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write history")
+	}
+	return nil
+}
+
+// readHistory returns the entries of the history log at path, oldest first.
+func readHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to open history")
+	}
+	defer func() { _ = f.Close() }()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse history line %d", line)
+		}
+		entries = append(entries, e)
+	}
+	return entries, errors.Wrap(scanner.Err(), "failed to read history")
+}
+
+// historyCmd implements "commando history [-n count]".
+func historyCmd(arguments []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	count := flags.Int("n", 20, "number of most recent runs to list (0 for all)")
+	wide := flags.Bool("wide", false, "do not truncate or wrap runs to fit the terminal")
+	_ = flags.Parse(arguments)
+
+	entries, err := readHistory(historyPath())
+	if err != nil {
+		return err
+	}
+	if *count > 0 && len(entries) > *count {
+		entries = entries[len(entries)-*count:]
+	}
+
+	runs := &table{}
+	for _, e := range entries {
+		s := colors.success
+		if e.Status != "completed" || e.failures() > 0 {
+			s = colors.failure
+		}
+		runs.add(s, e.ID, e.Started.Local().Format("2006-01-02 15:04:05"), e.Operator, e.Status,
+			fmt.Sprintf("%d hosts, %d failed", len(e.Hosts), e.failures()), e.Kind+" "+strings.Join(e.Items, " "))
+	}
+	if *wide {
+		tableWidth = 0
+	}
+	runs.print()
+	return nil
+}
+
+// showCmd implements "commando show <run-id>".
+func showCmd(arguments []string) error {
+	if len(arguments) != 1 {
+		return errors.New("usage: commando show <run-id>")
+	}
+
+	entries, err := readHistory(historyPath())
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.ID != arguments[0] {
+			continue
+		}
+
+		colors.info.println("run %s by %s at %s: %s (%.1fs)", e.ID, e.Operator,
+			e.Started.Local().Format(time.RFC3339), e.Status, e.Seconds)
+		colors.info.println("%s %s on %d hosts", e.Kind, strings.Join(e.Items, " "), len(e.Hosts))
+		for _, res := range e.Results {
+			line := fmt.Sprintf("%s %s `%s` exit %d (%.1fs)", res.Host, res.File, res.Command, res.ExitCode, res.Seconds)
+			switch {
+			case res.Skipped != "":
+				colors.muted.println("%s %s skipped: %s", res.Host, res.File, res.Skipped)
+			case res.Error != "":
+				colors.failure.println("%s: %s", line, res.Error)
+			case len(res.Failed) > 0:
+				colors.failure.println("%s: %s", line, strings.Join(res.Failed, "; "))
+			default:
+				colors.success.println("%s", line)
+			}
+		}
+		return nil
+	}
+	return errors.Errorf("no run with id %s in history", arguments[0])
+}
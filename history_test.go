@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_history(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history", "runs.jsonl")
+
+	entries, err := readHistory(path)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	r := &runner{id: "run-1", results: []result{
+		{Host: "web1", File: "1-check", Command: "true", Output: "secret output"},
+		{Host: "web2", File: "1-check", Command: "true", ExitCode: 1, Error: "exit status 1"},
+	}}
+	started := time.Now().Add(-time.Minute)
+	require.NoError(t, appendHistory(path, newHistoryEntry(r, started, "scripts", []string{"1-check"}, []string{"web1", "web2"}, "completed")))
+
+	r.id = "run-2"
+	r.results = nil
+	require.NoError(t, appendHistory(path, newHistoryEntry(r, started, "command", []string{"uptime"}, []string{"web1"}, "cancelled")))
+
+	entries, err = readHistory(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	require.Equal(t, "run-1", entries[0].ID)
+	require.Equal(t, 1, entries[0].failures())
+	require.Equal(t, 1, entries[0].Results[1].ExitCode)
+	require.True(t, entries[0].Seconds >= 60)
+	require.Equal(t, "cancelled", entries[1].Status)
+
+	bs, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(bs), "secret output")
+}
@@ -5,6 +5,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
 const expandFmt = `([[:word:]-]+)(\{([\d]+)..([\d]+)\})?([[:word:]\.-]*)`
@@ -18,6 +20,60 @@ func hosts(input string) []string {
 	return resolve(split)
 }
 
+// A provider discovers hosts from an external source, given the part of a
+// host expression after its "<provider>:" prefix, e.g. "aws:tag:Role=web".
+type provider func(args args, query string) ([]string, error)
+
+var providers = map[string]provider{
+	"aws":    awsHosts,
+	"consul": consulHosts,
+	"plugin": pluginHosts,
+}
+
+// targets returns the hosts to execute against, which are those given
+// by --hosts, or every host in the inventory if --hosts is not set.
+//
+// Host expressions of --hosts with a provider prefix are resolved
+// by that provider, and those of the form "group:<name>" are the hosts
+// of that group in the inventory, whereas the rest are expanded as usual.
+func targets(args args, inv inventory) ([]string, error) {
+	if args.hostList == "" {
+		return inv.hosts(), nil
+	}
+
+	var resolved []string
+	for _, raw := range strings.Split(args.hostList, ",") {
+		raw = strings.TrimSpace(raw)
+
+		if strings.HasPrefix(raw, "group:") {
+			group := inv.group(strings.TrimPrefix(raw, "group:"))
+			if len(group) == 0 {
+				return nil, errors.Errorf("no hosts in inventory of %q", raw)
+			}
+			resolved = append(resolved, group...)
+			continue
+		}
+
+		idx := strings.Index(raw, ":")
+		if idx > 0 {
+			if discover, exists := providers[raw[:idx]]; exists {
+				query := raw[idx+1:]
+				discovered, err := cachedDiscover(args, raw, func() ([]string, error) {
+					return discover(args, query)
+				})
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to discover hosts from %q", raw)
+				}
+				resolved = append(resolved, discovered...)
+				continue
+			}
+		}
+
+		resolved = append(resolved, expand(raw)...)
+	}
+	return resolved, nil
+}
+
 func resolve(resolvable []string) []string {
 	var resolved []string
 	for _, raw := range resolvable {
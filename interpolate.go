@@ -0,0 +1,47 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+var interpolationRe = regexp.MustCompile(`\$\$|\$\{([[:alpha:]_][[:word:]]*)(?:(:?[-?])([^}]*))?\}`)
+
+// interpolate expands the references to variables in s, as looked up with
+// lookup (e.g. os.LookupEnv), of the forms
+//
+//	${VAR}          the value of VAR, or nothing if it is unset
+//	${VAR:-default} the value of VAR, or default if it is unset or empty
+//	${VAR-default}  the value of VAR, or default if it is unset
+//	${VAR:?message} the value of VAR, or an error if it is unset or empty
+//	${VAR?message}  the value of VAR, or an error if it is unset
+//	$$              a literal $
+func interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	var err error
+	expanded := interpolationRe.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		m := interpolationRe.FindStringSubmatch(ref)
+		name, operator, word := m[1], m[2], m[3]
+
+		value, set := lookup(name)
+		missing := !set || (value == "" && len(operator) == 2)
+		switch {
+		case !missing:
+			return value
+		case operator == ":-" || operator == "-":
+			return word
+		case operator == ":?" || operator == "?":
+			if word == "" {
+				word = "is required"
+			}
+			if err == nil {
+				err = errors.Errorf("%s %s", name, word)
+			}
+		}
+		return value
+	})
+	return expanded, err
+}
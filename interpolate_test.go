@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_interpolate(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		value, set := map[string]string{"DC": "ams1", "EMPTY": ""}[name]
+		return value, set
+	}
+
+	for s, exp := range map[string]string{
+		"web-${DC}.example.com":  "web-ams1.example.com",
+		"${UNSET}":               "",
+		"${UNSET:-fra1}":         "fra1",
+		"${EMPTY:-fra1}":         "fra1",
+		"${EMPTY-fra1}":          "",
+		"${DC:?is required}":     "ams1",
+		"cost $$5, not $DC":      "cost $5, not $DC",
+		"${DC}-${UNSET-default}": "ams1-default",
+	} {
+		expanded, err := interpolate(s, lookup)
+		require.NoError(t, err)
+		require.Equal(t, exp, expanded, s)
+	}
+
+	_, err := interpolate("env=${ENV:?must be set for CI}", lookup)
+	require.EqualError(t, err, "ENV must be set for CI")
+	_, err = interpolate("${EMPTY:?}", lookup)
+	require.EqualError(t, err, "EMPTY is required")
+	_, err = interpolate("${EMPTY?}", lookup)
+	require.NoError(t, err)
+}
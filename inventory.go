@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// metadata is arbitrary information about a host, such as the
+// datacenter it lives in, its role, or the groups it belongs to.
+type metadata map[string]string
+
+// An inventory describes a set of hosts along with their metadata.
+//
+// Each line of an inventory file is a host expression (as accepted by --hosts)
+// followed by any number of key=value pairs, e.g.
+//
+//	web{1..4}.ams1.example.com dc=ams1 role=web groups=frontend,public
+//
+// Blank lines and lines starting with '#' are ignored.
+type inventory struct {
+	order []string
+	meta  map[string]metadata
+}
+
+func loadInventory(path string) (inventory, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return inventory{}, errors.Wrap(err, "failed to read inventory")
+	}
+	inv, err := parseInventory(string(bs))
+	if err != nil {
+		return inv, parseError{err: err}
+	}
+	return inv, nil
+}
+
+func parseInventory(content string) (inventory, error) {
+	inv := inventory{meta: make(map[string]metadata)}
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		line, err := interpolate(line, os.LookupEnv)
+		if err != nil {
+			return inventory{}, errors.Wrapf(err, "line %d of inventory", i+1)
+		}
+
+		tokens := strings.Fields(line)
+		meta := make(metadata)
+		for _, token := range tokens[1:] {
+			idx := strings.Index(token, "=")
+			if idx < 1 {
+				return inventory{}, errors.Errorf("malformed metadata %q on line %d of inventory", token, i+1)
+			}
+			meta[token[:idx]] = token[idx+1:]
+		}
+
+		expanded := expand(tokens[0])
+		if len(expanded) == 0 {
+			return inventory{}, errors.Errorf("no hosts resolved from %q on line %d of inventory", tokens[0], i+1)
+		}
+
+		for _, host := range expanded {
+			if _, exists := inv.meta[host]; !exists {
+				inv.order = append(inv.order, host)
+				inv.meta[host] = make(metadata)
+			}
+			for key, value := range meta {
+				inv.meta[host][key] = value
+			}
+		}
+	}
+
+	return inv, nil
+}
+
+// hosts returns every host in the inventory, in the order they were listed.
+func (inv inventory) hosts() []string {
+	return inv.order
+}
+
+// metadata returns the metadata of host, which is empty if
+// host is not in the inventory.
+func (inv inventory) metadata(host string) metadata {
+	return inv.meta[host]
+}
+
+// masked returns a copy of md with the values of keys marking them as
+// secrets masked, as in inventorySecrets, to be reported.
+func (md metadata) masked() metadata {
+	if md == nil {
+		return nil
+	}
+	masked := make(metadata, len(md))
+	for key, value := range md {
+		if isSecret(strings.ToUpper(key)) && value != "" {
+			value = mask
+		}
+		masked[key] = value
+	}
+	return masked
+}
+
+// group returns the hosts of the inventory belonging to group, which are
+// those with the group in their "groups" label, or as their "role".
+func (inv inventory) group(group string) []string {
+	var hosts []string
+	for _, host := range inv.order {
+		meta := inv.meta[host]
+		member := meta["role"] == group
+		for _, g := range strings.Split(meta["groups"], ",") {
+			member = member || g == group
+		}
+		if member {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
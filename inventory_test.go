@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const inventory1 = `
+# frontends
+web{1..2}.example.com dc=ams1 role=web groups=frontend,public
+
+db1.example.com dc=fra1 role=db
+web2.example.com rack=4
+`
+
+func Test_parseInventory(t *testing.T) {
+	inv, err := parseInventory(inventory1)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"web1.example.com",
+		"web2.example.com",
+		"db1.example.com",
+	}, inv.hosts())
+	require.Equal(t, metadata{
+		"dc":     "ams1",
+		"role":   "web",
+		"groups": "frontend,public",
+		"rack":   "4",
+	}, inv.metadata("web2.example.com"))
+	require.Equal(t, metadata{
+		"dc":   "fra1",
+		"role": "db",
+	}, inv.metadata("db1.example.com"))
+	require.Nil(t, inv.metadata("other.example.com"))
+
+	_, err = parseInventory("web1 dc")
+	require.Error(t, err)
+}
+
+func Test_metadata_masked(t *testing.T) {
+	inv, err := parseInventory("db1 role=db db_password=hunter2 api_token=")
+	require.NoError(t, err)
+	require.Equal(t, metadata{"role": "db", "db_password": mask, "api_token": ""}, inv.metadata("db1").masked())
+	require.Equal(t, "hunter2", inv.metadata("db1")["db_password"], "the inventory is left as is")
+
+	r := &runner{out: &quiet{}, inventory: inv}
+	r.record(result{Host: "db1"})
+	require.Equal(t, mask, r.results[0].Metadata["db_password"])
+}
+
+func Test_parseInventory_interpolate(t *testing.T) {
+	require.NoError(t, os.Setenv("COMMANDO_TEST_DOMAIN", "ci.example.com"))
+	defer os.Unsetenv("COMMANDO_TEST_DOMAIN")
+
+	inv, err := parseInventory("web1.${COMMANDO_TEST_DOMAIN} env=${COMMANDO_TEST_ENV:-local}")
+	require.NoError(t, err)
+	require.Equal(t, []string{"web1.ci.example.com"}, inv.hosts())
+	require.Equal(t, metadata{"env": "local"}, inv.metadata("web1.ci.example.com"))
+
+	_, err = parseInventory("web1\nweb2 env=${COMMANDO_TEST_ENV:?is required}")
+	require.EqualError(t, err, "line 2 of inventory: COMMANDO_TEST_ENV is required")
+}
+
+func Test_inventory_group(t *testing.T) {
+	inv, err := parseInventory("web{1..2} groups=frontend,public\ndb1 role=db\nlb1 role=web groups=public\n")
+	require.NoError(t, err)
+	require.Equal(t, []string{"web1", "web2", "lb1"}, inv.group("public"))
+	require.Equal(t, []string{"lb1"}, inv.group("web"))
+	require.Equal(t, []string{"db1"}, inv.group("db"))
+	require.Empty(t, inv.group("backend"))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveRequest is the global request sent as a keepalive, which
+// servers answer (if only to refuse it) as long as they are responsive.
+const keepaliveRequest = "keepalive@openssh.com"
+
+// keepaliveMisses is how many keepalives in a row may go unanswered before
+// the server is considered unresponsive, as ServerAliveCountMax of ssh.
+const keepaliveMisses = 3
+
+// lostWait is how long a server has to answer whether it is still there,
+// once a command failed in a way that suggests its connection was lost.
+const lostWait = 5 * time.Second
+
+// connectionLostError is returned by a script whose connection was lost
+// while it ran, e.g. to a flaky link or an unresponsive server, or before
+// it could start, with err of starting it.
+type connectionLostError struct {
+	after time.Duration
+	err   error
+}
+
+func (e connectionLostError) Error() string {
+	if e.err != nil {
+		return "connection lost before execution: " + e.err.Error()
+	}
+	return "connection lost during execution, after " + e.after.Round(time.Second).String()
+}
+
+// alive returns whether the server of conn answers a keepalive within wait.
+func alive(conn ssh.Conn, wait time.Duration) bool {
+	answered := make(chan error, 1)
+	go func() {
+		_, _, err := conn.SendRequest(keepaliveRequest, true, nil)
+		answered <- err
+	}()
+
+	select {
+	case err := <-answered:
+		return err == nil
+	case <-time.After(wait):
+		return false
+	}
+}
+
+// keepalive sends keepalives on client every interval (if any) until it is
+// closed, and closes it once keepaliveMisses in a row go unanswered, so
+// that commands on an unresponsive server fail instead of hanging.
+func (r *runner) keepalive(client *ssh.Client, host string) {
+	if r.keepaliveEvery <= 0 {
+		return
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(closed)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(r.keepaliveEvery)
+		defer ticker.Stop()
+
+		missed := 0
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+
+			if alive(client, r.keepaliveEvery) {
+				missed = 0
+				continue
+			}
+			if missed++; missed >= keepaliveMisses {
+				r.out.warning("%s is unresponsive, closing its connection", host)
+				_ = client.Close()
+				return
+			}
+		}
+	}()
+}
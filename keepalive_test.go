@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// unresponsiveConn is a connection whose server answers requests once
+// answer is closed, or never if it is nil.
+type unresponsiveConn struct {
+	ssh.Conn
+	answer chan struct{}
+	err    error
+}
+
+func (c unresponsiveConn) SendRequest(string, bool, []byte) (bool, []byte, error) {
+	if c.answer != nil {
+		<-c.answer
+	}
+	return false, nil, c.err
+}
+
+func Test_alive(t *testing.T) {
+	answered := make(chan struct{})
+	close(answered)
+	require.True(t, alive(unresponsiveConn{answer: answered}, time.Second), "refusing the request is an answer")
+	require.False(t, alive(unresponsiveConn{answer: answered, err: io.EOF}, time.Second))
+
+	hung := unresponsiveConn{answer: make(chan struct{})}
+	defer close(hung.answer)
+	require.False(t, alive(hung, 10*time.Millisecond))
+}
+
+func Test_connectionLostError(t *testing.T) {
+	err := connectionLostError{after: 90*time.Second + 300*time.Millisecond}
+	require.Equal(t, "connection lost during execution, after 1m30s", err.Error())
+	require.Equal(t, retryConnect, retryClass(err))
+
+	err = connectionLostError{err: errors.Wrap(io.EOF, "failed to create session")}
+	require.Equal(t, "connection lost before execution: failed to create session: EOF", err.Error())
+	require.Equal(t, reasonLost, reason(err))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// loadSigner loads the private key at keyPath, which is combined with the
+// signed OpenSSH certificate at certPath, if set, so that the certificate
+// is presented to servers when authenticating.
+func loadSigner(keyPath, certPath string) (ssh.Signer, error) {
+	keyBytes, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read private key")
+	}
+
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse private key (use ssh-agent for encrypted keys)")
+	}
+
+	if certPath == "" {
+		return signer, nil
+	}
+
+	certBytes, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read certificate")
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse certificate")
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.Errorf("%s is not an ssh certificate", certPath)
+	}
+
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// hostKeyCallback returns the callback used to verify the keys of hosts.
+// If caPath is set, hosts must present a host certificate signed by one of
+// the certificate authorities listed in it (in authorized_keys format, or
+// known_hosts format with @cert-authority markers). Otherwise host keys
+// are not verified.
+func hostKeyCallback(caPath string) (ssh.HostKeyCallback, error) {
+	if caPath == "" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	bs, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read host certificate authorities")
+	}
+
+	authorities, err := parseAuthorities(bs)
+	if err != nil {
+		return nil, err
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+			for _, authority := range authorities {
+				if bytes.Equal(authority.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: func(host string, _ net.Addr, _ ssh.PublicKey) error {
+			return errors.Errorf("host %s did not present a certificate", host)
+		},
+	}
+	return checker.CheckHostKey, nil
+}
+
+func parseAuthorities(bs []byte) ([]ssh.PublicKey, error) {
+	var authorities []ssh.PublicKey
+	for _, line := range bytes.Split(bs, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if bytes.HasPrefix(line, []byte("@cert-authority")) {
+			_, _, key, _, _, err := ssh.ParseKnownHosts(line)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse certificate authority")
+			}
+			authorities = append(authorities, key)
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse certificate authority")
+		}
+		authorities = append(authorities, key)
+	}
+
+	if len(authorities) == 0 {
+		return nil, errors.Errorf("no host certificate authorities found")
+	}
+	return authorities, nil
+}
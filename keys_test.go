@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T) (*rsa.PrivateKey, ssh.Signer) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return key, signer
+}
+
+func Test_loadSigner_cert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, ca := newTestKey(t)
+	key, signer := newTestKey(t)
+
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	keyPath := filepath.Join(dir, "id_rsa")
+	certPath := filepath.Join(dir, "id_rsa-cert.pub")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, ioutil.WriteFile(keyPath, keyPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(certPath, ssh.MarshalAuthorizedKey(cert), 0600))
+
+	loaded, err := loadSigner(keyPath, certPath)
+	require.NoError(t, err)
+	_, isCert := loaded.PublicKey().(*ssh.Certificate)
+	require.True(t, isCert)
+
+	_, err = loadSigner(keyPath, keyPath)
+	require.Error(t, err)
+}
+
+func Test_parseAuthorities(t *testing.T) {
+	_, ca1 := newTestKey(t)
+	_, ca2 := newTestKey(t)
+
+	content := "# host cas\n" +
+		string(ssh.MarshalAuthorizedKey(ca1.PublicKey())) +
+		"@cert-authority *.example.com " + string(ssh.MarshalAuthorizedKey(ca2.PublicKey()))
+
+	authorities, err := parseAuthorities([]byte(content))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(authorities))
+	require.Equal(t, ca1.PublicKey().Marshal(), authorities[0].Marshal())
+	require.Equal(t, ca2.PublicKey().Marshal(), authorities[1].Marshal())
+
+	_, err = parseAuthorities([]byte("# nothing\n"))
+	require.Error(t, err)
+}
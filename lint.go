@@ -0,0 +1,296 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A lintProblem is a mistake found in a script file by "commando lint".
+// Errors fail the lint, while warnings may be intended.
+type lintProblem struct {
+	file    string
+	step    int // from 1, or 0 for the whole file
+	warning bool
+	message string
+}
+
+func (p lintProblem) String() string {
+	level := "error"
+	if p.warning {
+		level = "warning"
+	}
+	if p.step == 0 {
+		return fmt.Sprintf("%s: %s: %s", p.file, level, p.message)
+	}
+	return fmt.Sprintf("%s: step %d: %s: %s", p.file, p.step, level, p.message)
+}
+
+// builtinVars are the variables every host has, besides its metadata.
+var builtinVars = []string{"host", "hostname_short", "index", "Batch.Number", "Batch.Hosts"}
+
+// terminalCommands are commands after which the following steps of a script
+// file never run, as they take the host down (and its connection with it).
+var terminalCommands = []string{"reboot", "poweroff", "halt", "shutdown", "systemctl reboot", "systemctl poweroff", "systemctl halt", "init 0", "init 6"}
+
+// lintDirs lints the script files of dirs, as lintDir, and the files of
+// the same name across them, as lintDuplicates.
+func lintDirs(dirs []string, metadataKeys map[string]bool) ([]lintProblem, error) {
+	var (
+		problems []lintProblem
+		files    [][]scriptfile
+	)
+	for _, dir := range dirs {
+		found, linted, err := lintDir(dir, metadataKeys)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, found...)
+		files = append(files, linted)
+	}
+	return append(problems, lintDuplicates(files)...), nil
+}
+
+// lintDuplicates returns the problems of script files of dirs (in the
+// order of --scripts) of the same path, of which only the last overlay
+// runs, and of the same "# name:" header, which the catalog cannot tell
+// apart.
+func lintDuplicates(dirs [][]scriptfile) []lintProblem {
+	var problems []lintProblem
+	overlay := make(map[string]scriptfile)
+	for _, files := range dirs {
+		for _, sf := range files {
+			if overridden, exists := overlay[sf.name]; exists {
+				problems = append(problems, lintProblem{file: overridden.path, warning: true,
+					message: fmt.Sprintf("overridden by %s, as only the last of the --scripts of a path runs", sf.path)})
+			}
+			overlay[sf.name] = sf
+		}
+	}
+
+	paths := make(map[string][]string) // by name of the header
+	for _, files := range dirs {
+		for _, sf := range files {
+			if name := sf.meta["name"]; name != "" && overlay[sf.name].path == sf.path {
+				paths[name] = append(paths[name], sf.path)
+			}
+		}
+	}
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(paths[name]) > 1 {
+			problems = append(problems, lintProblem{file: paths[name][0],
+				message: fmt.Sprintf("duplicate name %q, also of %s", name, strings.Join(paths[name][1:], ", "))})
+		}
+	}
+	return problems
+}
+
+// lintDir parses every script file of dir, returning the problems found
+// in them, and the files linted. Variables are checked against the
+// metadata keys of the inventory, if known (i.e. not nil).
+func lintDir(dir string, metadataKeys map[string]bool) ([]lintProblem, []scriptfile, error) {
+	var (
+		problems []lintProblem
+		files    []scriptfile
+	)
+	included := make(map[string]bool)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrap(err, "failed to read scripts")
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		name, err := scriptName(dir, path)
+		if err != nil {
+			return err
+		}
+		sf, err := read(name, path, included)
+		if err != nil {
+			problems = append(problems, lintProblem{file: path, message: err.Error()})
+			return nil
+		}
+		files = append(files, sf)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var linted []scriptfile
+	for _, sf := range files {
+		if included[sf.path] {
+			continue
+		}
+		linted = append(linted, sf)
+		problems = append(problems, lintScripts(sf, metadataKeys)...)
+	}
+	return problems, linted, nil
+}
+
+// lintScripts checks the steps of sf for undefined variables, sudo without
+// a password on stdin, and steps which never run.
+func lintScripts(sf scriptfile, metadataKeys map[string]bool) []lintProblem {
+	var problems []lintProblem
+	add := func(step int, warning bool, format string, args ...interface{}) {
+		problems = append(problems, lintProblem{file: sf.path, step: step, warning: warning, message: fmt.Sprintf(format, args...)})
+	}
+
+	if _, err := sf.deprecation(time.Now()); err != nil {
+		add(0, true, "%v, so no longer runs unless --allow-sunset", err)
+	}
+
+	defined := make(map[string]bool)
+	for _, name := range builtinVars {
+		defined[name] = true
+	}
+	for _, sc := range sf.scripts {
+		for _, p := range sc.params {
+			defined[p.name] = true
+		}
+	}
+
+	for i, sc := range sf.scripts {
+		step := i + 1
+
+		texts := append([]string{sc.command}, sc.stdin...)
+		for _, g := range sc.guards {
+			texts = append(texts, g.value)
+		}
+		for _, c := range sc.when {
+			texts = append(texts, c.left, c.right)
+		}
+		for _, name := range placeholders(texts) {
+			switch {
+			case defined[name] || metadataKeys[name] || strings.HasPrefix(name, "facts."):
+			case metadataKeys == nil:
+				add(step, true, "variable %s is not a param, registered or built-in, so must be inventory metadata", name)
+			default:
+				add(step, false, "variable %s is undefined", name)
+			}
+		}
+		if sc.register != "" {
+			defined[sc.register] = true
+		}
+
+		if usesSudo(sc.command) && !hasPassword(sc.stdin) {
+			add(step, true, "sudo without PASSWORD on stdin hangs or fails on hosts which require a password (use sudo -n if none do)")
+		}
+
+		if ends(sc.command) && i < len(sf.scripts)-1 {
+			add(step+1, false, "step never runs, as `%s` ends step %d", sc.command, step)
+		}
+	}
+	return problems
+}
+
+// placeholders returns the names of the variables placeholders of texts refer to.
+func placeholders(texts []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, text := range texts {
+		for _, matches := range placeholderRe.FindAllStringSubmatch(text, -1) {
+			if !seen[matches[1]] {
+				seen[matches[1]] = true
+				names = append(names, matches[1])
+			}
+		}
+	}
+	return names
+}
+
+// usesSudo returns whether command runs sudo, which may prompt for a password.
+func usesSudo(command string) bool {
+	tokens := strings.Fields(strings.NewReplacer(";", " ", "&&", " ", "||", " ", "|", " ").Replace(command))
+	for i, token := range tokens {
+		if token != "sudo" {
+			continue
+		}
+		if i+1 < len(tokens) && (tokens[i+1] == "-n" || tokens[i+1] == "--non-interactive") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func hasPassword(stdin []string) bool {
+	for _, line := range stdin {
+		if strings.TrimSpace(line) == "PASSWORD" {
+			return true
+		}
+	}
+	return false
+}
+
+// ends returns whether command takes the host down.
+func ends(command string) bool {
+	command = strings.Join(strings.Fields(strings.TrimPrefix(strings.TrimSpace(command), "sudo ")), " ")
+	for _, c := range terminalCommands {
+		if command == c || strings.HasPrefix(command, c+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// lintCmd implements "commando lint", which checks script files for
+// mistakes without connecting to any host.
+func lintCmd(arguments []string) error {
+	var args args
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.Var(&args.scriptDirs, "scripts", "the directory full of scripts to check (may be repeated)")
+	flags.StringVar(&args.inventory, "inventory", "", "file listing hosts and their metadata, which variables may refer to")
+	_ = flags.Parse(arguments)
+
+	if len(args.scriptDirs) == 0 {
+		return errors.New("usage: commando lint --scripts dir [--inventory file]")
+	}
+
+	var metadataKeys map[string]bool
+	if args.inventory != "" {
+		inv, err := loadInventory(args.inventory)
+		if err != nil {
+			return err
+		}
+		metadataKeys = make(map[string]bool)
+		for _, host := range inv.hosts() {
+			for key := range inv.metadata(host) {
+				metadataKeys[key] = true
+			}
+		}
+	}
+
+	problems, err := lintDirs(args.scriptDirs, metadataKeys)
+	if err != nil {
+		return err
+	}
+	errs := 0
+	for _, p := range problems {
+		if p.warning {
+			colors.notice.println("%s", p)
+		} else {
+			errs++
+			colors.failure.println("%s", p)
+		}
+	}
+
+	if errs > 0 {
+		return errors.Errorf("found %d errors", errs)
+	}
+	colors.success.println("no errors found")
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lintDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	}
+	write("1-upgrade", "# param: version\nsudo apt-get install app={{.version}} {{.extra}}\n---\nsudo reboot\n---\nuptime")
+	write("2-registered", "# register: sha\n@local git rev-parse HEAD\n---\necho {{.sha}} {{.hostname_short}} {{.dc}}\n---\nsudo -n true")
+	write("3-broken", "# timeout: soon\nuptime")
+	write("nested/1-upgrade", "sudo whoami\nPASSWORD")
+	write("4-restart", "# deprecated: use 5-restart\n# sunset: 2020-01-31\nservice app restart")
+
+	problems, _, err := lintDir(dir, map[string]bool{"dc": true})
+	require.NoError(t, err)
+
+	var messages []string
+	for _, p := range problems {
+		rel, err := filepath.Rel(dir, p.file)
+		require.NoError(t, err)
+		p.file = rel
+		messages = append(messages, p.String())
+	}
+	require.ElementsMatch(t, []string{
+		"1-upgrade: step 1: error: variable extra is undefined",
+		"1-upgrade: step 1: warning: sudo without PASSWORD on stdin hangs or fails on hosts which require a password (use sudo -n if none do)",
+		"1-upgrade: step 2: warning: sudo without PASSWORD on stdin hangs or fails on hosts which require a password (use sudo -n if none do)",
+		"1-upgrade: step 3: error: step never runs, as `sudo reboot` ends step 2",
+		"4-restart: warning: script 4-restart was sunset on 2020-01-31: use 5-restart, so no longer runs unless --allow-sunset",
+		"3-broken: error: bad directive in script 3-broken: malformed timeout: time: invalid duration \"soon\"",
+	}, messages)
+}
+
+func Test_lintDirs_duplicates(t *testing.T) {
+	base, err := ioutil.TempDir("", "lint")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(base) }()
+
+	write := func(name, content string) {
+		path := filepath.Join(base, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	}
+	write("shared/1-restart", "# name: Restart app\nsystemctl restart app")
+	write("shared/2-status", "# name: Status\nsystemctl status app")
+	write("shared/db/2-status", "# name: Status\nsystemctl status db")
+	write("team/1-restart", "# name: Restart app\nsystemctl restart app --no-block")
+
+	problems, err := lintDirs([]string{filepath.Join(base, "shared"), filepath.Join(base, "team")}, nil)
+	require.NoError(t, err)
+
+	var messages []string
+	for _, p := range problems {
+		messages = append(messages, strings.Replace(p.String(), base+string(filepath.Separator), "", -1))
+	}
+	require.Equal(t, []string{
+		"shared/1-restart: warning: overridden by team/1-restart, as only the last of the --scripts of a path runs",
+		`shared/2-status: error: duplicate name "Status", also of shared/db/2-status`,
+	}, messages)
+}
+
+func Test_usesSudo(t *testing.T) {
+	require.True(t, usesSudo("sudo systemctl restart app"))
+	require.True(t, usesSudo("cd /srv && sudo make install"))
+	require.False(t, usesSudo("sudo -n true"))
+	require.False(t, usesSudo("echo pseudo"))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// localHost is the host of results of @local steps.
+const localHost = "local"
+
+// A localStep is a step of a script file marked "@local", which runs on
+// the operator's machine once per batch, when the first host reaches it.
+// Its output may be registered as a variable for the steps that follow.
+type localStep struct {
+	once sync.Once
+	err  error
+}
+
+// markLocal marks sc as a local step if its command is "@local <command>".
+func (s *script) markLocal() {
+	if strings.HasPrefix(s.command, "@local ") {
+		s.local = true
+		s.command = strings.TrimSpace(strings.TrimPrefix(s.command, "@local "))
+	}
+}
+
+// executeLocal runs the local step i of file, unless another host of the
+// batch already did, in which case its error (if any) is returned again.
+func (r *runner) executeLocal(file string, i int, sc script) error {
+	r.lock.Lock()
+	key := fmt.Sprintf("%d:%s#%d", r.batch.Number, file, i)
+	if r.locals == nil {
+		r.locals = make(map[string]*localStep)
+	}
+	step, exists := r.locals[key]
+	if !exists {
+		step = &localStep{}
+		r.locals[key] = step
+	}
+	r.lock.Unlock()
+
+	step.once.Do(func() {
+		step.err = r.runLocal(file, sc)
+	})
+	return step.err
+}
+
+func (r *runner) runLocal(file string, sc script) error {
+	vars := r.globalVars()
+	command := expandVars(sc.command, vars)
+	if err := r.confirm(localHost, file, command, sc); err == errDeclined {
+		r.out.message("skipping local step `%s`: %v", command, err)
+		r.record(result{Host: localHost, File: file, Command: command, Skipped: err.Error()})
+		return nil
+	} else if err != nil {
+		r.record(result{Host: localHost, File: file, Command: command, Skipped: err.Error()})
+		return err
+	}
+	r.out.command(localHost, command)
+
+	timeout := sc.timeout
+	if timeout == 0 {
+		timeout = r.timeout
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var stdin []string
+	for _, line := range sc.stdin {
+		stdin = append(stdin, expandVars(line, vars))
+	}
+
+	prelude, err := shellSh.prelude(expandVars(sc.cwd, vars), sc.umask)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", strings.TrimSpace(prelude+" "+command))
+	cmd.Env = append(os.Environ(), sc.env...)
+	cmd.Stdin = strings.NewReader(combine(stdin) + sc.payload)
+
+	start := time.Now()
+	bs, err := cmd.CombinedOutput()
+	res := result{Host: localHost, File: file, Command: command, Seconds: time.Since(start).Seconds()}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = timeoutError{after: timeout}
+	}
+
+	output, notes := splitNotes(redact(strings.TrimSpace(string(bs)), r.secrets))
+	if output != "" {
+		r.out.output(localHost, output)
+	}
+	res.Output, res.Notes = output, notes
+	res.ExitCode = localExitCode(err)
+	if err != nil {
+		res.Error = err.Error()
+		r.record(res)
+		return errors.Wrapf(err, "local step `%s` failed", command)
+	}
+
+	failed := evaluate(localHost, sc, output, res.ExitCode)
+	for _, f := range failed {
+		res.Failed = append(res.Failed, fmt.Sprintf("%s (got %s)", f.check, f.actual))
+	}
+	r.record(res)
+	if len(failed) > 0 {
+		return failed
+	}
+
+	if sc.register != "" {
+		r.lock.Lock()
+		if r.registered == nil {
+			r.registered = make(map[string]string)
+		}
+		r.registered[sc.register] = output
+		r.lock.Unlock()
+	}
+	return nil
+}
+
+// localExitCode returns the exit code of a local command that returned err.
+func localExitCode(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *exec.ExitError:
+		if status, ok := e.Sys().(interface{ ExitStatus() int }); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const file9 = `
+# register: version
+@local echo 1.4.2
+---
+echo deploying {{.version}}
+`
+
+func Test_parse_local(t *testing.T) {
+	sf, err := parse("9-local", file9)
+	require.NoError(t, err)
+	require.True(t, sf.scripts[0].local)
+	require.Equal(t, "echo 1.4.2", sf.scripts[0].command)
+	require.Equal(t, "version", sf.scripts[0].register)
+	require.False(t, sf.scripts[1].local)
+
+	_, err = parse("bad", "# register: not a name\n@local true")
+	require.Error(t, err)
+}
+
+func Test_executeLocal(t *testing.T) {
+	sf, err := parse("9-local", file9)
+	require.NoError(t, err)
+
+	r := &runner{out: &quiet{}}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.executeLocal(sf.name, 0, sf.scripts[0]))
+	}
+	require.Len(t, r.results, 1, "runs once per run")
+	require.Equal(t, localHost, r.results[0].Host)
+	require.Equal(t, "1.4.2", r.globalVars()["version"])
+	require.Equal(t, "echo deploying 1.4.2", expandVars(sf.scripts[1].command, r.globalVars()))
+
+	failing := script{command: "echo oops; exit 3", local: true}
+	err = r.executeLocal(sf.name, 1, failing)
+	require.Error(t, err)
+	require.Equal(t, 3, r.results[1].ExitCode)
+	require.Equal(t, "oops", r.results[1].Output)
+}
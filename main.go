@@ -5,71 +5,403 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh"
 )
 
 // typical example of running a basic command
 // commando --command "uname -a" --hosts "tst-mexec{1..6}"
 
+// subcommands of commando, e.g. "commando cancel <run-id>"
+var subcommands = map[string]func([]string) error{
+	"apply":   applyCmd,
+	"cancel":  cancelCmd,
+	"daemon":  daemonCmd,
+	"doctor":  doctorCmd,
+	"exec":    execCmd,
+	"fetch":   fetchCmd,
+	"forget":  forgetCmd,
+	"grep":    grepCmd,
+	"history": historyCmd,
+	"list":    listCmd,
+	"lint":    lintCmd,
+	"pause":   pauseCmd,
+	"plan":    planCmd,
+	"probe":   probeCmd,
+	"resume":  resumeCmd,
+	"serve":   serveCmd,
+	"show":    showCmd,
+	"skip":    skipCmd,
+	"submit":  submitCmd,
+	"tail":    tailCmd,
+}
+
 func main() {
-	args := arguments()
+	if t, err := defaultTheme(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "ignoring theme: %v\n", err)
+	} else {
+		colors = t
+	}
+
+	if len(os.Args) > 1 {
+		if subcommand, exists := subcommands[os.Args[1]]; exists {
+			if err := subcommand(os.Args[2:]); err != nil {
+				exitf(exitStatus(err), "failed to %s: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	start(parseArguments(os.Args[1:]), nil)
+}
+
+// start runs as args say, on the hosts they target, or as planned by
+// "commando plan", if planned is not nil.
+func start(args args, planned *plan) {
 	v := args.verbose
 
 	tracef(v, "cliargs user: %q", args.user)
 	tracef(v, "cliargs hosts: %q", args.hostList)
-	tracef(v, "cliargs scripts: %q", args.scriptDir)
+	tracef(v, "cliargs scripts: %q", args.scriptDirs)
 	tracef(v, "cliargs command: %q", args.command)
 	tracef(v, "cliargs noPassword: %q", args.noPassword)
 	tracef(v, "cliargs verbose: %q", args.verbose)
+	tracef(v, "cliargs env: %q", args.env)
+	tracef(v, "cliargs env-file: %q", args.envFiles)
+	tracef(v, "cliargs inventory: %q", args.inventory)
+	tracef(v, "cliargs report: %q", args.report)
+	tracef(v, "cliargs group-by: %q", args.groupBy)
+	tracef(v, "cliargs canary: %q", args.canary)
+	tracef(v, "cliargs parallel: %d", args.parallel)
+	tracef(v, "cliargs output: %q", args.output)
+	tracef(v, "cliargs vault-path: %q", args.vaultPath)
 
 	if err := validate(args); err != nil {
 		dief("arguments are invalid: %v", err)
 	}
+	if args.theme != "" {
+		t, err := loadTheme(args.theme)
+		if err != nil {
+			dief("arguments are invalid: --theme is invalid: %v", err)
+		}
+		colors = t
+	}
+	if args.wide {
+		tableWidth = 0
+	}
+	askpass = args.askpass
+	passwords, _ = passwordFrom(args) // validated above
+
+	out, err := newRenderer(args)
+	if err != nil {
+		dief("arguments are invalid: %v", err)
+	}
+	if args.progress {
+		out = newProgress(out, os.Stderr)
+	}
+
+	id := newRunID()
+	var posted *webhooks
+	if len(args.webhooks) > 0 || len(args.notifiers) > 0 {
+		hooks, err := loadHooks(args.webhooks, args.webhookTemplates, args.notifiers)
+		if err != nil {
+			dief("failed to configure webhooks: %v", err)
+		}
+		posted = newWebhooks(out, id, hooks)
+		out = posted
+	}
+	if args.statsd != "" {
+		if out, err = newStatsd(out, args.statsd, args.statsdTags); err != nil {
+			dief("failed to configure statsd: %v", err)
+		}
+	}
+	if len(args.sinks) > 0 {
+		if out, err = newSinking(out, args.sinks); err != nil {
+			dief("failed to configure result sinks: %v", err)
+		}
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		var err error
+		if inv, err = loadInventory(args.inventory); err != nil {
+			exitf(exitStatus(err), "failed to load inventory: %v", err)
+		}
+	}
+
+	dot, err := loadDotenv(args)
+	if err != nil {
+		dief("failed to load env files: %v", err)
+	}
+	args.env = append(dot.env, args.env...)
+
+	caps, err := loadBudget(args, dot)
+	if err != nil {
+		dief("arguments are invalid: %v", err)
+	}
+
+	var source secretSource
+	switch {
+	case args.vaultPath != "":
+		if source, err = newVault(args.vaultPath); err != nil {
+			dief("failed to configure vault: %v", err)
+		}
+	case args.secretPlugin != "":
+		if source, err = findPlugin(args.secretPlugin); err != nil {
+			dief("failed to configure secrets: %v", err)
+		}
+	}
+
+	var signers []ssh.Signer
+	if args.key != "" {
+		signer, err := loadSigner(args.key, args.cert)
+		if err != nil {
+			dief("failed to load key: %v", err)
+		}
+		signers = append(signers, signer)
+	}
 
-	hosts := hosts(args.hostList)
+	hostKeys, err := hostKeyCallback(args.hostCA)
+	if err != nil {
+		dief("failed to load host certificate authorities: %v", err)
+	}
+
+	if _, err := newDialer(proxyURL(args.proxy)); err != nil {
+		dief("failed to configure proxy: %v", err)
+	}
+
+	var hosts []string
+	if planned != nil {
+		hosts = planned.hosts()
+	} else {
+		if hosts, err = targets(args, inv); err != nil {
+			dief("failed to resolve hosts: %v", err)
+		}
+		if hosts, err = narrow(args, inv, hosts); err != nil {
+			dief("arguments are invalid: %v", err)
+		}
+		hosts = deduplicated(args, hosts, out.warning)
+	}
 	if len(hosts) == 0 {
 		dief("no hosts resolved from --host regex")
 	}
 
+	st, err := loadState(statePath())
+	if err != nil {
+		dief("failed to load state: %v", err)
+	}
+
+	vars, err := parseVars(args.vars)
+	if err != nil {
+		dief("arguments are invalid: %v", err)
+	}
+	if planned != nil {
+		for name, value := range planned.Params {
+			vars[name] = value
+		}
+		if err := unmaskVars(vars, os.Stdin, stdinIsTerminal()); err != nil {
+			dief("failed to apply plan: %v", err)
+		}
+	}
+	verify := func(scripts []scriptfile) {
+		if planned == nil {
+			return
+		}
+		current, err := newPlan(args, inv, hosts, scripts, vars, dot.secrets)
+		if err == nil {
+			err = planned.verify(current)
+		}
+		if err != nil {
+			dief("failed to apply plan: %v", err)
+		}
+	}
+
+	newRun := func(pswd string) *runner {
+		r := newRunner(args, pswd, inv, out)
+		r.id = id
+		r.source = source
+		r.secrets = append(append(append([]string(nil), dot.secrets...), inventorySecrets(inv, hosts)...), secretVars(vars)...)
+		r.signers = signers
+		r.hostKeys = hostKeys
+		r.index = indexes(hosts)
+		r.state = st
+		r.params = vars
+		r.hooks = posted
+		return r
+	}
+
 	if args.command == "" {
 		scripts, err := load(args)
+		if err != nil {
+			exitf(exitStatus(err), "failed to load scripts: %v", err)
+		}
+		scripts = withEnv(scripts, args.env)
+
+		warnings, err := deprecations(scripts, time.Now(), args.allowSunset)
 		if err != nil {
 			dief("failed to load scripts: %v", err)
 		}
+		for _, warning := range warnings {
+			out.warning("%s", warning)
+		}
 
-		color.Magenta("will execute scripts")
-		color.Yellow(fmt.Sprintf("%v", scripts))
-		color.Magenta("on hosts")
-		color.Yellow(fmt.Sprintf("%v", hosts))
+		// before connecting to any host, so that missing params fail fast
+		if vars, err = params(scripts, vars, os.Stdin, stdinIsTerminal()); err != nil {
+			dief("failed to resolve params: %v", err)
+		}
 
-		pswd, err := prompt(args)
-		if err != nil {
-			dief("failed to read password: %v", err)
+		verify(scripts)
+
+		names := make([]string, 0, len(scripts))
+		for _, script := range scripts {
+			names = append(names, script.name)
 		}
+		caps = withinBudget(args, caps, "scripts", names, hosts)
+		out.plan("scripts", names, hosts)
 
-		if err := run(args.user, pswd, hosts, scripts); err != nil {
-			dief("failed to run scripts: %v", err)
+		pswd := dot.password
+		if pswd == "" {
+			if pswd, err = prompt(args); err != nil {
+				dief("failed to read password: %v", err)
+			}
+		}
+
+		r := newRun(pswd)
+		started := time.Now()
+		err = r.controlled(func() error {
+			defer r.enforce(caps)()
+			hosts, err := precheck(args.precheck, r, hosts)
+			if err != nil {
+				return err
+			}
+			defer r.closeWarm()
+			if hosts = r.requireProbe(args.requireProbe, hosts); len(hosts) == 0 {
+				return nil
+			}
+			if err := r.validateSudo(hosts, runsSudo(scripts) || r.becomes(hosts)); err != nil {
+				return err
+			}
+			stop, err := r.forward(args.forwards, args.forwardVia)
+			if err != nil {
+				return err
+			}
+			defer stop()
+			return r.rebootAfter(canaried(args, r, hosts, func(hosts []string) error {
+				return batched(args, r, hosts, func(hosts []string) error {
+					return r.run(hosts, scripts)
+				})
+			}))
+		})
+		writeResults(args, r, err)
+		r.saveCache()
+		r.remember(started, "scripts", names, hosts, err)
+		if err != nil {
+			exitf(exitStatus(err), "failed to run scripts: %v", err)
 		}
 	} else {
-		color.Magenta("will execute command")
-		color.Yellow(args.command)
-		color.Magenta("on hosts")
-		color.Yellow(fmt.Sprintf("%v", hosts))
+		verify(nil)
+		caps = withinBudget(args, caps, "command", []string{args.command}, hosts)
+		out.plan("command", []string{args.command}, hosts)
+
+		pswd := dot.password
+		if args.pw && source == nil && pswd == "" {
+			var err error
+			if pswd, err = easyPrompt(args.user); err != nil {
+				dief("failed to read password: %v", err)
+			}
+		}
+
+		r := newRun(pswd)
+		started := time.Now()
+		err := r.controlled(func() error {
+			defer r.enforce(caps)()
+			hosts, err := precheck(args.precheck, r, hosts)
+			if err != nil {
+				return err
+			}
+			defer r.closeWarm()
+			if hosts = r.requireProbe(args.requireProbe, hosts); len(hosts) == 0 {
+				return nil
+			}
+			if err := r.validateSudo(hosts, args.pw || r.becomes(hosts)); err != nil {
+				return err
+			}
+			stop, err := r.forward(args.forwards, args.forwardVia)
+			if err != nil {
+				return err
+			}
+			defer stop()
+			return r.rebootAfter(canaried(args, r, hosts, func(hosts []string) error {
+				return batched(args, r, hosts, func(hosts []string) error {
+					return r.runCmd(hosts, args.command, args.pw, args.env)
+				})
+			}))
+		})
+		writeResults(args, r, err)
+		r.saveCache()
+		r.remember(started, "command", []string{args.command}, hosts, err)
+		if err != nil {
+			exitf(exitStatus(err), "failed to run command: %v", err)
+		}
+	}
+}
+
+// writeResults summarizes the results collected by r, and writes
+// them to the report file, if requested.
+func writeResults(args args, r *runner, err error) {
+	rpt := report{ID: r.id, Status: status(err), Reason: reason(err), Results: r.results}
+
+	if args.groupBy != "" {
+		rpt.GroupBy = args.groupBy
+		rpt.Groups = groupBy(args.groupBy, r.results)
+	}
+
+	if args.diff {
+		rpt.Variants = diff(r.results)
+	}
+	rpt.Reboots = r.rebootsRequired()
+	rpt.Probe = r.probe
+	r.out.summary(rpt)
 
-		if err := runCmd(args.user, hosts, args.command, args.pw); err != nil {
-			dief("failed to run command: %v", err)
+	if args.report == "" {
+		return
+	}
+
+	if args.anonymize {
+		if rpt, err = anonymize(rpt); err != nil {
+			dief("failed to anonymize report: %v", err)
 		}
 	}
+
+	if err := writeReport(args.report, rpt); err != nil {
+		dief("failed to write report: %v", err)
+	}
+}
+
+func status(err error) string {
+	switch err {
+	case nil:
+		return "completed"
+	case errCancelled:
+		return "cancelled"
+	default:
+		return "failed"
+	}
 }
 
 func dief(format string, args ...interface{}) {
+	exitf(1, format, args...)
+}
+
+// exitf prints the message, and exits with code, see exitStatus.
+func exitf(code int, format string, args ...interface{}) {
 	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
-	os.Exit(1)
+	os.Exit(code)
 }
 
 func tracef(verbose bool, format string, args ...interface{}) {
 	if verbose {
-		color.Cyan(format+"\n", args...)
+		colors.accent.println(format+"\n", args...)
 	}
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// narrow the resolved hosts to those targeted by the run: hosts matching
+// --exclude are skipped, as are hosts whose labels in inv do not match
+// --select, --shuffle randomizes their order (or --order-by
+// sorts them by their metadata in inv), and --limit takes only the first
+// of them.
+func narrow(args args, inv inventory, hosts []string) ([]string, error) {
+	patterns, err := exclusions(args.exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	narrowed := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if !excluded(host, patterns) {
+			narrowed = append(narrowed, host)
+		}
+	}
+
+	if args.selector != "" {
+		reqs, err := parseSelector(args.selector)
+		if err != nil {
+			return nil, errors.Wrap(err, "--select is invalid")
+		}
+		narrowed = selected(narrowed, inv, reqs)
+	}
+
+	if args.shuffle {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		random.Shuffle(len(narrowed), func(i, j int) {
+			narrowed[i], narrowed[j] = narrowed[j], narrowed[i]
+		})
+	}
+
+	if args.orderBy != "" {
+		keys, err := parseOrder(args.orderBy)
+		if err != nil {
+			return nil, errors.Wrap(err, "--order-by is invalid")
+		}
+		orderHosts(narrowed, keys, inv)
+	}
+
+	if args.limit != "" && len(narrowed) > 0 {
+		n, err := hostCount(args.limit, len(narrowed))
+		if err != nil {
+			return nil, errors.Wrap(err, "--limit is invalid")
+		}
+		narrowed = narrowed[:n]
+	}
+
+	return narrowed, nil
+}
+
+// exclusions returns the patterns of hosts of --exclude, each of which is
+// a file listing hosts (one per line), or host expressions and globs
+// separated by commas, e.g. "db*,cache{1..3}".
+func exclusions(values []string) ([]string, error) {
+	var patterns []string
+	for _, value := range values {
+		if _, err := os.Stat(value); err == nil {
+			bs, err := ioutil.ReadFile(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read --exclude file")
+			}
+			for _, line := range strings.Split(string(bs), "\n") {
+				if line = strings.TrimSpace(line); line != "" && line[0] != '#' {
+					patterns = append(patterns, line)
+				}
+			}
+			continue
+		}
+
+		for _, pattern := range strings.Split(value, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if strings.Contains(pattern, "..") {
+				patterns = append(patterns, expand(pattern)...)
+			} else if pattern != "" {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Errorf("malformed --exclude pattern %q", pattern)
+		}
+	}
+	return patterns, nil
+}
+
+func excluded(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_narrow(t *testing.T) {
+	hosts := []string{"web1", "web2", "web3", "db1", "db2", "cache1", "cache2"}
+
+	f, err := ioutil.TempFile("", "exclude")
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, err = f.WriteString("# broken\ncache2\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	narrowed, err := narrow(args{exclude: stringsFlag{"db*,web{2..3}", f.Name()}}, inventory{}, hosts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"web1", "cache1"}, narrowed)
+
+	narrowed, err = narrow(args{limit: "3"}, inventory{}, hosts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"web1", "web2", "web3"}, narrowed)
+
+	narrowed, err = narrow(args{limit: "50%", shuffle: true}, inventory{}, hosts)
+	require.NoError(t, err)
+	require.Len(t, narrowed, 4)
+
+	_, err = narrow(args{limit: "none"}, inventory{}, hosts)
+	require.Error(t, err)
+}
+
+func Test_narrow_orderBy(t *testing.T) {
+	inv, err := parseInventory("web1 rack=b index=10\nweb2 rack=a index=2\nweb3 rack=b index=9\nweb4\nweb5 rack=a index=1\n")
+	require.NoError(t, err)
+	hosts := []string{"web1", "web2", "web3", "web4", "web5"}
+
+	narrowed, err := narrow(args{orderBy: "vars.rack,vars.index"}, inv, hosts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"web5", "web2", "web3", "web1", "web4"}, narrowed)
+
+	narrowed, err = narrow(args{orderBy: "-vars.rack,host", limit: "2"}, inv, hosts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"web1", "web3"}, narrowed)
+
+	_, err = narrow(args{orderBy: "rack"}, inv, hosts)
+	require.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package main
+
+import "strings"
+
+// noteMarker starts lines of output which annotate the result of a script,
+// e.g. "::commando-note::reboot required", so that runbooks can surface
+// signals in summaries and reports without them being parsed from output.
+const noteMarker = "::commando-note::"
+
+// splitNotes returns output without its lines of notes, and the notes.
+func splitNotes(output string) (string, []string) {
+	if !strings.Contains(output, noteMarker) {
+		return output, nil
+	}
+
+	var kept, notes []string
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, noteMarker) {
+			if note := strings.TrimSpace(strings.TrimPrefix(trimmed, noteMarker)); note != "" {
+				notes = append(notes, note)
+			}
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n")), notes
+}
+
+// notesTable returns a table of the notes of the results, with columns
+// host, file and note.
+func notesTable(results []result) *table {
+	t := &table{indent: "  "}
+	for _, res := range results {
+		for _, note := range res.Notes {
+			t.add(colors.info, res.Host, res.File, note)
+		}
+	}
+	return t
+}
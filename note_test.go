@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_splitNotes(t *testing.T) {
+	output, notes := splitNotes("upgraded 3 packages\r\n::commando-note::reboot required\r\n  ::commando-note:: kernel 5.10 -> 6.1\r\ndone")
+	require.Equal(t, "upgraded 3 packages\r\ndone", output)
+	require.Equal(t, []string{"reboot required", "kernel 5.10 -> 6.1"}, notes)
+
+	output, notes = splitNotes("nothing to note")
+	require.Equal(t, "nothing to note", output)
+	require.Nil(t, notes)
+}
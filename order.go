@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// An orderKey is a key of --order-by: host, or vars.NAME for the inventory
+// metadata NAME of hosts, descending if prefixed with "-".
+type orderKey struct {
+	name       string
+	descending bool
+}
+
+// parseOrder parses a value of --order-by, keys separated by commas,
+// e.g. "vars.rack,vars.index".
+func parseOrder(spec string) ([]orderKey, error) {
+	var keys []orderKey
+	for _, key := range strings.Split(spec, ",") {
+		key = strings.TrimSpace(key)
+		k := orderKey{descending: strings.HasPrefix(key, "-")}
+		key = strings.TrimPrefix(key, "-")
+		switch {
+		case key == "host":
+			k.name = key
+		case strings.HasPrefix(key, "vars.") && key != "vars.":
+			k.name = strings.TrimPrefix(key, "vars.")
+		default:
+			return nil, errors.Errorf("malformed order key %q, must be host or vars.NAME", key)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// orderHosts sorts hosts by keys, stably, so that hosts which are equal by
+// every key keep their order. Values of the same key are compared as
+// numbers if both are, and hosts missing a value come last.
+func orderHosts(hosts []string, keys []orderKey, inv inventory) {
+	value := func(host string, k orderKey) (string, bool) {
+		if k.name == "host" {
+			return host, true
+		}
+		v, exists := inv.metadata(host)[k.name]
+		return v, exists
+	}
+
+	sort.SliceStable(hosts, func(i, j int) bool {
+		for _, k := range keys {
+			x, xOK := value(hosts[i], k)
+			y, yOK := value(hosts[j], k)
+			switch {
+			case xOK != yOK:
+				return xOK
+			case !xOK || x == y:
+				continue
+			}
+
+			less := x < y
+			if xn, err := number(x); err == nil {
+				if yn, err := number(y); err == nil {
+					less = xn < yn
+				}
+			}
+			return less != k.descending
+		}
+		return false
+	})
+}
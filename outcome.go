@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxOutcomeLines is how many lines of the output of an outcome are printed.
+const maxOutcomeLines = 5
+
+// An outcome is a result shared by a group of hosts: of the same script
+// (or of connecting, for hosts which could not be), with the same status,
+// exit code and output, once the names and addresses of hosts are
+// normalized out of them.
+type outcome struct {
+	File     string
+	Command  string
+	Status   string // see resultStatus
+	ExitCode int
+	Detail   string // the error, failures or reason for skipping
+	Output   string
+	Hosts    []string
+}
+
+func (o outcome) String() string {
+	switch {
+	case o.Status == "ok" && o.ExitCode != 0:
+		return fmt.Sprintf("ok (exit %d)", o.ExitCode)
+	case o.Status == "ok":
+		return "ok"
+	case o.Status == "error" && o.ExitCode > 0:
+		return fmt.Sprintf("exit %d", o.ExitCode)
+	}
+	return o.Status + ": " + o.Detail
+}
+
+// outcomes groups the hosts of results by the outcome of each script. The
+// outcomes of each script are ordered from the most to the least hosts,
+// so that outliers come last.
+func outcomes(results []result) []outcome {
+	var grouped []outcome
+	index := make(map[string]int)
+	order := make(map[[2]string]int) // of scripts, as they first ran
+	for _, res := range results {
+		script := [2]string{res.File, res.Command}
+		if _, exists := order[script]; !exists {
+			order[script] = len(order)
+		}
+
+		status := resultStatus(res)
+		detail := res.Error
+		switch status {
+		case "failed":
+			detail = strings.Join(res.Failed, "; ")
+		case "skipped":
+			detail = res.Skipped
+		}
+		o := outcome{
+			File:     res.File,
+			Command:  res.Command,
+			Status:   status,
+			ExitCode: res.ExitCode,
+			Detail:   normalize(detail, res.Host),
+			Output:   normalize(res.Output, res.Host),
+		}
+
+		key := fmt.Sprintf("%q %q %q %d %q %q", o.File, o.Command, o.Status, o.ExitCode, o.Detail, o.Output)
+		i, exists := index[key]
+		if !exists {
+			i = len(grouped)
+			index[key] = i
+			grouped = append(grouped, o)
+		}
+		grouped[i].Hosts = append(grouped[i].Hosts, res.Host)
+	}
+
+	sort.SliceStable(grouped, func(i, j int) bool {
+		a := order[[2]string{grouped[i].File, grouped[i].Command}]
+		b := order[[2]string{grouped[j].File, grouped[j].Command}]
+		if a != b {
+			return a < b
+		}
+		return len(grouped[i].Hosts) > len(grouped[j].Hosts)
+	})
+	return grouped
+}
+
+// normalize text of host, replacing its name (and short name) with <host>
+// and IP addresses with <ip>, so that the same output of different hosts
+// is identical.
+func normalize(text, host string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	if host != "" {
+		text = strings.Replace(text, host, "<host>", -1)
+		if short := strings.SplitN(host, ".", 2)[0]; short != host {
+			text = strings.Replace(text, short, "<host>", -1)
+		}
+	}
+	return ipRe.ReplaceAllStringFunc(text, func(candidate string) string {
+		if net.ParseIP(candidate) == nil {
+			return candidate
+		}
+		return "<ip>"
+	})
+}
+
+func printOutcomes(grouped []outcome) {
+	for i, o := range grouped {
+		first := i == 0 || grouped[i-1].File != o.File || grouped[i-1].Command != o.Command
+		if first {
+			switch {
+			case o.File == "" && o.Command == "":
+				colors.info.println("=== connecting")
+			case o.Command == "":
+				colors.info.println("=== %s", o.File)
+			case o.File == "":
+				colors.info.println("=== `%s`", o.Command)
+			default:
+				colors.info.println("=== %s `%s`", o.File, o.Command)
+			}
+		}
+
+		s := colors.success
+		switch o.Status {
+		case "skipped":
+			s = colors.notice
+		case "failed", "error":
+			s = colors.failure
+		}
+		noun := "hosts"
+		if len(o.Hosts) == 1 {
+			noun = "host"
+		}
+		s.println("  %d %s: %s", len(o.Hosts), noun, o)
+
+		// hosts of the most common outcome go without saying, if it is ok
+		if !first || o.Status != "ok" {
+			hosts := &table{indent: "    "}
+			hosts.add(colors.muted, strings.Join(o.Hosts, ", "))
+			hosts.print()
+		}
+
+		lines := strings.Split(o.Output, "\n")
+		if o.Output == "" {
+			lines = nil
+		}
+		for j, line := range lines {
+			if j == maxOutcomeLines {
+				colors.output.println("    … (%d more lines)", len(lines)-j)
+				break
+			}
+			colors.output.println("    %s", line)
+		}
+	}
+}
+
+// summarized renders only messages and warnings while the run progresses,
+// and the outcomes of the run once it completes, so that hundreds of
+// identical results read as a line each.
+type summarized struct {
+	lock sync.Mutex
+}
+
+func (s *summarized) plan(string, []string, []string) {}
+func (s *summarized) begin(string, string)            {}
+func (s *summarized) command(string, string)          {}
+func (s *summarized) output(string, string)           {}
+func (s *summarized) result(result)                   {}
+func (s *summarized) end(string, string)              {}
+
+func (s *summarized) message(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	colors.info.println(format, args...)
+}
+
+func (s *summarized) warning(format string, args ...interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	colors.failure.println(format, args...)
+}
+
+func (s *summarized) summary(rpt report) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if rpt.Probe != nil {
+		printProbePhase(rpt.Probe)
+	}
+	printOutcomes(outcomes(rpt.Results))
+
+	if t := notesTable(rpt.Results); len(t.rows) > 0 {
+		colors.info.println("notes")
+		t.print()
+	}
+	if len(rpt.Reboots) > 0 {
+		colors.notice.println("reboot required")
+		rebootsTable(rpt.Reboots).print()
+	}
+	if rpt.GroupBy != "" {
+		printGroups(rpt.GroupBy, rpt.Groups)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_normalize(t *testing.T) {
+	require.Equal(t, "<host> (<ip>) is up\nsee <host>:8080", normalize("web1.example.com (10.0.0.1) is up\r\nsee web1:8080", "web1.example.com"))
+	require.Equal(t, "at 12:30:00, <ip> is up", normalize("at 12:30:00, 2001:db8::1 is up", ""))
+	require.Equal(t, "version 1.2.3", normalize("version 1.2.3", "web1"))
+}
+
+func Test_outcomes(t *testing.T) {
+	grouped := outcomes([]result{
+		{Host: "db1", ExitCode: -1, Error: "dial tcp 10.0.0.3:22: connect: connection refused"},
+		{Host: "web1", File: "check.sh", Command: "uptime", Output: "web1 is up"},
+		{Host: "web2", File: "check.sh", Command: "uptime", ExitCode: 1, Error: "Process exited with status 1", Output: "down"},
+		{Host: "web3", File: "check.sh", Command: "uptime", Output: "web3 is up"},
+		{Host: "db2", ExitCode: -1, Error: "dial tcp 10.0.0.4:22: connect: connection refused"},
+		{Host: "web1", File: "check.sh", Command: "df", Failed: []string{"disk 95% < 90% (got 95)"}},
+	})
+
+	require.Len(t, grouped, 4)
+	require.Equal(t, []string{"db1", "db2"}, grouped[0].Hosts)
+	require.Equal(t, "error: dial tcp <ip>:22: connect: connection refused", grouped[0].String())
+
+	require.Equal(t, []string{"web1", "web3"}, grouped[1].Hosts)
+	require.Equal(t, "<host> is up", grouped[1].Output)
+	require.Equal(t, "ok", grouped[1].String())
+
+	require.Equal(t, []string{"web2"}, grouped[2].Hosts)
+	require.Equal(t, "exit 1", grouped[2].String())
+
+	require.Equal(t, "df", grouped[3].Command)
+	require.Equal(t, "failed: disk 95% < 90% (got 95)", grouped[3].String())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var paramNameRe = regexp.MustCompile(`^[[:word:]-]+$`)
+
+// A param is a parameter a script requires, declared by a directive like
+// "# param: version" or "# param: region default=us-east-1", and used in
+// the script as the placeholder {{.version}}.
+type param struct {
+	name       string
+	defaultVal string
+	hasDefault bool
+}
+
+func parseParam(value string) (param, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || !paramNameRe.MatchString(fields[0]) {
+		return param{}, errors.Errorf("malformed param %q, expected NAME [default=VALUE]", value)
+	}
+
+	p := param{name: fields[0]}
+	if len(fields) > 1 {
+		rest := strings.TrimSpace(strings.TrimPrefix(value, fields[0]))
+		if !strings.HasPrefix(rest, "default=") {
+			return param{}, errors.Errorf("malformed param %q, expected NAME [default=VALUE]", value)
+		}
+		p.defaultVal, p.hasDefault = strings.TrimPrefix(rest, "default="), true
+	}
+	return p, nil
+}
+
+// parseVars parses the --var flags, of the form NAME=VALUE.
+func parseVars(kvs []string) (map[string]string, error) {
+	vars := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !paramNameRe.MatchString(parts[0]) {
+			return nil, errors.Errorf("malformed --var %q, expected NAME=VALUE", kv)
+		}
+		vars[parts[0]] = parts[1]
+	}
+	return vars, nil
+}
+
+// params returns the values of the params declared by files: those given
+// by --var, else their defaults, else values read from in, as prompted on
+// a terminal. If in is not a terminal, a missing param is an error.
+func params(files []scriptfile, vars map[string]string, in io.Reader, interactive bool) (map[string]string, error) {
+	values := make(map[string]string, len(vars))
+	for name, value := range vars {
+		values[name] = value
+	}
+
+	var reader *bufio.Reader
+	for _, file := range files {
+		for _, sc := range file.scripts {
+			for _, p := range sc.params {
+				if _, exists := values[p.name]; exists {
+					continue
+				}
+				if p.hasDefault {
+					values[p.name] = p.defaultVal
+					continue
+				}
+				if !interactive {
+					return nil, errors.Errorf("script %s requires param %s, set it with --var %s=VALUE", file.name, p.name, p.name)
+				}
+
+				if reader == nil {
+					reader = bufio.NewReader(in)
+				}
+				colors.muted.println("  value of %s (required by %s) --> ", p.name, file.name)
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to read param %s", p.name)
+				}
+				values[p.name] = strings.TrimSpace(line)
+			}
+		}
+	}
+	return values, nil
+}
+
+// stdinIsTerminal returns whether params may be prompted for.
+func stdinIsTerminal() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const file8 = `
+# param: version
+# param: region default=us-east-1
+curl -o /tmp/app.tgz https://releases/{{.region}}/app-{{.version}}.tgz
+---
+# param: channel
+echo {{.channel}}
+`
+
+func Test_params(t *testing.T) {
+	sf, err := parse("8-params", file8)
+	require.NoError(t, err)
+	require.Equal(t, []param{
+		{name: "version"},
+		{name: "region", defaultVal: "us-east-1", hasDefault: true},
+	}, sf.scripts[0].params)
+
+	files := []scriptfile{sf}
+
+	_, err = params(files, map[string]string{"version": "1.2"}, nil, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "--var channel=VALUE")
+
+	values, err := params(files, map[string]string{"version": "1.2"}, strings.NewReader("stable\n"), true)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"version": "1.2", "region": "us-east-1", "channel": "stable"}, values)
+
+	values, err = params(files, map[string]string{"version": "1.2", "region": "eu-west-1", "channel": "beta"}, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, "eu-west-1", values["region"])
+}
+
+func Test_parseParam(t *testing.T) {
+	_, err := parseParam("version latest")
+	require.Error(t, err)
+	_, err = parseParam("")
+	require.Error(t, err)
+
+	p, err := parseParam("motd default=hello world")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", p.defaultVal)
+
+	vars, err := parseVars([]string{"version=1.2=rc1"})
+	require.NoError(t, err)
+	require.Equal(t, "1.2=rc1", vars["version"])
+	_, err = parseVars([]string{"version"})
+	require.Error(t, err)
+}
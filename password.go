@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"os"
-
-	"github.com/fatih/color"
+	"os/exec"
+	"runtime"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -16,19 +19,174 @@ func prompt(args args) (string, error) {
 		return "", nil
 	}
 
-	color.White("  password for '%s' --> ", args.user)
-	bs, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		return "", errors.Wrap(err, "failed to read password")
+	if args.vaultPath != "" || args.secretPlugin != "" {
+		tracef(args.verbose, "skipping password prompt, using secrets")
+		return "", nil
 	}
-	return string(bs), nil
+
+	return easyPrompt(args.user)
+}
+
+// askpass is the program which passwords are read with, instead of the
+// terminal, set by --askpass or $COMMANDO_ASKPASS. The program is given
+// the prompt as its argument and prints the password, like ssh-askpass.
+var askpass = os.Getenv("COMMANDO_ASKPASS")
+
+// askpassProgram returns the program of --askpass, where "systemd" is
+// shorthand for systemd-ask-password.
+func askpassProgram(name string) string {
+	if name == "systemd" {
+		return "systemd-ask-password"
+	}
+	return name
 }
 
+// A passwordSource acquires the password of the operator, so that it need
+// not be typed in again, or exported in plaintext.
+type passwordSource interface {
+	fmt.Stringer
+	password(user string) (string, error)
+}
+
+// passwords is where passwords are read from, set by --password-from or
+// --password-command, by default the terminal (or --askpass).
+var passwords passwordSource = promptPassword{}
+
 func easyPrompt(user string) (string, error) {
-	color.White("  password for '%s' --> ", user)
-	bs, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	return passwords.password(user)
+}
+
+// parsePasswordSource parses the source of --password-from, one of prompt,
+// env:NAME, keychain[:SERVICE] or command:COMMAND.
+func parsePasswordSource(spec string) (passwordSource, error) {
+	kind, value := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, value = spec[:i], spec[i+1:]
+	}
+
+	switch {
+	case kind == "prompt" && value == "":
+		return promptPassword{}, nil
+	case kind == "env" && value != "":
+		return envPassword(value), nil
+	case kind == "keychain" && value == "":
+		return keychainPassword("commando"), nil
+	case kind == "keychain":
+		return keychainPassword(value), nil
+	case kind == "command" && value != "":
+		return commandPassword(value), nil
+	}
+	return nil, errors.Errorf("unknown password source %q, must be one of prompt, env:NAME, keychain[:SERVICE], command:COMMAND", spec)
+}
+
+// passwordFrom returns the source of --password-from or --password-command.
+func passwordFrom(args args) (passwordSource, error) {
+	switch {
+	case args.passwordCmd != "":
+		return commandPassword(args.passwordCmd), nil
+	case args.passwordFrom != "":
+		return parsePasswordSource(args.passwordFrom)
+	}
+	return promptPassword{}, nil
+}
+
+// promptPassword reads passwords on the terminal, or with --askpass.
+type promptPassword struct{}
+
+func (promptPassword) String() string { return "prompt" }
+
+func (promptPassword) password(user string) (string, error) {
+	if askpass != "" {
+		return askPassword(askpassProgram(askpass), "password for '"+user+"':")
+	}
+
+	// stdin may be a script piped in (see --script -), so read the terminal
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer func() { _ = tty.Close() }()
+			fd = int(tty.Fd())
+		}
+	}
+
+	colors.muted.println("  password for '%s' --> ", user)
+	bs, err := terminal.ReadPassword(fd)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to read password")
 	}
 	return string(bs), nil
 }
+
+// envPassword reads the password from an environment variable.
+type envPassword string
+
+func (e envPassword) String() string { return "env:" + string(e) }
+
+func (e envPassword) password(string) (string, error) {
+	value, exists := os.LookupEnv(string(e))
+	if !exists {
+		return "", errors.Errorf("failed to read password: $%s is not set", string(e))
+	}
+	return value, nil
+}
+
+// keychainPassword reads the password of the user stored under a service
+// in the macOS Keychain, or elsewhere in the Secret Service (e.g. GNOME
+// Keyring or KWallet) with secret-tool.
+type keychainPassword string
+
+func (k keychainPassword) String() string { return "keychain:" + string(k) }
+
+func (k keychainPassword) password(user string) (string, error) {
+	cmd := exec.Command("secret-tool", "lookup", "service", string(k), "user", user)
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", string(k), "-a", user, "-w")
+	}
+	cmd.Stderr = os.Stderr
+	bs, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read password of %s from keychain service %s", user, string(k))
+	}
+	return strings.TrimRight(string(bs), "\r\n"), nil
+}
+
+// commandPassword reads the password from the output of a command, such as
+// "pass show ssh/prod", which is given the user in $COMMANDO_USER.
+type commandPassword string
+
+func (c commandPassword) String() string { return "command:" + string(c) }
+
+func (c commandPassword) password(user string) (string, error) {
+	cmd := exec.Command("sh", "-c", string(c))
+	cmd.Env = append(os.Environ(), "COMMANDO_USER="+user)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	bs, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to run --password-command")
+	}
+	// like pass, commands may print more lines after the password
+	return strings.TrimRight(strings.SplitN(string(bs), "\n", 2)[0], "\r"), nil
+}
+
+// askPassword runs program to read a password, which is neither echoed
+// nor seen in shell history or the arguments of any process.
+func askPassword(program, prompt string) (string, error) {
+	cmd := exec.Command(program, prompt)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	bs, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read password with %s", program)
+	}
+	return strings.TrimRight(string(bs), "\r\n"), nil
+}
+
+// confirm asks the operator a yes or no question on the terminal.
+func confirm(question string) (bool, error) {
+	colors.muted.println("  %s [y/N] --> ", question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read confirmation")
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
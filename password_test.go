@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_askPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askpass")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	program := filepath.Join(dir, "askpass")
+	script := "#!/bin/sh\n[ \"$1\" = \"password for 'bob':\" ] && echo 's3cret'\n"
+	require.NoError(t, ioutil.WriteFile(program, []byte(script), 0700))
+
+	askpass = program
+	defer func() { askpass = "" }()
+
+	password, err := easyPrompt("bob")
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", password)
+
+	_, err = easyPrompt("alice")
+	require.Error(t, err)
+}
+
+func Test_askpassProgram(t *testing.T) {
+	require.Equal(t, "systemd-ask-password", askpassProgram("systemd"))
+	require.Equal(t, "/usr/lib/ssh/ssh-askpass", askpassProgram("/usr/lib/ssh/ssh-askpass"))
+}
+
+func Test_parsePasswordSource(t *testing.T) {
+	for spec, expected := range map[string]passwordSource{
+		"prompt":                  promptPassword{},
+		"env:SSH_PASSWORD":        envPassword("SSH_PASSWORD"),
+		"keychain:commando":       keychainPassword("commando"),
+		"keychain:prod":           keychainPassword("prod"),
+		"command:pass show ssh/a": commandPassword("pass show ssh/a"),
+	} {
+		source, err := parsePasswordSource(spec)
+		require.NoError(t, err)
+		require.Equal(t, expected, source)
+		require.Equal(t, spec, source.String())
+	}
+
+	source, err := parsePasswordSource("keychain")
+	require.NoError(t, err)
+	require.Equal(t, keychainPassword("commando"), source)
+
+	for _, spec := range []string{"", "env:", "command:", "prompt:x", "vault:x"} {
+		_, err := parsePasswordSource(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func Test_envPassword(t *testing.T) {
+	require.NoError(t, os.Setenv("COMMANDO_TEST_PASSWORD", "s3cret"))
+	defer func() { _ = os.Unsetenv("COMMANDO_TEST_PASSWORD") }()
+
+	password, err := envPassword("COMMANDO_TEST_PASSWORD").password("bob")
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", password)
+
+	_, err = envPassword("COMMANDO_TEST_MISSING").password("bob")
+	require.Error(t, err)
+}
+
+func Test_commandPassword(t *testing.T) {
+	password, err := commandPassword(`printf 's3cret-%s\nurl: example.com\n' "$COMMANDO_USER"`).password("bob")
+	require.NoError(t, err)
+	require.Equal(t, "s3cret-bob", password)
+
+	_, err = commandPassword("exit 1").password("bob")
+	require.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pauseFile exists in the control directory of a run while it is paused.
+const pauseFile = "pause"
+
+// paused returns whether pausing the run has been requested.
+func (c *control) paused() bool {
+	_, err := os.Stat(filepath.Join(c.dir, pauseFile))
+	return err == nil
+}
+
+// follow pauses or resumes r as requested through c.
+func (r *runner) follow(c *control) {
+	switch paused := c.paused(); {
+	case paused && !r.isPaused():
+		r.pause()
+	case !paused && r.isPaused():
+		r.resume()
+	}
+}
+
+// pause stops the runner from starting scripts on any more hosts, and
+// any more scripts on hosts, until it is resumed. Scripts in flight run
+// to completion.
+func (r *runner) pause() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.resumed != nil || r.cancelled {
+		return
+	}
+	r.resumed = make(chan struct{})
+	r.out.warning("run %s paused once scripts in flight finish (commando resume %s to continue)", r.id, r.id)
+}
+
+// resume a paused runner.
+func (r *runner) resume() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.resumed == nil {
+		return
+	}
+	close(r.resumed)
+	r.resumed = nil
+	r.out.message("run %s resumed", r.id)
+}
+
+func (r *runner) isPaused() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.resumed != nil
+}
+
+// waitIfPaused blocks while the runner is paused, until it is resumed or
+// cancelled.
+func (r *runner) waitIfPaused() {
+	r.lock.Lock()
+	resumed := r.resumed
+	r.lock.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+}
+
+// watchPauses follows the pauses requested through c until done, for
+// runners (e.g. of the daemon) which may be paused but not cancelled.
+func (r *runner) watchPauses(c *control, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			r.follow(c)
+		}
+	}
+}
+
+// pauseCmd implements "commando pause <run-id>".
+func pauseCmd(arguments []string) error {
+	dir, err := controlDir("pause", arguments)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, pauseFile), nil, 0600); err != nil {
+		return errors.Wrap(err, "failed to pause run")
+	}
+	colors.info.println("pausing run %s once scripts in flight finish", filepath.Base(dir))
+	return nil
+}
+
+// resumeCmd implements "commando resume <run-id>".
+func resumeCmd(arguments []string) error {
+	dir, err := controlDir("resume", arguments)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, pauseFile)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to resume run")
+	}
+	colors.info.println("resuming run %s", filepath.Base(dir))
+	return nil
+}
+
+// controlDir returns the control directory of the run whose id is the
+// only argument of the subcommand name.
+func controlDir(name string, arguments []string) (string, error) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	_ = flags.Parse(arguments)
+
+	if flags.NArg() != 1 {
+		return "", errors.Errorf("usage: commando %s <run-id>", name)
+	}
+	id := flags.Arg(0)
+	dir := filepath.Join(runsDir(), id)
+	if _, err := os.Stat(dir); err != nil {
+		return "", errors.Errorf("no run in progress with id %s", id)
+	}
+	return dir, nil
+}
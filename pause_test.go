@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runner_pause(t *testing.T) {
+	r := &runner{id: "run1", out: &quiet{}}
+	r.waitIfPaused() // not paused, so returns at once
+
+	r.pause()
+	require.True(t, r.isPaused())
+
+	waited := make(chan struct{})
+	go func() {
+		r.waitIfPaused()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("paused runner did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.resume()
+	require.False(t, r.isPaused())
+	<-waited
+
+	// cancelling a paused run gives up waiting for a resume
+	r.pause()
+	r.cancel(false)
+	require.False(t, r.isPaused())
+	r.waitIfPaused()
+	r.pause()
+	require.False(t, r.isPaused(), "cancelled runs are not paused")
+}
+
+func Test_runner_follow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pause")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &control{id: "run1", dir: dir}
+	r := &runner{id: "run1", out: &quiet{}}
+
+	r.follow(c)
+	require.False(t, r.isPaused())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, pauseFile), nil, 0600))
+	r.follow(c)
+	require.True(t, r.isPaused())
+
+	require.NoError(t, os.Remove(filepath.Join(dir, pauseFile)))
+	r.follow(c)
+	require.False(t, r.isPaused())
+}
+
+func Test_controlDir(t *testing.T) {
+	_, err := controlDir("pause", nil)
+	require.EqualError(t, err, "usage: commando pause <run-id>")
+
+	_, err = controlDir("resume", []string{"19700101-000000-000000"})
+	require.EqualError(t, err, "no run in progress with id 19700101-000000-000000")
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// planVersion is the version of the format of plans.
+const planVersion = 1
+
+// A plan is the fully resolved execution plan of a run, as written by
+// "commando plan" for review, which "commando apply" executes as is.
+type plan struct {
+	Version  int               `json:"version"`
+	ID       string            `json:"id"`
+	Created  time.Time         `json:"created"`
+	Operator string            `json:"operator"`
+	Args     []string          `json:"args"` // of the run, which apply runs with
+	Kind     string            `json:"kind"`
+	Items    []string          `json:"items"`
+	Parallel int               `json:"parallel"`
+	Params   map[string]string `json:"params,omitempty"`
+	Scripts  []plannedScript   `json:"scripts,omitempty"`
+	Canary   []string          `json:"canary,omitempty"`
+	Batches  [][]string        `json:"batches"`
+	Hosts    []plannedHost     `json:"hosts"`
+}
+
+// A plannedScript is a script file of a plan, with the digest of its
+// content (and that of the files it includes).
+type plannedScript struct {
+	Name   string `json:"name"`
+	Digest string `json:"sha256"`
+}
+
+// A plannedHost is a host of a plan, and the steps run on it, in order.
+type plannedHost struct {
+	Host  string        `json:"host"`
+	Batch int           `json:"batch"`
+	Steps []plannedStep `json:"steps"`
+}
+
+// A plannedStep is a step with the variables of its host substituted, and
+// secrets masked. Variables registered while running remain placeholders.
+type plannedStep struct {
+	File    string   `json:"file,omitempty"`
+	Command string   `json:"command"`
+	Stdin   []string `json:"stdin,omitempty"`
+}
+
+// newPlan resolves the plan of running scripts (or --command, if scripts
+// is nil) on hosts, in the order and batches they would run in.
+func newPlan(args args, inv inventory, hosts []string, scripts []scriptfile, vars map[string]string, secrets []string) (plan, error) {
+	p := plan{Version: planVersion, Kind: "command", Items: []string{args.command}, Parallel: args.parallel, Params: maskedVars(vars)}
+	if scripts != nil {
+		p.Kind, p.Items = "scripts", nil
+	}
+	for _, sf := range scripts {
+		digest, err := scriptDigest(sf)
+		if err != nil {
+			return p, err
+		}
+		p.Items = append(p.Items, sf.name)
+		p.Scripts = append(p.Scripts, plannedScript{Name: sf.name, Digest: digest})
+	}
+
+	// like canaried, then batched
+	groups := [][]string{hosts}
+	if args.canary != "" {
+		n, err := hostCount(args.canary, len(hosts))
+		if err != nil {
+			return p, err
+		}
+		p.Canary, groups = hosts[:n], [][]string{hosts[:n], hosts[n:]}
+	}
+	for _, group := range groups {
+		chunks, err := batches(group, args.batch)
+		if err != nil {
+			return p, err
+		}
+		for _, chunk := range chunks {
+			if len(chunk) > 0 {
+				p.Batches = append(p.Batches, chunk)
+			}
+		}
+	}
+
+	r := newRunner(args, "", inv, &quiet{})
+	r.index, r.params = indexes(hosts), vars
+	secrets = append(append(secrets, inventorySecrets(inv, hosts)...), secretVars(vars)...)
+	for _, chunk := range p.Batches {
+		b := r.startBatch(chunk)
+		for _, host := range chunk {
+			planned := plannedHost{Host: host, Batch: b.Number}
+			if scripts == nil {
+				sc := r.withVars(host, script{command: args.command})
+				planned.Steps = append(planned.Steps, plannedStep{Command: redact(sc.command, secrets)})
+			}
+			for _, sf := range scripts {
+				for _, sc := range sf.scripts {
+					sc = r.withVars(host, sc)
+					step := plannedStep{File: sf.name, Command: redact(sc.command, secrets)}
+					for _, line := range sc.stdin {
+						step.Stdin = append(step.Stdin, redact(line, secrets))
+					}
+					planned.Steps = append(planned.Steps, step)
+				}
+			}
+			p.Hosts = append(p.Hosts, planned)
+		}
+	}
+	return p, nil
+}
+
+// scriptDigest returns the SHA-256 of the content of sf, with its includes.
+func scriptDigest(sf scriptfile) (string, error) {
+	content := sf.source
+	if sf.path != "" {
+		var err error
+		if content, err = include(sf.path, nil, make(map[string]bool)); err != nil {
+			return "", errors.Wrapf(err, "failed to read script %s", sf.name)
+		}
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// inventorySecrets returns the values of the metadata of hosts whose keys
+// mark them as secrets, like those of env files (in any case).
+func inventorySecrets(inv inventory, hosts []string) []string {
+	var secrets []string
+	for _, host := range hosts {
+		for key, value := range inv.metadata(host) {
+			if isSecret(strings.ToUpper(key)) && value != "" {
+				secrets = append(secrets, value)
+			}
+		}
+	}
+	return secrets
+}
+
+// secretVars returns the values of vars whose names mark them as secrets,
+// like those of env files (in any case).
+func secretVars(vars map[string]string) []string {
+	var secrets []string
+	for name, value := range vars {
+		if isSecret(strings.ToUpper(name)) && value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+	return secrets
+}
+
+// maskedVars returns vars with the values of secrets masked, as plans are
+// written with.
+func maskedVars(vars map[string]string) map[string]string {
+	if vars == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(vars))
+	for name, value := range vars {
+		if isSecret(strings.ToUpper(name)) && value != "" {
+			value = mask
+		}
+		masked[name] = value
+	}
+	return masked
+}
+
+// maskedArgs returns the arguments of a run with the values of secret
+// --vars masked, as plans are written with.
+func maskedArgs(arguments []string) []string {
+	maskVar := func(kv string) string {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 && parts[1] != "" && isSecret(strings.ToUpper(parts[0])) {
+			return parts[0] + "=" + mask
+		}
+		return kv
+	}
+
+	masked := make([]string, 0, len(arguments))
+	for i := 0; i < len(arguments); i++ {
+		switch arg := arguments[i]; {
+		case (arg == "--var" || arg == "-var") && i+1 < len(arguments):
+			masked = append(masked, arg, maskVar(arguments[i+1]))
+			i++
+		case strings.HasPrefix(strings.TrimLeft(arg, "-"), "var=") && strings.HasPrefix(arg, "-"):
+			flagName := arg[:strings.Index(arg, "=")+1]
+			masked = append(masked, flagName+maskVar(strings.TrimPrefix(arg, flagName)))
+		default:
+			masked = append(masked, arg)
+		}
+	}
+	return masked
+}
+
+// unmaskVars sets the values of the secret vars masked in a plan, from the
+// environment variables of their names, or else as read from in, as
+// prompted on a terminal. If in is not a terminal, a missing one is an
+// error.
+func unmaskVars(vars map[string]string, in io.Reader, interactive bool) error {
+	var names []string
+	for name, value := range vars {
+		if value == mask && isSecret(strings.ToUpper(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var reader *bufio.Reader
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			vars[name] = value
+			continue
+		}
+		if !interactive {
+			return errors.Errorf("plan masks the value of %s, set it in the environment", name)
+		}
+
+		if reader == nil {
+			reader = bufio.NewReader(in)
+		}
+		colors.muted.println("  value of %s (masked in the plan) --> ", name)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", name)
+		}
+		vars[name] = strings.TrimSpace(line)
+	}
+	return nil
+}
+
+// hosts returns the hosts of p, in the order they run in.
+func (p plan) hosts() []string {
+	hosts := make([]string, 0, len(p.Hosts))
+	for _, h := range p.Hosts {
+		hosts = append(hosts, h.Host)
+	}
+	return hosts
+}
+
+// verify returns an error unless p is the plan resolved now, i.e. neither
+// its scripts nor the variables of its hosts changed since it was made.
+func (p plan) verify(current plan) error {
+	if p.Version != planVersion {
+		return errors.Errorf("plan is of version %d, expected %d", p.Version, planVersion)
+	}
+	digests := make(map[string]string, len(current.Scripts))
+	for _, s := range current.Scripts {
+		digests[s.Name] = s.Digest
+	}
+	for _, s := range p.Scripts {
+		if digests[s.Name] != s.Digest {
+			return errors.Errorf("script %s changed since the plan was made", s.Name)
+		}
+	}
+	for i, h := range p.Hosts {
+		if i >= len(current.Hosts) || !reflect.DeepEqual(h, current.Hosts[i]) {
+			return errors.Errorf("steps of %s changed since the plan was made", h.Host)
+		}
+	}
+
+	current.ID, current.Created, current.Operator, current.Args = p.ID, p.Created, p.Operator, p.Args
+	expected, _ := json.Marshal(p)
+	actual, _ := json.Marshal(current)
+	if string(expected) != string(actual) {
+		return errors.New("plan differs from the plan resolved now (e.g. in its batches or params)")
+	}
+	return nil
+}
+
+// readPlan reads the plan written by "commando plan" to path, as JSON.
+func readPlan(path string) (plan, error) {
+	var p plan
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, errors.Wrap(err, "failed to read plan")
+	}
+	return p, errors.Wrapf(json.Unmarshal(bs, &p), "failed to parse plan %s", path)
+}
+
+// planCmd implements "commando plan [--format json|yaml] [--out FILE] <run
+// flags>", which writes the plan of the run instead of running it.
+func planCmd(arguments []string) error {
+	format := flag.String("format", "json", "write the plan as json or yaml (only json plans may be applied)")
+	out := flag.String("out", "", "write the plan to this file (default stdout)")
+	args := parseArguments(arguments)
+	if *format != "json" && *format != "yaml" {
+		return errors.Errorf("--format must be one of json, yaml")
+	}
+	if err := validate(args); err != nil {
+		return errors.Wrap(err, "arguments are invalid")
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		var err error
+		if inv, err = loadInventory(args.inventory); err != nil {
+			return errors.Wrap(err, "failed to load inventory")
+		}
+	}
+	dot, err := loadDotenv(args)
+	if err != nil {
+		return errors.Wrap(err, "failed to load env files")
+	}
+	args.env = append(dot.env, args.env...)
+
+	hosts, err := targets(args, inv)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve hosts")
+	}
+	if hosts, err = narrow(args, inv, hosts); err != nil {
+		return errors.Wrap(err, "arguments are invalid")
+	}
+	// the plan may be written to stdout, so the report is only printed with --out
+	args.resolveReport = args.resolveReport && *out != ""
+	hosts = deduplicated(args, hosts, func(format string, a ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", a...)
+	})
+	if len(hosts) == 0 {
+		return errors.New("no hosts resolved from --host regex")
+	}
+
+	vars, err := parseVars(args.vars)
+	if err != nil {
+		return errors.Wrap(err, "arguments are invalid")
+	}
+	var scripts []scriptfile
+	if args.command == "" {
+		if scripts, err = load(args); err != nil {
+			return errors.Wrap(err, "failed to load scripts")
+		}
+		scripts = withEnv(scripts, args.env)
+		if vars, err = params(scripts, vars, os.Stdin, stdinIsTerminal()); err != nil {
+			return errors.Wrap(err, "failed to resolve params")
+		}
+	}
+
+	p, err := newPlan(args, inv, hosts, scripts, vars, dot.secrets)
+	if err != nil {
+		return errors.Wrap(err, "failed to plan")
+	}
+	p.ID, p.Created, p.Operator, p.Args = newRunID(), time.Now().UTC(), os.Getenv("USER"), maskedArgs(withoutPlanFlags(arguments))
+
+	w := os.Stdout
+	if *out != "" {
+		if w, err = os.OpenFile(*out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err != nil {
+			return errors.Wrap(err, "failed to write plan")
+		}
+		defer func() { _ = w.Close() }()
+	}
+	if *format == "yaml" {
+		return errors.Wrap(writeYAML(w, p), "failed to write plan")
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return errors.Wrap(encoder.Encode(p), "failed to write plan")
+}
+
+// withoutPlanFlags returns the arguments of a run without the flags of
+// "commando plan" itself, so that they may be run by "commando apply".
+func withoutPlanFlags(arguments []string) []string {
+	var kept []string
+	for i := 0; i < len(arguments); i++ {
+		switch arg := arguments[i]; {
+		case arg == "--format" || arg == "-format" || arg == "--out" || arg == "-out":
+			i++
+		case strings.HasPrefix(strings.TrimLeft(arg, "-"), "format=") || strings.HasPrefix(strings.TrimLeft(arg, "-"), "out="):
+		default:
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+// applyCmd implements "commando apply <plan.json>", which runs the plan
+// exactly, once it has checked the plan still holds.
+func applyCmd(arguments []string) error {
+	if len(arguments) != 1 {
+		return errors.New("usage: commando apply <plan.json>")
+	}
+	p, err := readPlan(arguments[0])
+	if err != nil {
+		return err
+	}
+	colors.info.println("applying plan %s of %s, made by %s", p.ID, p.Created.Local().Format(time.RFC3339), p.Operator)
+	start(parseArguments(p.Args), &p)
+	return nil
+}
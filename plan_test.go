@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "01-deploy")
+	require.NoError(t, ioutil.WriteFile(path, []byte("# param: version\ncurl -H 'Authorization: {{.api_token}}' https://{{.host}}/deploy/{{.version}}\n---\necho batch {{.Batch.Number}} {{.sha}}"), 0600))
+	sf, err := read("01-deploy", path, make(map[string]bool))
+	require.NoError(t, err)
+
+	inv, err := parseInventory("web1 api_token=t0ken\nweb2 api_token=t0ken\nweb3 api_token=t0ken\n")
+	require.NoError(t, err)
+
+	cfg := args{sshConfig: "none", parallel: 2, canary: "1", batch: "1"}
+	hosts := []string{"web1", "web2", "web3"}
+	p, err := newPlan(cfg, inv, hosts, []scriptfile{sf}, map[string]string{"version": "1.2"}, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, "scripts", p.Kind)
+	require.Equal(t, []string{"01-deploy"}, p.Items)
+	require.Equal(t, []string{"web1"}, p.Canary)
+	require.Equal(t, [][]string{{"web1"}, {"web2"}, {"web3"}}, p.Batches)
+	require.Equal(t, hosts, p.hosts())
+	require.Equal(t, plannedHost{Host: "web2", Batch: 2, Steps: []plannedStep{
+		{File: "01-deploy", Command: "curl -H 'Authorization: ********' https://web2/deploy/1.2"},
+		{File: "01-deploy", Command: "echo batch 2 {{.sha}}"},
+	}}, p.Hosts[1])
+
+	current, err := newPlan(cfg, inv, hosts, []scriptfile{sf}, map[string]string{"version": "1.2"}, nil)
+	require.NoError(t, err)
+	p.ID, p.Args = "20250630-120000-abcdef", []string{"--scripts", dir}
+	require.NoError(t, p.verify(current))
+
+	current, err = newPlan(cfg, inv, hosts, []scriptfile{sf}, map[string]string{"version": "1.3"}, nil)
+	require.NoError(t, err)
+	require.EqualError(t, p.verify(current), "steps of web1 changed since the plan was made")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("uptime"), 0600))
+	current, err = newPlan(cfg, inv, hosts, []scriptfile{sf}, map[string]string{"version": "1.2"}, nil)
+	require.NoError(t, err)
+	require.EqualError(t, p.verify(current), "script 01-deploy changed since the plan was made")
+}
+
+func Test_withoutPlanFlags(t *testing.T) {
+	require.Equal(t,
+		[]string{"--hosts", "web1,web2", "--scripts", "deploy/", "--var", "version=1.2"},
+		withoutPlanFlags([]string{"--format", "yaml", "--hosts", "web1,web2", "--out=plan.json", "--scripts", "deploy/", "-format=json", "--var", "version=1.2"}),
+	)
+}
+
+func Test_newPlan_secretVars(t *testing.T) {
+	cfg := args{sshConfig: "none", parallel: 1, command: "deploy --token {{.api_token}} {{.version}}"}
+	vars := map[string]string{"api_token": "t0ken", "version": "1.2"}
+	p, err := newPlan(cfg, inventory{}, []string{"web1"}, nil, vars, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"api_token": mask, "version": "1.2"}, p.Params)
+	require.Equal(t, "deploy --token ******** 1.2", p.Hosts[0].Steps[0].Command)
+
+	require.Equal(t,
+		[]string{"--var", "api_token=" + mask, "--var=DB_PASSWORD=" + mask, "-var", "version=1.2", "--command", "uptime"},
+		maskedArgs([]string{"--var", "api_token=t0ken", "--var=DB_PASSWORD=s3cret", "-var", "version=1.2", "--command", "uptime"}),
+	)
+
+	// masked values are required again to apply the plan
+	applied := map[string]string{"api_token": mask, "version": "1.2"}
+	require.EqualError(t, unmaskVars(applied, strings.NewReader(""), false), "plan masks the value of api_token, set it in the environment")
+	require.NoError(t, unmaskVars(applied, strings.NewReader("t0ken\n"), true))
+	require.Equal(t, vars, applied)
+
+	applied["api_token"] = mask
+	require.NoError(t, os.Setenv("api_token", "t0ken"))
+	defer func() { _ = os.Unsetenv("api_token") }()
+	require.NoError(t, unmaskVars(applied, strings.NewReader(""), false))
+	require.Equal(t, vars, applied)
+
+	current, err := newPlan(cfg, inventory{}, []string{"web1"}, nil, applied, nil)
+	require.NoError(t, err)
+	require.NoError(t, p.verify(current))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pluginProtocol is the version of the plugin protocol, sent with every
+// request so that plugins may reject versions they do not understand.
+const pluginProtocol = 1
+
+// A plugin is an executable which extends commando without living in this
+// repository, e.g. with an in-house CMDB integration. Plugins are named
+// commando-<name> and found on $PATH, unless given as a path.
+//
+// The protocol is a JSON request written to the stdin of the plugin, which
+// answers with a JSON response on stdout and exits. Requests are one of:
+//
+//	{"protocol": 1, "kind": "inventory", "query": "role=web"}
+//	{"protocol": 1, "kind": "secret", "host": "web1"}
+//	{"protocol": 1, "kind": "notify", "event": {"event": "host-failed", ...}}
+//
+// and are answered with {"hosts": [...]}, {"secret": {"password": ...}},
+// and {} respectively, or {"error": "..."} if the request failed. Result
+// sink plugins are the exception, see pluginSink.
+type plugin struct {
+	name string
+	path string
+}
+
+type pluginRequest struct {
+	Protocol int    `json:"protocol"`
+	Kind     string `json:"kind"`
+	Query    string `json:"query,omitempty"`
+	Host     string `json:"host,omitempty"`
+	Event    *event `json:"event,omitempty"`
+}
+
+type pluginResponse struct {
+	Hosts  []string          `json:"hosts,omitempty"`
+	Secret map[string]string `json:"secret,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+func findPlugin(name string) (*plugin, error) {
+	path := name
+	if !strings.Contains(name, "/") {
+		var err error
+		if path, err = exec.LookPath("commando-" + name); err != nil {
+			return nil, errors.Errorf("plugin commando-%s not found on $PATH", name)
+		}
+	}
+	return &plugin{name: name, path: path}, nil
+}
+
+func (p *plugin) String() string {
+	return "plugin " + p.name
+}
+
+func (p *plugin) call(request pluginRequest) (pluginResponse, error) {
+	request.Protocol = pluginProtocol
+
+	var response pluginResponse
+	bs, err := json.Marshal(request)
+	if err != nil {
+		return response, errors.Wrap(err, "failed to encode plugin request")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.path)
+	cmd.Stdin = bytes.NewReader(bs)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return response, errors.Wrapf(err, "%s failed: %s", p, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := json.Unmarshal(out, &response); err != nil {
+		return response, errors.Wrapf(err, "%s returned a malformed response", p)
+	}
+	if response.Error != "" {
+		return response, errors.Errorf("%s: %s", p, response.Error)
+	}
+	return response, nil
+}
+
+// pluginHosts discovers hosts with an inventory plugin, given a query of
+// the form <name>:<query>, e.g. "plugin:cmdb:role=web".
+func pluginHosts(_ args, query string) ([]string, error) {
+	parts := strings.SplitN(query, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, errors.Errorf("malformed plugin query %q, must be <name>:<query>", query)
+	}
+
+	p, err := findPlugin(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := p.call(pluginRequest{Kind: "inventory", Query: parts[1]})
+	if err != nil {
+		return nil, err
+	}
+	return response.Hosts, nil
+}
+
+// secret returns the credentials for host from a secret plugin.
+func (p *plugin) secret(host string) (map[string]string, error) {
+	response, err := p.call(pluginRequest{Kind: "secret", Host: host})
+	if err != nil {
+		return nil, err
+	}
+	return response.Secret, nil
+}
+
+// notify sends an event of the run to a notifier plugin.
+func (p *plugin) notify(e event) error {
+	_, err := p.call(pluginRequest{Kind: "notify", Event: &e})
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// a plugin answering inventory requests, and failing everything else
+const testPlugin = `#!/bin/sh
+request=$(cat)
+case "$request" in
+  *'"kind":"inventory"'*) echo '{"hosts": ["web1", "web2"]}' ;;
+  *) echo '{"error": "unsupported request"}' ;;
+esac
+`
+
+func Test_plugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "commando-cmdb")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testPlugin), 0700))
+
+	hosts, err := pluginHosts(args{}, path+":role=web")
+	require.NoError(t, err)
+	require.Equal(t, []string{"web1", "web2"}, hosts)
+
+	p, err := findPlugin(path)
+	require.NoError(t, err)
+	_, err = p.secret("web1")
+	require.EqualError(t, err, "plugin "+path+": unsupported request")
+
+	_, err = pluginHosts(args{}, "role=web")
+	require.Error(t, err)
+
+	_, err = findPlugin("does-not-exist")
+	require.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// actions of --precheck on unreachable hosts
+const (
+	precheckAbort   = "abort"   // fail the run before running anything
+	precheckSkip    = "skip"    // run on the reachable hosts only
+	precheckConfirm = "confirm" // ask whether to run on the reachable hosts
+)
+
+var precheckActions = []string{precheckAbort, precheckSkip, precheckConfirm}
+
+// precheck connects to (and authenticates with) every host in parallel
+// before running anything, reporting which are unreachable, and returning
+// the hosts to run on as configured by --precheck. Connections are kept,
+// for the run to use.
+func precheck(action string, r *runner, hosts []string) ([]string, error) {
+	if action == "" {
+		return hosts, nil
+	}
+
+	unreachable := r.probeAll(hosts)
+	if len(unreachable) == 0 {
+		r.out.message("all %d hosts are reachable", len(hosts))
+		return hosts, nil
+	}
+
+	names := make([]string, 0, len(unreachable))
+	for host := range unreachable {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	r.out.warning("%d of %d hosts are unreachable", len(unreachable), len(hosts))
+	for _, host := range names {
+		r.out.warning("  %s: %v", host, unreachable[host])
+	}
+
+	reachable := make([]string, 0, len(hosts)-len(unreachable))
+	for _, host := range hosts {
+		if _, failed := unreachable[host]; !failed {
+			reachable = append(reachable, host)
+		}
+	}
+
+	switch action {
+	case precheckAbort:
+		r.closeWarm()
+		return nil, errors.Errorf("%d hosts are unreachable: %s", len(names), strings.Join(names, ", "))
+	case precheckConfirm:
+		proceed, err := confirm(fmt.Sprintf("continue with the %d reachable hosts?", len(reachable)))
+		if err != nil || !proceed {
+			r.closeWarm()
+			if err == nil {
+				err = errors.New("run aborted after precheck")
+			}
+			return nil, err
+		}
+	}
+
+	for _, host := range names {
+		r.record(result{Host: host, ExitCode: -1, Error: unreachable[host].Error()})
+	}
+	return reachable, nil
+}
+
+// probeAll dials every host, at most --parallel (and at least 20) at a
+// time, keeping the connections to those it reaches, and returning why
+// the others are unreachable.
+func (r *runner) probeAll(hosts []string) map[string]error {
+	parallel := r.parallel
+	if parallel < 20 {
+		parallel = 20
+	}
+
+	var (
+		lock        sync.Mutex
+		wg          sync.WaitGroup
+		unreachable = make(map[string]error)
+	)
+	slots := make(chan struct{}, parallel)
+	for _, host := range hosts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(host string) {
+			defer func() { <-slots; wg.Done() }()
+			client, err := r.dial(host)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				unreachable[host] = err
+				return
+			}
+			r.lock.Lock()
+			if r.warm == nil {
+				r.warm = make(map[string]*ssh.Client)
+			}
+			r.warm[host] = client
+			r.lock.Unlock()
+		}(host)
+	}
+	wg.Wait()
+	return unreachable
+}
+
+// takeWarm returns the connection to host made by the precheck, if it
+// is still alive.
+func (r *runner) takeWarm(host string) (*ssh.Client, bool) {
+	r.lock.Lock()
+	client, exists := r.warm[host]
+	delete(r.warm, host)
+	r.lock.Unlock()
+	if !exists {
+		return nil, false
+	}
+
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		_ = client.Close()
+		return nil, false
+	}
+	return client, true
+}
+
+// closeWarm closes the connections made by the precheck which were not used.
+func (r *runner) closeWarm() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for host, client := range r.warm {
+		_ = client.Close()
+		delete(r.warm, host)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_precheck(t *testing.T) {
+	refused := func(string, string) (net.Conn, error) { return nil, errors.New("connection refused") }
+	r := &runner{out: &quiet{}, dialer: refused, passwords: make(map[string]string)}
+
+	hosts, err := precheck("", r, []string{"web1", "web2"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"web1", "web2"}, hosts)
+
+	_, err = precheck(precheckAbort, r, []string{"web1", "web2"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "2 hosts are unreachable: web1, web2")
+	require.Empty(t, r.results)
+
+	hosts, err = precheck(precheckSkip, r, []string{"web1", "web2"})
+	require.NoError(t, err)
+	require.Empty(t, hosts)
+	require.Len(t, r.results, 2)
+	require.Contains(t, r.results[0].Error, "connection refused")
+}
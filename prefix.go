@@ -0,0 +1,51 @@
+package main
+
+import (
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// hostColor returns the color of host among those of the theme, so each
+// host's lines stand out, which is the same on every run.
+func hostColor(host string) *color.Color {
+	if len(colors.hosts) == 0 {
+		return style{}.color()
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(host))
+	return colors.hosts[h.Sum32()%uint32(len(colors.hosts))].color()
+}
+
+// A prefixer writes whole lines of output, each prefixed with the host it
+// came from, so that the output of hosts running in parallel never garbles.
+type prefixer struct {
+	lock   sync.Mutex
+	w      io.Writer
+	colors bool // of each prefix, by host
+}
+
+// println writes each line of text prefixed with [host], in color c
+// (if any), with a single write.
+func (p *prefixer) println(host string, c *color.Color, text string) {
+	prefix := "[" + host + "]"
+	if p.colors {
+		prefix = hostColor(host).Sprint(prefix)
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(text, "\r\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if c != nil {
+			line = c.Sprint(line)
+		}
+		_, _ = b.WriteString(prefix + " " + line + "\n")
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, _ = io.WriteString(p.w, b.String())
+}
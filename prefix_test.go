@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_prefixer(t *testing.T) {
+	var buf bytes.Buffer
+	p := &prefixer{w: &buf}
+	p.println("web1", nil, "line one\r\nline two\n")
+	require.Equal(t, "[web1] line one\n[web1] line two\n", buf.String())
+}
+
+func Test_prefixer_concurrent(t *testing.T) {
+	var buf bytes.Buffer
+	p := &prefixer{w: &buf}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.println(host, nil, strings.Repeat(host, 50))
+			}
+		}(fmt.Sprintf("web%d", i))
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, 1000, len(lines))
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		host := strings.Trim(fields[0], "[]")
+		require.Equal(t, strings.Repeat(host, 50), fields[1])
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// preflight checks host before any of files run on it, returning whether
+// they should. Hosts short of the space of --min-free are skipped, and
+// hosts whose clock is skewed by more than --max-skew are warned about,
+// or fail (with --skew-action fail).
+func (r *runner) preflight(client *ssh.Client, host string, files []scriptfile) (bool, error) {
+	if reason, err := r.lowSpace(client, host, r.minFree); err != nil {
+		r.record(result{Host: host, ExitCode: -1, Error: err.Error()})
+		return false, errors.Wrapf(err, "failed to check %s", host)
+	} else if reason != "" {
+		r.out.warning("skipping %s: %s", host, reason)
+		for _, file := range files {
+			r.record(result{Host: host, File: file.name, Skipped: reason})
+		}
+		return false, nil
+	}
+
+	if r.maxSkew <= 0 {
+		return true, nil
+	}
+	probe, done, err := r.probeClient(client, host)
+	if err != nil {
+		r.out.warning("failed to check the clock of %s: %v", host, err)
+		return true, nil
+	}
+	skew, err := clockSkew(probe)
+	done()
+	if err != nil {
+		r.out.warning("failed to check the clock of %s: %v", host, err)
+		return true, nil
+	}
+	if math.Abs(float64(skew)) <= float64(r.maxSkew) {
+		return true, nil
+	}
+
+	check := skewCheck{max: r.maxSkew}
+	if !r.failOnSkew {
+		r.out.warning("clock of %s is %s, more than %s", host, describeSkew(skew), r.maxSkew)
+		return true, nil
+	}
+	r.record(result{Host: host, ExitCode: -1, Failed: []string{fmt.Sprintf("%s (got %s)", check, describeSkew(skew))}})
+	return false, failures{{host: host, check: check, actual: describeSkew(skew)}}
+}
+
+// skewCheck is the check of the clock of a host being within max of ours.
+type skewCheck struct {
+	max time.Duration
+}
+
+func (c skewCheck) String() string {
+	return "clock skew within " + c.max.String()
+}
+
+// clockSkew returns how far ahead of the local clock the clock of the
+// host of client is (negative if behind), assuming the remote clock was
+// read halfway through the round trip.
+func clockSkew(client *ssh.Client) (time.Duration, error) {
+	before := time.Now()
+	output, err := remote(client, "date +%s", "")
+	after := time.Now()
+	if err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("unexpected output of date: %q", output)
+	}
+	local := before.Add(after.Sub(before) / 2)
+	return skewOf(time.Unix(seconds, 0), local), nil
+}
+
+// skewOf returns how far ahead remote, which has a resolution of a
+// second, is of local. Skews within that resolution are none.
+func skewOf(remote, local time.Time) time.Duration {
+	skew := remote.Sub(local.Truncate(time.Second))
+	if skew == time.Second || skew == -time.Second {
+		return 0
+	}
+	return skew
+}
+
+func describeSkew(skew time.Duration) string {
+	if skew < 0 {
+		return (-skew).String() + " behind"
+	}
+	return skew.String() + " ahead"
+}
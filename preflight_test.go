@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_skewOf(t *testing.T) {
+	local := time.Date(2026, 10, 16, 12, 0, 0, 600*int(time.Millisecond), time.UTC)
+
+	require.Equal(t, time.Duration(0), skewOf(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), local))
+	require.Equal(t, time.Duration(0), skewOf(time.Date(2026, 10, 16, 12, 0, 1, 0, time.UTC), local))
+	require.Equal(t, 45*time.Second, skewOf(time.Date(2026, 10, 16, 12, 0, 45, 0, time.UTC), local))
+	require.Equal(t, -2*time.Minute, skewOf(time.Date(2026, 10, 16, 11, 58, 0, 0, time.UTC), local))
+
+	require.Equal(t, "45s ahead", describeSkew(45*time.Second))
+	require.Equal(t, "2m0s behind", describeSkew(-2*time.Minute))
+	require.Equal(t, "clock skew within 30s", skewCheck{max: 30 * time.Second}.String())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// sudoFacts returns what is known about sudo on host, probing it the first time.
+func (r *runner) sudoFacts(client *ssh.Client, host string) (map[string]string, error) {
+	r.lock.Lock()
+	facts, exists := r.sudo[host]
+	r.lock.Unlock()
+	if exists {
+		return facts, nil
+	}
+
+	output, err := r.probeRemote(client, host, sudoCheck)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to detect sudo")
+	}
+	facts = fields(output)
+
+	r.lock.Lock()
+	r.sudo[host] = facts
+	r.lock.Unlock()
+	return facts, nil
+}
+
+// adapt a script marked with "# sudo: true" to how sudo is configured on
+// host: if sudo does not require a password the password is not sent,
+// and if sudo is missing the command is run with su instead (if enabled
+// with --su-fallback).
+func (r *runner) adapt(client *ssh.Client, host string, sc script) (script, error) {
+	if !sc.sudo {
+		return sc, nil
+	}
+
+	facts, err := r.sudoFacts(client, host)
+	if err != nil {
+		return sc, err
+	}
+
+	switch {
+	case facts["sudo"] == "missing" && !r.suFallback:
+		return sc, errors.Errorf("sudo is not installed on %s", host)
+	case facts["sudo"] == "missing":
+		command := strings.TrimPrefix(strings.TrimSpace(sc.command), "sudo ")
+		sc.command = "su -c " + quote(command)
+	case facts["nopasswd"] == "yes":
+		sc.stdin = withoutPassword(sc.stdin)
+	}
+	return sc, nil
+}
+
+// runsSudo returns whether any step of scripts is marked "# sudo", or is
+// given the password on stdin (e.g. piping it to sudo -S itself).
+func runsSudo(scripts []scriptfile) bool {
+	for _, sf := range scripts {
+		for _, sc := range sf.scripts {
+			if sc.sudo || len(withoutPassword(sc.stdin)) < len(sc.stdin) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withoutPassword removes the PASSWORD lines of stdin.
+func withoutPassword(stdin []string) []string {
+	kept := make([]string, 0, len(stdin))
+	for _, line := range stdin {
+		if strings.TrimSpace(line) != "PASSWORD" {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// sudoValidate validates the password on stdin with sudo, ignoring any
+// credentials sudo cached, so that the password itself is checked.
+const sudoValidate = "LC_ALL=C sudo -k -S -p '' -v"
+
+// validateSudo checks the sudo password is right on the first of hosts,
+// before running on more than one, so that a wrong password fails the run
+// rather than locking out the account with failed attempts on every host.
+// It is only checked if the run uses sudo, i.e. has steps marked "# sudo"
+// or given the password, a --command given the password with --pw, or
+// commands run as another user (see becomeUser).
+func (r *runner) validateSudo(hosts []string, sudo bool) error {
+	if !r.sudoCheck || !sudo || len(hosts) < 2 {
+		return nil
+	}
+	host := hosts[0]
+
+	client, err := r.dial(host)
+	if err != nil {
+		return nil // which the run reports
+	}
+	defer func() { _ = client.Close() }()
+	return r.checkSudoPassword(client, host, len(hosts)-1)
+}
+
+// checkSudoPassword validates the sudo password on host, connected to with
+// client, before running on more hosts. Hosts where sudo is missing or
+// needs no password are not checked.
+func (r *runner) checkSudoPassword(client *ssh.Client, host string, more int) error {
+	password := r.password(host)
+	if password == "" {
+		return nil
+	}
+	facts, err := r.sudoFacts(client, host)
+	if err != nil || facts["sudo"] != "present" || facts["nopasswd"] == "yes" {
+		return nil
+	}
+
+	output, err := remote(client, sudoValidate, password+"\n")
+	switch {
+	case err == nil:
+		r.out.message("validated the sudo password on %s", host)
+		return nil
+	case sudoRejected(output):
+		return authError{err: errors.Errorf("the sudo password is wrong on %s, so the run stopped before trying it on %d more hosts (use --no-sudo-check to skip the check)", host, more)}
+	default:
+		r.out.warning("could not validate the sudo password on %s: %s", host, output)
+		return nil
+	}
+}
+
+// sudoRejected returns whether the output of sudoValidate says the
+// password was wrong, rather than e.g. that the user may not sudo.
+func sudoRejected(output string) bool {
+	return strings.Contains(output, "incorrect password") || strings.Contains(output, "Sorry, try again")
+}
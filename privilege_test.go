@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_sudoRejected(t *testing.T) {
+	require.True(t, sudoRejected("Sorry, try again.\nsudo: no password was provided\nsudo: 1 incorrect password attempt"))
+	require.False(t, sudoRejected("alice is not in the sudoers file.  This incident will be reported."))
+	require.False(t, sudoRejected("sudo: sorry, you must have a tty to run sudo"))
+}
+
+func Test_runner_validateSudo(t *testing.T) {
+	refused := func(string, string) (net.Conn, error) { return nil, errors.New("connection refused") }
+	r := &runner{out: &quiet{}, dialer: refused, pass: "hunter2", sudoCheck: true, passwords: make(map[string]string)}
+	require.NoError(t, r.validateSudo([]string{"web1"}, true), "a single host is not checked")
+	require.NoError(t, r.validateSudo([]string{"web1", "web2"}, true), "hosts which cannot be dialed are reported by the run")
+
+	r.sudoCheck = false
+	require.NoError(t, r.validateSudo([]string{"web1", "web2"}, true))
+
+	r.sudoCheck, r.dialer = true, func(string, string) (net.Conn, error) {
+		panic("dialed a host of a run not using sudo")
+	}
+	require.NoError(t, r.validateSudo([]string{"web1", "web2"}, false))
+}
+
+// sudoServer returns a client of a server whose sudo accepts password on
+// stdin, and rejects any other.
+func sudoServer(t *testing.T, password string) *ssh.Client {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	address := serve(t, func(conn net.Conn) {
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for ch := range chans {
+			channel, requests, err := ch.Accept()
+			if err != nil {
+				return
+			}
+			for req := range requests {
+				_ = req.Reply(req.Type == "exec", nil)
+				if req.Type != "exec" {
+					continue
+				}
+				status := uint32(0)
+				if line, _ := bufio.NewReader(channel).ReadString('\n'); strings.TrimSpace(line) != password {
+					_, _ = io.WriteString(channel.Stderr(), "Sorry, try again.\nsudo: 1 incorrect password attempt\n")
+					status = 1
+				}
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				_ = channel.Close()
+			}
+		}
+	})
+
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{User: "alice", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	require.NoError(t, err)
+	return client
+}
+
+func Test_runner_checkSudoPassword(t *testing.T) {
+	r := &runner{out: &quiet{}, pass: "hunter2", passwords: make(map[string]string),
+		sudo: map[string]map[string]string{"web1": {"sudo": "present", "nopasswd": "no"}}}
+
+	accepted := sudoServer(t, "hunter2")
+	defer func() { _ = accepted.Close() }()
+	require.NoError(t, r.checkSudoPassword(accepted, "web1", 2))
+
+	rejected := sudoServer(t, "letmein")
+	defer func() { _ = rejected.Close() }()
+	err := r.checkSudoPassword(rejected, "web1", 2)
+	require.EqualError(t, err, "the sudo password is wrong on web1, so the run stopped before trying it on 2 more hosts (use --no-sudo-check to skip the check)")
+	require.Equal(t, reasonAuth, reason(err))
+}
+
+func Test_runsSudo(t *testing.T) {
+	plain, err := parse("10-status.sh", "systemctl status app")
+	require.NoError(t, err)
+	restart, err := parse("20-restart.sh", "systemctl status app\n---\n# sudo: true\nsudo systemctl restart app\nPASSWORD")
+	require.NoError(t, err)
+
+	require.False(t, runsSudo([]scriptfile{plain}))
+	require.True(t, runsSudo([]scriptfile{plain, restart}))
+
+	// steps piping the password to sudo themselves use it as much
+	piped, err := parse("30-reload.sh", "sudo -S systemctl reload app\nPASSWORD")
+	require.NoError(t, err)
+	require.True(t, runsSudo([]scriptfile{plain, piped}))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// sudoCheck reports whether sudo exists, works without a password, and
+// which groups the user is in, as key=value lines.
+const sudoCheck = `if ! command -v sudo >/dev/null 2>&1; then echo sudo=missing; exit 0; fi
+echo sudo=present
+if sudo -n true >/dev/null 2>&1; then echo nopasswd=yes; else echo nopasswd=no; fi
+echo groups=$(id -Gn)`
+
+// sudoGroups are groups commonly granted sudo by default sudoers files.
+var sudoGroups = []string{"sudo", "wheel", "admin"}
+
+// A sudoProbe is what was learned about sudo on a host.
+type sudoProbe struct {
+	host     string
+	sudo     string // yes, no, likely, or unknown
+	nopasswd bool
+	password string // none, user, root, target, runas, or unknown
+	err      error
+}
+
+// probeCmd implements "commando probe sudo --hosts ...".
+func probeCmd(arguments []string) error {
+	if len(arguments) == 0 || arguments[0] != "sudo" {
+		return errors.Errorf("usage: commando probe sudo [--hosts hosts] [--inventory file] [--user user] [--pw]")
+	}
+
+	var args args
+	flags := flag.NewFlagSet("probe sudo", flag.ExitOnError)
+	flags.StringVar(&args.user, "user", os.Getenv("USER"), "ssh username")
+	flags.StringVar(&args.hostList, "hosts", "", "the list of hosts")
+	flags.StringVar(&args.inventory, "inventory", "", "file listing hosts and their metadata")
+	flags.BoolVar(&args.pw, "pw", false, "prompt for a password, to check which password sudo requires")
+	_ = flags.Parse(arguments[1:])
+
+	if args.hostList == "" && args.inventory == "" {
+		return errors.Errorf("--hosts or --inventory is required")
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		var err error
+		if inv, err = loadInventory(args.inventory); err != nil {
+			return err
+		}
+	}
+
+	hosts, err := targets(args, inv)
+	if err != nil {
+		return err
+	}
+
+	var pswd string
+	if args.pw {
+		if pswd, err = easyPrompt(args.user); err != nil {
+			return err
+		}
+	}
+
+	r := newRunner(args, pswd, inv, &console{})
+	for _, host := range hosts {
+		printProbe(r.probeSudo(host))
+	}
+	return nil
+}
+
+func (r *runner) probeSudo(host string) sudoProbe {
+	probe := sudoProbe{host: host, sudo: "unknown", password: "unknown"}
+
+	client, err := r.dial(host)
+	if err != nil {
+		probe.err = errors.Wrap(err, "failed to dial host")
+		return probe
+	}
+	defer func() { _ = client.Close() }()
+
+	output, err := remote(client, sudoCheck, "")
+	if err != nil {
+		probe.err = err
+		return probe
+	}
+
+	facts := fields(output)
+	switch {
+	case facts["sudo"] == "missing":
+		probe.sudo, probe.password = "no", "none"
+		return probe
+	case facts["nopasswd"] == "yes":
+		probe.sudo, probe.nopasswd, probe.password = "yes", true, "none"
+		return probe
+	}
+
+	for _, group := range strings.Fields(facts["groups"]) {
+		for _, sudoGroup := range sudoGroups {
+			if group == sudoGroup {
+				probe.sudo = "likely"
+			}
+		}
+	}
+
+	password := r.password(host)
+	if password == "" {
+		return probe
+	}
+
+	// with a password, sudo can list what the user may do, including
+	// the defaults which determine whose password sudo asks for
+	listing, err := remote(client, "sudo -S -p '' -l", password+"\n")
+	if err != nil {
+		probe.sudo = "no"
+		return probe
+	}
+
+	probe.sudo, probe.password = "yes", "user"
+	for _, which := range []string{"rootpw", "targetpw", "runaspw"} {
+		if strings.Contains(listing, which) && !strings.Contains(listing, "!"+which) {
+			probe.password = strings.TrimSuffix(which, "pw")
+		}
+	}
+	return probe
+}
+
+// remote runs command on client without a PTY, returning its output.
+func remote(client *ssh.Client, command, stdin string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create session")
+	}
+	defer func() { _ = session.Close() }()
+
+	session.Stdin = strings.NewReader(stdin)
+	bs, err := session.CombinedOutput(command)
+	return strings.TrimSpace(string(bs)), err
+}
+
+func printProbe(probe sudoProbe) {
+	if probe.err != nil {
+		colors.failure.println("%s: %v", probe.host, probe.err)
+		return
+	}
+
+	line := fmt.Sprintf("%s: sudo=%s nopasswd=%t password=%s", probe.host, probe.sudo, probe.nopasswd, probe.password)
+	switch probe.sudo {
+	case "yes":
+		colors.success.println("%s", line)
+	case "no":
+		colors.failure.println("%s", line)
+	default:
+		colors.notice.println("%s", line)
+	}
+}
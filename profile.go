@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// A profile adapts how commands are executed to what a host's ssh server
+// supports. Network gear and appliances often only allow a single exec
+// channel per connection, reject PTY requests, and run the command line
+// with their own CLI rather than a shell.
+type profile struct {
+	noShellWrapper bool // run the command as is, without environment
+	noPTY          bool // never request a PTY
+	singleSession  bool // open a new connection for every command
+}
+
+// profiles which may be selected per host with the "profile" inventory label
+var profiles = map[string]profile{
+	"default": {},
+	"network": {noShellWrapper: true, noPTY: true, singleSession: true},
+}
+
+func parseProfile(s string) (profile, error) {
+	p, exists := profiles[s]
+	if !exists {
+		return profile{}, errors.Errorf("unknown profile %q, must be one of default, network", s)
+	}
+	return p, nil
+}
+
+// profile returns the profile to use on host, which may be set per host
+// with the "profile" label in the inventory, overriding --no-shell-wrapper,
+// --no-pty and --single-session.
+func (r *runner) profile(host string) (profile, error) {
+	if s, exists := r.inventory.metadata(host)["profile"]; exists {
+		return parseProfile(s)
+	}
+	return r.defaultProfile, nil
+}
+
+// probeClient returns client to run a probe of host on, or a connection
+// of its own if the host allows only one exec channel per connection,
+// which is left for the command the probe is for, and a func closing it.
+func (r *runner) probeClient(client *ssh.Client, host string) (*ssh.Client, func(), error) {
+	p, err := r.profile(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.singleSession {
+		return client, func() {}, nil
+	}
+	own, err := r.dial(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	return own, func() { _ = own.Close() }, nil
+}
+
+// probeRemote runs command on client to learn about host, as remote does,
+// but on a connection of its own as probeClient.
+func (r *runner) probeRemote(client *ssh.Client, host, command string) (string, error) {
+	return r.probeRemoteInput(client, host, command, "")
+}
+
+// probeRemoteInput is probeRemote, with stdin.
+func (r *runner) probeRemoteInput(client *ssh.Client, host, command, stdin string) (string, error) {
+	client, done, err := r.probeClient(client, host)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	return remote(client, command, stdin)
+}
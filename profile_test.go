@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runner_profile(t *testing.T) {
+	inv, err := parseInventory("switch1 profile=network\nweb1\nfw1 profile=bogus\n")
+	require.NoError(t, err)
+
+	r := &runner{inventory: inv, defaultProfile: profile{noPTY: true}}
+
+	p, err := r.profile("switch1")
+	require.NoError(t, err)
+	require.Equal(t, profile{noShellWrapper: true, noPTY: true, singleSession: true}, p)
+
+	p, err = r.profile("web1")
+	require.NoError(t, err)
+	require.Equal(t, profile{noPTY: true}, p)
+
+	_, err = r.profile("fw1")
+	require.Error(t, err)
+}
+
+func Test_runner_probeRemote_singleSession(t *testing.T) {
+	inv, err := parseInventory("switch1 profile=network\n")
+	require.NoError(t, err)
+
+	// probes of hosts allowing a single exec channel dial a connection of
+	// their own, rather than using the one of the command (nil here)
+	refused := func(string, string) (net.Conn, error) { return nil, errors.New("connection refused") }
+	r := &runner{inventory: inv, out: &quiet{}, dialer: refused, passwords: make(map[string]string),
+		sudo: make(map[string]map[string]string), hostFacts: make(map[string]map[string]string)}
+
+	_, err = r.sudoFacts(nil, "switch1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connection refused")
+
+	_, err = r.facts(nil, "switch1")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connection refused")
+
+	_, _, err = r.guarded(nil, "switch1", script{guards: []guard{{directive: "creates", value: "/etc/x"}}})
+	require.Contains(t, err.Error(), "connection refused")
+	_, err = r.lowSpace(nil, "switch1", []spaceCheck{{path: "/", min: 1 << 20}})
+	require.Contains(t, err.Error(), "connection refused")
+	_, err = r.fetchTracked(nil, "switch1", "/etc/x")
+	require.Contains(t, err.Error(), "connection refused")
+	_, _, err = r.uploadScript(nil, "switch1", script{upload: "true\n", runWith: "sh"})
+	require.Contains(t, err.Error(), "connection refused")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// barWidth is the number of characters in the progress bar.
+const barWidth = 30
+
+// progress wraps another renderer with a live progress bar drawn on w
+// (typically stderr), showing how many hosts have completed, failed, and
+// are in flight, with an estimate of the time remaining. The output of
+// each host is held back and rendered by the wrapped renderer only once
+// the host completes, so parallel hosts do not interleave.
+type progress struct {
+	lock  sync.Mutex
+	inner renderer
+	w     io.Writer
+	now   func() time.Time
+
+	start    time.Time
+	total    int
+	files    int
+	inFlight map[string]bool
+	ended    map[string]int
+	failed   map[string]bool
+	done     int
+	failures int
+	pending  map[string][]func()
+	drawn    bool
+}
+
+func newProgress(inner renderer, w io.Writer) *progress {
+	return &progress{
+		inner:    inner,
+		w:        w,
+		now:      time.Now,
+		inFlight: make(map[string]bool),
+		ended:    make(map[string]int),
+		failed:   make(map[string]bool),
+		pending:  make(map[string][]func()),
+	}
+}
+
+func (p *progress) plan(kind string, items []string, hosts []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.start = p.now()
+	p.total = len(hosts)
+	p.files = len(items)
+	if kind == "command" {
+		p.files = 1
+	}
+	p.passthrough(func() { p.inner.plan(kind, items, hosts) })
+}
+
+func (p *progress) message(format string, args ...interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.passthrough(func() { p.inner.message(format, args...) })
+}
+
+func (p *progress) warning(format string, args ...interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.passthrough(func() { p.inner.warning(format, args...) })
+}
+
+func (p *progress) begin(host, file string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inFlight[host] = true
+	p.hold(host, func() { p.inner.begin(host, file) })
+}
+
+func (p *progress) command(host, command string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.hold(host, func() { p.inner.command(host, command) })
+}
+
+func (p *progress) output(host, text string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.hold(host, func() { p.inner.output(host, text) })
+}
+
+func (p *progress) result(res result) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !res.ok() {
+		p.failed[res.Host] = true
+	}
+	p.hold(res.Host, func() { p.inner.result(res) })
+
+	if res.File == "" && res.Command == "" {
+		// the host could not be dialed, so nothing else will run on it
+		p.complete(res.Host)
+	}
+}
+
+func (p *progress) end(host, file string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.hold(host, func() { p.inner.end(host, file) })
+
+	p.ended[host]++
+	if p.ended[host] >= p.files {
+		p.complete(host)
+	}
+}
+
+func (p *progress) summary(rpt report) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// render hosts which never completed, e.g. because the run was cancelled
+	for host := range p.pending {
+		p.complete(host)
+	}
+	p.clear()
+	p.inner.summary(rpt)
+}
+
+// hold back an event of host until it completes; p must be locked.
+func (p *progress) hold(host string, event func()) {
+	p.pending[host] = append(p.pending[host], event)
+	p.draw()
+}
+
+// complete renders the held back events of host; p must be locked.
+func (p *progress) complete(host string) {
+	events := p.pending[host]
+	delete(p.pending, host)
+	delete(p.inFlight, host)
+
+	p.done++
+	if p.failed[host] {
+		p.failures++
+	}
+
+	p.passthrough(func() {
+		for _, event := range events {
+			event()
+		}
+	})
+}
+
+// passthrough renders with the wrapped renderer, clearing the progress
+// bar out of its way and drawing it again afterwards; p must be locked.
+func (p *progress) passthrough(render func()) {
+	p.clear()
+	render()
+	p.draw()
+}
+
+func (p *progress) clear() {
+	if p.drawn {
+		_, _ = fmt.Fprint(p.w, "\r\033[2K")
+		p.drawn = false
+	}
+}
+
+func (p *progress) draw() {
+	if p.total == 0 {
+		return
+	}
+	_, _ = fmt.Fprint(p.w, "\r\033[2K"+p.status())
+	p.drawn = true
+}
+
+// status returns the line of the progress bar, e.g.
+// "[=====>      ] 12/40 hosts, 1 failed, 4 in flight, ETA 1m30s".
+func (p *progress) status() string {
+	done := p.done
+	if done > p.total {
+		done = p.total
+	}
+
+	filled := barWidth * done / p.total
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+
+	eta := "?"
+	if done > 0 {
+		elapsed := p.now().Sub(p.start)
+		remaining := elapsed / time.Duration(done) * time.Duration(p.total-done)
+		eta = remaining.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("[%s] %d/%d hosts, %d failed, %d in flight, ETA %s",
+		bar, done, p.total, p.failures, len(p.inFlight), eta)
+}
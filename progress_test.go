@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_progress_status(t *testing.T) {
+	now := time.Date(2019, 10, 14, 10, 0, 0, 0, time.UTC)
+	p := newProgress(&quiet{}, &bytes.Buffer{})
+	p.now = func() time.Time { return now }
+
+	p.plan("scripts", []string{"a.sh", "b.sh"}, []string{"h1", "h2", "h3", "h4"})
+	require.Equal(t, "[>                             ] 0/4 hosts, 0 failed, 0 in flight, ETA ?", p.status())
+
+	p.begin("h1", "a.sh")
+	p.end("h1", "a.sh")
+	p.begin("h2", "a.sh")
+	require.Equal(t, 2, len(p.inFlight))
+
+	now = now.Add(30 * time.Second)
+	p.begin("h1", "b.sh")
+	p.result(result{Host: "h1", File: "b.sh", Command: "false", ExitCode: 1, Error: "Process exited with status 1"})
+	p.end("h1", "b.sh")
+	p.result(result{Host: "h3", ExitCode: -1, Error: "connection refused"})
+
+	require.Equal(t, "[===============>              ] 2/4 hosts, 2 failed, 1 in flight, ETA 30s", p.status())
+}
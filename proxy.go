@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+func init() {
+	// x/net/proxy has socks5 and socks5h, but no HTTP CONNECT proxies
+	proxy.RegisterDialerType("http", func(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+		return httpProxy{url: u, forward: forward}, nil
+	})
+}
+
+// A dialer makes the TCP connections ssh runs over.
+type dialer func(network, address string) (net.Conn, error)
+
+// proxyURL returns the proxy of --proxy, or else of $ALL_PROXY.
+func proxyURL(flag string) string {
+	for _, value := range []string{flag, os.Getenv("ALL_PROXY"), os.Getenv("all_proxy")} {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// noProxy returns the hosts of $NO_PROXY, which are dialed directly.
+func noProxy() string {
+	for _, value := range []string{os.Getenv("NO_PROXY"), os.Getenv("no_proxy")} {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// newDialer returns a dialer connecting through the proxy at rawURL,
+// which is a socks5:// (or socks5h://) or http:// proxy, except to the
+// hosts of $NO_PROXY, or a direct dialer if rawURL is empty.
+func newDialer(rawURL string) (dialer, error) {
+	if rawURL == "" {
+		return net.Dial, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed proxy url")
+	}
+
+	d, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, errors.Errorf("unsupported proxy scheme %q, must be one of socks5, socks5h, http", u.Scheme)
+	}
+	if hosts := noProxy(); hosts != "" {
+		perHost := proxy.NewPerHost(d, proxy.Direct)
+		perHost.AddFromString(hosts)
+		d = perHost
+	}
+	return d.Dial, nil
+}
+
+// An httpProxy connects through an HTTP proxy with CONNECT, optionally
+// authenticating with the user and password of its url.
+type httpProxy struct {
+	url     *url.URL
+	forward proxy.Dialer
+}
+
+func (p httpProxy) Dial(network, address string) (net.Conn, error) {
+	conn, err := p.forward.Dial("tcp", p.url.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial proxy")
+	}
+
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if p.url.User != nil {
+		password, _ := p.url.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.url.User.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to write to proxy")
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to read from proxy")
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, errors.Errorf("proxy %s failed to connect to %s: %s", p.url.Host, address, response.Status)
+	}
+
+	// the ssh server may already have sent its banner, read along with the response
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
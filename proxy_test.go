@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serve accepts one connection on a local listener, handing it to handle.
+func serve(t *testing.T, handle func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		defer func() { _ = listener.Close() }()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		handle(conn)
+	}()
+	return listener.Addr().String()
+}
+
+func Test_dialSOCKS5(t *testing.T) {
+	requests := make(chan []byte, 1)
+	addr := serve(t, func(conn net.Conn) {
+		greeting := make([]byte, 2)
+		_, _ = io.ReadFull(conn, greeting)
+		_, _ = io.ReadFull(conn, make([]byte, greeting[1])) // the methods offered
+		_, _ = conn.Write([]byte{5, 2})
+
+		auth := make([]byte, 2+3+1+6)
+		_, _ = io.ReadFull(conn, auth)
+		_, _ = conn.Write([]byte{1, 0})
+
+		request := make([]byte, 5+len("web1")+2)
+		_, _ = io.ReadFull(conn, request)
+		requests <- request
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 22})
+		_, _ = conn.Write([]byte("SSH-2.0-test\r\n"))
+	})
+
+	dial, err := newDialer("socks5://bob:secret@" + addr)
+	require.NoError(t, err)
+	conn, err := dial("tcp", "web1:22")
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	require.Equal(t, []byte{5, 1, 0, 3, 4, 'w', 'e', 'b', '1', 0, 22}, <-requests)
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "SSH-2.0-test\r\n", banner)
+}
+
+func Test_dialHTTP(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		request, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || request.Method != http.MethodConnect || request.Host != "web1:22" {
+			_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			return
+		}
+		// the banner is sent along with the response
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nSSH-2.0-test\r\n"))
+	})
+
+	dial, err := newDialer("http://" + addr)
+	require.NoError(t, err)
+	conn, err := dial("tcp", "web1:22")
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "SSH-2.0-test\r\n", banner)
+}
+
+func Test_newDialer_noProxy(t *testing.T) {
+	addr := serve(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("SSH-2.0-test\r\n"))
+	})
+	host, _, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Setenv("NO_PROXY", "web1.example.com,"+host))
+	defer func() { _ = os.Unsetenv("NO_PROXY") }()
+
+	// hosts of $NO_PROXY are dialed directly, rather than through the
+	// proxy, which is not listening
+	dial, err := newDialer("socks5://127.0.0.1:1")
+	require.NoError(t, err)
+	conn, err := dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "SSH-2.0-test\r\n", banner)
+}
+
+func Test_newDialer_invalid(t *testing.T) {
+	_, err := newDialer("ftp://proxy:21")
+	require.Error(t, err)
+}
+
+func Test_newRunner_proxy(t *testing.T) {
+	// runners of every subcommand dial through the proxy, which is not listening
+	r := newRunner(args{proxy: "socks5://127.0.0.1:1"}, "", inventory{}, &quiet{})
+	_, err := r.dialer("tcp", "web1.example.com:22")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "127.0.0.1:1")
+
+	r = newRunner(args{proxy: "ftp://proxy:21"}, "", inventory{}, &quiet{})
+	_, err = r.dialer("tcp", "web1.example.com:22")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported proxy scheme")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_kafkaSink(t *testing.T) {
+	var batches [][]kafkaRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/topics/results", r.URL.Path)
+		require.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		var body struct {
+			Records []kafkaRecord `json:"records"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		batches = append(batches, body.Records)
+		if body.Records[0].Key == "db1" {
+			http.Error(w, `{"error_code": 40403}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sink, err := openKafkaSink(server.URL + "/topics/results")
+	require.NoError(t, err)
+	for i := 0; i < kafkaBatch+1; i++ {
+		require.NoError(t, sink.write(result{Host: fmt.Sprintf("web%d", i)}))
+	}
+	require.NoError(t, sink.flush())
+	require.Len(t, batches, 2)
+	require.Len(t, batches[0], kafkaBatch)
+	require.Equal(t, kafkaRecord{Key: "web100", Value: result{Host: "web100"}}, batches[1][0])
+
+	require.NoError(t, sink.write(result{Host: "db1"}))
+	require.EqualError(t, sink.flush(), `failed to publish to kafka: 404 Not Found: {"error_code": 40403}`)
+
+	_, err = openKafkaSink("kafka-rest:8082")
+	require.Error(t, err)
+}
+
+// fakeNATS accepts one connection, recording the payloads published to
+// it, and rejecting those published to subject "denied".
+func fakeNATS(t *testing.T, published chan<- string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		defer close(published)
+
+		r := bufio.NewReader(conn)
+		_, _ = fmt.Fprintf(conn, "INFO {\"server_id\":\"test\"}\r\nPING\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				_, _ = fmt.Fprintf(conn, "PONG\r\n")
+			case "PUB":
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				if fields[1] == "denied" {
+					_, _ = fmt.Fprintf(conn, "-ERR 'Permissions Violation for Publish to \"denied\"'\r\n")
+					continue
+				}
+				published <- string(payload[:n])
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func Test_natsSink(t *testing.T) {
+	published := make(chan string, 10)
+	sink, err := openNATSSink("nats://" + fakeNATS(t, published) + "/fleet.results")
+	require.NoError(t, err)
+	require.NoError(t, sink.write(result{Host: "web1", Output: "ok"}))
+	require.NoError(t, sink.write(result{Host: "web2"}))
+	require.NoError(t, sink.flush())
+
+	var payloads []string
+	for payload := range published {
+		payloads = append(payloads, payload)
+	}
+	require.Len(t, payloads, 2)
+	require.True(t, strings.HasPrefix(payloads[0], `{"host":"web1","output":"ok"`), payloads[0])
+
+	published = make(chan string, 10)
+	sink, err = openNATSSink("nats://" + fakeNATS(t, published) + "/denied")
+	require.NoError(t, err)
+	require.NoError(t, sink.write(result{Host: "web1"}))
+	require.EqualError(t, sink.flush(), `nats: 'Permissions Violation for Publish to "denied"'`)
+
+	_, err = openNATSSink("nats://localhost:4222/fleet.*")
+	require.Error(t, err)
+}
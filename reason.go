@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Reasons are the machine-readable categories of failures, included as
+// "reason" in JSON output, and mapped to exit codes by exitStatus.
+const (
+	reasonAuth     = "auth"            // failed to authenticate to a host
+	reasonDial     = "dial"            // failed to connect to a host
+	reasonExec     = "exec"            // a command exited non-zero
+	reasonTimeout  = "timeout"         // a command ran longer than its timeout
+	reasonLost     = "connection-lost" // the connection was lost while a command ran
+	reasonParse    = "parse"           // a script or inventory is malformed
+	reasonAssert   = "assert"          // an assertion or expectation did not hold
+	reasonCancel   = "cancelled"       // the run was cancelled
+	reasonInternal = "error"           // any other error
+)
+
+// reasonExitCodes are the exit codes of commando for failures of each
+// reason, distinct so that each may be told apart; 2 is that of invalid
+// flags, and 130 that of interrupted runs.
+var reasonExitCodes = map[string]int{
+	reasonInternal: 1,
+	reasonAuth:     3,
+	reasonDial:     4,
+	reasonExec:     5,
+	reasonTimeout:  6,
+	reasonParse:    7,
+	reasonLost:     8,
+	reasonAssert:   9,
+	reasonCancel:   130,
+}
+
+// authError is returned by hosts which could not be authenticated to.
+type authError struct {
+	err error
+}
+
+func (e authError) Error() string { return e.err.Error() }
+
+// dialError is returned by hosts which could not be connected to.
+type dialError struct {
+	err error
+}
+
+func (e dialError) Error() string { return e.err.Error() }
+
+// execError is returned by a command which exited with a non-zero code.
+type execError struct {
+	exitCode int
+	err      *ssh.ExitError
+}
+
+func (e execError) Error() string { return e.err.Error() }
+
+// parseError is returned by a malformed script or inventory.
+type parseError struct {
+	err error
+}
+
+func (e parseError) Error() string { return e.err.Error() }
+
+// dialFailed classifies err, of connecting to a host, as an authError or
+// a dialError.
+func dialFailed(err error) error {
+	switch errors.Cause(err).(type) {
+	case nil, authError, dialError:
+		return err
+	}
+	// x/crypto has no type for failing to authenticate as a client (only
+	// ServerAuthError, of servers), and flattens the error of the handshake
+	// into a string, so it is matched by its message
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return authError{err: err}
+	}
+	return dialError{err: err}
+}
+
+// failedToDial returns whether err is of failing to connect or
+// authenticate to a host.
+func failedToDial(err error) bool {
+	switch errors.Cause(err).(type) {
+	case authError, dialError:
+		return true
+	}
+	return false
+}
+
+// reason returns the reason of the failure err, or "" for no failure.
+func reason(err error) string {
+	switch errors.Cause(err).(type) {
+	case nil:
+		return ""
+	case authError:
+		return reasonAuth
+	case dialError:
+		return reasonDial
+	case execError, *ssh.ExitError:
+		return reasonExec
+	case timeoutError:
+		return reasonTimeout
+	case connectionLostError:
+		return reasonLost
+	case parseError:
+		return reasonParse
+	case failures:
+		return reasonAssert
+	}
+	if errors.Cause(err) == errCancelled {
+		return reasonCancel
+	}
+	return reasonInternal
+}
+
+// exitStatus returns the exit code of commando for the failure err.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	return reasonExitCodes[reason(err)]
+}
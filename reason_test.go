@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_reason(t *testing.T) {
+	cases := []struct {
+		err    error
+		reason string
+	}{
+		{nil, ""},
+		{errors.Wrap(authError{err: errors.New("ssh: unable to authenticate")}, "failed to dial host"), reasonAuth},
+		{dialError{err: errors.New("connection refused")}, reasonDial},
+		{errors.Wrap(execError{exitCode: 3, err: &ssh.ExitError{}}, "failed to run 1.sh on web1"), reasonExec},
+		{timeoutError{}, reasonTimeout},
+		{connectionLostError{}, reasonLost},
+		{parseError{err: errors.New("x")}, reasonParse},
+		{failures{{host: "web1"}}, reasonAssert},
+		{errCancelled, reasonCancel},
+		{errors.New("something else"), reasonInternal},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.reason, reason(c.err), "%v", c.err)
+	}
+}
+
+func Test_dialFailed(t *testing.T) {
+	err := dialFailed(errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain"))
+	require.IsType(t, authError{}, err)
+	require.Equal(t, "ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain", err.Error())
+
+	require.IsType(t, dialError{}, dialFailed(errors.New("dial tcp 10.0.0.1:22: connect: connection refused")))
+	require.IsType(t, authError{}, dialFailed(authError{err: errors.New("vault sealed")}))
+	require.NoError(t, dialFailed(nil))
+}
+
+func Test_exitStatus(t *testing.T) {
+	require.Equal(t, 0, exitStatus(nil))
+	require.Equal(t, 1, exitStatus(errors.New("x")))
+	require.Equal(t, 9, exitStatus(failures{{host: "web1"}}))
+	require.Equal(t, 3, exitStatus(authError{err: errors.New("x")}))
+	require.Equal(t, 4, exitStatus(errors.Wrap(dialError{err: errors.New("x")}, "failed to dial host")))
+	require.Equal(t, 5, exitStatus(execError{exitCode: 2, err: &ssh.ExitError{}}))
+	require.Equal(t, 6, exitStatus(timeoutError{}))
+	require.Equal(t, 7, exitStatus(parseError{err: errors.New("x")}))
+	require.Equal(t, 8, exitStatus(connectionLostError{}))
+	require.Equal(t, 130, exitStatus(errCancelled))
+
+	// each reason has an exit code of its own
+	reasons := make(map[int]string)
+	for reason, code := range reasonExitCodes {
+		require.NotContains(t, reasons, code, "%s and %s", reason, reasons[code])
+		reasons[code] = reason
+	}
+}
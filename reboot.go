@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// rebootCheck reports whether a host requires a reboot, as flagged by
+// Debian and Ubuntu in /var/run/reboot-required (along with the packages
+// which require it), or by needs-restarting on RHEL, CentOS and Fedora.
+const rebootCheck = `if [ -f /var/run/reboot-required ]; then
+  echo required=yes
+  [ -r /var/run/reboot-required.pkgs ] && echo packages=$(sort -u /var/run/reboot-required.pkgs | tr '\n' ' ')
+elif command -v needs-restarting >/dev/null 2>&1; then
+  needs-restarting -r >/dev/null 2>&1
+  [ $? -eq 1 ] && echo required=yes
+fi
+true`
+
+// rebootCommand reboots a host, with "# sudo: true" semantics.
+const rebootCommand = "sudo -S shutdown -r now"
+
+// A reboot is a host which requires a reboot, e.g. for a new kernel.
+type reboot struct {
+	Host     string   `json:"host"`
+	Packages []string `json:"packages,omitempty"`
+}
+
+// checkReboot checks whether host requires a reboot, once its scripts have
+// run, if --reboot-report is set.
+func (r *runner) checkReboot(client *ssh.Client, host string) {
+	if !r.rebootReport {
+		return
+	}
+
+	output, err := r.probeRemote(client, host, rebootCheck)
+	if err != nil {
+		r.out.warning("failed to check whether %s requires a reboot: %v", host, err)
+		return
+	}
+	checked := fields(output)
+	if checked["required"] != "yes" {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.reboots == nil {
+		r.reboots = make(map[string][]string)
+	}
+	r.reboots[host] = strings.Fields(checked["packages"])
+}
+
+// rebootsRequired returns the hosts found to require a reboot, by name.
+func (r *runner) rebootsRequired() []reboot {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	reboots := make([]reboot, 0, len(r.reboots))
+	for host, packages := range r.reboots {
+		reboots = append(reboots, reboot{Host: host, Packages: packages})
+	}
+	sort.Slice(reboots, func(i, j int) bool {
+		return reboots[i].Host < reboots[j].Host
+	})
+	return reboots
+}
+
+// rebootsTable lists the hosts of reboots, and the packages requiring them.
+func rebootsTable(reboots []reboot) *table {
+	t := &table{indent: "  "}
+	for _, rb := range reboots {
+		t.add(colors.notice, rb.Host, strings.Join(rb.Packages, " "))
+	}
+	return t
+}
+
+// rebootAfter reboots the hosts found to require it, if --reboot-now is
+// set and the run succeeded, once the operator confirms.
+func (r *runner) rebootAfter(err error) error {
+	reboots := r.rebootsRequired()
+	if err != nil || !r.rebootNow || len(reboots) == 0 {
+		return err
+	}
+
+	colors.notice.println("reboot required")
+	rebootsTable(reboots).print()
+	proceed, err := confirm(fmt.Sprintf("reboot these %d hosts now?", len(reboots)))
+	if err != nil || !proceed {
+		return err
+	}
+
+	hosts := make([]string, 0, len(reboots))
+	for _, rb := range reboots {
+		hosts = append(hosts, rb.Host)
+	}
+	return r.each(hosts, r.reboot)
+}
+
+// reboot host, recording the outcome as a result of the "reboot" script.
+func (r *runner) reboot(client *ssh.Client, host string) error {
+	res := result{Host: host, File: "reboot", Command: rebootCommand}
+	sc, err := r.adapt(client, host, script{command: rebootCommand, stdin: []string{"PASSWORD"}, sudo: true})
+	if err == nil {
+		stdin := combine(substitute(sc.stdin, map[string]string{
+			"PASSWORD": r.password(host),
+		}))
+		res.Command = sc.command
+		res.Output, err = r.probeRemoteInput(client, host, sc.command, stdin)
+	}
+
+	if _, exited := err.(*ssh.ExitMissingError); exited || err == io.EOF {
+		err = nil // the connection may go down with the host before the command exits
+	}
+	if err != nil {
+		res.ExitCode, res.Error = -1, err.Error()
+		r.record(res)
+		return errors.Wrapf(err, "failed to reboot %s", host)
+	}
+	r.out.message("rebooting %s", host)
+	r.record(res)
+	return nil
+}
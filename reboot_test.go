@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rebootsRequired(t *testing.T) {
+	r := &runner{reboots: map[string][]string{
+		"web2": nil,
+		"web1": {"linux-image-5.15.0-91-generic", "libc6"},
+	}}
+	reboots := r.rebootsRequired()
+	require.Equal(t, []reboot{
+		{Host: "web1", Packages: []string{"linux-image-5.15.0-91-generic", "libc6"}},
+		{Host: "web2"},
+	}, reboots)
+
+	require.Equal(t, [][]string{
+		{"  web1  linux-image-5.15.0-91-generic libc6"},
+		{"  web2  "},
+	}, rebootsTable(reboots).render(0))
+
+	// failed runs do not reboot
+	r.rebootNow = true
+	require.Equal(t, errCancelled, r.rebootAfter(errCancelled))
+	require.NoError(t, (&runner{rebootNow: true}).rebootAfter(nil))
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+)
+
+// A renderer presents the progress and results of a run. Implementations
+// are selected with --output, and must be safe for concurrent use.
+type renderer interface {
+	// plan is rendered before the run starts, listing what will be
+	// executed (scripts or a command) on which hosts.
+	plan(kind string, items []string, hosts []string)
+
+	// message is rendered for notable events of the run itself.
+	message(format string, args ...interface{})
+
+	// warning is rendered for problems with the run itself.
+	warning(format string, args ...interface{})
+
+	// begin is rendered when a script file (or command) starts on host.
+	begin(host, file string)
+
+	// command is rendered when a command starts executing on host.
+	command(host, command string)
+
+	// output is rendered with the output of a command as it becomes available.
+	output(host, text string)
+
+	// result is rendered when a command completes.
+	result(res result)
+
+	// end is rendered when a script file (or command) completes on host.
+	end(host, file string)
+
+	// summary is rendered once the run is complete.
+	summary(rpt report)
+}
+
+var renderers = map[string]func(args) renderer{
+	"console":  newConsole,
+	"quiet":    func(args) renderer { return &quiet{} },
+	"json":     func(args) renderer { return &jsonLines{encoder: json.NewEncoder(os.Stdout)} },
+	"gha":      func(args) renderer { return &gha{} },
+	"tui":      func(args) renderer { return newTUI(os.Stdout) },
+	"junit":    func(args) renderer { return &document{w: os.Stdout, write: writeJUnit} },
+	"markdown": func(args) renderer { return &document{w: os.Stdout, write: writeMarkdown} },
+	"summary":  func(args) renderer { return &summarized{} },
+}
+
+// newRenderer returns the renderer selected by --output.
+func newRenderer(args args) (renderer, error) {
+	f, exists := renderers[args.output]
+	if !exists {
+		names := make([]string, 0, len(renderers))
+		for n := range renderers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("unknown output %q, must be one of %s", args.output, strings.Join(names, ", "))
+	}
+	return f(args), nil
+}
+
+// failedTable returns a table of the failures of the results, with
+// columns host, file, and the check which failed (with the value got).
+func failedTable(results []result) *table {
+	t := &table{indent: "  "}
+	for _, res := range results {
+		for _, f := range res.Failed {
+			t.add(colors.failure, res.Host, res.File, f)
+		}
+	}
+	return t
+}
+
+// skippedTable returns a table of the scripts which were skipped, with
+// columns host, file, and the reason.
+func skippedTable(results []result) *table {
+	t := &table{indent: "  "}
+	for _, res := range results {
+		if res.Skipped != "" {
+			t.add(colors.notice, res.Host, res.File, res.Skipped)
+		}
+	}
+	return t
+}
+
+// console renders colorized, human readable output to the terminal.
+// When hosts run in parallel, each line is prefixed with its host.
+type console struct {
+	lock  sync.Mutex
+	lines *prefixer
+}
+
+func newConsole(args args) renderer {
+	c := &console{}
+	if args.parallel > 1 || args.hostColors {
+		c.lines = &prefixer{w: color.Output, colors: args.hostColors}
+	}
+	return c
+}
+
+// println renders a line of host in style s, prefixed if need be.
+func (c *console) println(host string, s style, format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	if c.lines != nil {
+		c.lines.println(host, s.color(), text)
+		return
+	}
+	_, _ = s.color().Println(text)
+}
+
+func (c *console) plan(kind string, items []string, hosts []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	colors.info.println("will execute %s", kind)
+	if kind == "command" {
+		colors.notice.println("%s", strings.Join(items, " "))
+	} else {
+		colors.notice.println("%v", items)
+	}
+	colors.info.println("on hosts")
+	colors.notice.println("%v", hosts)
+}
+
+func (c *console) message(format string, args ...interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	colors.info.println(format, args...)
+}
+
+func (c *console) warning(format string, args ...interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	colors.failure.println(format, args...)
+}
+
+func (c *console) begin(host, _ string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.lines == nil {
+		colors.info.println("--- %s ---", host)
+	}
+}
+
+func (c *console) command(host, command string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.println(host, colors.notice, "executing command `%s`", command)
+}
+
+func (c *console) output(host, text string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.println(host, colors.output, "%s", text)
+}
+
+func (c *console) result(res result) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if res.Output == "" && res.Command != "" && res.Skipped == "" {
+		c.println(res.Host, colors.info, "<no output>")
+	}
+	if res.Usage != nil {
+		c.println(res.Host, colors.info, "%s", res.Usage)
+	}
+	for _, note := range res.Notes {
+		c.println(res.Host, colors.info, "note: %s", note)
+	}
+	for _, f := range res.Failed {
+		c.println(res.Host, colors.failure, "assertion failed: %s", f)
+	}
+}
+
+func (c *console) end(_, _ string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.lines == nil {
+		fmt.Println("")
+	}
+}
+
+func (c *console) summary(rpt report) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if rpt.Probe != nil {
+		printProbePhase(rpt.Probe)
+	}
+
+	if t := notesTable(rpt.Results); len(t.rows) > 0 {
+		colors.info.println("notes")
+		t.print()
+	}
+
+	if t := skippedTable(rpt.Results); len(t.rows) > 0 {
+		colors.notice.println("skipped")
+		t.print()
+	}
+
+	if t := failedTable(rpt.Results); len(t.rows) > 0 {
+		colors.failure.println("assertions failed")
+		t.print()
+	}
+
+	if len(rpt.Variants) > 0 {
+		printVariants(rpt.Variants)
+	}
+
+	if len(rpt.Reboots) > 0 {
+		colors.notice.println("reboot required")
+		rebootsTable(rpt.Reboots).print()
+	}
+
+	if rpt.GroupBy != "" {
+		printGroups(rpt.GroupBy, rpt.Groups)
+	}
+}
+
+// quiet renders only failures.
+type quiet struct {
+	lock sync.Mutex
+}
+
+func (q *quiet) plan(string, []string, []string) {}
+func (q *quiet) message(string, ...interface{})  {}
+func (q *quiet) begin(string, string)            {}
+func (q *quiet) command(string, string)          {}
+func (q *quiet) output(string, string)           {}
+func (q *quiet) end(string, string)              {}
+func (q *quiet) summary(report)                  {}
+
+func (q *quiet) warning(format string, args ...interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func (q *quiet) result(res result) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if res.Error != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: %s: %s\n", res.Host, res.File, res.Error)
+	}
+	for _, f := range res.Failed {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: %s: %s\n", res.Host, res.File, f)
+	}
+}
+
+// jsonLines renders each result as a line of JSON on stdout,
+// with anything else written to stderr.
+type jsonLines struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+func (j *jsonLines) plan(string, []string, []string) {}
+func (j *jsonLines) begin(string, string)            {}
+func (j *jsonLines) command(string, string)          {}
+func (j *jsonLines) output(string, string)           {}
+func (j *jsonLines) end(string, string)              {}
+func (j *jsonLines) summary(report)                  {}
+
+func (j *jsonLines) message(format string, args ...interface{}) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+func (j *jsonLines) warning(format string, args ...interface{}) {
+	j.message(format, args...)
+}
+
+func (j *jsonLines) result(res result) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	_ = j.encoder.Encode(res)
+}
+
+// gha renders output for GitHub Actions, grouping the output of each
+// host and annotating failures as errors.
+type gha struct {
+	lock sync.Mutex
+}
+
+func (g *gha) plan(kind string, items []string, hosts []string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Printf("will execute %s %v on hosts %v\n", kind, items, hosts)
+}
+
+func (g *gha) message(format string, args ...interface{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Printf(format+"\n", args...)
+}
+
+func (g *gha) warning(format string, args ...interface{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Printf("::warning::%s\n", ghaEscape(fmt.Sprintf(format, args...)))
+}
+
+func (g *gha) begin(host, file string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Printf("::group::%s %s\n", host, file)
+}
+
+func (g *gha) command(_, command string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Printf("executing command `%s`\n", command)
+}
+
+func (g *gha) output(_, text string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Println(text)
+}
+
+func (g *gha) result(res result) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, note := range res.Notes {
+		fmt.Printf("::notice title=%s::%s\n", ghaEscape(res.Host), ghaEscape(note))
+	}
+	if res.Error != "" {
+		fmt.Printf("::error title=%s::%s\n", ghaEscape(res.Host), ghaEscape(res.Error))
+	}
+	for _, f := range res.Failed {
+		fmt.Printf("::error title=%s::%s\n", ghaEscape(res.Host), ghaEscape(f))
+	}
+}
+
+func (g *gha) end(_, _ string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	fmt.Println("::endgroup::")
+}
+
+func (g *gha) summary(rpt report) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for name, t := range rpt.Groups {
+		fmt.Printf("%s=%s: %d ok, %d failed\n", rpt.GroupBy, name, t.OK, t.Failed)
+	}
+}
+
+func ghaEscape(s string) string {
+	s = strings.Replace(s, "%", "%25", -1)
+	s = strings.Replace(s, "\r", "%0D", -1)
+	return strings.Replace(s, "\n", "%0A", -1)
+}
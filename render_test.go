@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newRenderer(t *testing.T) {
+	for _, name := range []string{"console", "quiet", "json", "gha", "tui", "junit", "markdown"} {
+		_, err := newRenderer(args{output: name})
+		require.NoError(t, err, name)
+	}
+
+	_, err := newRenderer(args{output: "xml"})
+	require.Error(t, err)
+}
+
+func Test_ghaEscape(t *testing.T) {
+	require.Equal(t, "100%25 done%0Anext", ghaEscape("100% done\nnext"))
+}
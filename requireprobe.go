@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// A probePhase is the first phase of a run with --require-probe, which
+// runs its command on every host, so that the scripts run only on the
+// hosts where it matched (exited 0).
+type probePhase struct {
+	Command   string   `json:"command"`
+	Matched   []string `json:"matched"`
+	Unmatched []string `json:"unmatched,omitempty"`
+	Failed    []string `json:"failed,omitempty"` // could not be probed
+}
+
+// requireProbe runs command on every host, at most --parallel (and at
+// least 20) at a time, returning the hosts where it matched, in order.
+// Hosts where it did not match are recorded as skipped, and those which
+// could not be probed as failed. Connections to the hosts matched are
+// kept, for the run to use.
+func (r *runner) requireProbe(command string, hosts []string) []string {
+	if command == "" {
+		return hosts
+	}
+	parallel := r.parallel
+	if parallel < 20 {
+		parallel = 20
+	}
+
+	var (
+		lock      sync.Mutex
+		wg        sync.WaitGroup
+		unmatched = make(map[string]int)
+		failed    = make(map[string]error)
+	)
+	slots := make(chan struct{}, parallel)
+	for _, host := range hosts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(host string) {
+			defer func() { <-slots; wg.Done() }()
+			client, code, err := r.probeHost(host, command)
+
+			lock.Lock()
+			defer lock.Unlock()
+			switch {
+			case err != nil:
+				failed[host] = err
+			case code != 0:
+				unmatched[host] = code
+				_ = client.Close()
+			default:
+				r.lock.Lock()
+				if r.warm == nil {
+					r.warm = make(map[string]*ssh.Client)
+				}
+				r.warm[host] = client
+				r.lock.Unlock()
+			}
+		}(host)
+	}
+	wg.Wait()
+
+	phase := &probePhase{Command: command}
+	matched := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if err, ok := failed[host]; ok {
+			phase.Failed = append(phase.Failed, host)
+			r.record(result{Host: host, Command: command, ExitCode: -1, Error: "failed to probe: " + err.Error()})
+			continue
+		}
+		if code, ok := unmatched[host]; ok {
+			phase.Unmatched = append(phase.Unmatched, host)
+			r.record(result{Host: host, Command: command, ExitCode: code, Skipped: fmt.Sprintf("probe did not match (exit code %d)", code)})
+			continue
+		}
+		phase.Matched = append(phase.Matched, host)
+		matched = append(matched, host)
+	}
+	sort.Strings(phase.Unmatched)
+	sort.Strings(phase.Failed)
+
+	r.lock.Lock()
+	r.probe = phase
+	r.lock.Unlock()
+
+	r.out.message("probe %q matched %d of %d hosts", command, len(matched), len(hosts))
+	if len(phase.Failed) > 0 {
+		r.out.warning("%d hosts could not be probed: %v", len(phase.Failed), phase.Failed)
+	}
+	return matched
+}
+
+// probeHost runs command on host, returning the connection to it and the
+// exit code of command.
+func (r *runner) probeHost(host, command string) (*ssh.Client, int, error) {
+	client, warm := r.takeWarm(host)
+	if !warm {
+		var err error
+		if client, err = r.dial(host); err != nil {
+			return nil, -1, err
+		}
+	}
+
+	_, err := remote(client, command, "")
+	if code := exitCode(err); code > 0 {
+		return client, code, nil
+	} else if err != nil {
+		_ = client.Close()
+		return nil, -1, err
+	}
+	return client, 0, nil
+}
+
+// printProbePhase prints how many hosts the probe of a run matched.
+func printProbePhase(phase *probePhase) {
+	colors.info.println("probe %q", phase.Command)
+	t := &table{indent: "  "}
+	t.add(colors.success, "matched", fmt.Sprintf("%d", len(phase.Matched)))
+	t.add(colors.muted, "unmatched", fmt.Sprintf("%d", len(phase.Unmatched)))
+	if len(phase.Failed) > 0 {
+		t.add(colors.failure, "failed", fmt.Sprintf("%d", len(phase.Failed)))
+	}
+	t.print()
+}
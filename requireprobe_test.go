@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_requireProbe(t *testing.T) {
+	refused := func(string, string) (net.Conn, error) { return nil, errors.New("connection refused") }
+	r := &runner{out: &quiet{}, dialer: refused, passwords: make(map[string]string)}
+
+	hosts := r.requireProbe("", []string{"web1", "web2"})
+	require.Equal(t, []string{"web1", "web2"}, hosts)
+	require.Nil(t, r.probe)
+
+	hosts = r.requireProbe("systemctl is-failed myapp", []string{"web2", "web1"})
+	require.Empty(t, hosts)
+	require.Equal(t, &probePhase{Command: "systemctl is-failed myapp", Failed: []string{"web1", "web2"}}, r.probe)
+	require.Len(t, r.results, 2)
+	require.Equal(t, "web2", r.results[0].Host)
+	require.Contains(t, r.results[0].Error, "failed to probe")
+	require.Contains(t, r.results[0].Error, "connection refused")
+}
+
+func Test_anonymize_probe(t *testing.T) {
+	p := &pseudonyms{Names: make(map[string]string), next: make(map[string]int)}
+	rpt := p.report(report{
+		Results: []result{{Host: "web1"}, {Host: "web2", Skipped: "probe did not match (exit code 1)"}},
+		Probe:   &probePhase{Command: "test -f /etc/web1", Matched: []string{"web1"}, Unmatched: []string{"web2"}},
+	})
+	require.Equal(t, &probePhase{Command: "test -f /etc/host-1", Matched: []string{"host-1"}, Unmatched: []string{"host-2"}}, rpt.Probe)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// resolveConcurrency is how many hosts are resolved at a time.
+const resolveConcurrency = 32
+
+// A resolution is what a host resolved to in DNS, before the run starts.
+type resolution struct {
+	host      string
+	name      string // HostName of ssh_config, else host
+	port      string
+	addresses []string
+	reverse   []string // names of the addresses, with --resolve-report
+	err       error
+	duplicate string // an earlier host resolving to the same address and port
+	skipped   string // why it was not resolved here
+}
+
+// A resolver looks names up in DNS, and addresses up in reverse.
+type resolver struct {
+	lookupHost func(name string) ([]string, error)
+	lookupAddr func(address string) ([]string, error)
+}
+
+var systemResolver = resolver{lookupHost: net.LookupHost, lookupAddr: net.LookupAddr}
+
+// resolveAll resolves hosts as configured in cfg, in reverse too if
+// reverse is set, except those whose names are resolved by a proxy or
+// jump host. Hosts resolving to the address and port of an earlier host
+// are marked duplicates of it.
+func (rs resolver) resolveAll(hosts []string, cfg sshConfig, proxied, reverse bool) []resolution {
+	resolutions := make([]resolution, len(hosts))
+	slots := make(chan struct{}, resolveConcurrency)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, host string) {
+			defer func() { <-slots; wg.Done() }()
+			resolutions[i] = rs.resolve(host, cfg.lookup(host), proxied, reverse)
+		}(i, host)
+	}
+	wg.Wait()
+
+	first := make(map[string]string)
+	for i, res := range resolutions {
+		if len(res.addresses) == 0 {
+			continue
+		}
+		key := net.JoinHostPort(res.addresses[0], res.port)
+		if host, exists := first[key]; exists {
+			resolutions[i].duplicate = host
+			continue
+		}
+		first[key] = res.host
+	}
+	return resolutions
+}
+
+func (rs resolver) resolve(host string, cfg sshHost, proxied, reverse bool) resolution {
+	res := resolution{host: host, name: cfg.hostName, port: cfg.port}
+	switch {
+	case proxied:
+		res.skipped = "resolved by the proxy"
+		return res
+	case cfg.proxyJump != "":
+		hops := jumps(cfg.proxyJump)
+		res.skipped = "resolved by " + hops[len(hops)-1].host
+		return res
+	}
+
+	if ip := net.ParseIP(cfg.hostName); ip != nil {
+		res.addresses = []string{ip.String()}
+	} else if res.addresses, res.err = rs.lookupHost(cfg.hostName); res.err != nil {
+		return res
+	}
+	sort.Strings(res.addresses)
+
+	if reverse {
+		for _, address := range res.addresses {
+			names, _ := rs.lookupAddr(address)
+			for _, name := range names {
+				res.reverse = append(res.reverse, strings.TrimSuffix(name, "."))
+			}
+		}
+	}
+	return res
+}
+
+// status describes res, for warnings and the resolve report.
+func (res resolution) status() string {
+	switch {
+	case res.skipped != "":
+		return res.skipped
+	case res.err != nil:
+		if dnsErr, ok := res.err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return "NXDOMAIN"
+		}
+		return res.err.Error()
+	case res.duplicate != "":
+		return "duplicate of " + res.duplicate
+	}
+	return "ok"
+}
+
+// resolveTable lists each host, the name it resolved, its addresses (and
+// their names) and status.
+func resolveTable(resolutions []resolution) *table {
+	t := &table{indent: "  "}
+	for _, res := range resolutions {
+		style := colors.success
+		switch {
+		case res.err != nil:
+			style = colors.failure
+		case res.duplicate != "":
+			style = colors.notice
+		case res.skipped != "":
+			style = colors.muted
+		}
+		name := res.name
+		if name == res.host {
+			name = ""
+		}
+		addresses := strings.Join(res.addresses, " ")
+		if len(res.reverse) > 0 {
+			addresses += " (" + strings.Join(res.reverse, " ") + ")"
+		}
+		t.add(style, res.host, name, addresses, res.status())
+	}
+	return t
+}
+
+// deduplicated resolves hosts before the run starts, returning them without
+// those which are duplicates of an earlier host, and warning about those
+// which do not resolve (e.g. typos). With --resolve-report, the mapping of
+// every host is printed.
+func deduplicated(args args, hosts []string, warning func(string, ...interface{})) []string {
+	if args.noResolve {
+		return hosts
+	}
+	cfg, _ := argsSSHConfig(args) // warned about by the runner
+	resolutions := systemResolver.resolveAll(hosts, cfg, proxyURL(args.proxy) != "", args.resolveReport)
+
+	if args.resolveReport {
+		colors.info.println("resolved %d hosts", len(hosts))
+		resolveTable(resolutions).print()
+	}
+
+	kept := make([]string, 0, len(hosts))
+	for _, res := range resolutions {
+		switch {
+		case res.err != nil:
+			warning("%s does not resolve: %s", res.host, res.status())
+		case res.duplicate != "":
+			warning("skipping %s, which resolves to %s like %s", res.host, res.addresses[0], res.duplicate)
+			continue
+		}
+		kept = append(kept, res.host)
+	}
+	return kept
+}
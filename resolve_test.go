@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fakeResolver(hosts map[string][]string, addrs map[string][]string) resolver {
+	return resolver{
+		lookupHost: func(name string) ([]string, error) {
+			addresses, ok := hosts[name]
+			if !ok {
+				return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+			}
+			return addresses, nil
+		},
+		lookupAddr: func(address string) ([]string, error) {
+			return addrs[address], nil
+		},
+	}
+}
+
+func Test_resolver_resolveAll(t *testing.T) {
+	rs := fakeResolver(map[string][]string{
+		"web1":          {"10.0.0.2", "10.0.0.1"},
+		"web1.internal": {"10.0.0.1"},
+		"db1":           {"10.0.1.1"},
+	}, map[string][]string{
+		"10.0.0.1": {"web1.example.com."},
+	})
+	cfg := sshConfig{blocks: []sshConfigBlock{
+		{patterns: []string{"db1-alt"}, options: [][2]string{{"hostname", "db1"}, {"port", "2222"}}},
+		{patterns: []string{"cache*"}, options: [][2]string{{"proxyjump", "ops@bastion:22"}}},
+	}}
+
+	resolutions := rs.resolveAll([]string{"web1", "db1", "web1.internal", "db1-alt", "wbe1", "10.0.0.1", "cache1"}, cfg, false, true)
+	require.Len(t, resolutions, 7)
+
+	require.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, resolutions[0].addresses)
+	require.Equal(t, []string{"web1.example.com"}, resolutions[0].reverse)
+	require.Equal(t, "ok", resolutions[0].status())
+	require.Equal(t, "ok", resolutions[1].status())
+	require.Equal(t, "duplicate of web1", resolutions[2].status())
+	require.Equal(t, "ok", resolutions[3].status(), "on another port")
+	require.Equal(t, "NXDOMAIN", resolutions[4].status())
+	require.Equal(t, "duplicate of web1", resolutions[5].status())
+	require.Equal(t, "resolved by bastion", resolutions[6].status())
+
+	proxied := rs.resolveAll([]string{"web1", "web1.internal"}, sshConfig{}, true, false)
+	require.Equal(t, "resolved by the proxy", proxied[0].status())
+	require.Equal(t, "resolved by the proxy", proxied[1].status())
+}
+
+func Test_deduplicated(t *testing.T) {
+	defer func(rs resolver) { systemResolver = rs }(systemResolver)
+	systemResolver = fakeResolver(map[string][]string{
+		"web1":          {"10.0.0.1"},
+		"web1.internal": {"10.0.0.1"},
+		"web2":          {"10.0.0.2"},
+	}, nil)
+
+	var warnings []string
+	warning := func(format string, args ...interface{}) {
+		warnings = append(warnings, format)
+	}
+	args := args{sshConfig: "none"}
+
+	hosts := deduplicated(args, []string{"web1", "web2", "web1.internal", "wbe3"}, warning)
+	require.Equal(t, []string{"web1", "web2", "wbe3"}, hosts)
+	require.Len(t, warnings, 2)
+
+	warnings = nil
+	args.noResolve = true
+	hosts = deduplicated(args, []string{"web1", "web1.internal"}, warning)
+	require.Equal(t, []string{"web1", "web1.internal"}, hosts)
+	require.Empty(t, warnings)
+}
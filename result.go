@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// A result records the outcome of executing one script on one host,
+// along with the inventory metadata of that host so that results may
+// be aggregated by datacenter, role, etc.
+type result struct {
+	Host     string        `json:"host"`
+	File     string        `json:"file,omitempty"`
+	Command  string        `json:"command,omitempty"`
+	Output   string        `json:"output"`
+	ExitCode int           `json:"exit_code"`
+	Error    string        `json:"error,omitempty"`
+	Failed   []string      `json:"failed_assertions,omitempty"`
+	Skipped  string        `json:"skipped,omitempty"`
+	Notes    []string      `json:"notes,omitempty"`
+	Tracked  []trackedFile `json:"tracked,omitempty"`
+	Reason   string        `json:"reason,omitempty"` // of the error, see reason
+	Seconds  float64       `json:"seconds"`
+	Usage    *usage        `json:"usage,omitempty"`
+	Metadata metadata      `json:"metadata,omitempty"`
+}
+
+// ok returns whether the script ran successfully and all of its assertions held.
+func (r result) ok() bool {
+	return r.Error == "" && len(r.Failed) == 0
+}
+
+// exitCode returns the exit code of the remote command that returned err,
+// or -1 if the command did not run to completion.
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *ssh.ExitError:
+		return e.ExitStatus()
+	case execError:
+		return e.exitCode
+	default:
+		return -1
+	}
+}
+
+type report struct {
+	ID      string           `json:"id"`
+	Status  string           `json:"status"`
+	Results []result         `json:"results"`
+	GroupBy string           `json:"group_by,omitempty"`
+	Groups  map[string]tally `json:"groups,omitempty"`
+
+	Variants []variant `json:"variants,omitempty"`
+	Reboots  []reboot  `json:"reboot_required,omitempty"`
+
+	Probe *probePhase `json:"probe,omitempty"`
+
+	Reason string `json:"reason,omitempty"` // of the failure of the run, see reason
+}
+
+// writeReport writes rpt to path, in the format of its extension: JUnit
+// XML for .xml, e.g. for the dashboards of CI, Markdown for .md, and JSON
+// otherwise.
+func writeReport(path string, rpt report) error {
+	var b bytes.Buffer
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		err = writeJUnit(&b, rpt)
+	case ".md":
+		err = writeMarkdown(&b, rpt)
+	default:
+		var bs []byte
+		bs, err = json.MarshalIndent(rpt, "", "  ")
+		b.Write(bs)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to encode report")
+	}
+	bs := b.Bytes()
+
+	if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+		return errors.Wrap(err, "failed to write report")
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// classes of failures which may be retried
+const (
+	retryConnect = "connect" // the host could not be connected to
+	retryTimeout = "timeout" // the script timed out
+	retryExit    = "exit"    // the script exited non-zero
+	retryAssert  = "assert"  // an assertion or expectation of the script failed
+)
+
+var retryClasses = []string{retryConnect, retryTimeout, retryExit, retryAssert}
+
+// A retryPolicy is how failures are retried: up to attempts in total, on
+// failures of the given classes, with exponential backoff between them.
+//
+// Policies are set with --retry, the "retry" inventory label of hosts
+// (e.g. of a group), and the "# retry:" directive of scripts, each
+// overriding the settings of the former, e.g.
+//
+//	attempts=3,base=1s,multiplier=2,max=30s,jitter=0.2,on=connect+timeout
+type retryPolicy struct {
+	attempts   int
+	base       time.Duration
+	multiplier float64
+	max        time.Duration
+	jitter     float64 // fraction by which delays vary randomly
+	on         map[string]bool
+}
+
+var defaultRetry = retryPolicy{
+	attempts:   1,
+	base:       time.Second,
+	multiplier: 2,
+	max:        30 * time.Second,
+	jitter:     0.2,
+	on:         map[string]bool{retryConnect: true},
+}
+
+// parseRetry returns the policy of spec, a comma separated list of
+// key=value settings overriding those of base.
+func parseRetry(spec string, base retryPolicy) (retryPolicy, error) {
+	p := base
+	for _, setting := range strings.Split(spec, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return p, errors.Errorf("malformed retry setting %q, expected KEY=VALUE", setting)
+		}
+		key, value := parts[0], parts[1]
+
+		var err error
+		switch key {
+		case "attempts":
+			if p.attempts, err = strconv.Atoi(value); err == nil && p.attempts < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "base":
+			p.base, err = time.ParseDuration(value)
+		case "multiplier":
+			if p.multiplier, err = strconv.ParseFloat(value, 64); err == nil && p.multiplier < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "max":
+			p.max, err = time.ParseDuration(value)
+		case "jitter":
+			if p.jitter, err = strconv.ParseFloat(value, 64); err == nil && (p.jitter < 0 || p.jitter > 1) {
+				err = errors.New("must be between 0 and 1")
+			}
+		case "on":
+			p.on = make(map[string]bool)
+			for _, class := range strings.Split(value, "+") {
+				if !isRetryClass(class) {
+					return p, errors.Errorf("unknown retry class %q, must be one of %s", class, strings.Join(retryClasses, ", "))
+				}
+				p.on[class] = true
+			}
+		default:
+			return p, errors.Errorf("unknown retry setting %q, must be one of attempts, base, multiplier, max, jitter, on", key)
+		}
+		if err != nil {
+			return p, errors.Wrapf(err, "malformed retry %s", key)
+		}
+	}
+	return p, nil
+}
+
+func isRetryClass(class string) bool {
+	for _, c := range retryClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (p retryPolicy) String() string {
+	var on []string
+	for class := range p.on {
+		on = append(on, class)
+	}
+	sort.Strings(on)
+	return "attempts=" + strconv.Itoa(p.attempts) + ",on=" + strings.Join(on, "+")
+}
+
+// retries returns whether a failure of class after attempt should be retried.
+func (p retryPolicy) retries(class string, attempt int) bool {
+	return class != "" && p.on[class] && attempt < p.attempts
+}
+
+// delay returns how long to wait after attempt before the next one.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := float64(p.base) * math.Pow(p.multiplier, float64(attempt-1))
+	if p.max > 0 && d > float64(p.max) {
+		d = float64(p.max)
+	}
+	d *= 1 + p.jitter*(2*rand.Float64()-1)
+	return time.Duration(d)
+}
+
+// retryClass returns the class of the failure err of a script, if any.
+func retryClass(err error) string {
+	switch err.(type) {
+	case nil:
+		return ""
+	case failures:
+		return retryAssert
+	case timeoutError:
+		return retryTimeout
+	case *ssh.ExitError, execError:
+		return retryExit
+	case connectionLostError:
+		return retryConnect
+	default:
+		return retryConnect
+	}
+}
+
+// timeoutError is returned by a script which ran for longer than its timeout.
+type timeoutError struct {
+	after time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return "timed out after " + e.after.String()
+}
+
+// retryPolicy returns the policy of retrying sc on host, where sc may
+// be empty for the policy of connecting to host.
+func (r *runner) retryPolicy(host string, sc script) (retryPolicy, error) {
+	p := r.retry
+	if spec, exists := r.inventory.metadata(host)["retry"]; exists {
+		var err error
+		if p, err = parseRetry(spec, p); err != nil {
+			return p, errors.Wrapf(err, "retry label of %s is invalid", host)
+		}
+	}
+	if sc.retry != "" {
+		return parseRetry(sc.retry, p)
+	}
+	return p, nil
+}
+
+// retrying calls fn until it succeeds, fails in a way p does not
+// retry, or the run is cancelled, waiting between attempts.
+func (r *runner) retrying(p retryPolicy, what string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		class := retryClass(err)
+		if !p.retries(class, attempt) || r.isCancelled() {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		r.out.warning("%s failed (%s: %v), retrying in %s (attempt %d of %d)",
+			what, class, err, delay.Round(time.Millisecond), attempt+1, p.attempts)
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRetry(t *testing.T) {
+	p, err := parseRetry("attempts=3,base=500ms,on=connect+timeout", defaultRetry)
+	require.NoError(t, err)
+	require.Equal(t, 3, p.attempts)
+	require.Equal(t, 500*time.Millisecond, p.base)
+	require.Equal(t, 2.0, p.multiplier, "unset settings are inherited")
+	require.Equal(t, map[string]bool{"connect": true, "timeout": true}, p.on)
+
+	p, err = parseRetry("attempts=5", p)
+	require.NoError(t, err)
+	require.Equal(t, 5, p.attempts)
+	require.Equal(t, 500*time.Millisecond, p.base)
+
+	for _, spec := range []string{"attempts=0", "on=reboot", "jitter=2", "delay=1s", "attempts"} {
+		_, err := parseRetry(spec, defaultRetry)
+		require.Error(t, err, spec)
+	}
+}
+
+func Test_retryPolicy_delay(t *testing.T) {
+	p := retryPolicy{base: time.Second, multiplier: 2, max: 5 * time.Second}
+	require.Equal(t, time.Second, p.delay(1))
+	require.Equal(t, 4*time.Second, p.delay(3))
+	require.Equal(t, 5*time.Second, p.delay(4))
+
+	p.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.delay(1)
+		require.True(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, d)
+	}
+}
+
+func Test_runner_retrying(t *testing.T) {
+	inv, err := parseInventory("db1 retry=attempts=3,base=1ms,on=exit+assert\nweb1\n")
+	require.NoError(t, err)
+	r := &runner{inventory: inv, retry: defaultRetry, out: &quiet{}}
+
+	p, err := r.retryPolicy("db1", script{})
+	require.NoError(t, err)
+	calls := 0
+	err = r.retrying(p, "test", func() error {
+		calls++
+		if calls < 3 {
+			return failures{{host: "db1"}}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	calls = 0
+	err = r.retrying(p, "test", func() error {
+		calls++
+		return timeoutError{after: time.Second}
+	})
+	require.Equal(t, timeoutError{after: time.Second}, err)
+	require.Equal(t, 1, calls, "timeouts are not retried on db1")
+
+	p, err = r.retryPolicy("web1", script{retry: "attempts=2,base=1ms"})
+	require.NoError(t, err)
+	calls = 0
+	err = r.retrying(p, "test", func() error {
+		calls++
+		return errors.New("connection refused")
+	})
+	require.Error(t, err)
+	require.Equal(t, 2, calls)
+}
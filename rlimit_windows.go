@@ -0,0 +1,7 @@
+package main
+
+// openFilesLimit returns the soft limit of open files of this process,
+// which windows does not have.
+func openFilesLimit() (uint64, bool) {
+	return 0, false
+}
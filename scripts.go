@@ -3,12 +3,17 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
-
-	"github.com/fatih/color"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -16,24 +21,287 @@ import (
 )
 
 type script struct {
-	command string
-	stdin   []string
+	command    string
+	stdin      []string
+	payload    string // fed verbatim after stdin
+	upload     string // uploaded to a file and run with runWith instead, see packUpload
+	runWith    string
+	asserts    []assertion
+	expects    []expectation
+	expectExit exitCodes
+	env        []string
+	timeout    time.Duration
+	sudo       bool
+	dangerous  bool     // confirmed with --confirm dangerous
+	heavy      bool     // limited per size class of hosts, see heavyLimits
+	track      []string // paths fetched once the step ran, see trackFiles
+	guards     []guard
+	when       []condition // which must all hold for the script to run
+	group      string      // parallel group, run concurrently with adjacent steps of the same one
+	params     []param
+	retry      string
+	local      bool   // run on the operator's machine, see markLocal
+	register   string // variable to store the output in
+	checksum   string // path to compare the hash of across hosts
+	minFree    []spaceCheck
+	cwd        string // directory to run the command in
+	umask      string
+}
+
+var directiveRe = regexp.MustCompile(`^#\s*([[:word:]-]+):\s*(.*)$`)
+
+// bareDirectiveRe matches directives which may be declared without a
+// value, e.g. "# dangerous".
+var bareDirectiveRe = regexp.MustCompile(`^#\s*(dangerous|heavy)\s*$`)
+
+var umaskRe = regexp.MustCompile(`^[0-7]{3,4}$`)
+
+// known directives which may be declared in script comments,
+// e.g. "# assert: .disk_used_pct < 90"
+var knownDirectives = map[string]bool{
+	"assert":         true,
+	"expect":         true,
+	"expect-exit":    true,
+	"env":            true,
+	"timeout":        true,
+	"sudo":           true,
+	"creates":        true,
+	"unless":         true,
+	"param":          true,
+	"retry":          true,
+	"register":       true,
+	"min-free":       true,
+	"cwd":            true,
+	"umask":          true,
+	"when":           true,
+	"parallel-group": true,
+	"parallel_group": true,
+	"dangerous":      true,
+	"heavy":          true,
+	"track":          true,
+	"upload":         true,
+	"deprecated":     true,
+	"sunset":         true,
+	"name":           true,
+	"description":    true,
+	"owner":          true,
+	"danger":         true,
+}
+
+type directive struct {
+	key   string
+	value string
+}
+
+func directives(lines []string) []directive {
+	var found []directive
+	for _, line := range lines {
+		if matches := bareDirectiveRe.FindStringSubmatch(strings.TrimSpace(line)); matches != nil {
+			found = append(found, directive{key: matches[1]})
+			continue
+		}
+		matches := directiveRe.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil || !knownDirectives[matches[1]] {
+			continue
+		}
+		found = append(found, directive{
+			key:   matches[1],
+			value: strings.TrimSpace(matches[2]),
+		})
+	}
+	return found
+}
+
+func (s *script) configure(ds []directive) error {
+	for _, d := range ds {
+		switch d.key {
+		case "assert":
+			a, err := parseAssertion(d.value)
+			if err != nil {
+				return err
+			}
+			s.asserts = append(s.asserts, a)
+		case "expect":
+			e, err := parseExpectation(d.value)
+			if err != nil {
+				return err
+			}
+			s.expects = append(s.expects, e)
+		case "expect-exit":
+			codes, err := parseExitCodes(d.value)
+			if err != nil {
+				return err
+			}
+			s.expectExit = codes
+		case "env":
+			if _, _, err := splitEnv(d.value); err != nil {
+				return err
+			}
+			s.env = append(s.env, d.value)
+		case "timeout":
+			timeout, err := time.ParseDuration(d.value)
+			if err != nil {
+				return errors.Wrap(err, "malformed timeout")
+			}
+			s.timeout = timeout
+		case "sudo":
+			sudo, err := strconv.ParseBool(d.value)
+			if err != nil {
+				return errors.Wrap(err, "malformed sudo")
+			}
+			s.sudo = sudo
+		case "dangerous":
+			dangerous := true
+			if d.value != "" {
+				var err error
+				if dangerous, err = strconv.ParseBool(d.value); err != nil {
+					return errors.Wrap(err, "malformed dangerous")
+				}
+			}
+			s.dangerous = dangerous
+		case "heavy":
+			if s.local {
+				return errors.Errorf("heavy does not apply to local steps")
+			}
+			heavy := true
+			if d.value != "" {
+				var err error
+				if heavy, err = strconv.ParseBool(d.value); err != nil {
+					return errors.Wrap(err, "malformed heavy")
+				}
+			}
+			s.heavy = heavy
+		case "creates", "unless":
+			if d.value == "" {
+				return errors.Errorf("%s requires a value", d.key)
+			}
+			s.guards = append(s.guards, guard{directive: d.key, value: d.value})
+		case "upload":
+			if err := s.configureUpload(d.value); err != nil {
+				return err
+			}
+		case "parallel-group", "parallel_group":
+			if s.local {
+				return errors.Errorf("%s does not apply to local steps", d.key)
+			}
+			if !paramNameRe.MatchString(d.value) {
+				return errors.Errorf("malformed %s %q, expected a name", d.key, d.value)
+			}
+			s.group = d.value
+		case "track":
+			if s.local {
+				return errors.Errorf("track does not apply to local steps")
+			}
+			if d.value == "" {
+				return errors.Errorf("track requires a path")
+			}
+			s.track = append(s.track, d.value)
+		case "when":
+			if s.local {
+				return errors.Errorf("when does not apply to local steps, which run once for every host")
+			}
+			c, err := parseCondition(d.value)
+			if err != nil {
+				return err
+			}
+			s.when = append(s.when, c)
+		case "cwd":
+			if d.value == "" {
+				return errors.Errorf("cwd requires a value")
+			}
+			s.cwd = d.value
+		case "umask":
+			if !umaskRe.MatchString(d.value) {
+				return errors.Errorf("malformed umask %q, expected octal e.g. 022", d.value)
+			}
+			s.umask = d.value
+		case "min-free":
+			c, err := parseSpaceCheck(d.value, " ")
+			if err != nil {
+				return err
+			}
+			s.minFree = append(s.minFree, c)
+		case "param":
+			p, err := parseParam(d.value)
+			if err != nil {
+				return err
+			}
+			s.params = append(s.params, p)
+		case "retry":
+			if _, err := parseRetry(d.value, defaultRetry); err != nil {
+				return err
+			}
+			s.retry = d.value
+		case "register":
+			if !paramNameRe.MatchString(d.value) {
+				return errors.Errorf("malformed register %q, expected a variable name", d.value)
+			}
+			s.register = d.value
+		}
+	}
+	return nil
 }
 
 // A scriptfile contains one or more scripts to be executed.
 type scriptfile struct {
 	name    string
+	path    string
+	source  string // with includes, of a script file read from stdin, which has no path
 	scripts []script
+
+	deprecated string    // reason, e.g. "use 05-new-restart"
+	sunset     time.Time // after which the script no longer runs
+	meta       metadata  // of its header: name, description, owner and danger
 }
 
 func (s scriptfile) String() string {
 	return s.name
 }
 
+// load the scripts of every --scripts directory, which are overlaid such
+// that scripts in later directories replace scripts of the same path in
+// earlier directories. Scripts are returned ordered by name, their path
+// within their directory.
 func load(cfg args) ([]scriptfile, error) {
+	if cfg.script != "" {
+		sf, err := readScript(cfg.script, os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return []scriptfile{sf}, nil
+	}
+
+	overlay := make(map[string]scriptfile)
+
+	for _, dir := range cfg.scriptDirs {
+		scripts, err := loadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, script := range scripts {
+			overlay[script.name] = script
+		}
+	}
+
+	if len(overlay) == 0 {
+		return nil, errors.Errorf("no scripts found")
+	}
+
+	scripts := make([]scriptfile, 0, len(overlay))
+	for _, script := range overlay {
+		scripts = append(scripts, script)
+	}
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].name < scripts[j].name
+	})
+	return scripts, nil
+}
+
+func loadDir(dir string) ([]scriptfile, error) {
 	var scripts []scriptfile
+	included := make(map[string]bool)
 
-	err := filepath.Walk(cfg.scriptDir, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return errors.Wrap(err, "failed to read scripts")
 		}
@@ -43,46 +311,257 @@ func load(cfg args) ([]scriptfile, error) {
 			return nil
 		}
 
-		script, err := read(info.Name(), path)
+		name, err := scriptName(dir, path)
+		if err != nil {
+			return err
+		}
+
+		script, err := read(name, path, included)
 		if err != nil {
-			return errors.Wrapf(err, "failed to read script file %s", info.Name())
+			return errors.Wrapf(err, "failed to read script file %s", name)
 		}
 
 		scripts = append(scripts, script)
 		return nil
 	})
 
-	if len(scripts) == 0 {
-		return nil, errors.Errorf("no scripts found")
+	// files included by other scripts are not run on their own
+	runnable := scripts[:0]
+	for _, script := range scripts {
+		if !included[script.path] {
+			runnable = append(runnable, script)
+		}
 	}
+	return runnable, err
+}
 
-	return scripts, err
+// scriptName returns the name of the script file at path, its path within
+// dir, so that files of subdirectories only replace files of the same path
+// in other --scripts directories.
+func scriptName(dir, path string) (string, error) {
+	name, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read scripts")
+	}
+	return filepath.ToSlash(name), nil
 }
 
-func read(name, path string) (scriptfile, error) {
-	bs, err := ioutil.ReadFile(path)
+func read(name, path string, included map[string]bool) (scriptfile, error) {
+	path, err := filepath.Abs(path)
 	if err != nil {
 		return scriptfile{}, errors.Wrap(err, "failed to read script")
 	}
-	s := strings.TrimSpace(string(bs))
-	return parse(name, s)
+
+	content, err := include(path, nil, included)
+	if err != nil {
+		return scriptfile{}, err
+	}
+
+	sf, err := parse(name, strings.TrimSpace(content))
+	sf.path = path
+	if err != nil {
+		return sf, parseError{err: err}
+	}
+	return sf, nil
+}
+
+// stdinScript is the name of the script file read from stdin.
+const stdinScript = "stdin"
+
+// readScript reads the one script file of --script, at path, or from stdin
+// if path is "-", in which case its includes are relative to the working
+// directory.
+func readScript(path string, stdin io.Reader) (scriptfile, error) {
+	if path != "-" {
+		return read(filepath.Base(path), path, make(map[string]bool))
+	}
+
+	bs, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return scriptfile{}, errors.Wrap(err, "failed to read script from stdin")
+	}
+	if len(bytes.TrimSpace(bs)) == 0 {
+		return scriptfile{}, errors.New("no script on stdin")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return scriptfile{}, errors.Wrap(err, "failed to read script from stdin")
+	}
+	content, err := includeIn(wd, string(bs), nil, make(map[string]bool))
+	if err != nil {
+		return scriptfile{}, err
+	}
+
+	sf, err := parse(stdinScript, strings.TrimSpace(content))
+	sf.source = content
+	if err != nil {
+		err = parseError{err: err}
+	}
+	return sf, err
+}
+
+var includeRe = regexp.MustCompile(`^#include\s+(\S+)\s*$`)
+
+// include returns the content of the script file at path, with each of its
+// "#include <file>" lines replaced by the content of that file, which is
+// relative to the directory of the file including it. The stack of files
+// being included is used to detect cycles, and every included file is
+// added to included.
+func include(path string, stack []string, included map[string]bool) (string, error) {
+	for _, p := range stack {
+		if p == path {
+			return "", errors.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), path)
+		}
+	}
+	stack = append(stack, path)
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read script")
+	}
+	return includeIn(filepath.Dir(path), string(bs), stack, included)
+}
+
+// includeIn returns content with its includes replaced as by include, for
+// content whose includes are relative to dir.
+func includeIn(dir, content string, stack []string, included map[string]bool) (string, error) {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if file := strings.TrimPrefix(strings.TrimSpace(line), "@stdin-file "); file != strings.TrimSpace(line) {
+			// stdin files are relative to the file naming them, too
+			if file = strings.TrimSpace(file); !filepath.IsAbs(file) {
+				lines[i] = "@stdin-file " + filepath.Join(dir, file)
+			}
+			continue
+		}
+
+		matches := includeRe.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			continue
+		}
+
+		file := matches[1]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		included[file] = true
+
+		content, err := include(file, stack, included)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to include %s", matches[1])
+		}
+		lines[i] = content
+	}
+	return strings.Join(lines, "\n"), nil
 }
 
 func parse(name, content string) (scriptfile, error) {
-	parts := strings.Split(content, "---")
-	scriptFile := scriptfile{name: name}
+	scriptFile := scriptfile{name: name, meta: make(metadata)}
+
+	content, heredocs, err := heredocs(content)
+	if err != nil {
+		return scriptFile, errors.Wrapf(err, "bad heredoc in script %s", name)
+	}
 
-	for _, part := range parts {
-		lines := cleanup(strings.Split(part, "\n"))
+	parts := strings.Split(content, "---")
+	for i, part := range parts {
+		raw := strings.Split(part, "\n")
+		lines := cleanup(raw)
 		if len(lines) == 0 {
 			return scriptFile, errors.Errorf("no command in script %s", name)
 		}
-		s := script{lines[0], lines[1:]}
+		s := script{command: lines[0]}
+		s.markLocal()
+		if err := s.input(lines[1:], heredocs); err != nil {
+			return scriptFile, errors.Wrapf(err, "bad stdin in script %s", name)
+		}
+		ds := directives(raw)
+		if err := scriptFile.configure(ds, i == 0); err != nil {
+			return scriptFile, errors.Wrapf(err, "bad directive in script %s", name)
+		}
+		if err := s.configure(ds); err != nil {
+			return scriptFile, errors.Wrapf(err, "bad directive in script %s", name)
+		}
+		if s.runWith != "" {
+			if err := s.packUpload(raw); err != nil {
+				return scriptFile, errors.Wrapf(err, "bad upload in script %s", name)
+			}
+		}
+		if err := s.markChecksum(); err != nil {
+			return scriptFile, errors.Wrapf(err, "bad module in script %s", name)
+		}
 		scriptFile.scripts = append(scriptFile.scripts, s)
 	}
+	if scriptFile.meta["danger"] == dangerHigh {
+		for i := range scriptFile.scripts {
+			scriptFile.scripts[i].dangerous = true
+		}
+	}
 	return scriptFile, nil
 }
 
+var heredocRe = regexp.MustCompile(`^<<\s*([[:word:]]+)$`)
+
+// heredocs extracts the heredoc blocks of content, which start with a
+// "<<MARKER" line and end with a "MARKER" line, and whose lines are fed
+// to the command verbatim. Each block is replaced by a "@heredoc <n>" line,
+// so that its content is not mistaken for separators, comments or directives.
+func heredocs(content string) (string, []string, error) {
+	var (
+		lines  []string
+		blocks []string
+		marker string
+		block  []string
+	)
+
+	for _, line := range strings.Split(content, "\n") {
+		switch {
+		case marker != "" && strings.TrimSpace(line) == marker:
+			lines = append(lines, fmt.Sprintf("@heredoc %d", len(blocks)))
+			blocks = append(blocks, strings.Join(block, "\n")+"\n")
+			marker, block = "", nil
+		case marker != "":
+			block = append(block, line)
+		case heredocRe.MatchString(strings.TrimSpace(line)):
+			marker = heredocRe.FindStringSubmatch(strings.TrimSpace(line))[1]
+		default:
+			lines = append(lines, line)
+		}
+	}
+
+	if marker != "" {
+		return "", nil, errors.Errorf("heredoc %s is not terminated", marker)
+	}
+	return strings.Join(lines, "\n"), blocks, nil
+}
+
+// input configures the stdin of the script from the lines after its
+// command, which are literal lines, heredocs, or "@stdin-file <path>"
+// lines naming a local file whose content is fed verbatim.
+func (s *script) input(lines []string, heredocs []string) error {
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@heredoc "):
+			n, err := strconv.Atoi(strings.TrimPrefix(line, "@heredoc "))
+			if err != nil || n >= len(heredocs) {
+				return errors.Errorf("malformed %q", line)
+			}
+			s.payload += heredocs[n]
+		case strings.HasPrefix(line, "@stdin-file "):
+			bs, err := ioutil.ReadFile(strings.TrimSpace(strings.TrimPrefix(line, "@stdin-file ")))
+			if err != nil {
+				return errors.Wrap(err, "failed to read stdin file")
+			}
+			s.payload += string(bs)
+		case s.payload != "":
+			return errors.Errorf("literal stdin %q must come before heredocs and stdin files", line)
+		default:
+			s.stdin = append(s.stdin, line)
+		}
+	}
+	return nil
+}
+
 func cleanup(lines []string) []string {
 	cleansed := make([]string, 0, len(lines))
 	for _, dirty := range lines {
@@ -97,48 +576,351 @@ func cleanup(lines []string) []string {
 	return cleansed
 }
 
-func run(user, pass string, hosts []string, files []scriptfile) error {
-	for _, host := range hosts {
+// A runner executes scripts on hosts, recording the result of each.
+type runner struct {
+	id             string
+	user           string
+	pass           string
+	timeout        time.Duration
+	flushInterval  time.Duration
+	keepaliveEvery time.Duration
+	parallel       int
+	usage          bool
+	defaultShell   shell
+	shellHistory   string
+	inventory      inventory
+	source         secretSource
+	signers        []ssh.Signer
+	hostKeys       ssh.HostKeyCallback
+	dialer         dialer
+	secrets        []string
+	suFallback     bool
+	sudoCheck      bool // see validateSudo
+	otpCommand     string
+	locale         string
+	frame          bool
+	sshConfig      sshConfig
+	userSet        bool
+	becomeAs       string // see becomeUser
+	proxy          string
+	controlPath    string // of ControlMasters to reuse, see controlMaster
+	cache          bool
+	retry          retryPolicy
+	cacheUpdated   map[string]bool
+	rebootReport   bool
+	rebootNow      bool
+	defaultProfile profile
+	out            renderer
+	confirmer      *confirmer
+	results        []result
+
+	lock        sync.Mutex
+	confirming  sync.Mutex // held while asking the operator to confirm a step
+	cancelled   bool
+	resumed     chan struct{} // closed when a paused run is resumed, nil unless paused
+	sessions    map[*ssh.Session]chan struct{}
+	passwords   map[string]string
+	sudo        map[string]map[string]string
+	hostFacts   map[string]map[string]string
+	index       map[string]int
+	params      map[string]string
+	locals      map[string]*localStep
+	registered  map[string]string
+	hostVars    map[string]map[string]string // registered by steps of the script file running on each host
+	batch       batch
+	canarying   bool                         // while canary hosts run, which failing to dial does not stop, see canaried
+	checksums   map[string]map[string]string // hash by host, by file and path
+	reboots     map[string][]string          // packages requiring a reboot, by host
+	warm        map[string]*ssh.Client       // connections made by the precheck
+	probe       *probePhase                  // of --require-probe
+	forwards    []*forwarder
+	forwardEach bool // whether forwards go through each host, rather than --forward-via
+	heavy       heavyLimits
+	hooks       *webhooks
 
-		client, err := makeClient(user, pass, host)
+	trackHistory sync.Once      // reads history, for the previous tracked files
+	history      []historyEntry // of previous runs, see previousTrack
+
+	minFree       []spaceCheck
+	maxSkew       time.Duration
+	failOnSkew    bool
+	silencers     []silencer
+	silenceFor    time.Duration
+	silenceTarget string
+	state         state
+
+	maxOutput int64  // bytes of output of each script kept, see capture
+	truncate  string // policy of maxOutput
+	spillDir  string
+	spills    int32
+}
+
+func newRunner(args args, pass string, inv inventory, out renderer) *runner {
+	cfg, err := argsSSHConfig(args)
+	if err != nil {
+		out.warning("ignoring ssh config: %v", err)
+	}
+
+	retry, err := parseRetry(args.retry, defaultRetry)
+	if err != nil {
+		out.warning("ignoring --retry: %v", err)
+		retry = defaultRetry
+	}
+
+	// hosts are not dialed directly when the proxy is malformed
+	dial, err := newDialer(proxyURL(args.proxy))
+	if err != nil {
+		out.warning("failed to configure proxy: %v", err)
+		failed := err
+		dial = func(string, string) (net.Conn, error) { return nil, failed }
+	}
+
+	var minFree []spaceCheck
+	for _, value := range args.minFree {
+		c, err := parseSpaceCheck(value, "=")
 		if err != nil {
-			return errors.Wrap(err, "failed to dial host")
+			out.warning("ignoring --min-free: %v", err)
+			continue
 		}
+		minFree = append(minFree, c)
+	}
 
+	var silencers []silencer
+	for _, spec := range args.silences {
+		s, err := newSilencer(spec, args.silenceLabel)
+		if err != nil {
+			out.warning("ignoring --silence: %v", err)
+			continue
+		}
+		silencers = append(silencers, s)
+	}
+
+	heavy, err := parseHeavyLimits(args.heavyLimits)
+	if err != nil {
+		out.warning("ignoring --heavy-limit: %v", err)
+	}
+
+	var maxOutput int64
+	if args.maxOutput != "" {
+		if maxOutput, err = parseSize(args.maxOutput); err != nil {
+			out.warning("ignoring --max-output: %v", err)
+		}
+	}
+
+	return &runner{
+		id:            newRunID(),
+		user:          args.user,
+		pass:          pass,
+		timeout:       args.timeout,
+		flushInterval: args.flushInterval,
+		parallel:      args.parallel,
+		usage:         args.usage,
+		rebootReport:  args.rebootReport || args.rebootNow,
+		rebootNow:     args.rebootNow,
+		suFallback:    args.suFallback,
+		sudoCheck:     !args.noSudoCheck,
+		otpCommand:    args.otpCommand,
+		locale:        args.locale,
+		frame:         !args.noFrame,
+		sshConfig:     cfg,
+		userSet:       args.userSet,
+		becomeAs:      args.becomeUser,
+		proxy:         proxyURL(args.proxy),
+		dialer:        dial,
+		controlPath:   args.controlPath,
+		cache:         !args.noCache,
+		retry:         retry,
+		silencers:     silencers,
+		minFree:       minFree,
+		heavy:         heavy,
+		maxSkew:       args.maxSkew,
+		failOnSkew:    args.skewAction == "fail",
+		silenceFor:    args.silenceFor,
+		silenceTarget: args.silenceTarget,
+		maxOutput:     maxOutput,
+		truncate:      args.truncate,
+		spillDir:      args.spillOutput,
+		defaultProfile: profile{
+			noShellWrapper: args.noShellWrapper,
+			noPTY:          args.noPTY,
+			singleSession:  args.singleSession,
+		},
+		defaultShell:   shell(args.shell),
+		keepaliveEvery: args.keepalive,
+		shellHistory:   args.shellHistory,
+		inventory:      inv,
+		out:            out,
+		confirmer:      newConfirmer(args.confirm, os.Stdin),
+		hostKeys:       ssh.InsecureIgnoreHostKey(),
+		sessions:       make(map[*ssh.Session]chan struct{}),
+		passwords:      make(map[string]string),
+		sudo:           make(map[string]map[string]string),
+		hostFacts:      make(map[string]map[string]string),
+	}
+}
+
+func (r *runner) record(res result) {
+	if res.Error != "" && res.Reason == "" {
+		res.Reason = reasonInternal
+	}
+	res.Metadata = r.inventory.metadata(res.Host).masked()
+	r.out.result(res)
+
+	r.lock.Lock()
+	r.results = append(r.results, res)
+	r.lock.Unlock()
+}
+
+func (r *runner) run(hosts []string, files []scriptfile) error {
+	err := r.each(hosts, func(client *ssh.Client, host string) error {
+		if ok, err := r.preflight(client, host, files); !ok {
+			return err
+		}
+
+		var failed failures
 		for _, file := range files {
-			if err := executeScriptFile(client, user, pass, host, file); err != nil {
+			if sk, ok := r.state.skipped(host, file.name, time.Now()); ok {
+				r.out.message("skipping %s on %s: %s", file.name, host, sk)
+				r.record(result{Host: host, File: file.name, Skipped: sk.String()})
+				continue
+			}
+
+			err := r.executeScriptFile(client, host, file)
+			if f, ok := err.(failures); ok {
+				// keep going, so assertions are checked across the fleet
+				failed = append(failed, f...)
+			} else if err != nil {
 				return errors.Wrapf(err, "failed to run %s on %s", file, host)
 			}
-			fmt.Println("")
 		}
+		r.checkReboot(client, host)
+
+		if len(failed) > 0 {
+			return failed
+		}
+		return nil
+	})
+
+	// files checked with @checksum (without a hash) must match across hosts
+	drift := r.checksumDrift(hosts)
+	if len(drift) == 0 {
+		return err
 	}
-	return nil
+	for _, f := range drift {
+		r.out.warning("%s", f)
+	}
+	if f, ok := err.(failures); ok || err == nil {
+		return append(f, drift...)
+	}
+	return err
 }
 
-func runCmd(user string, hosts []string, command string, pw bool) error {
-	var pass string
-	if pw {
-		var err error
-		pass, err = easyPrompt(user)
-		if err != nil {
-			return err
+func (r *runner) runCmd(hosts []string, command string, pw bool, env []string) error {
+	return r.each(hosts, func(client *ssh.Client, host string) error {
+		if err := r.executeCommand(client, host, command, pw, env); err != nil {
+			return errors.Wrapf(err, "failed to run %s on %s", command, host)
 		}
+		r.checkReboot(client, host)
+		return nil
+	})
+}
+
+// each dials every host and calls fn with the connection, on up to
+// --parallel hosts at a time. Assertion failures are collected across
+// hosts, whereas any other error stops further hosts from being started,
+// except for hosts failing to dial while canary hosts run.
+func (r *runner) each(hosts []string, fn func(client *ssh.Client, host string) error) error {
+	parallel := r.parallel
+	if parallel < 1 {
+		parallel = 1
 	}
+
+	var (
+		lock   sync.Mutex
+		wg     sync.WaitGroup
+		failed failures
+		fatal  error
+		dials  error // the first of hosts failing to dial, while canarying
+	)
+
+	slots := make(chan struct{}, parallel)
 	for _, host := range hosts {
-		client, err := makeClient(user, pass, host)
-		if err != nil {
-			return errors.Wrap(err, "failed to dial host")
-		}
+		slots <- struct{}{}
+		r.waitIfPaused()
 
-		if err := executeCommand(client, user, pass, host, command, pw); err != nil {
-			return errors.Wrapf(err, "failed to run %s on %s", command, host)
+		lock.Lock()
+		stop := fatal != nil
+		lock.Unlock()
+		if stop || r.isCancelled() {
+			break
 		}
-		fmt.Println("")
+
+		wg.Add(1)
+		go func(host string) {
+			defer func() { <-slots; wg.Done() }()
+
+			err := r.onHost(host, fn)
+
+			lock.Lock()
+			defer lock.Unlock()
+			switch f, ok := err.(failures); {
+			case ok:
+				failed = append(failed, f...)
+			case err == nil:
+			case r.canarying && failedToDial(err):
+				if dials == nil {
+					dials = err
+				}
+			case fatal == nil:
+				fatal = err
+			}
+		}(host)
 	}
+	wg.Wait()
 
+	switch {
+	case r.isCancelled():
+		return errCancelled
+	case fatal != nil:
+		return fatal
+	case dials != nil:
+		return dials
+	case len(failed) > 0:
+		return failed
+	}
 	return nil
 }
 
+func (r *runner) onHost(host string, fn func(client *ssh.Client, host string) error) error {
+	p, err := r.retryPolicy(host, script{})
+	if err != nil {
+		r.record(result{Host: host, ExitCode: -1, Error: err.Error()})
+		return err
+	}
+
+	client, warm := r.takeWarm(host)
+	if !warm {
+		err = r.retrying(p, "connecting to "+host, func() error {
+			var err error
+			client, err = r.dial(host)
+			return err
+		})
+	}
+	if err != nil {
+		r.record(result{Host: host, ExitCode: -1, Error: err.Error(), Reason: reason(err)})
+		return errors.Wrap(err, "failed to dial host")
+	}
+	defer func() { _ = client.Close() }()
+	if r.release(client, host) {
+		// commands and probes dial connections of their own
+		return fn(client, host)
+	}
+	r.keepalive(client, host)
+	defer r.forwardThrough(host, client)()
+
+	return fn(client, host)
+}
+
 func substitute(stdin []string, substitutions map[string]string) []string {
 	var replaced []string
 	for _, line := range stdin {
@@ -160,11 +942,50 @@ func combine(stdin []string) string {
 	return b.String()
 }
 
-func executeScriptFile(client *ssh.Client, user, pass, host string, sf scriptfile) error {
-	color.Magenta(fmt.Sprintf("--- %s ---", host))
+// track session while it is in-flight, so that it may be interrupted.
+// The returned channel must be closed once the remote process exits.
+func (r *runner) track(session *ssh.Session) chan struct{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	exited := make(chan struct{})
+	r.sessions[session] = exited
+	return exited
+}
 
-	for _, script := range sf.scripts {
-		if err := executeScript(client, user, pass, host, script); err != nil {
+func (r *runner) untrack(session *ssh.Session) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	close(r.sessions[session])
+	delete(r.sessions, session)
+}
+
+func (r *runner) executeScriptFile(client *ssh.Client, host string, sf scriptfile) error {
+	r.out.begin(host, sf.name)
+	defer r.out.end(host, sf.name)
+	defer r.forgetVars(host)
+
+	i := 0
+	for _, group := range parallelGroups(sf.scripts) {
+		if r.waitIfPaused(); r.isCancelled() {
+			return errCancelled
+		}
+
+		var err error
+		switch {
+		case len(group) > 1:
+			err = r.executeGroup(client, host, sf.name, group)
+		case group[0].local:
+			err = r.executeLocal(sf.name, i, group[0])
+		default:
+			err = r.executeScript(client, host, sf.name, group[0])
+		}
+		i += len(group)
+		if f, ok := err.(failures); ok {
+			for i := range f {
+				f[i].file = sf.name
+			}
+			return f
+		} else if err != nil {
 			return err
 		}
 	}
@@ -172,30 +993,197 @@ func executeScriptFile(client *ssh.Client, user, pass, host string, sf scriptfil
 	return nil
 }
 
-func executeCommand(client *ssh.Client, user, pass, host, command string, pw bool) error {
-	color.Magenta(fmt.Sprintf("--- %s ---", host))
+func (r *runner) executeCommand(client *ssh.Client, host, command string, pw bool, env []string) error {
+	r.out.begin(host, "")
+	defer r.out.end(host, "")
 
-	sc := script{command: command}
+	sc := script{command: command, env: env}
 	if pw {
 		sc.stdin = []string{"PASSWORD"}
 	}
 
-	return executeScript(client, user, pass, host, sc)
+	return r.executeScript(client, host, "", sc)
 }
 
-func executeScript(client *ssh.Client, user, pass, host string, sc script) error {
-	color.Yellow("executing command `%s`\n", sc.command)
+// executeScript executes sc on host, retrying it as configured for the
+// script and host, and records the result of its last attempt.
+func (r *runner) executeScript(client *ssh.Client, host, file string, sc script) error {
+	p, err := r.retryPolicy(host, sc)
+	if err != nil {
+		r.record(result{Host: host, File: file, Command: sc.command, ExitCode: -1, Error: err.Error()})
+		return err
+	}
+
+	if sc.heavy {
+		defer r.heavySlot(host, sc.command)()
+	}
+
+	var last result
+	err = r.retrying(p, fmt.Sprintf("`%s` on %s", sc.command, host), func() error {
+		return r.attempt(client, host, file, sc, func(res result) { last = res })
+	})
+	last.Reason = reason(err)
+	if len(sc.track) > 0 && last.Skipped == "" && last.Command != "" {
+		var notes []string
+		last.Tracked, notes = r.trackFiles(client, host, file, sc.track)
+		last.Notes = append(last.Notes, notes...)
+	}
+	r.record(last)
+	if sc.checksum != "" && last.ok() && last.Skipped == "" {
+		r.sawChecksum(host, file, sc.checksum, fields(last.Output)["sha256"])
+	}
+	if sc.register != "" && err == nil && last.Skipped == "" {
+		r.registerVar(host, sc.register, last.Output)
+	}
+	return err
+}
+
+// attempt to execute sc on host once, passing its result to record.
+func (r *runner) attempt(client *ssh.Client, host, file string, sc script, record func(result)) error {
+	p, err := r.profile(host)
+	if err == nil && p.singleSession {
+		// the host allows only one exec channel per connection
+		if client, err = r.dial(host); err == nil {
+			defer func() { _ = client.Close() }()
+		}
+	}
+	if err == nil {
+		sc = r.withVars(host, sc)
+		sc, err = r.expandModule(client, host, sc)
+	}
+	if err == nil {
+		sc, err = r.adapt(client, host, sc)
+	}
+	if err != nil {
+		record(result{Host: host, File: file, Command: sc.command, ExitCode: -1, Error: err.Error()})
+		return err
+	}
+
+	if reason, err := r.unmet(client, host, sc); err != nil {
+		record(result{Host: host, File: file, Command: sc.command, ExitCode: -1, Error: err.Error()})
+		return err
+	} else if reason != "" {
+		r.out.message("skipping `%s` on %s: %s", sc.command, host, reason)
+		record(result{Host: host, File: file, Command: sc.command, Skipped: reason})
+		return nil
+	}
+
+	if g, holds, err := r.guarded(client, host, sc); err != nil {
+		record(result{Host: host, File: file, Command: sc.command, ExitCode: -1, Error: err.Error()})
+		return err
+	} else if holds {
+		r.out.message("skipping `%s` on %s: %s", sc.command, host, g)
+		record(result{Host: host, File: file, Command: sc.command, Skipped: g.String()})
+		return nil
+	}
+
+	if reason, err := r.lowSpace(client, host, sc.minFree); err != nil {
+		record(result{Host: host, File: file, Command: sc.command, ExitCode: -1, Error: err.Error()})
+		return err
+	} else if reason != "" {
+		r.out.warning("skipping `%s` on %s: %s", sc.command, host, reason)
+		record(result{Host: host, File: file, Command: sc.command, Skipped: reason})
+		return nil
+	}
+
+	if err := r.confirm(host, file, sc.command, sc); err == errDeclined {
+		r.out.message("skipping `%s` on %s: %v", sc.command, host, err)
+		record(result{Host: host, File: file, Command: sc.command, Skipped: err.Error()})
+		return nil
+	} else if err != nil {
+		record(result{Host: host, File: file, Command: sc.command, Skipped: err.Error()})
+		return err
+	}
+
+	r.out.command(host, sc.command)
+
+	res := result{Host: host, File: file, Command: sc.command}
 
 	session, err := client.NewSession()
 	if err != nil {
-		return errors.Wrap(err, "asdf")
+		res.ExitCode, res.Error = -1, err.Error()
+		record(res)
+		// the host was already connected to, so its connection was lost since
+		return connectionLostError{err: errors.Wrap(err, "failed to create session")}
 	}
+	defer func() { _ = session.Close() }()
 
 	stdin := combine(substitute(sc.stdin, map[string]string{
-		"PASSWORD": pass,
+		"PASSWORD": r.password(host),
 	}))
 
-	session.Stdin = strings.NewReader(stdin)
+	session.Stdin = strings.NewReader(stdin + sc.payload)
+
+	var history string
+	sh, err := r.shell(host)
+	if err == nil {
+		history, err = r.historyMode(host)
+	}
+	if err != nil {
+		res.ExitCode, res.Error = -1, err.Error()
+		record(res)
+		return err
+	}
+
+	run := sc.command
+	if sc.upload != "" {
+		var path string
+		if sh != shellSh {
+			err = errors.New("uploaded steps require a POSIX shell")
+		} else {
+			run, path, err = r.uploadScript(client, host, sc)
+		}
+		if err != nil {
+			res.ExitCode, res.Error = -1, err.Error()
+			record(res)
+			return err
+		}
+		defer r.removeUpload(client, host, path)
+	}
+
+	command, marker := run, ""
+	if !p.noShellWrapper {
+		prelude, err := sh.prelude(sc.cwd, sc.umask)
+		if err != nil {
+			res.ExitCode, res.Error = -1, err.Error()
+			record(res)
+			return err
+		}
+		statements := setenv(session, sh, withLocale(sc.env, sh, r.locale))
+		if h := historyStatement(history, sh); h != "" {
+			statements = append([]string{h}, statements...)
+		}
+		if prelude != "" {
+			statements = append(statements, prelude)
+		}
+		command = strings.Join(append(statements, run), " ")
+		if r.frame && sh == shellSh {
+			marker = frameMarker + r.id
+			command = framed(command, marker)
+		}
+		command = sh.wrap(command)
+		if r.usage && sh == shellSh {
+			command = withUsage(command)
+		}
+	}
+	if user := r.becomeUser(host); user != "" {
+		var password string
+		switch {
+		case sh != shellSh:
+			err = errors.New("becoming another user requires a POSIX shell")
+		case sc.upload != "":
+			err = errors.New("uploaded steps are private to the login user, so cannot run as another")
+		default:
+			command, password, err = r.become(client, host, user, command)
+		}
+		if err != nil {
+			res.ExitCode, res.Error = -1, err.Error()
+			record(res)
+			return err
+		}
+		session.Stdin = strings.NewReader(password + stdin + sc.payload)
+	}
+	command = withoutHistory(history, command)
 
 	modes := ssh.TerminalModes{
 		ssh.ECHO:          0,
@@ -203,45 +1191,253 @@ func executeScript(client *ssh.Client, user, pass, host string, sc script) error
 		ssh.TTY_OP_OSPEED: 14400,
 	}
 
-	if err := session.RequestPty("xterm", 40, 80, modes); err != nil {
-		return errors.Wrap(err, "request pty failed")
+	if sh.pty() && !p.noPTY {
+		if err := session.RequestPty("xterm", 40, 80, modes); err != nil {
+			res.ExitCode, res.Error = -1, err.Error()
+			record(res)
+			return errors.Wrap(err, "request pty failed")
+		}
 	}
 
-	bs, err := session.CombinedOutput(sc.command)
+	timeout := sc.timeout
+	if timeout == 0 {
+		timeout = r.timeout
+	}
 
-	// print the output regardless of err
-	output := strings.TrimSpace(string(bs))
-	if len(output) == 0 {
-		color.Magenta("<no output>")
-	} else {
-		color.Blue(output)
+	var timer *time.Timer
+	exited := r.track(session)
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { terminate(session, exited) })
 	}
 
-	return err
+	start := time.Now()
+	captured := r.newCapture(host)
+	defer captured.close()
+	var out *stream
+	session.Stdout, session.Stderr = captured, captured
+	if r.flushInterval > 0 {
+		fr := &framer{marker: marker}
+		out = newStream(r.flushInterval, captured, func(text string) {
+			if text = fr.filter(text); text != "" {
+				r.out.output(host, redact(text, r.secrets))
+			}
+		})
+		session.Stdout, session.Stderr = out, out
+	}
+	err = session.Run(command)
+	// the command timed out only if it was terminated before it returned
+	timedOut := timer != nil && !timer.Stop()
+	if out != nil {
+		out.close()
+	}
+	res.Seconds = time.Since(start).Seconds()
+	r.untrack(session)
+
+	if timedOut {
+		err = timeoutError{after: timeout}
+	} else if _, exited := err.(*ssh.ExitError); err != nil && !exited && !alive(client, lostWait) {
+		// the output captured so far is kept
+		err = connectionLostError{after: time.Since(start)}
+	}
+
+	// render the output regardless of err, unless it was already streamed
+	output, used := splitUsage(strings.TrimSpace(unframe(captured.String(), marker)))
+	output, notes := splitNotes(redact(output, r.secrets))
+	if note := captured.note(); note != "" {
+		notes = append(notes, note)
+	}
+	res.Usage, res.Notes = used, notes
+	if len(output) > 0 && r.flushInterval == 0 {
+		r.out.output(host, output)
+	}
+
+	res.Output = output
+	res.ExitCode = exitCode(err)
+	if _, exited := err.(*ssh.ExitError); exited && len(sc.expectExit) > 0 {
+		// the exit code is checked as an expectation instead
+		err = nil
+	} else if exited {
+		err = execError{exitCode: res.ExitCode, err: err.(*ssh.ExitError)}
+	}
+	if err != nil {
+		res.Error = err.Error()
+		record(res)
+		return err
+	}
+
+	failed := evaluate(host, sc, output, res.ExitCode)
+	for _, f := range failed {
+		res.Failed = append(res.Failed, fmt.Sprintf("%s (got %s)", f.check, f.actual))
+	}
+	record(res)
+
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// release the connection to host if each command runs on a connection
+// of its own, having already verified the host can be dialed, returning
+// whether it was released.
+func (r *runner) release(client *ssh.Client, host string) bool {
+	if p, err := r.profile(host); err == nil && p.singleSession {
+		_ = client.Close()
+		return true
+	}
+	return false
+}
+
+// dial host, authenticating with the credentials for that host.
+func (r *runner) dial(host string) (*ssh.Client, error) {
+	creds, err := r.credentials(host)
+	if err != nil {
+		return nil, authError{err: err}
+	}
+
+	r.lock.Lock()
+	r.passwords[host] = creds.password
+	r.lock.Unlock()
+
+	if client, ok := r.controlMaster(host); ok {
+		return client, nil
+	}
+	client, err := r.connect(host, creds)
+	return client, dialFailed(err)
 }
 
-func makeClient(user, pass, host string) (*ssh.Client, error) {
+// connect to host as configured for it in ssh_config, which may set its
+// address, user and keys, and jump hosts to connect through.
+func (r *runner) connect(host string, creds credentials) (*ssh.Client, error) {
+	cfg := r.sshConfig.lookup(host)
+	user := r.loginUser(host, cfg)
+	creds.signers = append(creds.signers, cfg.signers(user)...)
+
+	var hops []*ssh.Client
+	closeHops := func() {
+		for i := len(hops) - 1; i >= 0; i-- {
+			_ = hops[i].Close()
+		}
+	}
+
+	dial := r.dialer
+	for _, jump := range jumps(cfg.proxyJump) {
+		jc := r.sshConfig.lookup(jump.host)
+		jumpUser := user
+		switch {
+		case jump.user != "":
+			jumpUser = jump.user
+		case jc.user != "" && !r.userSet:
+			jumpUser = jc.user
+		}
+		if jump.port != "" {
+			jc.port = jump.port
+		}
+
+		jumpCreds, err := r.credentials(jump.host)
+		if err != nil {
+			closeHops()
+			return nil, err
+		}
+		jumpCreds.signers = append(jumpCreds.signers, jc.signers(jumpUser)...)
+
+		client, err := makeClient(jumpUser, jumpCreds, r.hostKeys, dial, jc.address())
+		if err != nil {
+			closeHops()
+			return nil, errors.Wrapf(err, "failed to connect to jump host %s", jump.host)
+		}
+		hops = append(hops, client)
+		dial = client.Dial
+	}
+
+	proxy := cfg.proxyJump
+	if proxy == "" {
+		proxy = r.proxy
+	}
+	dial = r.cachedDial(host, proxy, dial)
+	client, err := makeClient(user, creds, r.cachedHostKeys(host, r.hostKeys), dial, cfg.address())
+	if err != nil {
+		closeHops()
+		return nil, err
+	}
+
+	if len(hops) > 0 {
+		go func() {
+			_ = client.Wait()
+			closeHops()
+		}()
+	}
+	return client, nil
+}
+
+// password returns the password to send on stdin to scripts on host.
+func (r *runner) password(host string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if password, exists := r.passwords[host]; exists {
+		return password
+	}
+	return r.pass
+}
+
+func makeClient(user string, creds credentials, hostKeys ssh.HostKeyCallback, dial dialer, address string) (*ssh.Client, error) {
 	config := &ssh.ClientConfig{
 		User:            user,
-		Auth:            newSSHAuth(user, pass),
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Auth:            newSSHAuth(user, hostname(address), creds),
+		HostKeyCallback: hostKeys,
 	}
 
-	address := fmt.Sprintf("%s:22", host)
-	return ssh.Dial("tcp", address, config)
+	if dial == nil {
+		dial = net.Dial
+	}
+
+	conn, err := dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
-func newSSHAuth(user, pass string) []ssh.AuthMethod {
+func newSSHAuth(user, host string, creds credentials) []ssh.AuthMethod {
 	authMethods := make([]ssh.AuthMethod, 0)
+
+	// Kerberos tickets are tried first, as by OpenSSH
+	if gssapi := gssapiAuth(host); gssapi != nil {
+		authMethods = append(authMethods, gssapi)
+	}
+
+	// explicitly configured keys are tried before those of the agent
+	if len(creds.signers) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeys(creds.signers...))
+	}
+
 	sshAgent := sshAgentAuth()
 	if sshAgent != nil {
 		authMethods = append(authMethods, sshAgent)
 	}
 
-	if pass == "" {
+	if creds.password == "" {
 		authMethods = append(authMethods, PasswordCallback(user))
 	} else {
-		authMethods = append(authMethods, ssh.Password(pass))
+		authMethods = append(authMethods, ssh.Password(creds.password))
 	}
+
+	// tried last, for hosts requiring a one-time password
+	authMethods = append(authMethods, keyboardInteractive(creds))
 	return authMethods
 }
+
+// hostname returns the host of address, without its port.
+func hostname(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
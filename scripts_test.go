@@ -1,6 +1,10 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -54,6 +58,11 @@ PASSWORD
 # comment 4
 `
 
+const file5 = `
+# assert: .disk_used_pct < 90
+df --output=pcent /
+`
+
 func Test_parseScript(t *testing.T) {
 	tests := []struct {
 		content    string
@@ -134,3 +143,164 @@ func Test_parseScript(t *testing.T) {
 		}
 	}
 }
+
+func Test_parseScript_directives(t *testing.T) {
+	scriptFile, err := parse("4-script5", file5)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(scriptFile.scripts))
+	require.Equal(t, "df --output=pcent /", scriptFile.scripts[0].command)
+	require.Equal(t, []assertion{
+		{field: "disk_used_pct", operator: "<", expected: "90"},
+	}, scriptFile.scripts[0].asserts)
+}
+
+func Test_load_overlay(t *testing.T) {
+	base, err := ioutil.TempDir("", "base")
+	require.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	prod, err := ioutil.TempDir("", "prod")
+	require.NoError(t, err)
+	defer os.RemoveAll(prod)
+
+	write := func(dir, name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write(base, "0-uname", "uname -a")
+	write(base, "1-restart", "systemctl restart app")
+	write(prod, "1-restart", "systemctl restart app-prod")
+	write(prod, "2-check", "systemctl status app-prod")
+	write(base, "db/3-check", "systemctl status postgresql")
+	write(base, "web/3-check", "systemctl status nginx")
+	write(prod, "web/3-check", "systemctl status nginx-prod")
+
+	scripts, err := load(args{scriptDirs: stringsFlag{base, prod}})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(scripts))
+	require.Equal(t, "0-uname", scripts[0].name)
+	require.Equal(t, "1-restart", scripts[1].name)
+	require.Equal(t, "systemctl restart app-prod", scripts[1].scripts[0].command)
+	require.Equal(t, "2-check", scripts[2].name)
+	require.Equal(t, "db/3-check", scripts[3].name)
+	require.Equal(t, "systemctl status postgresql", scripts[3].scripts[0].command)
+	require.Equal(t, "web/3-check", scripts[4].name)
+	require.Equal(t, "systemctl status nginx-prod", scripts[4].scripts[0].command)
+}
+
+func Test_load_include(t *testing.T) {
+	dir, err := ioutil.TempDir("", "include")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write("common/setup.script", "#include proxy.script\n---\nyum-config-manager --enable extras")
+	write("common/proxy.script", "export https_proxy=http://proxy:3128")
+	write("1-install", "#include common/setup.script\n---\nyum install -y app")
+
+	scripts, err := loadDir(dir)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(scripts))
+	require.Equal(t, "1-install", scripts[0].name)
+
+	var commands []string
+	for _, sc := range scripts[0].scripts {
+		commands = append(commands, sc.command)
+	}
+	require.Equal(t, []string{
+		"export https_proxy=http://proxy:3128",
+		"yum-config-manager --enable extras",
+		"yum install -y app",
+	}, commands)
+
+	write("common/proxy.script", "#include ../1-install")
+	_, err = loadDir(dir)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "include cycle")
+}
+
+const file6 = `
+tee /etc/ssl/app.pem
+<<PEM
+-----BEGIN CERTIFICATE-----
+  # not a comment
+-----END CERTIFICATE-----
+PEM
+---
+sudo -S psql
+PASSWORD
+<<SQL
+SELECT 1;
+SQL
+`
+
+func Test_parse_heredoc(t *testing.T) {
+	sf, err := parse("6-heredoc", file6)
+	require.NoError(t, err)
+	require.Equal(t, []script{
+		{
+			command: "tee /etc/ssl/app.pem",
+			payload: "-----BEGIN CERTIFICATE-----\n  # not a comment\n-----END CERTIFICATE-----\n",
+		},
+		{
+			command: "sudo -S psql",
+			stdin:   []string{"PASSWORD"},
+			payload: "SELECT 1;\n",
+		},
+	}, sf.scripts)
+
+	_, err = parse("7-unterminated", "cat\n<<EOF\nfoo\n")
+	require.Error(t, err)
+}
+
+func Test_load_stdinFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stdin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "scripts"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "payload.json"), []byte(`{"a": 1}`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "scripts", "1-post"), []byte("curl -d @- localhost\n@stdin-file ../payload.json"), 0644))
+
+	scripts, err := loadDir(filepath.Join(dir, "scripts"))
+	require.NoError(t, err)
+	require.Equal(t, `{"a": 1}`, scripts[0].scripts[0].payload)
+}
+
+func Test_readScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "script")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "proxy.script"), []byte("export https_proxy=http://proxy:3128"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1-install"), []byte("#include proxy.script\n---\nyum install -y app"), 0644))
+
+	sf, err := readScript(filepath.Join(dir, "1-install"), strings.NewReader(""))
+	require.NoError(t, err)
+	require.Equal(t, "1-install", sf.name)
+	require.Len(t, sf.scripts, 2)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer func() { _ = os.Chdir(wd) }()
+
+	sf, err = readScript("-", strings.NewReader("#include proxy.script\n---\nsystemctl restart app\n"))
+	require.NoError(t, err)
+	require.Equal(t, stdinScript, sf.name)
+	require.Equal(t, "", sf.path)
+	require.Equal(t, "systemctl restart app", sf.scripts[1].command)
+	require.Contains(t, sf.source, "export https_proxy")
+
+	digest, err := scriptDigest(sf)
+	require.NoError(t, err)
+	require.Len(t, digest, 64)
+
+	_, err = readScript("-", strings.NewReader("\n  \n"))
+	require.EqualError(t, err, "no script on stdin")
+}
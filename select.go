@@ -0,0 +1,126 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	setRequirementRe   = regexp.MustCompile(`^([[:word:].-]+)\s+(in|notin)\s+\((.*)\)$`)
+	valueRequirementRe = regexp.MustCompile(`^([[:word:].-]+)\s*(==|!=|=)\s*(.*)$`)
+	labelRe            = regexp.MustCompile(`^!?[[:word:].-]+$`)
+)
+
+// A requirement is a term of a label selector, which hosts match by the
+// labels (metadata) they have in the inventory, e.g. "env!=prod".
+type requirement struct {
+	key      string
+	operator string // =, !=, in, notin, exists or !exists
+	values   []string
+}
+
+// parseSelector parses a label selector of --select: requirements
+// separated by commas, all of which hosts must match, of the forms
+//
+//	key=value, key==value  the label has the value
+//	key!=value             the label does not have the value (or is missing)
+//	key in (a,b)           the label has one of the values
+//	key notin (a,b)        the label has none of the values (or is missing)
+//	key                    the label is set
+//	!key                   the label is not set
+func parseSelector(s string) ([]requirement, error) {
+	var reqs []requirement
+	for _, term := range splitSelector(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var req requirement
+		if m := setRequirementRe.FindStringSubmatch(term); m != nil {
+			req = requirement{key: m[1], operator: m[2]}
+			for _, v := range strings.Split(m[3], ",") {
+				req.values = append(req.values, strings.TrimSpace(v))
+			}
+		} else if m := valueRequirementRe.FindStringSubmatch(term); m != nil {
+			req = requirement{key: m[1], operator: strings.Replace(m[2], "==", "=", 1), values: []string{strings.TrimSpace(m[3])}}
+		} else if labelRe.MatchString(term) {
+			req = requirement{key: strings.TrimPrefix(term, "!"), operator: "exists"}
+			if strings.HasPrefix(term, "!") {
+				req.operator = "!exists"
+			}
+		} else {
+			return nil, errors.Errorf("malformed selector requirement %q, expected e.g. role=web, env!=prod or dc in (ams1,fra1)", term)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// splitSelector splits s on the commas which are not within parentheses.
+func splitSelector(s string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+// matches returns whether meta satisfies req. Labels of several values
+// separated by commas, like groups, have each of them.
+func (req requirement) matches(meta metadata) bool {
+	value, exists := meta[req.key]
+	has := func(values []string) bool {
+		if !exists {
+			return false
+		}
+		for _, v := range strings.Split(value, ",") {
+			for _, want := range values {
+				if v == want {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	switch req.operator {
+	case "=", "in":
+		return has(req.values)
+	case "!=", "notin":
+		return !has(req.values)
+	case "exists":
+		return exists
+	default:
+		return !exists
+	}
+}
+
+// selected returns the hosts whose metadata in inv match every requirement.
+func selected(hosts []string, inv inventory, reqs []requirement) []string {
+	var matched []string
+	for _, host := range hosts {
+		meta := inv.metadata(host)
+		all := true
+		for _, req := range reqs {
+			all = all && req.matches(meta)
+		}
+		if all {
+			matched = append(matched, host)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSelector(t *testing.T) {
+	reqs, err := parseSelector("role=web, env!=prod,dc in (ams1, fra1),canary,!retired")
+	require.NoError(t, err)
+	require.Equal(t, []requirement{
+		{key: "role", operator: "=", values: []string{"web"}},
+		{key: "env", operator: "!=", values: []string{"prod"}},
+		{key: "dc", operator: "in", values: []string{"ams1", "fra1"}},
+		{key: "canary", operator: "exists"},
+		{key: "retired", operator: "!exists"},
+	}, reqs)
+
+	_, err = parseSelector("role=web,env<prod")
+	require.EqualError(t, err, `malformed selector requirement "env<prod", expected e.g. role=web, env!=prod or dc in (ams1,fra1)`)
+}
+
+func Test_narrow_select(t *testing.T) {
+	inv, err := parseInventory(`web{1..2} role=web env=prod dc=ams1
+web3 role=web env=staging dc=fra1
+db1 role=db env=prod dc=ams1 groups=backup,primary
+db2 role=db dc=nyc1 groups=backup retired=true
+`)
+	require.NoError(t, err)
+	hosts := inv.hosts()
+
+	tests := []struct {
+		selector string
+		expected []string
+	}{
+		{"role=web,env!=prod", []string{"web3"}},
+		{"env!=prod", []string{"web3", "db2"}},
+		{"dc in (ams1,fra1),role==db", []string{"db1"}},
+		{"dc notin (ams1,fra1)", []string{"db2"}},
+		{"groups=backup,!retired", []string{"db1"}},
+		{"retired", []string{"db2"}},
+	}
+	for _, test := range tests {
+		narrowed, err := narrow(args{selector: test.selector}, inv, hosts)
+		require.NoError(t, err)
+		require.Equal(t, test.expected, narrowed, test.selector)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxServedRuns is how many runs the server keeps, with their results,
+// before forgetting the oldest (which remain in the history log).
+const maxServedRuns = 100
+
+// A runRequest is posted to the server to start a run of a command, or of
+// scripts of the server's script directories.
+type runRequest struct {
+	Command string            `json:"command,omitempty"`
+	Scripts []string          `json:"scripts,omitempty"`
+	Hosts   string            `json:"hosts,omitempty"` // all hosts of the server if empty
+	Vars    map[string]string `json:"vars,omitempty"`
+}
+
+// A servedRun is a run started through the API, which renders its results
+// to the clients streaming them as they arrive.
+type servedRun struct {
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	Items   []string  `json:"items"`
+	Hosts   []string  `json:"hosts"`
+	Started time.Time `json:"started"`
+	Status  string    `json:"status"`
+	Results []result  `json:"results"`
+
+	lock    sync.Mutex
+	changed *sync.Cond
+}
+
+func newServedRun(kind string, items, hosts []string) *servedRun {
+	sr := &servedRun{
+		ID:      newRunID(),
+		Kind:    kind,
+		Items:   items,
+		Hosts:   hosts,
+		Started: time.Now().UTC(),
+		Status:  "running",
+		Results: []result{},
+	}
+	sr.changed = sync.NewCond(&sr.lock)
+	return sr
+}
+
+func (sr *servedRun) plan(string, []string, []string) {}
+func (sr *servedRun) message(string, ...interface{})  {}
+func (sr *servedRun) warning(string, ...interface{})  {}
+func (sr *servedRun) begin(string, string)            {}
+func (sr *servedRun) command(string, string)          {}
+func (sr *servedRun) output(string, string)           {}
+func (sr *servedRun) end(string, string)              {}
+func (sr *servedRun) summary(report)                  {}
+
+func (sr *servedRun) result(res result) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.Results = append(sr.Results, res)
+	sr.changed.Broadcast()
+}
+
+func (sr *servedRun) finish(err error) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.Status = status(err)
+	sr.changed.Broadcast()
+}
+
+// next waits for the results after the first i, returning them and
+// whether the run has completed, or nothing once ctx is done.
+func (sr *servedRun) next(ctx context.Context, i int) ([]result, bool) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sr.lock.Lock()
+			sr.changed.Broadcast()
+			sr.lock.Unlock()
+		case <-stop:
+		}
+	}()
+
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	for len(sr.Results) == i && sr.Status == "running" && ctx.Err() == nil {
+		sr.changed.Wait()
+	}
+	return append([]result(nil), sr.Results[i:]...), sr.Status != "running"
+}
+
+// server exposes a pool over HTTP, authenticated by a bearer token.
+type server struct {
+	args    args
+	pool    *pool
+	hosts   []string
+	scripts []scriptfile
+	token   string
+
+	lock  sync.Mutex
+	runs  map[string]*servedRun
+	order []string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	given := r.Header.Get("Authorization")
+	if !strings.HasPrefix(given, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(given, "Bearer ")), []byte(s.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/hosts":
+		writeJSON(w, http.StatusOK, s.hosts)
+	case r.Method == http.MethodGet && r.URL.Path == "/scripts":
+		names := make([]string, 0, len(s.scripts))
+		for _, sf := range s.scripts {
+			names = append(names, sf.name)
+		}
+		writeJSON(w, http.StatusOK, names)
+	case r.Method == http.MethodGet && r.URL.Path == "/history":
+		s.history(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/runs":
+		s.start(w, r)
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "runs":
+		if sr := s.run(parts[1]); sr != nil {
+			sr.lock.Lock()
+			defer sr.lock.Unlock()
+			writeJSON(w, http.StatusOK, sr)
+		} else {
+			http.Error(w, "no such run", http.StatusNotFound)
+		}
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "runs" && parts[2] == "results":
+		if sr := s.run(parts[1]); sr != nil {
+			streamResults(w, r, sr)
+		} else {
+			http.Error(w, "no such run", http.StatusNotFound)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *server) run(id string) *servedRun {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.runs[id]
+}
+
+// start a run of the request, responding with it before it completes.
+func (s *server) start(w http.ResponseWriter, r *http.Request) {
+	var request runRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "malformed request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sr, execute, err := s.prepare(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.lock.Lock()
+	s.runs[sr.ID] = sr
+	s.order = append(s.order, sr.ID)
+	if len(s.order) > maxServedRuns {
+		delete(s.runs, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.lock.Unlock()
+
+	go execute()
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": sr.ID})
+}
+
+// prepare the run of request, returning it and the func executing it.
+func (s *server) prepare(request runRequest) (*servedRun, func(), error) {
+	hosts := s.hosts
+	if request.Hosts != "" {
+		var err error
+		if hosts, err = hostsOf(request.Hosts, s.pool.r.inventory, s.hosts); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var (
+		sr    *servedRun
+		files []scriptfile
+	)
+	switch {
+	case request.Command != "" && len(request.Scripts) == 0:
+		sr = newServedRun("command", []string{request.Command}, hosts)
+	case request.Command == "" && len(request.Scripts) > 0:
+		for _, name := range request.Scripts {
+			sf, exists := s.script(name)
+			if !exists {
+				return nil, nil, errors.Errorf("no such script %q", name)
+			}
+			files = append(files, sf)
+		}
+		if _, err := deprecations(files, time.Now(), false); err != nil {
+			return nil, nil, err
+		}
+		sr = newServedRun("scripts", request.Scripts, hosts)
+	default:
+		return nil, nil, errors.New("exactly one of command or scripts is required")
+	}
+
+	vars, err := params(files, request.Vars, nil, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	run := s.pool.runner(s.args, hosts, sr)
+	run.id, run.params = sr.ID, vars
+	return sr, func() {
+		// served runs are cancelled and paused through their id, like any other
+		if c, err := openControl(sr.ID); err != nil {
+			colors.failure.println("run %s cannot be controlled: %v", sr.ID, err)
+		} else {
+			done := make(chan struct{})
+			defer func() { close(done); c.close() }()
+			go run.watch(c, done)
+		}
+
+		err := s.pool.each(run, hosts, s.args.parallel, func(client *ssh.Client, host string) error {
+			if sr.Kind == "command" {
+				return run.executeCommand(client, host, request.Command, false, nil)
+			}
+			for _, sf := range files {
+				if err := run.executeScriptFile(client, host, sf); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		sr.finish(err)
+		run.remember(sr.Started, sr.Kind, sr.Items, hosts, err)
+	}, nil
+}
+
+func (s *server) script(name string) (scriptfile, bool) {
+	for _, sf := range s.scripts {
+		if sf.name == name {
+			return sf, true
+		}
+	}
+	return scriptfile{}, false
+}
+
+// streamResults writes the results of sr as lines of JSON as they arrive, until
+// the run completes, or the client of r disconnects.
+func streamResults(w http.ResponseWriter, r *http.Request, sr *servedRun) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for i := 0; ; {
+		results, done := sr.next(r.Context(), i)
+		if r.Context().Err() != nil {
+			return
+		}
+		for _, res := range results {
+			if err := encoder.Encode(res); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		i += len(results)
+		if done && len(results) == 0 {
+			return
+		}
+	}
+}
+
+// history responds with the last n (default 20) runs of the history log.
+func (s *server) history(w http.ResponseWriter, r *http.Request) {
+	n := 20
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 1 {
+			http.Error(w, "malformed n", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := readHistory(historyPath())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// serveCmd implements "commando serve", which keeps connections to the
+// hosts of a fleet open like the daemon, and exposes an HTTP API to run
+// commands and scripts on them, stream their results and query history.
+func serveCmd(arguments []string) error {
+	var args args
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	refresh := poolFlags(flags, &args)
+	flags.Var(&args.scriptDirs, "scripts", "the directory full of scripts which may be run (may be repeated)")
+	listen := flags.String("listen", "127.0.0.1:8080", "address to serve the API on")
+	tokenFile := flags.String("token-file", "", "file of the bearer token clients must send (default $COMMANDO_TOKEN)")
+	_ = flags.Parse(arguments)
+
+	token := os.Getenv("COMMANDO_TOKEN")
+	if *tokenFile != "" {
+		bs, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read token")
+		}
+		token = strings.TrimSpace(string(bs))
+	}
+	if token == "" {
+		return errors.New("--token-file or $COMMANDO_TOKEN is required, so the API is authenticated")
+	}
+
+	var scripts []scriptfile
+	if len(args.scriptDirs) > 0 {
+		var err error
+		if scripts, err = load(args); err != nil {
+			return errors.Wrap(err, "failed to load scripts")
+		}
+	}
+
+	p, hosts, err := startPool(args, *refresh)
+	if err != nil {
+		return err
+	}
+
+	s := &server{args: args, pool: p, hosts: hosts, scripts: scripts, token: token, runs: make(map[string]*servedRun)}
+	colors.info.println("serving %d hosts and %d scripts on http://%s", len(hosts), len(scripts), *listen)
+	return http.ListenAndServe(*listen, s)
+}
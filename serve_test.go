@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_server(t *testing.T) {
+	sr := newServedRun("command", []string{"uptime"}, []string{"web1", "web2"})
+	s := &server{hosts: []string{"web1", "web2"}, token: "secret", runs: map[string]*servedRun{sr.ID: sr},
+		pool: newPool(&runner{})}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	get := func(path, token string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		return response
+	}
+
+	response := get("/hosts", "guess")
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// the token is only accepted with the bearer scheme
+	request, err := http.NewRequest(http.MethodGet, srv.URL+"/hosts", nil)
+	require.NoError(t, err)
+	request.Header.Set("Authorization", "secret")
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	response = get("/hosts", "secret")
+	var hosts []string
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&hosts))
+	require.Equal(t, []string{"web1", "web2"}, hosts)
+
+	require.Equal(t, http.StatusNotFound, get("/runs/nope", "secret").StatusCode)
+
+	post := func(body string) *http.Response {
+		request, err := http.NewRequest(http.MethodPost, srv.URL+"/runs", strings.NewReader(body))
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "Bearer secret")
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		return response
+	}
+	require.Equal(t, http.StatusBadRequest, post(`{"command": "uptime", "scripts": ["motd"]}`).StatusCode)
+
+	// runs may only target hosts of the server
+	response = post(`{"command": "uptime", "hosts": "web1,evil.example.com"}`)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, "evil.example.com is not one of the hosts served\n", string(body))
+	require.Equal(t, http.StatusBadRequest, post(`{"command": "uptime", "hosts": "consul:web"}`).StatusCode)
+
+	// results stream as they arrive, until the run completes
+	sr.result(result{Host: "web1", Command: "uptime", Output: "up 1 day"})
+	response = get("/runs/"+sr.ID+"/results", "secret")
+	defer func() { _ = response.Body.Close() }()
+	scanner := bufio.NewScanner(response.Body)
+
+	require.True(t, scanner.Scan())
+	require.Contains(t, scanner.Text(), `"host":"web1"`)
+	sr.result(result{Host: "web2", Command: "uptime", Output: "up 2 days"})
+	require.True(t, scanner.Scan())
+	require.Contains(t, scanner.Text(), `"host":"web2"`)
+	sr.finish(nil)
+	require.False(t, scanner.Scan())
+
+	var run servedRun
+	require.NoError(t, json.NewDecoder(get("/runs/"+sr.ID, "secret").Body).Decode(&run))
+	require.Equal(t, "completed", run.Status)
+	require.Len(t, run.Results, 2)
+}
+
+func Test_servedRun_next_disconnect(t *testing.T) {
+	sr := newServedRun("command", []string{"uptime"}, []string{"web1"})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// clients streaming results stop waiting once they disconnect
+	returned := make(chan bool)
+	go func() {
+		results, done := sr.next(ctx, 0)
+		returned <- len(results) == 0 && !done
+	}()
+	cancel()
+	select {
+	case empty := <-returned:
+		require.True(t, empty)
+	case <-time.After(5 * time.Second):
+		t.Fatal("next did not return once the client disconnected")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Packets of version 3 of the SFTP protocol (draft-ietf-secsh-filexfer-02),
+// of which only what is needed to write new files is implemented.
+const (
+	sftpVersion     = 3
+	sftpChunk       = 32 * 1024
+	sftpWrite       = 0x02
+	sftpCreate      = 0x08
+	sftpExclusive   = 0x20
+	sftpPermissions = 0x04
+	sftpOK          = 0
+)
+
+type (
+	sftpInitPacket struct {
+		Version uint32 `sshtype:"1"`
+	}
+	sftpVersionPacket struct {
+		Version    uint32 `sshtype:"2"`
+		Extensions []byte `ssh:"rest"`
+	}
+	sftpOpenPacket struct {
+		ID          uint32 `sshtype:"3"`
+		Path        string
+		Flags       uint32
+		AttrFlags   uint32
+		Permissions uint32
+	}
+	sftpClosePacket struct {
+		ID     uint32 `sshtype:"4"`
+		Handle string
+	}
+	sftpWritePacket struct {
+		ID     uint32 `sshtype:"6"`
+		Handle string
+		Offset uint64
+		Data   []byte
+	}
+	sftpStatusPacket struct {
+		ID      uint32 `sshtype:"101"`
+		Code    uint32
+		Message string
+		Rest    []byte `ssh:"rest"`
+	}
+	sftpHandlePacket struct {
+		ID     uint32 `sshtype:"102"`
+		Handle string
+	}
+)
+
+// An sftpSession is the sftp subsystem of a session, sending one request
+// at a time.
+type sftpSession struct {
+	in  io.Writer
+	out io.Reader
+	id  uint32
+}
+
+// sftpPut writes content to a new file at path (which must not exist)
+// on the host of client over SFTP, with permissions mode.
+func sftpPut(client *ssh.Client, path string, content []byte, mode uint32) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return errors.Wrap(err, "failed to create session")
+	}
+	defer func() { _ = session.Close() }()
+
+	s := &sftpSession{}
+	if s.in, err = session.StdinPipe(); err != nil {
+		return errors.Wrap(err, "failed to open sftp")
+	}
+	if s.out, err = session.StdoutPipe(); err != nil {
+		return errors.Wrap(err, "failed to open sftp")
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return errors.Wrap(err, "sftp is not available")
+	}
+
+	var version sftpVersionPacket
+	if err := s.roundTrip(sftpInitPacket{Version: sftpVersion}, &version); err != nil {
+		return errors.Wrap(err, "failed to start sftp")
+	}
+
+	var handle sftpHandlePacket
+	err = s.roundTrip(sftpOpenPacket{
+		ID:          s.next(),
+		Path:        path,
+		Flags:       sftpWrite | sftpCreate | sftpExclusive,
+		AttrFlags:   sftpPermissions,
+		Permissions: mode,
+	}, &handle)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", path)
+	}
+
+	for offset := 0; offset < len(content); offset += sftpChunk {
+		end := offset + sftpChunk
+		if end > len(content) {
+			end = len(content)
+		}
+		packet := sftpWritePacket{ID: s.next(), Handle: handle.Handle, Offset: uint64(offset), Data: content[offset:end]}
+		if err := s.roundTrip(packet, nil); err != nil {
+			return errors.Wrapf(err, "failed to write %s", path)
+		}
+	}
+
+	return errors.Wrapf(s.roundTrip(sftpClosePacket{ID: s.next(), Handle: handle.Handle}, nil), "failed to close %s", path)
+}
+
+func (s *sftpSession) next() uint32 {
+	s.id++
+	return s.id
+}
+
+// roundTrip sends packet, and reads its reply into reply, or expects a
+// status of success if reply is nil.
+func (s *sftpSession) roundTrip(packet interface{}, reply interface{}) error {
+	bs := ssh.Marshal(packet)
+	frame := make([]byte, 4, 4+len(bs))
+	binary.BigEndian.PutUint32(frame, uint32(len(bs)))
+	if _, err := s.in.Write(append(frame, bs...)); err != nil {
+		return err
+	}
+
+	var n uint32
+	if err := binary.Read(s.out, binary.BigEndian, &n); err != nil {
+		return err
+	}
+	if n > 2*sftpChunk {
+		return errors.Errorf("sftp reply is too long (%d bytes)", n)
+	}
+	bs = make([]byte, n)
+	if _, err := io.ReadFull(s.out, bs); err != nil {
+		return err
+	}
+
+	var status sftpStatusPacket
+	if err := ssh.Unmarshal(bs, &status); err == nil {
+		if status.Code == sftpOK && reply == nil {
+			return nil
+		}
+		return errors.Errorf("sftp status %d: %s", status.Code, status.Message)
+	}
+	if reply == nil {
+		return errors.Errorf("unexpected sftp reply of type %d", bs[0])
+	}
+	return ssh.Unmarshal(bs, reply)
+}
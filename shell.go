@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// A shell determines how commands are executed on a host. Commands run in
+// a posix shell on a PTY by default, whereas Windows OpenSSH servers need
+// commands wrapped for powershell or cmd, and reject the PTY request.
+type shell string
+
+const (
+	shellSh         shell = "sh"
+	shellPowershell shell = "powershell"
+	shellCmd        shell = "cmd"
+)
+
+func parseShell(s string) (shell, error) {
+	switch sh := shell(s); sh {
+	case shellSh, shellPowershell, shellCmd:
+		return sh, nil
+	}
+	return "", errors.Errorf("unknown shell %q, must be one of sh, powershell, cmd", s)
+}
+
+// pty returns whether a PTY should be requested for commands run by the shell.
+func (sh shell) pty() bool {
+	return sh == shellSh
+}
+
+// export returns the statement which sets key to value in the shell.
+func (sh shell) export(key, value string) string {
+	switch sh {
+	case shellPowershell:
+		return fmt.Sprintf("$env:%s=%s;", key, strings.Replace(quote(value), `'\''`, `''`, -1))
+	case shellCmd:
+		return fmt.Sprintf("set %s=%s&&", key, value)
+	default:
+		// not "export K=V", which the bourne shell of solaris and aix rejects
+		return fmt.Sprintf("%s=%s; export %s;", key, quote(value), key)
+	}
+}
+
+// prelude returns the statements which change to the working directory
+// cwd, and set the umask, before a command (either may be empty). Only
+// posix shells have a umask.
+func (sh shell) prelude(cwd, umask string) (string, error) {
+	var statements []string
+	switch {
+	case cwd == "":
+	case sh == shellPowershell:
+		statements = append(statements, fmt.Sprintf("Set-Location -LiteralPath %s -ErrorAction Stop;", strings.Replace(quote(cwd), `'\''`, `''`, -1)))
+	case sh == shellCmd:
+		statements = append(statements, fmt.Sprintf(`cd /d "%s"&&`, cwd))
+	default:
+		statements = append(statements, fmt.Sprintf("cd %s || exit 1;", quote(cwd)))
+	}
+
+	switch {
+	case umask == "":
+	case sh != shellSh:
+		return "", errors.Errorf("umask is not supported by %s", sh)
+	default:
+		statements = append(statements, fmt.Sprintf("umask %s;", umask))
+	}
+	return strings.Join(statements, " "), nil
+}
+
+// wrap command so that it is executed by the shell.
+func (sh shell) wrap(command string) string {
+	switch sh {
+	case shellPowershell:
+		// encoding the command avoids the quoting rules of the windows command line
+		return "powershell -NoProfile -NonInteractive -EncodedCommand " + encodePowershell(command)
+	case shellCmd:
+		return "cmd /c " + command
+	default:
+		return command
+	}
+}
+
+// encodePowershell encodes command as expected by -EncodedCommand,
+// which is base64 of the UTF-16LE bytes of the command.
+func encodePowershell(command string) string {
+	units := utf16.Encode([]rune(command))
+	bs := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		bs = append(bs, byte(u), byte(u>>8))
+	}
+	return base64.StdEncoding.EncodeToString(bs)
+}
+
+// shell returns the shell to use on host, which may be set per host
+// with the "shell" label in the inventory, overriding --shell.
+func (r *runner) shell(host string) (shell, error) {
+	if s, exists := r.inventory.metadata(host)["shell"]; exists {
+		return parseShell(s)
+	}
+	return r.defaultShell, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_shell_wrap(t *testing.T) {
+	require.Equal(t, "uname -a", shellSh.wrap("uname -a"))
+	require.Equal(t, "cmd /c ver", shellCmd.wrap("ver"))
+
+	wrapped := shellPowershell.wrap("Get-Service")
+	prefix := "powershell -NoProfile -NonInteractive -EncodedCommand "
+	require.Equal(t, prefix, wrapped[:len(prefix)])
+	bs, err := base64.StdEncoding.DecodeString(wrapped[len(prefix):])
+	require.NoError(t, err)
+	require.Equal(t, []byte("G\x00e\x00t\x00-\x00S\x00e\x00r\x00v\x00i\x00c\x00e\x00"), bs)
+}
+
+func Test_shell_export(t *testing.T) {
+	require.Equal(t, `A='it'\''s'; export A;`, shellSh.export("A", "it's"))
+	require.Equal(t, `$env:A='it''s';`, shellPowershell.export("A", "it's"))
+	require.Equal(t, `set A=b&&`, shellCmd.export("A", "b"))
+}
+
+func Test_withLocale(t *testing.T) {
+	require.Equal(t, []string{"LC_ALL=C", "LC_ALL=de_DE.UTF-8"}, withLocale([]string{"LC_ALL=de_DE.UTF-8"}, shellSh, "C"))
+	require.Equal(t, []string{"A=1"}, withLocale([]string{"A=1"}, shellSh, ""))
+	require.Empty(t, withLocale(nil, shellPowershell, "C"))
+}
+
+func Test_shell_prelude(t *testing.T) {
+	prelude, err := shellSh.prelude("/opt/my app", "022")
+	require.NoError(t, err)
+	require.Equal(t, `cd '/opt/my app' || exit 1; umask 022;`, prelude)
+
+	prelude, err = shellPowershell.prelude(`C:\app`, "")
+	require.NoError(t, err)
+	require.Equal(t, `Set-Location -LiteralPath 'C:\app' -ErrorAction Stop;`, prelude)
+
+	_, err = shellCmd.prelude("", "077")
+	require.Error(t, err)
+
+	sf, err := parse("12-build", "# cwd: /srv/{{.app}}\n# umask: 0027\nmake")
+	require.NoError(t, err)
+	require.Equal(t, "/srv/{{.app}}", sf.scripts[0].cwd)
+	require.Equal(t, "0027", sf.scripts[0].umask)
+	_, err = parse("bad", "# umask: 999\nmake")
+	require.Error(t, err)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signalGrace is how long a remote process is given to exit after
+// being sent SIGTERM, before being sent SIGKILL.
+const signalGrace = 5 * time.Second
+
+// terminate stops the remote process of session by sending it SIGTERM,
+// escalating to SIGKILL if it has not exited (closed exited) within the
+// grace period, and finally closing the session itself.
+func terminate(session *ssh.Session, exited <-chan struct{}) {
+	defer func() { _ = session.Close() }()
+
+	for _, sig := range []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL} {
+		_ = session.Signal(sig)
+		select {
+		case <-exited:
+			return
+		case <-time.After(signalGrace):
+		}
+	}
+}
+
+// handleSignals cancels the run on the first SIGINT or SIGTERM, which stops
+// scheduling hosts and terminates in-flight sessions, so the results so far
+// can be summarized. A second signal exits immediately.
+func (r *runner) handleSignals(done <-chan struct{}) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case <-done:
+		return
+	case sig := <-signals:
+		r.out.warning("received %v, stopping (again to exit now)", sig)
+		r.cancel(true)
+	}
+
+	select {
+	case <-done:
+	case <-signals:
+		os.Exit(130)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A silencer silences alerts of hosts in a monitoring system while they
+// are being run on, returning a func clearing the silences it created.
+type silencer interface {
+	silence(targets []string, until time.Time, comment string) (func() error, error)
+}
+
+// newSilencer returns the silencer of a value of --silence, KIND=URL, for
+// kinds alertmanager (URL of Alertmanager) and nagios (URL of the cgi-bin
+// directory of Nagios). Credentials may be given in the URL.
+func newSilencer(spec, label string) (silencer, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.Errorf("malformed silence %q, must be KIND=URL", spec)
+	}
+	base := strings.TrimSuffix(parts[1], "/")
+	if _, err := url.Parse(base); err != nil {
+		return nil, errors.Wrapf(err, "malformed silence URL %q", base)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch parts[0] {
+	case "alertmanager":
+		return &alertmanager{url: base, label: label, client: client}, nil
+	case "nagios":
+		return &nagios{url: base, client: client}, nil
+	}
+	return nil, errors.Errorf("unknown silence %q, must be one of alertmanager, nagios", parts[0])
+}
+
+// alertmanager silences alerts whose label matches any of the targets.
+type alertmanager struct {
+	url    string
+	label  string
+	client *http.Client
+}
+
+type alertmanagerMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+}
+
+type alertmanagerSilence struct {
+	Matchers  []alertmanagerMatcher `json:"matchers"`
+	StartsAt  time.Time             `json:"startsAt"`
+	EndsAt    time.Time             `json:"endsAt"`
+	CreatedBy string                `json:"createdBy"`
+	Comment   string                `json:"comment"`
+}
+
+func (a *alertmanager) silence(targets []string, until time.Time, comment string) (func() error, error) {
+	quoted := make([]string, 0, len(targets))
+	for _, target := range targets {
+		quoted = append(quoted, regexp.QuoteMeta(target))
+	}
+
+	body, err := json.Marshal(alertmanagerSilence{
+		Matchers:  []alertmanagerMatcher{{Name: a.label, Value: strings.Join(quoted, "|"), IsRegex: true}},
+		StartsAt:  time.Now().UTC(),
+		EndsAt:    until.UTC(),
+		CreatedBy: "commando",
+		Comment:   comment,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode silence")
+	}
+
+	response, err := a.client.Post(a.url+"/api/v2/silences", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create silence")
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode >= 300 {
+		return nil, errors.Errorf("failed to create silence: unexpected status %s", response.Status)
+	}
+
+	var created struct {
+		ID string `json:"silenceID"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&created); err != nil {
+		return nil, errors.Wrap(err, "failed to decode silence")
+	}
+
+	return func() error {
+		request, err := http.NewRequest(http.MethodDelete, a.url+"/api/v2/silence/"+url.PathEscape(created.ID), nil)
+		if err != nil {
+			return err
+		}
+		return a.do(request)
+	}, nil
+}
+
+func (a *alertmanager) do(request *http.Request) error {
+	response, err := a.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode >= 300 {
+		return errors.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+// nagios schedules downtime of the targets, which are Nagios host names,
+// with commands of its cmd.cgi.
+type nagios struct {
+	url    string
+	client *http.Client
+}
+
+// commands of Nagios cmd.cgi
+const (
+	nagiosScheduleHostDowntime  = "55"
+	nagiosDeleteDowntimeForHost = "170"
+)
+
+func (n *nagios) silence(targets []string, until time.Time, comment string) (func() error, error) {
+	const layout = "01-02-2006 15:04:05"
+	for _, target := range targets {
+		err := n.command(nagiosScheduleHostDowntime, url.Values{
+			"host":         {target},
+			"com_author":   {"commando"},
+			"com_data":     {comment},
+			"start_time":   {time.Now().Format(layout)},
+			"end_time":     {until.Format(layout)},
+			"fixed":        {"1"},
+			"trigger":      {"0"},
+			"childoptions": {"0"},
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to schedule downtime of %s", target)
+		}
+	}
+
+	return func() error {
+		for _, target := range targets {
+			if err := n.command(nagiosDeleteDowntimeForHost, url.Values{"host": {target}}); err != nil {
+				return errors.Wrapf(err, "failed to delete downtime of %s", target)
+			}
+		}
+		return nil
+	}, nil
+}
+
+func (n *nagios) command(typ string, form url.Values) error {
+	form.Set("cmd_typ", typ)
+	form.Set("cmd_mod", "2") // commit
+	response, err := n.client.PostForm(n.url+"/cmd.cgi", form)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode >= 300 {
+		return errors.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+// silence silences hosts in every monitoring system of --silence, for
+// the expected duration of the run, returning a func clearing them.
+// Hosts are identified by the --silence-target template, expanded with
+// the variables of each host (e.g. its inventory metadata).
+func (r *runner) silence(hosts []string) (func(), error) {
+	if len(r.silencers) == 0 {
+		return func() {}, nil
+	}
+
+	targets := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		targets = append(targets, expandVars(r.silenceTarget, r.vars(host)))
+	}
+	until := time.Now().Add(r.silenceFor)
+	comment := "commando run " + r.id
+
+	var clears []func() error
+	clearAll := func() {
+		for _, clear := range clears {
+			if err := clear(); err != nil {
+				r.out.warning("failed to clear silence: %v", err)
+			}
+		}
+	}
+	for _, s := range r.silencers {
+		clear, err := s.silence(targets, until, comment)
+		if err != nil {
+			clearAll()
+			return nil, err
+		}
+		clears = append(clears, clear)
+	}
+	r.out.message("silenced %d hosts until %s", len(hosts), until.Format(time.Kitchen))
+	return clearAll, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_alertmanager_silence(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		created  alertmanagerSilence
+		requests []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodPost {
+			bs, _ := ioutil.ReadAll(r.Body)
+			_ = json.Unmarshal(bs, &created)
+			_, _ = w.Write([]byte(`{"silenceID": "abc"}`))
+		}
+	}))
+	defer server.Close()
+
+	inv, err := parseInventory("web1 port=9100\nweb2 port=9101\n")
+	require.NoError(t, err)
+	s, err := newSilencer("alertmanager="+server.URL+"/", "instance")
+	require.NoError(t, err)
+	r := &runner{id: "run1", inventory: inv, out: &quiet{}, silencers: []silencer{s}, silenceFor: time.Hour, silenceTarget: "{{.host}}:{{.port}}"}
+
+	clear, err := r.silence([]string{"web1", "web2"})
+	require.NoError(t, err)
+	require.Equal(t, []alertmanagerMatcher{{Name: "instance", Value: "web1:9100|web2:9101", IsRegex: true}}, created.Matchers)
+	require.Equal(t, "commando run run1", created.Comment)
+
+	clear()
+	require.Equal(t, []string{"POST /api/v2/silences", "DELETE /api/v2/silence/abc"}, requests)
+}
+
+func Test_nagios_silence(t *testing.T) {
+	var forms []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/nagios/cgi-bin/cmd.cgi", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		forms = append(forms, map[string]string{"cmd_typ": r.PostForm.Get("cmd_typ"), "host": r.PostForm.Get("host")})
+	}))
+	defer server.Close()
+
+	s, err := newSilencer("nagios="+server.URL+"/nagios/cgi-bin", "")
+	require.NoError(t, err)
+	clear, err := s.silence([]string{"web1"}, time.Now().Add(time.Hour), "commando run run1")
+	require.NoError(t, err)
+	require.NoError(t, clear())
+	require.Equal(t, []map[string]string{
+		{"cmd_typ": "55", "host": "web1"},
+		{"cmd_typ": "170", "host": "web1"},
+	}, forms)
+}
+
+func Test_newSilencer_invalid(t *testing.T) {
+	_, err := newSilencer("pagerduty=https://example.com", "")
+	require.Error(t, err)
+	_, err = newSilencer("alertmanager", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A resultSink receives every result of a run as it is recorded, e.g. to
+// stream results into a database or message queue without parsing the
+// output of commando. flush is called once, when the run completes.
+//
+// As commando is a command rather than a library, sinks are registered
+// in this package with registerSink, and sinks living elsewhere are
+// plugins, to which results are streamed (see pluginSink).
+type resultSink interface {
+	write(res result) error
+	flush() error
+}
+
+// sinkKinds open the sinks of --sink KIND:ARG, by kind.
+var sinkKinds = make(map[string]func(arg string) (resultSink, error))
+
+// registerSink makes sinks of kind available to --sink, opened with open.
+func registerSink(kind string, open func(arg string) (resultSink, error)) {
+	if _, exists := sinkKinds[kind]; exists {
+		panic("result sink " + kind + " registered twice")
+	}
+	sinkKinds[kind] = open
+}
+
+func init() {
+	registerSink("jsonl", openJSONLSink)
+	registerSink("plugin", openPluginSink)
+}
+
+// parseSinkSpec parses the KIND:ARG of --sink.
+func parseSinkSpec(spec string) (string, string, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", errors.Errorf("malformed result sink %q, must be KIND:ARG", spec)
+	}
+	if _, exists := sinkKinds[parts[0]]; !exists {
+		kinds := make([]string, 0, len(sinkKinds))
+		for kind := range sinkKinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		return "", "", errors.Errorf("unknown result sink %q, must be one of %s", parts[0], strings.Join(kinds, ", "))
+	}
+	return parts[0], parts[1], nil
+}
+
+// sinking wraps another renderer, writing every result to sinks. A sink
+// which fails is warned about once, and does not fail the run.
+type sinking struct {
+	inner renderer
+	specs []string
+	sinks []resultSink
+
+	lock   sync.Mutex
+	failed map[int]bool
+}
+
+// newSinking opens the sinks of specs, wrapping inner.
+func newSinking(inner renderer, specs []string) (*sinking, error) {
+	s := &sinking{inner: inner, specs: specs, failed: make(map[int]bool)}
+	for _, spec := range specs {
+		kind, arg, err := parseSinkSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		sink, err := sinkKinds[kind](arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open result sink %s", spec)
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+	return s, nil
+}
+
+func (s *sinking) plan(kind string, items []string, hosts []string) { s.inner.plan(kind, items, hosts) }
+func (s *sinking) message(format string, args ...interface{})       { s.inner.message(format, args...) }
+func (s *sinking) warning(format string, args ...interface{})       { s.inner.warning(format, args...) }
+func (s *sinking) begin(host, file string)                          { s.inner.begin(host, file) }
+func (s *sinking) command(host, command string)                     { s.inner.command(host, command) }
+func (s *sinking) output(host, text string)                         { s.inner.output(host, text) }
+func (s *sinking) end(host, file string)                            { s.inner.end(host, file) }
+
+func (s *sinking) result(res result) {
+	s.inner.result(res)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, sink := range s.sinks {
+		if s.failed[i] {
+			continue
+		}
+		if err := sink.write(res); err != nil {
+			s.failed[i] = true
+			s.inner.warning("result sink %s failed: %v", s.specs[i], err)
+		}
+	}
+}
+
+func (s *sinking) summary(rpt report) {
+	s.inner.summary(rpt)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, sink := range s.sinks {
+		if err := sink.flush(); err != nil && !s.failed[i] {
+			s.inner.warning("result sink %s failed: %v", s.specs[i], err)
+		}
+	}
+}
+
+// A jsonlSink appends every result to a file, as a line of JSON.
+type jsonlSink struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func openJSONLSink(path string) (resultSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlSink{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (j *jsonlSink) write(res result) error {
+	return json.NewEncoder(j.w).Encode(res)
+}
+
+func (j *jsonlSink) flush() error {
+	err := j.w.Flush()
+	if closeErr := j.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// A pluginSink streams every result to a plugin started for the run, as
+// a line of JSON on its stdin of the form
+//
+//	{"protocol": 1, "kind": "result", "result": {"host": "web1", ...}}
+//
+// The stdin of the plugin is closed once the run completes, and it must
+// exit successfully once it has stored the results.
+type pluginSink struct {
+	p      *plugin
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+}
+
+type pluginResult struct {
+	Protocol int    `json:"protocol"`
+	Kind     string `json:"kind"`
+	Result   result `json:"result"`
+}
+
+func openPluginSink(name string) (resultSink, error) {
+	p, err := findPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &pluginSink{p: p, cmd: exec.Command(p.path)}
+	ps.cmd.Stderr = &ps.stderr
+	if ps.stdin, err = ps.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err := ps.cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start %s", p)
+	}
+	return ps, nil
+}
+
+func (ps *pluginSink) write(res result) error {
+	err := json.NewEncoder(ps.stdin).Encode(pluginResult{Protocol: pluginProtocol, Kind: "result", Result: res})
+	return errors.Wrapf(err, "failed to write to %s", ps.p)
+}
+
+func (ps *pluginSink) flush() error {
+	_ = ps.stdin.Close()
+	if err := ps.cmd.Wait(); err != nil {
+		return errors.Wrapf(err, "%s failed: %s", ps.p, strings.TrimSpace(ps.stderr.String()))
+	}
+	return nil
+}
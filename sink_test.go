@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSinkSpec(t *testing.T) {
+	kind, arg, err := parseSinkSpec("plugin:warehouse")
+	require.NoError(t, err)
+	require.Equal(t, "plugin", kind)
+	require.Equal(t, "warehouse", arg)
+
+	_, _, err = parseSinkSpec("jsonl")
+	require.EqualError(t, err, `malformed result sink "jsonl", must be KIND:ARG`)
+
+	_, _, err = parseSinkSpec("mqtt:topic")
+	require.EqualError(t, err, `unknown result sink "mqtt", must be one of jsonl, kafka, nats, plugin`)
+}
+
+func Test_sinking(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// a plugin storing the results streamed to it
+	stored := filepath.Join(dir, "stored")
+	path := filepath.Join(dir, "commando-warehouse")
+	require.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\ncat > "+stored+"\n"), 0700))
+	jsonl := filepath.Join(dir, "results.jsonl")
+
+	s, err := newSinking(&quiet{}, []string{"jsonl:" + jsonl, "plugin:" + path})
+	require.NoError(t, err)
+	s.result(result{Host: "web1", Output: "ok"})
+	s.result(result{Host: "web2", ExitCode: 1})
+	s.summary(report{})
+
+	bs, err := ioutil.ReadFile(jsonl)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"host":"web1"`)
+	require.Contains(t, lines[1], `"exit_code":1`)
+
+	bs, err = ioutil.ReadFile(stored)
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(string(bs)), "\n")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(lines[0], `{"protocol":1,"kind":"result","result":{"host":"web1"`), lines[0])
+
+	_, err = newSinking(&quiet{}, []string{"plugin:does-not-exist"})
+	require.Error(t, err)
+}
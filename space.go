@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// A spaceCheck requires at least min bytes to be free on the filesystem
+// of path, declared with --min-free PATH=SIZE for every script, or with
+// "# min-free: PATH SIZE" for one script, e.g. "# min-free: /var 2G".
+type spaceCheck struct {
+	path string
+	min  int64
+}
+
+func (c spaceCheck) String() string {
+	return fmt.Sprintf("%s free on %s", formatSize(c.min), c.path)
+}
+
+// parseSpaceCheck parses a path and size separated by sep.
+func parseSpaceCheck(s, sep string) (spaceCheck, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), sep, 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return spaceCheck{}, errors.Errorf("malformed min-free %q, expected PATH%sSIZE", s, sep)
+	}
+	size, err := parseSize(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return spaceCheck{}, err
+	}
+	return spaceCheck{path: strings.TrimSpace(parts[0]), min: size}, nil
+}
+
+var sizeUnits = []string{"", "K", "M", "G", "T"}
+
+// parseSize parses a size in bytes, or in K, M, G or T (powers of 1024).
+func parseSize(s string) (int64, error) {
+	number := strings.TrimSuffix(strings.ToUpper(s), "B")
+	multiplier := int64(1)
+	for i := len(sizeUnits) - 1; i > 0; i-- {
+		if strings.HasSuffix(number, sizeUnits[i]) {
+			number = strings.TrimSuffix(number, sizeUnits[i])
+			multiplier = int64(1) << (10 * uint(i))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("malformed size %q, expected e.g. 512M or 2G", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// formatSize formats bytes in the largest unit of which there is at least one.
+func formatSize(bytes int64) string {
+	value, unit := float64(bytes), 0
+	for value >= 1024 && unit < len(sizeUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64) + sizeUnits[unit]
+}
+
+// freeSpace returns the bytes available on the filesystems of paths,
+// reported by df in POSIX format (one line per path, in order).
+func freeSpace(client *ssh.Client, paths []string) (map[string]int64, error) {
+	quoted := make([]string, 0, len(paths))
+	for _, path := range paths {
+		quoted = append(quoted, quote(path))
+	}
+	output, err := remote(client, "df -Pk "+strings.Join(quoted, " "), "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to check free space: %s", output)
+	}
+	return parseDF(output, paths)
+}
+
+func parseDF(output string, paths []string) (map[string]int64, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != len(paths)+1 {
+		return nil, errors.Errorf("unexpected output of df: %q", output)
+	}
+
+	free := make(map[string]int64, len(paths))
+	for i, line := range lines[1:] {
+		columns := strings.Fields(line)
+		if len(columns) < 6 {
+			return nil, errors.Errorf("unexpected output of df: %q", line)
+		}
+		kb, err := strconv.ParseInt(columns[3], 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("unexpected output of df: %q", line)
+		}
+		free[paths[i]] = kb * 1024
+	}
+	return free, nil
+}
+
+// lowSpace returns which of checks fails on host, if any, as the reason
+// to skip running on it.
+func (r *runner) lowSpace(client *ssh.Client, host string, checks []spaceCheck) (string, error) {
+	if len(checks) == 0 {
+		return "", nil
+	}
+	client, done, err := r.probeClient(client, host)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	paths := make([]string, 0, len(checks))
+	for _, c := range checks {
+		paths = append(paths, c.path)
+	}
+	free, err := freeSpace(client, paths)
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range checks {
+		if free[c.path] < c.min {
+			return fmt.Sprintf("only %s free on %s, %s required", formatSize(free[c.path]), c.path, formatSize(c.min)), nil
+		}
+	}
+	return "", nil
+}
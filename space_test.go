@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSize(t *testing.T) {
+	for s, exp := range map[string]int64{"512": 512, "1k": 1024, "1.5M": 1572864, "2G": 2147483648, "2GB": 2147483648, "1T": 1099511627776} {
+		size, err := parseSize(s)
+		require.NoError(t, err, s)
+		require.Equal(t, exp, size, s)
+	}
+	_, err := parseSize("lots")
+	require.Error(t, err)
+
+	require.Equal(t, "1.5G", formatSize(1610612736))
+	require.Equal(t, "512", formatSize(512))
+}
+
+func Test_parseSpaceCheck(t *testing.T) {
+	c, err := parseSpaceCheck("/var 2G", " ")
+	require.NoError(t, err)
+	require.Equal(t, spaceCheck{path: "/var", min: 2 << 30}, c)
+	require.Equal(t, "2G free on /var", c.String())
+
+	_, err = parseSpaceCheck("/var", "=")
+	require.Error(t, err)
+
+	sf, err := parse("11-upgrade", "# min-free: / 500M\n# min-free: /var 2G\napt-get -y upgrade")
+	require.NoError(t, err)
+	require.Len(t, sf.scripts[0].minFree, 2)
+}
+
+func Test_parseDF(t *testing.T) {
+	output := `Filesystem     1024-blocks     Used Available Capacity Mounted on
+/dev/sda1         41152736 35000000   4056736      90% /
+/dev/sdb1        103080224  1000000  96823424       2% /var`
+
+	free, err := parseDF(output, []string{"/", "/var/lib"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"/": 4056736 * 1024, "/var/lib": 96823424 * 1024}, free)
+
+	_, err = parseDF(output, []string{"/"})
+	require.Error(t, err)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// An sshConfig is the subset of an OpenSSH client configuration file
+// (usually ~/.ssh/config) commando applies to each host: HostName, User,
+// Port, IdentityFile and ProxyJump, set by Host blocks.
+type sshConfig struct {
+	blocks []sshConfigBlock
+}
+
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string // lowercased keyword, value
+}
+
+// sshHost is the configuration of an ssh_config alias.
+type sshHost struct {
+	hostName      string
+	user          string
+	port          string
+	identityFiles []string
+	proxyJump     string
+}
+
+func defaultSSHConfig() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// loadSSHConfig parses the ssh_config file at path, which need not exist.
+func loadSSHConfig(path string) (sshConfig, error) {
+	var cfg sshConfig
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, errors.Wrap(err, "failed to open ssh config")
+	}
+	defer func() { _ = f.Close() }()
+
+	// options before the first Host block apply to every host
+	block := &sshConfigBlock{patterns: []string{"*"}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value := splitSSHOption(text)
+		if value == "" {
+			return cfg, errors.Errorf("malformed ssh config line %d: %q", line, text)
+		}
+
+		switch key {
+		case "host":
+			cfg.blocks = append(cfg.blocks, *block)
+			block = &sshConfigBlock{patterns: strings.Fields(value)}
+		case "match":
+			// Match criteria are not supported, so its options apply to no host
+			cfg.blocks = append(cfg.blocks, *block)
+			block = &sshConfigBlock{}
+		default:
+			block.options = append(block.options, [2]string{key, value})
+		}
+	}
+	cfg.blocks = append(cfg.blocks, *block)
+	return cfg, errors.Wrap(scanner.Err(), "failed to read ssh config")
+}
+
+// splitSSHOption splits a line like "HostName 10.0.0.1" or
+// "Port=2222" into its lowercased keyword and unquoted value.
+func splitSSHOption(line string) (string, string) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), ""
+	}
+	key := strings.ToLower(line[:i])
+	value := strings.TrimSpace(line[i:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	return key, strings.Trim(value, `"`)
+}
+
+// matches returns whether alias matches the patterns of the block: any
+// of its patterns (globs of * and ?), and none of its negated ones.
+func (b sshConfigBlock) matches(alias string) bool {
+	matched := false
+	for _, pattern := range b.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), alias)
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// argsSSHConfig loads the ssh_config of --ssh-config, by default that of
+// the operator, or none at all with --ssh-config none.
+func argsSSHConfig(args args) (sshConfig, error) {
+	path := args.sshConfig
+	switch path {
+	case "":
+		path = defaultSSHConfig()
+	case "none":
+		path = ""
+	}
+	return loadSSHConfig(path)
+}
+
+// lookup returns the configuration of alias. As with ssh, the first
+// value found for each option is used, except for IdentityFile, of
+// which every one found is used.
+func (c sshConfig) lookup(alias string) sshHost {
+	var h sshHost
+	for _, block := range c.blocks {
+		if !block.matches(alias) {
+			continue
+		}
+		for _, option := range block.options {
+			key, value := option[0], option[1]
+			switch {
+			case key == "hostname" && h.hostName == "":
+				h.hostName = value
+			case key == "user" && h.user == "":
+				h.user = value
+			case key == "port" && h.port == "":
+				h.port = value
+			case key == "proxyjump" && h.proxyJump == "":
+				h.proxyJump = value
+			case key == "identityfile":
+				h.identityFiles = append(h.identityFiles, value)
+			}
+		}
+	}
+
+	if h.hostName == "" {
+		h.hostName = alias
+	}
+	h.hostName = strings.Replace(strings.Replace(h.hostName, "%h", alias, -1), "%%", "%", -1)
+	if h.port == "" {
+		h.port = "22"
+	}
+	if h.proxyJump == "none" {
+		h.proxyJump = ""
+	}
+	return h
+}
+
+// address returns the host:port to connect to.
+func (h sshHost) address() string {
+	return net.JoinHostPort(h.hostName, h.port)
+}
+
+// signers loads the identity files of h, skipping those which are
+// missing or encrypted (which ssh-agent may still provide).
+func (h sshHost) signers(user string) []ssh.Signer {
+	home, _ := os.UserHomeDir()
+
+	var signers []ssh.Signer
+	for _, file := range h.identityFiles {
+		if strings.HasPrefix(file, "~/") {
+			file = filepath.Join(home, file[2:])
+		}
+		file = strings.NewReplacer("%d", home, "%h", h.hostName, "%r", user, "%%", "%").Replace(file)
+		if signer, err := loadSigner(file, ""); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	return signers
+}
+
+// jumps parses a ProxyJump value into the [user@]host[:port] jump hosts it lists.
+func jumps(proxyJump string) []sshJump {
+	var hops []sshJump
+	for _, spec := range strings.Split(proxyJump, ",") {
+		spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+		if spec == "" {
+			continue
+		}
+
+		var hop sshJump
+		if i := strings.LastIndex(spec, "@"); i >= 0 {
+			hop.user, spec = spec[:i], spec[i+1:]
+		}
+		hop.host = spec
+		if host, port, err := net.SplitHostPort(spec); err == nil {
+			hop.host, hop.port = host, port
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+type sshJump struct {
+	user string
+	host string
+	port string
+}
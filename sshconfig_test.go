@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sshConfigFile = `
+# defaults for every host
+IdentityFile ~/.ssh/id_default
+
+Host bastion
+  HostName bastion.example.com
+  User jump
+
+Host web* !web-legacy*
+  HostName %h.internal.example.com
+  Port=2222
+  ProxyJump bastion
+  IdentityFile "~/.ssh/id_web"
+
+Host *
+  User ops
+  Port 22
+
+Match exec "true"
+  User nobody
+`
+
+func Test_sshConfig_lookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(path, []byte(sshConfigFile), 0600))
+
+	cfg, err := loadSSHConfig(path)
+	require.NoError(t, err)
+
+	web := cfg.lookup("web1")
+	require.Equal(t, "web1.internal.example.com:2222", web.address())
+	require.Equal(t, "ops", web.user)
+	require.Equal(t, "bastion", web.proxyJump)
+	require.Equal(t, []string{"~/.ssh/id_default", "~/.ssh/id_web"}, web.identityFiles)
+
+	legacy := cfg.lookup("web-legacy1")
+	require.Equal(t, "web-legacy1:22", legacy.address())
+	require.Equal(t, "", legacy.proxyJump)
+
+	bastion := cfg.lookup("bastion")
+	require.Equal(t, "bastion.example.com:22", bastion.address())
+	require.Equal(t, "jump", bastion.user)
+
+	missing, err := loadSSHConfig(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	require.Equal(t, "db1:22", missing.lookup("db1").address())
+}
+
+func Test_jumps(t *testing.T) {
+	require.Equal(t, []sshJump{
+		{user: "admin", host: "bastion1", port: "2222"},
+		{host: "bastion2"},
+	}, jumps("admin@bastion1:2222, ssh://bastion2"))
+	require.Empty(t, jumps(""))
+}
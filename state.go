@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const skipUsage = "usage: commando skip [--reason reason] [--for duration] [--remove] <host> <script> | commando skip --list"
+
+// A skip records that a script is known not to apply to a host, e.g.
+// because of a known incompatibility, so that scheduled runs do not
+// report it as failed. Skips are kept after they expire, for auditing.
+type skip struct {
+	Host    string    `json:"host"`
+	Script  string    `json:"script"`
+	Reason  string    `json:"reason"`
+	By      string    `json:"by,omitempty"`
+	Added   time.Time `json:"added"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (s skip) active(now time.Time) bool {
+	return s.Expires.IsZero() || now.Before(s.Expires)
+}
+
+func (s skip) String() string {
+	if s.Expires.IsZero() {
+		return s.Reason
+	}
+	return fmt.Sprintf("%s (until %s)", s.Reason, s.Expires.Format(time.RFC3339))
+}
+
+// state is what commando remembers between runs.
+type state struct {
+	Skips      []skip               `json:"skips"`
+	Hosts      map[string]hostCache `json:"hosts,omitempty"`
+	Discovered map[string]discovery `json:"discovered,omitempty"`
+}
+
+func statePath() string {
+	return filepath.Join(filepath.Dir(runsDir()), "state.json")
+}
+
+// loadState reads the state file at path, which need not exist yet.
+func loadState(path string) (state, error) {
+	var st state
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return st, errors.Wrap(err, "failed to read state")
+	}
+
+	if err := json.Unmarshal(bs, &st); err != nil {
+		return st, errors.Wrapf(err, "failed to parse state %s", path)
+	}
+	return st, nil
+}
+
+func writeState(path string, st state) error {
+	bs, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode state")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create state directory")
+	}
+	if err := ioutil.WriteFile(path, bs, 0600); err != nil {
+		return errors.Wrap(err, "failed to write state")
+	}
+	return nil
+}
+
+// skipped returns the active skip of script on host, if any.
+func (st state) skipped(host, script string, now time.Time) (skip, bool) {
+	for _, s := range st.Skips {
+		if s.Host == host && s.Script == script && s.active(now) {
+			return s, true
+		}
+	}
+	return skip{}, false
+}
+
+// remove drops every skip of script on host, returning how many there were.
+func (st *state) remove(host, script string) int {
+	kept := st.Skips[:0]
+	for _, s := range st.Skips {
+		if s.Host != host || s.Script != script {
+			kept = append(kept, s)
+		}
+	}
+	removed := len(st.Skips) - len(kept)
+	st.Skips = kept
+	return removed
+}
+
+// skipCmd implements "commando skip", which adds, removes or lists skips.
+func skipCmd(arguments []string) error {
+	flags := flag.NewFlagSet("skip", flag.ExitOnError)
+	reason := flags.String("reason", "", "why the script does not apply to the host (required)")
+	expires := flags.Duration("for", 0, "how long the skip lasts, e.g. 720h (0 for no expiry)")
+	remove := flags.Bool("remove", false, "remove the skip instead of adding it")
+	list := flags.Bool("list", false, "list skips, including expired ones")
+	positional := parseInterspersed(flags, arguments)
+
+	path := statePath()
+	st, err := loadState(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if *list {
+		for _, s := range st.Skips {
+			c := colors.notice.color()
+			if !s.active(now) {
+				c = colors.muted.color()
+			}
+			_, _ = c.Printf("%s\t%s\t%s\t(added %s by %s)\n", s.Host, s.Script, s, s.Added.Format(time.RFC3339), s.By)
+		}
+		return nil
+	}
+
+	if len(positional) != 2 {
+		return errors.New(skipUsage)
+	}
+	host, script := positional[0], positional[1]
+
+	if *remove {
+		if st.remove(host, script) == 0 {
+			return errors.Errorf("%s is not skipped on %s", script, host)
+		}
+		colors.info.println("no longer skipping %s on %s", script, host)
+		return writeState(path, st)
+	}
+
+	if *reason == "" {
+		return errors.New("--reason is required, so skips remain auditable")
+	}
+
+	s := skip{Host: host, Script: script, Reason: *reason, By: os.Getenv("USER"), Added: now.UTC()}
+	if *expires > 0 {
+		s.Expires = now.Add(*expires).UTC()
+	}
+	st.remove(host, script)
+	st.Skips = append(st.Skips, s)
+
+	colors.info.println("skipping %s on %s: %s", script, host, s)
+	return writeState(path, st)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_state_skipped(t *testing.T) {
+	now := time.Date(2019, 10, 14, 12, 0, 0, 0, time.UTC)
+	st := state{Skips: []skip{
+		{Host: "db1", Script: "1-kernel", Reason: "custom kernel"},
+		{Host: "db2", Script: "1-kernel", Reason: "pending migration", Expires: now.Add(-time.Hour)},
+	}}
+
+	s, ok := st.skipped("db1", "1-kernel", now)
+	require.True(t, ok)
+	require.Equal(t, "custom kernel", s.Reason)
+
+	_, ok = st.skipped("db1", "2-ntp", now)
+	require.False(t, ok)
+
+	_, ok = st.skipped("db2", "1-kernel", now)
+	require.False(t, ok, "expired skips no longer apply")
+
+	require.Equal(t, 1, st.remove("db2", "1-kernel"))
+	require.Equal(t, 1, len(st.Skips))
+}
+
+func Test_loadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nested", "state.json")
+	st, err := loadState(path)
+	require.NoError(t, err)
+	require.Empty(t, st.Skips)
+
+	st.Skips = append(st.Skips, skip{Host: "db1", Script: "1-kernel", Reason: "custom kernel"})
+	require.NoError(t, writeState(path, st))
+
+	loaded, err := loadState(path)
+	require.NoError(t, err)
+	require.Equal(t, st.Skips[0].Reason, loaded.Skips[0].Reason)
+}
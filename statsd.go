@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// statsd wraps another renderer, sending metrics of the run to a statsd
+// (or DogStatsD) server over UDP as it progresses:
+//
+//	commando.script.runs      counter, by host, script and status
+//	commando.script.duration  timing of each script, by host, script and status
+//	commando.run.hosts        gauge of hosts by status, once the run completes
+//	commando.run.duration     timing of the run, by status
+//
+// Plain statsd does not support tags, so only the totals are sent to it.
+type statsd struct {
+	inner   renderer
+	conn    net.Conn
+	prefix  string
+	tags    []string // sent with every metric, DogStatsD only
+	dogs    bool
+	lock    sync.Mutex
+	started time.Time
+	hosts   map[string]string // status of each host
+}
+
+// newStatsd returns a statsd sink wrapping inner, sending metrics to the
+// server at rawURL, which is dogstatsd://host:port or statsd://host:port.
+func newStatsd(inner renderer, rawURL string, tags []string) (*statsd, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("malformed statsd address %q, must be dogstatsd://host:port or statsd://host:port", rawURL)
+	}
+
+	var dogs bool
+	switch u.Scheme {
+	case "dogstatsd":
+		dogs = true
+	case "statsd":
+	default:
+		return nil, errors.Errorf("unsupported statsd scheme %q, must be one of dogstatsd, statsd", u.Scheme)
+	}
+
+	conn, err := net.Dial("udp", u.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to statsd")
+	}
+
+	return &statsd{
+		inner:   inner,
+		conn:    conn,
+		prefix:  "commando.",
+		tags:    tags,
+		dogs:    dogs,
+		started: time.Now(),
+		hosts:   make(map[string]string),
+	}, nil
+}
+
+func (s *statsd) plan(kind string, items []string, hosts []string) {
+	s.lock.Lock()
+	s.started = time.Now()
+	s.lock.Unlock()
+	s.inner.plan(kind, items, hosts)
+}
+
+func (s *statsd) message(format string, args ...interface{}) { s.inner.message(format, args...) }
+func (s *statsd) warning(format string, args ...interface{}) { s.inner.warning(format, args...) }
+func (s *statsd) begin(host, file string)                    { s.inner.begin(host, file) }
+func (s *statsd) command(host, command string)               { s.inner.command(host, command) }
+func (s *statsd) output(host, text string)                   { s.inner.output(host, text) }
+func (s *statsd) end(host, file string)                      { s.inner.end(host, file) }
+
+func (s *statsd) result(res result) {
+	s.inner.result(res)
+
+	// a host has the worst status of its scripts
+	status := resultStatus(res)
+	s.lock.Lock()
+	if current, exists := s.hosts[res.Host]; !exists || statusRank[status] > statusRank[current] {
+		s.hosts[res.Host] = status
+	}
+	s.lock.Unlock()
+
+	script := res.File
+	if script == "" {
+		script = "command"
+	}
+	tags := []string{"host:" + res.Host, "script:" + script, "status:" + status}
+	s.send(
+		s.metric("script.runs", "1|c", tags),
+		s.metric("script.duration", fmt.Sprintf("%d|ms", int64(res.Seconds*1000)), tags),
+	)
+}
+
+func (s *statsd) summary(rpt report) {
+	counts := map[string]int{"ok": 0, "failed": 0, "error": 0, "skipped": 0}
+	s.lock.Lock()
+	for _, status := range s.hosts {
+		counts[status]++
+	}
+	elapsed := time.Since(s.started)
+	s.lock.Unlock()
+
+	var metrics []string
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		metrics = append(metrics, s.metric("run.hosts", fmt.Sprintf("%d|g", counts[status]), []string{"status:" + status}))
+	}
+	metrics = append(metrics, s.metric("run.duration", fmt.Sprintf("%d|ms", int64(elapsed/time.Millisecond)), []string{"status:" + rpt.Status}))
+	s.send(metrics...)
+	_ = s.conn.Close()
+
+	s.inner.summary(rpt)
+}
+
+// metric formats a metric in the statsd line protocol, with tags (and
+// the global tags) appended for DogStatsD.
+func (s *statsd) metric(name, value string, tags []string) string {
+	line := s.prefix + name + ":" + value
+	if !s.dogs {
+		return line
+	}
+	all := append(append([]string{}, s.tags...), tags...)
+	for i, tag := range all {
+		all[i] = strings.Replace(strings.Replace(tag, ",", "_", -1), "|", "_", -1)
+	}
+	return line + "|#" + strings.Join(all, ",")
+}
+
+// send metrics in a single datagram. Metrics are best effort, so errors
+// (e.g. no server listening) are ignored.
+func (s *statsd) send(metrics ...string) {
+	_, _ = s.conn.Write([]byte(strings.Join(metrics, "\n")))
+}
+
+var statusRank = map[string]int{"skipped": 0, "ok": 1, "failed": 2, "error": 3}
+
+// resultStatus returns the status of res: ok, failed, error or skipped.
+func resultStatus(res result) string {
+	switch {
+	case res.Skipped != "":
+		return "skipped"
+	case res.Error != "":
+		return "error"
+	case len(res.Failed) > 0:
+		return "failed"
+	}
+	return "ok"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_statsd(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	receive := func() []string {
+		buf := make([]byte, 64*1024)
+		require.NoError(t, server.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := server.ReadFrom(buf)
+		require.NoError(t, err)
+		return strings.Split(string(buf[:n]), "\n")
+	}
+
+	s, err := newStatsd(&quiet{}, "dogstatsd://"+server.LocalAddr().String(), []string{"env:prod"})
+	require.NoError(t, err)
+
+	s.result(result{Host: "web1", File: "1-check", Seconds: 1.5})
+	require.Equal(t, []string{
+		"commando.script.runs:1|c|#env:prod,host:web1,script:1-check,status:ok",
+		"commando.script.duration:1500|ms|#env:prod,host:web1,script:1-check,status:ok",
+	}, receive())
+
+	s.result(result{Host: "web1", File: "2-disk", Failed: []string{"x"}})
+	receive()
+	s.result(result{Host: "web2", File: "1-check", Skipped: "creates /x"})
+	receive()
+
+	s.summary(report{Status: "completed"})
+	metrics := receive()
+	require.Equal(t, []string{
+		"commando.run.hosts:0|g|#env:prod,status:error",
+		"commando.run.hosts:1|g|#env:prod,status:failed",
+		"commando.run.hosts:0|g|#env:prod,status:ok",
+		"commando.run.hosts:1|g|#env:prod,status:skipped",
+	}, metrics[:4])
+	require.True(t, strings.HasPrefix(metrics[4], "commando.run.duration:"))
+	require.True(t, strings.HasSuffix(metrics[4], "|ms|#env:prod,status:completed"))
+
+	_, err = newStatsd(&quiet{}, "udp://localhost:8125", nil)
+	require.Error(t, err)
+}
+
+func Test_statsd_plain(t *testing.T) {
+	s := &statsd{prefix: "commando."}
+	require.Equal(t, "commando.script.runs:1|c", s.metric("script.runs", "1|c", []string{"host:web1"}))
+}
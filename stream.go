@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A stream captures the output of a script while it runs, passing
+// complete lines of it to print every flush interval.
+type stream struct {
+	print    func(string)
+	lock     sync.Mutex
+	captured *capture
+	pending  bytes.Buffer
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func newStream(interval time.Duration, captured *capture, print func(string)) *stream {
+	s := &stream{
+		print:    print,
+		captured: captured,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.loop(interval)
+	return s
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, _ = s.captured.Write(p)
+	return s.pending.Write(p)
+}
+
+func (s *stream) loop(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			s.flush(true)
+			return
+		case <-ticker.C:
+			s.flush(false)
+		}
+	}
+}
+
+// flush prints the complete lines of pending output, or all of it if partial is set.
+func (s *stream) flush(partial bool) {
+	s.lock.Lock()
+	text := s.pending.String()
+	if !partial {
+		text = text[:strings.LastIndex(text, "\n")+1]
+	}
+	s.pending.Next(len(text))
+	s.lock.Unlock()
+
+	if text = strings.TrimRight(text, " \r\n"); strings.TrimSpace(text) != "" {
+		s.print(strings.Replace(text, "\r\n", "\n", -1))
+	}
+}
+
+// close flushes any remaining output, which was captured as it was written.
+func (s *stream) close() {
+	close(s.done)
+	<-s.stopped
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ungrouped is the group of hosts which do not have the label being grouped by.
+const ungrouped = "<none>"
+
+// A tally counts the hosts which succeeded or failed.
+type tally struct {
+	OK     int `json:"ok"`
+	Failed int `json:"failed"`
+}
+
+// hostsOK returns whether every result of each host was ok.
+func hostsOK(results []result) (map[string]bool, []string) {
+	ok := make(map[string]bool)
+	var order []string
+	for _, res := range results {
+		if _, exists := ok[res.Host]; !exists {
+			ok[res.Host] = true
+			order = append(order, res.Host)
+		}
+		ok[res.Host] = ok[res.Host] && res.ok()
+	}
+	return ok, order
+}
+
+// groupBy rolls up the hosts of results into tallies by the value of
+// their metadata label. Hosts belonging to multiple groups (i.e. with
+// the "groups" label) are counted towards each of them.
+func groupBy(label string, results []result) map[string]tally {
+	meta := make(map[string]metadata)
+	for _, res := range results {
+		meta[res.Host] = res.Metadata
+	}
+
+	ok, order := hostsOK(results)
+	groups := make(map[string]tally)
+	for _, host := range order {
+		for _, group := range values(meta[host], label) {
+			t := groups[group]
+			if ok[host] {
+				t.OK++
+			} else {
+				t.Failed++
+			}
+			groups[group] = t
+		}
+	}
+	return groups
+}
+
+func values(meta metadata, label string) []string {
+	value, exists := meta[label]
+	if !exists || value == "" {
+		return []string{ungrouped}
+	}
+	return strings.Split(value, ",")
+}
+
+func printGroups(label string, groups map[string]tally) {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	colors.info.println("summary by %s", label)
+	tbl := &table{indent: "  "}
+	for _, name := range names {
+		t := groups[name]
+		s := colors.success
+		if t.Failed > 0 {
+			s = colors.failure
+		}
+		tbl.add(s, label+"="+name, fmt.Sprintf("%d ok, %d failed", t.OK, t.Failed))
+	}
+	tbl.print()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_groupBy(t *testing.T) {
+	results := []result{
+		{Host: "web1", Metadata: metadata{"dc": "ams1", "groups": "web,public"}},
+		{Host: "web1", Metadata: metadata{"dc": "ams1", "groups": "web,public"}, Error: "exit 1"},
+		{Host: "web2", Metadata: metadata{"dc": "ams1", "groups": "web"}},
+		{Host: "db1", Metadata: metadata{"dc": "fra1"}},
+		{Host: "other"},
+	}
+
+	require.Equal(t, map[string]tally{
+		"ams1":    {OK: 1, Failed: 1},
+		"fra1":    {OK: 1},
+		ungrouped: {OK: 1},
+	}, groupBy("dc", results))
+
+	require.Equal(t, map[string]tally{
+		"web":     {OK: 1, Failed: 1},
+		"public":  {Failed: 1},
+		ungrouped: {OK: 2},
+	}, groupBy("groups", results))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// minColumn is the width columns are not truncated below.
+const minColumn = 8
+
+// tableWidth is the width tables are fitted to, or 0 to never truncate or
+// wrap them, as with --wide or when output is not a terminal.
+var tableWidth = terminalWidth(os.Stdout)
+
+// terminalWidth returns the width of the terminal f is, or else $COLUMNS,
+// or else 0 if f is not a terminal (e.g. output is piped to a file).
+func terminalWidth(f *os.File) int {
+	if !terminal.IsTerminal(int(f.Fd())) {
+		return 0
+	}
+	if width, _, err := terminal.GetSize(int(f.Fd())); err == nil && width > 0 {
+		return width
+	}
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return width
+}
+
+// A table renders rows of cells in aligned columns, fitted to a width:
+// the last column wraps (or is truncated, if it must not wrap), and the
+// others are truncated, widest first, so it gets the rest of the width.
+type table struct {
+	indent string
+	rows   [][]string
+	styles []style
+	nowrap bool // truncate the last column instead of wrapping it
+}
+
+// add a row of cells, rendered in s.
+func (t *table) add(s style, cells ...string) {
+	t.rows = append(t.rows, cells)
+	t.styles = append(t.styles, s)
+}
+
+// print the table, fitted to tableWidth.
+func (t *table) print() {
+	for i, lines := range t.render(tableWidth) {
+		for _, line := range lines {
+			t.styles[i].println("%s", line)
+		}
+	}
+}
+
+// render returns the lines of each row, fitted to width (0 for any width).
+func (t *table) render(width int) [][]string {
+	widths := t.fit(width)
+
+	rendered := make([][]string, 0, len(t.rows))
+	for _, row := range t.rows {
+		var b strings.Builder
+		_, _ = b.WriteString(t.indent)
+		var last string
+		for i, cell := range row {
+			if i == len(row)-1 {
+				last = cell
+				break
+			}
+			cell = truncate(cell, widths[i])
+			_, _ = b.WriteString(cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + "  ")
+		}
+		prefix := b.String()
+
+		if width == 0 || len(row) == 0 {
+			rendered = append(rendered, []string{prefix + last})
+			continue
+		}
+
+		room := widths[len(row)-1]
+		if t.nowrap {
+			rendered = append(rendered, []string{prefix + truncate(last, room)})
+			continue
+		}
+		var lines []string
+		for i, part := range wrap(last, room) {
+			if i > 0 {
+				prefix = strings.Repeat(" ", utf8.RuneCountInString(prefix))
+			}
+			lines = append(lines, prefix+part)
+		}
+		rendered = append(rendered, lines)
+	}
+	return rendered
+}
+
+// fit returns the width of each column, such that rows fit in width if
+// possible. The last column gets whatever width remains.
+func (t *table) fit(width int) []int {
+	var widths []int
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	if width == 0 || len(widths) == 0 {
+		return widths
+	}
+
+	last := len(widths) - 1
+	available := width - len(t.indent) - 2*last
+	want := widths[last]
+	if want > available/2 {
+		want = available / 2 // don't squeeze the other columns for long text
+	}
+	if want < minColumn {
+		want = minColumn
+	}
+	for {
+		used := 0
+		widest := -1
+		for i := 0; i < last; i++ {
+			used += widths[i]
+			if widths[i] > minColumn && (widest < 0 || widths[i] > widths[widest]) {
+				widest = i
+			}
+		}
+		if used+want <= available || widest < 0 {
+			widths[last] = available - used
+			if widths[last] < minColumn {
+				widths[last] = minColumn
+			}
+			return widths
+		}
+		widths[widest]--
+	}
+}
+
+// truncate s to width runes, marking what was cut with an ellipsis.
+func truncate(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// wrap s into lines of at most width runes, breaking at spaces where
+// possible and within words where not.
+func wrap(s string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for utf8.RuneCountInString(word) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				runes := []rune(word)
+				lines = append(lines, string(runes[:width]))
+				word = string(runes[width:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
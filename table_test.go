@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_table_render(t *testing.T) {
+	tbl := &table{indent: "  "}
+	tbl.add(style{}, "web1.ams1.example.com", "1-disk", "disk_used_pct < 90 (got 97)")
+	tbl.add(style{}, "db12.a-very-long-datacenter-name.example.com", "2-ntp", "offset < 0.1 (got 4.2)")
+
+	require.Equal(t, [][]string{
+		{"  web1.ams1.example.com                         1-disk  disk_used_pct < 90 (got 97)"},
+		{"  db12.a-very-long-datacenter-name.example.com  2-ntp   offset < 0.1 (got 4.2)"},
+	}, tbl.render(0))
+
+	require.Equal(t, [][]string{
+		{"  web1.ams1.example.com  1-disk  disk_used_pct < 90 (got 97)"},
+		{"  db12.a-very-long-dat…  2-ntp   offset < 0.1 (got 4.2)"},
+	}, tbl.render(60))
+
+	require.Equal(t, [][]string{
+		{
+			"  web1.ams1.exa…  1-disk  disk_used_pct < 90",
+			"                          (got 97)",
+		},
+		{
+			"  db12.a-very-l…  2-ntp   offset < 0.1 (got",
+			"                          4.2)",
+		},
+	}, tbl.render(46))
+
+	tbl.nowrap = true
+	require.Equal(t, "  web1.ams1.exa…  1-disk  disk_used_pct < 90 …", tbl.render(46)[0][0])
+}
+
+func Test_wrap(t *testing.T) {
+	require.Equal(t, []string{"a b", "c", "abcd", "ef"}, wrap("a b c abcdef", 4))
+	require.Equal(t, []string{"one", "two"}, wrap("one\ntwo", 10))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+)
+
+const tailUsage = "usage: commando tail [-f] [-n lines] <files...> --hosts hosts"
+
+// reconnect is how a followed tail whose connection dropped is retried
+var reconnect = retryPolicy{base: 2 * time.Second, multiplier: 2, max: 30 * time.Second}
+
+// tailCmd implements "commando tail", which multiplexes the tails of
+// files on many hosts, reconnecting to hosts whose connection drops.
+func tailCmd(arguments []string) error {
+	var args args
+	flags := flag.NewFlagSet("tail", flag.ExitOnError)
+	flags.StringVar(&args.user, "user", os.Getenv("USER"), "ssh username")
+	flags.StringVar(&args.hostList, "hosts", "", "the list of hosts")
+	flags.StringVar(&args.inventory, "inventory", "", "file listing hosts and their metadata")
+	flags.BoolVar(&args.pw, "pw", false, "prompt for a password")
+	follow := flags.Bool("f", false, "follow the files as they grow, reconnecting if the connection drops")
+	lines := flags.Int("n", 10, "print this many of the last lines of each file first")
+	files := parseInterspersed(flags, arguments)
+
+	if len(files) == 0 {
+		return errors.New(tailUsage)
+	}
+
+	if args.hostList == "" && args.inventory == "" {
+		return errors.Errorf("--hosts or --inventory is required")
+	}
+
+	var inv inventory
+	if args.inventory != "" {
+		var err error
+		if inv, err = loadInventory(args.inventory); err != nil {
+			return err
+		}
+	}
+
+	hosts, err := targets(args, inv)
+	if err != nil {
+		return err
+	}
+
+	var pswd string
+	if args.pw {
+		if pswd, err = easyPrompt(args.user); err != nil {
+			return err
+		}
+	}
+
+	r := newRunner(args, pswd, inv, &quiet{})
+	t := &tailer{files: files, follow: *follow, lines: &prefixer{w: color.Output, colors: true}}
+
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			t.tail(r, host, *lines)
+		}(host)
+	}
+	wg.Wait()
+	return nil
+}
+
+// A tailer prints the lines of files on hosts, prefixed by host.
+type tailer struct {
+	files  []string
+	follow bool
+	lines  *prefixer
+}
+
+// command returns the tail command line, which follows the files by name
+// so that rotated logs keep being followed. Files are not quoted, so that
+// globs expand remotely.
+func (t *tailer) command(lines int) string {
+	flags := fmt.Sprintf("-n %d", lines)
+	if t.follow {
+		flags += " -F"
+	}
+	return fmt.Sprintf("tail %s %s", flags, strings.Join(t.files, " "))
+}
+
+func (t *tailer) tail(r *runner, host string, lines int) {
+	attempt := 1
+	for {
+		started, err := t.session(r, host, lines)
+		if !t.follow {
+			if err != nil {
+				t.warn(host, err.Error())
+			}
+			return
+		}
+
+		if started {
+			// only new lines are printed after reconnecting
+			lines, attempt = 0, 1
+		}
+		delay := reconnect.delay(attempt)
+		t.warn(host, fmt.Sprintf("connection lost (%v), reconnecting in %s", err, delay))
+		time.Sleep(delay)
+		attempt++
+	}
+}
+
+// session runs a tail on host until it exits or the connection drops,
+// returning whether the tail was started.
+func (t *tailer) session(r *runner, host string, lines int) (bool, error) {
+	client, err := r.dial(host)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to dial host")
+	}
+	defer func() { _ = client.Close() }()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create session")
+	}
+	defer func() { _ = session.Close() }()
+
+	// stderr is included, e.g. for "file truncated" messages of tail
+	pr, pw := io.Pipe()
+	session.Stdout, session.Stderr = pw, pw
+
+	if err := session.Start(t.command(lines)); err != nil {
+		return false, errors.Wrap(err, "failed to start tail")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pr)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			t.print(host, scanner.Text())
+		}
+		_, _ = io.Copy(ioutil.Discard, pr)
+	}()
+
+	err = session.Wait()
+	_ = pw.Close()
+	<-done
+	return true, err
+}
+
+func (t *tailer) print(host, line string) {
+	t.lines.println(host, nil, line)
+}
+
+func (t *tailer) warn(host, message string) {
+	t.lines.println(host, colors.failure.color(), message)
+}
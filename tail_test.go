@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_tailer_command(t *testing.T) {
+	tl := &tailer{files: []string{"/var/log/syslog", "/var/log/app/*.log"}, follow: true}
+	require.Equal(t, "tail -n 10 -F /var/log/syslog /var/log/app/*.log", tl.command(10))
+
+	tl.follow = false
+	require.Equal(t, "tail -n 0 /var/log/syslog /var/log/app/*.log", tl.command(0))
+}
+
+func Test_hostColor(t *testing.T) {
+	require.Equal(t, hostColor("web1"), hostColor("web1"))
+}
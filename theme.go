@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+)
+
+// A style is the color (and attributes) of a kind of output, where no
+// attributes leave it in the default color of the terminal.
+type style []color.Attribute
+
+func (s style) color() *color.Color {
+	c := color.New(s...)
+	if len(s) == 0 {
+		c.DisableColor()
+	}
+	return c
+}
+
+// println prints format with args in s, followed by a newline.
+func (s style) println(format string, args ...interface{}) {
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	_, _ = s.color().Printf(format, args...)
+}
+
+func (s style) sprint(a ...interface{}) string {
+	return s.color().Sprint(a...)
+}
+
+// A theme is the style of each kind of output.
+type theme struct {
+	info    style // messages of commando itself
+	notice  style // commands, skips and warnings
+	output  style // output of hosts
+	success style
+	failure style
+	muted   style   // prompts, and what is no longer in effect
+	accent  style   // files, e.g. of grep
+	hosts   []style // of the prefixes of --host-colors, none for no colors
+}
+
+var themes = map[string]theme{
+	// output of hosts is in the default color, as blue is unreadable on black
+	"dark": {
+		info:    style{color.FgMagenta},
+		notice:  style{color.FgYellow},
+		output:  style{},
+		success: style{color.FgGreen},
+		failure: style{color.FgRed},
+		muted:   style{color.FgWhite},
+		accent:  style{color.FgCyan},
+		hosts: []style{
+			{color.FgCyan}, {color.FgGreen}, {color.FgYellow}, {color.FgMagenta},
+			{color.FgHiCyan}, {color.FgHiGreen}, {color.FgHiYellow}, {color.FgHiBlue}, {color.FgHiMagenta},
+		},
+	},
+	// yellow, white and the bright colors are unreadable on a light background
+	"light": {
+		info:    style{color.FgMagenta},
+		notice:  style{color.FgBlue},
+		output:  style{},
+		success: style{color.FgGreen},
+		failure: style{color.FgRed},
+		muted:   style{color.FgHiBlack},
+		accent:  style{color.FgCyan},
+		hosts: []style{
+			{color.FgBlue}, {color.FgMagenta}, {color.FgGreen}, {color.FgCyan}, {color.FgRed},
+			{color.FgBlue, color.Bold}, {color.FgMagenta, color.Bold}, {color.FgGreen, color.Bold},
+		},
+	},
+	// without colors, for when they cannot be told apart, failures are bold
+	"mono": {
+		failure: style{color.Bold},
+	},
+}
+
+// colors is the theme output is rendered in.
+var colors = themes["dark"]
+
+// attributes are the names of colors and attributes of theme files.
+var attributes = map[string]color.Attribute{
+	"bold":       color.Bold,
+	"faint":      color.Faint,
+	"italic":     color.Italic,
+	"underline":  color.Underline,
+	"black":      color.FgBlack,
+	"red":        color.FgRed,
+	"green":      color.FgGreen,
+	"yellow":     color.FgYellow,
+	"blue":       color.FgBlue,
+	"magenta":    color.FgMagenta,
+	"cyan":       color.FgCyan,
+	"white":      color.FgWhite,
+	"hi-black":   color.FgHiBlack,
+	"hi-red":     color.FgHiRed,
+	"hi-green":   color.FgHiGreen,
+	"hi-yellow":  color.FgHiYellow,
+	"hi-blue":    color.FgHiBlue,
+	"hi-magenta": color.FgHiMagenta,
+	"hi-cyan":    color.FgHiCyan,
+	"hi-white":   color.FgHiWhite,
+}
+
+func themePath() string {
+	return filepath.Join(filepath.Dir(runsDir()), "theme")
+}
+
+// defaultTheme returns the theme of $COMMANDO_THEME, or else of the theme
+// file (if it exists), or else mono if $NO_COLOR is set, or else dark.
+func defaultTheme() (theme, error) {
+	if spec := os.Getenv("COMMANDO_THEME"); spec != "" {
+		return loadTheme(spec)
+	}
+	if _, err := os.Stat(themePath()); err == nil {
+		return loadTheme(themePath())
+	}
+	if _, set := os.LookupEnv("NO_COLOR"); set {
+		return themes["mono"], nil
+	}
+	return themes["dark"], nil
+}
+
+// loadTheme returns the theme named spec, or else of the theme file at
+// spec, which has lines of the form ROLE=ATTR[+ATTR...], e.g.
+//
+//	base=light
+//	output=hi-black
+//	failure=red+bold
+//
+// where base is the theme the roles are set on (dark by default).
+func loadTheme(spec string) (theme, error) {
+	if t, exists := themes[spec]; exists {
+		return t, nil
+	}
+
+	f, err := os.Open(spec)
+	if err != nil {
+		return theme{}, errors.Errorf("unknown theme %q, must be one of %s, or a theme file", spec, strings.Join(themeNames(), ", "))
+	}
+	defer func() { _ = f.Close() }()
+
+	t := themes["dark"]
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return t, errors.Errorf("malformed theme line %d: %q, expected ROLE=ATTR", line, text)
+		}
+		role, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		if role == "base" {
+			base, exists := themes[value]
+			if !exists {
+				return t, errors.Errorf("unknown base theme %q on line %d, must be one of %s", value, line, strings.Join(themeNames(), ", "))
+			}
+			t = base
+			continue
+		}
+
+		s, err := parseStyle(value)
+		if err != nil {
+			return t, errors.Wrapf(err, "malformed theme line %d", line)
+		}
+		switch role {
+		case "info":
+			t.info = s
+		case "notice":
+			t.notice = s
+		case "output":
+			t.output = s
+		case "success":
+			t.success = s
+		case "failure":
+			t.failure = s
+		case "muted":
+			t.muted = s
+		case "accent":
+			t.accent = s
+		default:
+			return t, errors.Errorf("unknown theme role %q on line %d, must be one of base, info, notice, output, success, failure, muted, accent", role, line)
+		}
+	}
+	return t, errors.Wrap(scanner.Err(), "failed to read theme")
+}
+
+// parseStyle parses attributes joined by +, or "default" for none.
+func parseStyle(value string) (style, error) {
+	s := style{}
+	if value == "default" {
+		return s, nil
+	}
+	for _, name := range strings.Split(value, "+") {
+		attr, exists := attributes[strings.TrimSpace(name)]
+		if !exists {
+			return nil, errors.Errorf("unknown color %q", name)
+		}
+		s = append(s, attr)
+	}
+	return s, nil
+}
+
+func themeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
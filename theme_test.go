@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadTheme(t *testing.T) {
+	light, err := loadTheme("light")
+	require.NoError(t, err)
+	require.Equal(t, style{}, light.output)
+
+	dark, err := loadTheme("dark")
+	require.NoError(t, err)
+	require.Equal(t, style{}, dark.output, "output is in the default color of the terminal")
+
+	_, err = loadTheme("solarized")
+	require.EqualError(t, err, `unknown theme "solarized", must be one of dark, light, mono, or a theme file`)
+
+	dir, err := ioutil.TempDir("", "theme")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "theme")
+	require.NoError(t, ioutil.WriteFile(path, []byte("# mine\nbase=light\noutput=hi-black\nfailure=red+bold\nnotice=default\n"), 0600))
+	custom, err := loadTheme(path)
+	require.NoError(t, err)
+	require.Equal(t, style{color.FgHiBlack}, custom.output)
+	require.Equal(t, style{color.FgRed, color.Bold}, custom.failure)
+	require.Equal(t, style{}, custom.notice)
+	require.Equal(t, light.info, custom.info)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("output=mauve\n"), 0600))
+	_, err = loadTheme(path)
+	require.EqualError(t, err, `malformed theme line 1: unknown color "mauve"`)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("background=black\n"), 0600))
+	_, err = loadTheme(path)
+	require.EqualError(t, err, `unknown theme role "background" on line 1, must be one of base, info, notice, output, success, failure, muted, accent`)
+}
+
+func Test_style_sprint(t *testing.T) {
+	require.Equal(t, "plain", style{}.sprint("plain"))
+}
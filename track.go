@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// trackLimit is the size of the largest file tracked with "# track:".
+	trackLimit = 1 << 20
+
+	// diffLimit is how many lines of the diff of a tracked file are kept.
+	diffLimit = 50
+)
+
+// A trackedFile is a file declared with "# track: PATH", fetched once its
+// step ran, along with the hash it had after the previous run of the step.
+type trackedFile struct {
+	Path     string `json:"path"`
+	SHA256   string `json:"sha256,omitempty"`
+	Previous string `json:"previous_sha256,omitempty"`
+	Since    string `json:"since,omitempty"` // the run the previous hash is of
+	Diff     string `json:"diff,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// changed returns whether the file differs from the previous run.
+func (t trackedFile) changed() bool {
+	return t.Previous != "" && t.SHA256 != "" && t.SHA256 != t.Previous
+}
+
+// trackedDir is where the content of tracked files is kept by hash, to
+// diff them against the next run.
+func trackedDir() string {
+	return filepath.Join(filepath.Dir(runsDir()), "tracked")
+}
+
+// trackFiles fetches the files tracked by a step of file that ran on host,
+// and diffs them against the files of the previous run of the step.
+func (r *runner) trackFiles(client *ssh.Client, host, file string, paths []string) ([]trackedFile, []string) {
+	var (
+		tracked []trackedFile
+		notes   []string
+	)
+	for _, path := range paths {
+		t := trackedFile{Path: path}
+		content, err := r.fetchTracked(client, host, path)
+		if err == nil {
+			sum := sha256.Sum256(content)
+			t.SHA256 = hex.EncodeToString(sum[:])
+			err = storeTracked(t.SHA256, content)
+		}
+		if err != nil {
+			t.Error = err.Error()
+			r.out.warning("failed to track %s on %s: %v", path, host, err)
+			tracked = append(tracked, t)
+			continue
+		}
+
+		t.Previous, t.Since = r.previousTrack(host, file, path)
+		if t.changed() {
+			previous, err := ioutil.ReadFile(filepath.Join(trackedDir(), t.Previous))
+			if err == nil {
+				t.Diff = r.diffTracked(previous, content)
+			}
+			notes = append(notes, fmt.Sprintf("%s changed since run %s", path, t.Since))
+			r.out.message("%s changed on %s since run %s\n%s", path, host, t.Since, t.Diff)
+		}
+		tracked = append(tracked, t)
+	}
+	return tracked, notes
+}
+
+// diffTracked returns the diff of a tracked file, as lineDiff, with the
+// secrets of env files masked, as the diff is printed and reported.
+func (r *runner) diffTracked(previous, content []byte) string {
+	return redact(lineDiff(string(previous), string(content)), r.secrets)
+}
+
+// fetchTracked returns the content of the file at path on host.
+func (r *runner) fetchTracked(client *ssh.Client, host, path string) ([]byte, error) {
+	client, done, err := r.probeClient(client, host)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create session")
+	}
+	defer func() { _ = session.Close() }()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	if err := session.Run(fmt.Sprintf("head -c %d -- %s", trackLimit+1, quote(path))); err != nil {
+		return nil, errors.Errorf("failed to fetch: %s", strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() > trackLimit {
+		return nil, errors.Errorf("larger than %s", formatSize(trackLimit))
+	}
+	return stdout.Bytes(), nil
+}
+
+// storeTracked keeps content under its hash, unless it already is.
+func storeTracked(hash string, content []byte) error {
+	path := filepath.Join(trackedDir(), hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(trackedDir(), 0700); err != nil {
+		return errors.Wrap(err, "failed to create tracked directory")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, content, 0600), "failed to store")
+}
+
+// previousTrack returns the hash path had on host after the last run of
+// file recorded in history, and the ID of that run.
+func (r *runner) previousTrack(host, file, path string) (string, string) {
+	r.trackHistory.Do(func() {
+		entries, err := readHistory(historyPath())
+		if err != nil {
+			r.out.warning("not diffing tracked files: %v", err)
+		}
+		r.history = entries
+	})
+
+	for i := len(r.history) - 1; i >= 0; i-- {
+		e := r.history[i]
+		for _, res := range e.Results {
+			if res.Host != host || res.File != file {
+				continue
+			}
+			for _, t := range res.Tracked {
+				if t.Path == path && t.SHA256 != "" {
+					return t.SHA256, e.ID
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// lineDiff returns the lines removed from a (prefixed "-") and added to b
+// (prefixed "+"), in order, up to diffLimit of them.
+func lineDiff(a, b string) string {
+	as := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	bs := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	if len(as)*len(bs) > 4<<20 {
+		return fmt.Sprintf("(%d lines, too many to diff)", len(bs))
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of as[i:] and bs[j:]
+	lcs := make([][]int, len(as)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bs)+1)
+	}
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			switch {
+			case as[i] == bs[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(as) || j < len(bs) {
+		switch {
+		case i < len(as) && j < len(bs) && as[i] == bs[j]:
+			i, j = i+1, j+1
+		case i < len(as) && (j == len(bs) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+as[i])
+			i++
+		default:
+			lines = append(lines, "+"+bs[j])
+			j++
+		}
+	}
+	if len(lines) > diffLimit {
+		lines = append(lines[:diffLimit], fmt.Sprintf("... %d more lines", len(lines)-diffLimit))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_lineDiff(t *testing.T) {
+	before := "worker_processes 4;\nevents {}\nhttp {\n  gzip off;\n}\n"
+	after := "worker_processes 4;\nevents {}\nhttp {\n  gzip on;\n  server_tokens off;\n}\n"
+	require.Equal(t, "-  gzip off;\n+  gzip on;\n+  server_tokens off;", lineDiff(before, after))
+	require.Equal(t, "", lineDiff(before, before))
+}
+
+func Test_runner_diffTracked(t *testing.T) {
+	r := &runner{out: &quiet{}, secrets: []string{"hunter2"}}
+	diff := r.diffTracked([]byte("user=app\npassword=letmein\n"), []byte("user=app\npassword=hunter2\n"))
+	require.Equal(t, "-password=letmein\n+password="+mask, diff)
+}
+
+func Test_trackDirective(t *testing.T) {
+	sf, err := parse("nginx", "# track: /etc/nginx/nginx.conf\n# track: /etc/nginx/conf.d/app.conf\nnginx -s reload")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/etc/nginx/nginx.conf", "/etc/nginx/conf.d/app.conf"}, sf.scripts[0].track)
+
+	_, err = parse("bad", "# track: /etc/hosts\n@local make")
+	require.EqualError(t, err, "bad directive in script bad: track does not apply to local steps")
+}
+
+func Test_runner_previousTrack(t *testing.T) {
+	r := &runner{out: &quiet{}}
+	r.trackHistory.Do(func() {})
+	r.history = []historyEntry{
+		{ID: "run1", Results: []hostOutcome{{Host: "web1", File: "nginx", Tracked: []trackedFile{{Path: "/etc/nginx/nginx.conf", SHA256: "aaa"}}}}},
+		{ID: "run2", Results: []hostOutcome{{Host: "web1", File: "nginx", Tracked: []trackedFile{{Path: "/etc/nginx/nginx.conf", Error: "no such file"}}}}},
+		{ID: "run3", Results: []hostOutcome{{Host: "web2", File: "nginx", Tracked: []trackedFile{{Path: "/etc/nginx/nginx.conf", SHA256: "bbb"}}}}},
+	}
+
+	hash, since := r.previousTrack("web1", "nginx", "/etc/nginx/nginx.conf")
+	require.Equal(t, "aaa", hash)
+	require.Equal(t, "run1", since)
+
+	hash, _ = r.previousTrack("web3", "nginx", "/etc/nginx/nginx.conf")
+	require.Equal(t, "", hash)
+}
+
+func Test_newHistoryEntry_tracked(t *testing.T) {
+	r := &runner{id: "run4", results: []result{{
+		Host:    "web1",
+		File:    "nginx",
+		Tracked: []trackedFile{{Path: "/etc/nginx/nginx.conf", SHA256: "bbb", Previous: "aaa", Since: "run1", Diff: "-a\n+b"}},
+	}}}
+	e := newHistoryEntry(r, time.Now(), "scripts", []string{"nginx"}, []string{"web1"}, "completed")
+	require.Equal(t, []trackedFile{{Path: "/etc/nginx/nginx.conf", SHA256: "bbb", Previous: "aaa", Since: "run1"}}, e.Results[0].Tracked)
+	require.Equal(t, "-a\n+b", r.results[0].Tracked[0].Diff)
+}
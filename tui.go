@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// maxStatus is the maximum width of the status shown for a host.
+const maxStatus = 72
+
+// tui renders a live status board of every host, redrawn in place
+// as the run progresses.
+type tui struct {
+	lock     sync.Mutex
+	w        io.Writer
+	hosts    []string
+	status   map[string]string
+	failed   map[string]bool
+	messages []string
+	drawn    int
+}
+
+func newTUI(w io.Writer) *tui {
+	return &tui{
+		w:      w,
+		status: make(map[string]string),
+		failed: make(map[string]bool),
+	}
+}
+
+func (t *tui) plan(kind string, items []string, hosts []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.hosts = hosts
+	for _, host := range hosts {
+		t.status[host] = "pending"
+	}
+	t.messages = append(t.messages, fmt.Sprintf("executing %s %v", kind, items))
+	t.draw()
+}
+
+func (t *tui) message(format string, args ...interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.messages = append(t.messages, fmt.Sprintf(format, args...))
+	t.draw()
+}
+
+func (t *tui) warning(format string, args ...interface{}) {
+	t.message("warning: "+format, args...)
+}
+
+func (t *tui) begin(host, file string) {
+	t.update(host, "running "+file)
+}
+
+func (t *tui) command(host, command string) {
+	t.update(host, "running `"+command+"`")
+}
+
+func (t *tui) output(host, text string) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	t.update(host, "> "+strings.TrimSpace(lines[len(lines)-1]))
+}
+
+func (t *tui) result(res result) {
+	if res.ok() {
+		return
+	}
+
+	t.lock.Lock()
+	t.failed[res.Host] = true
+	t.lock.Unlock()
+
+	reason := res.Error
+	if reason == "" {
+		reason = strings.Join(res.Failed, "; ")
+	}
+	t.update(res.Host, "failed: "+reason)
+}
+
+func (t *tui) end(host, _ string) {
+	t.lock.Lock()
+	failed := t.failed[host]
+	t.lock.Unlock()
+
+	if !failed {
+		t.update(host, "ok")
+	}
+}
+
+func (t *tui) summary(rpt report) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.drawn = 0 // leave the final board in place
+
+	if len(rpt.Variants) > 0 {
+		printVariants(rpt.Variants)
+	}
+
+	if rpt.GroupBy != "" {
+		printGroups(rpt.GroupBy, rpt.Groups)
+	}
+}
+
+func (t *tui) update(host, status string) {
+	status = strings.Replace(strings.Replace(status, "\r", "", -1), "\n", " ", -1)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, exists := t.status[host]; !exists {
+		t.hosts = append(t.hosts, host)
+	}
+	t.status[host] = status
+	t.draw()
+}
+
+// draw redraws the board over the previously drawn one; t must be locked.
+func (t *tui) draw() {
+	if t.drawn > 0 {
+		_, _ = fmt.Fprintf(t.w, "\033[%dA", t.drawn)
+	}
+
+	lines := 0
+	line := func(c *color.Color, text string) {
+		_, _ = fmt.Fprint(t.w, "\033[2K")
+		_, _ = c.Fprintln(t.w, text)
+		lines++
+	}
+
+	for _, msg := range t.messages {
+		line(colors.info.color(), truncate(msg, tableWidth))
+	}
+
+	// lines must not wrap, or redrawing would not overwrite all of them
+	board := &table{nowrap: true}
+	for _, host := range t.hosts {
+		status := t.status[host]
+		if len(status) > maxStatus {
+			status = status[:maxStatus-3] + "..."
+		}
+
+		s := colors.notice
+		switch {
+		case t.failed[host]:
+			s = colors.failure
+		case status == "ok":
+			s = colors.success
+		case status == "pending":
+			s = colors.muted
+		}
+		board.add(s, host, status)
+	}
+	for i, row := range board.render(tableWidth) {
+		line(board.styles[i].color(), row[0])
+	}
+
+	t.drawn = lines
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// uploadDir is where scripts are uploaded to on hosts.
+const uploadDir = "/tmp"
+
+// shebang is the interpreter of steps run with that of their shebang line.
+const shebang = "#!"
+
+// configureUpload sets the interpreter of a step declared with
+// "# upload: ..." (true for that of its shebang line, or else sh).
+func (s *script) configureUpload(value string) error {
+	if s.local {
+		return errors.Errorf("upload does not apply to local steps")
+	}
+	if upload, err := strconv.ParseBool(value); err == nil {
+		s.runWith = ""
+		if upload {
+			s.runWith = shebang
+		}
+		return nil
+	}
+	if strings.TrimSpace(value) == "" {
+		return errors.Errorf("upload requires true, false or an interpreter")
+	}
+	s.runWith = strings.TrimSpace(value)
+	return nil
+}
+
+// packUpload makes s a step which is uploaded to hosts as a file, and run
+// with its interpreter, rather than sent as a command: its lines are kept
+// verbatim (but for directives), and none of them are fed on stdin.
+func (s *script) packUpload(raw []string) error {
+	var lines []string
+	for _, line := range raw {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "@heredoc ") || strings.HasPrefix(trimmed, "@stdin-file ") {
+			return errors.Errorf("heredocs and stdin files do not apply to uploaded steps")
+		}
+		if m := directiveRe.FindStringSubmatch(trimmed); (m != nil && knownDirectives[m[1]]) || bareDirectiveRe.MatchString(trimmed) {
+			continue
+		}
+		if len(lines) == 0 && trimmed == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return errors.Errorf("nothing to upload")
+	}
+
+	first := 0
+	if strings.HasPrefix(lines[0], shebang) {
+		if s.runWith == shebang {
+			s.runWith = strings.TrimSpace(lines[0][2:])
+		}
+		first = 1
+	}
+	if s.runWith == shebang {
+		s.runWith = "sh"
+	}
+	s.command = s.runWith
+	for _, line := range lines[first:] {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			s.command = s.runWith + ": " + line
+			break
+		}
+	}
+
+	s.upload = strings.Join(lines, "\n") + "\n"
+	s.stdin, s.payload = nil, ""
+	return nil
+}
+
+// uploadScript uploads the script of sc to a new file on host, over SFTP
+// or else with cat, returning the command running it with its interpreter
+// and the path of the file, which is to be removed once it has run.
+func (r *runner) uploadScript(client *ssh.Client, host string, sc script) (string, string, error) {
+	bs := make([]byte, 8)
+	_, _ = rand.Read(bs)
+	path := fmt.Sprintf("%s/commando-%s-%s", uploadDir, r.id, hex.EncodeToString(bs))
+
+	probe, done, err := r.probeClient(client, host)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to upload script")
+	}
+	err = sftpPut(probe, path, []byte(sc.upload), 0700)
+	done()
+	if err != nil {
+		// e.g. hosts without an sftp server; a write failing midway leaves
+		// a partial file, which cat would refuse to overwrite
+		cat := "rm -f " + quote(path) + " && umask 077 && set -C && cat > " + quote(path)
+		if _, catErr := r.probeRemoteInput(client, host, cat, sc.upload); catErr != nil {
+			return "", "", errors.Wrapf(err, "failed to upload script (and with cat: %v)", catErr)
+		}
+	}
+	return sc.runWith + " " + quote(path), path, nil
+}
+
+// removeUpload removes the uploaded file at path from the host of client.
+func (r *runner) removeUpload(client *ssh.Client, host, path string) {
+	if _, err := r.probeRemote(client, host, "rm -f "+quote(path)); err != nil {
+		r.out.warning("failed to remove %s from %s: %v", path, host, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_parse_upload(t *testing.T) {
+	sf, err := parse("upload", `#!/bin/bash
+# upload: true
+# timeout: 1m
+
+set -euo pipefail
+for f in /etc/app/*.conf; do
+    echo "$f"  # verbatim
+done
+
+---
+# upload: python3
+import os
+print(os.uname())
+---
+# upload: false
+uptime`)
+	require.NoError(t, err)
+	require.Len(t, sf.scripts, 3)
+
+	require.Equal(t, "/bin/bash", sf.scripts[0].runWith)
+	require.Equal(t, "/bin/bash: set -euo pipefail", sf.scripts[0].command)
+	require.Equal(t, "#!/bin/bash\n\nset -euo pipefail\nfor f in /etc/app/*.conf; do\n    echo \"$f\"  # verbatim\ndone\n", sf.scripts[0].upload)
+	require.Nil(t, sf.scripts[0].stdin)
+
+	require.Equal(t, "python3", sf.scripts[1].runWith)
+	require.Equal(t, "python3: import os", sf.scripts[1].command)
+	require.Equal(t, "import os\nprint(os.uname())\n", sf.scripts[1].upload)
+
+	require.Equal(t, "uptime", sf.scripts[2].command)
+	require.Empty(t, sf.scripts[2].upload)
+}
+
+func Test_parse_upload_errors(t *testing.T) {
+	_, err := parse("heredoc", "# upload: true\ntee /etc/motd\n<<EOF\nhello\nEOF\n")
+	require.Error(t, err)
+
+	_, err = parse("local", "@local make\n# upload: true\n")
+	require.Error(t, err)
+
+	_, err = parse("empty", "uptime\n# upload:\n")
+	require.Error(t, err)
+}
+
+func Test_sftpSession_roundTrip(t *testing.T) {
+	reply := func(packet interface{}) []byte {
+		bs := ssh.Marshal(packet)
+		frame := make([]byte, 4)
+		binary.BigEndian.PutUint32(frame, uint32(len(bs)))
+		return append(frame, bs...)
+	}
+
+	var sent bytes.Buffer
+	s := &sftpSession{in: &sent, out: bytes.NewReader(bytes.Join([][]byte{
+		reply(sftpHandlePacket{ID: 1, Handle: "h1"}),
+		reply(sftpStatusPacket{ID: 2, Code: sftpOK}),
+		reply(sftpStatusPacket{ID: 3, Code: 4, Message: "Failure"}),
+	}, nil))}
+
+	var handle sftpHandlePacket
+	require.NoError(t, s.roundTrip(sftpOpenPacket{ID: s.next(), Path: "/tmp/x"}, &handle))
+	require.Equal(t, "h1", handle.Handle)
+	require.NoError(t, s.roundTrip(sftpWritePacket{ID: s.next(), Handle: "h1", Data: []byte("echo hi\n")}, nil))
+	require.EqualError(t, s.roundTrip(sftpClosePacket{ID: s.next(), Handle: "h1"}, nil), "sftp status 4: Failure")
+
+	var open sftpOpenPacket
+	n := binary.BigEndian.Uint32(sent.Bytes())
+	require.NoError(t, ssh.Unmarshal(sent.Bytes()[4:4+n], &open))
+	require.Equal(t, "/tmp/x", open.Path)
+}
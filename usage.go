@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// usageMarker separates the output of a command from its resource usage.
+const usageMarker = "__COMMANDO_USAGE__"
+
+// usage is the resource usage of a script, as reported by GNU time.
+type usage struct {
+	MaxRSSKB      int64   `json:"max_rss_kb"`
+	UserSeconds   float64 `json:"user_seconds"`
+	SystemSeconds float64 `json:"system_seconds"`
+	WallSeconds   float64 `json:"wall_seconds"`
+}
+
+func (u usage) String() string {
+	return fmt.Sprintf("max rss %.1f MB, cpu %.2fs, wall %.2fs",
+		float64(u.MaxRSSKB)/1024, u.UserSeconds+u.SystemSeconds, u.WallSeconds)
+}
+
+// withUsage wraps command to be run by /usr/bin/time -v (if installed),
+// whose report is printed after the output of command, following the
+// usage marker. The exit code of command is preserved.
+func withUsage(command string) string {
+	return fmt.Sprintf(`if [ -x /usr/bin/time ]; then `+
+		`t=$(mktemp); /usr/bin/time -v -o "$t" sh -c %s; rc=$?; `+
+		`echo %s; cat "$t"; rm -f "$t"; exit $rc; `+
+		`else sh -c %s; fi`,
+		quote(command), usageMarker, quote(command))
+}
+
+// splitUsage separates output of a command wrapped by withUsage into the
+// output of the command itself, and its resource usage (nil if unknown).
+func splitUsage(output string) (string, *usage) {
+	idx := strings.LastIndex(output, usageMarker)
+	if idx < 0 {
+		return output, nil
+	}
+
+	u := &usage{}
+	for _, line := range strings.Split(output[idx+len(usageMarker):], "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch key, value := parts[0], parts[1]; {
+		case key == "Maximum resident set size (kbytes)":
+			u.MaxRSSKB, _ = strconv.ParseInt(value, 10, 64)
+		case key == "User time (seconds)":
+			u.UserSeconds, _ = strconv.ParseFloat(value, 64)
+		case key == "System time (seconds)":
+			u.SystemSeconds, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(key, "Elapsed (wall clock) time"):
+			u.WallSeconds = clock(value)
+		}
+	}
+	return strings.TrimSpace(output[:idx]), u
+}
+
+// clock parses a duration formatted as [h:]m:ss.ss into seconds.
+func clock(value string) float64 {
+	var seconds float64
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
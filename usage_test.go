@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const timeReport = `	Command being timed: "sh -c apt-get -y upgrade"
+	User time (seconds): 12.50
+	System time (seconds): 3.25
+	Percent of CPU this job got: 40%
+	Elapsed (wall clock) time (h:mm:ss or m:ss): 1:02:03.50
+	Maximum resident set size (kbytes): 204800
+	Exit status: 0`
+
+func Test_splitUsage(t *testing.T) {
+	output, u := splitUsage("upgraded 3 packages\n" + usageMarker + "\n" + timeReport)
+	require.Equal(t, "upgraded 3 packages", output)
+	require.Equal(t, &usage{
+		MaxRSSKB:      204800,
+		UserSeconds:   12.5,
+		SystemSeconds: 3.25,
+		WallSeconds:   3723.5,
+	}, u)
+	require.Equal(t, "max rss 200.0 MB, cpu 15.75s, wall 3723.50s", u.String())
+
+	output, u = splitUsage("no time installed")
+	require.Equal(t, "no time installed", output)
+	require.Nil(t, u)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholderRe matches placeholders of per-host variables, e.g. {{.host}}.
+var placeholderRe = regexp.MustCompile(`{{\s*\.([[:word:]-]+(?:\.[[:word:]-]+)*)\s*}}`)
+
+// vars returns the variables of host which may be used as placeholders in
+// commands and their stdin: its inventory metadata, overridden by script
+// params (and --var), outputs registered by local steps, and outputs
+// registered by earlier steps of the script file running on host, along with
+//
+//	host            the host, e.g. web3.ams1.example.com
+//	hostname_short  the host up to its first dot, e.g. web3
+//	index           the position of the host in the run, from 0
+func (r *runner) vars(host string) map[string]string {
+	vars := make(map[string]string)
+	for key, value := range r.inventory.metadata(host) {
+		vars[key] = value
+	}
+	for key, value := range r.globalVars() {
+		vars[key] = value
+	}
+	r.lock.Lock()
+	for key, value := range r.hostVars[host] {
+		vars[key] = value
+	}
+	r.lock.Unlock()
+
+	vars["host"] = host
+	vars["hostname_short"] = strings.SplitN(host, ".", 2)[0]
+	if index, exists := r.index[host]; exists {
+		vars["index"] = strconv.Itoa(index)
+	}
+	return vars
+}
+
+// globalVars returns the variables which are the same on every host of a
+// batch: script params, the outputs registered by local steps, and
+// Batch.Number and Batch.Hosts, the hosts of the current batch.
+func (r *runner) globalVars() map[string]string {
+	vars := r.batchVars()
+	if vars == nil {
+		vars = make(map[string]string, len(r.params))
+	}
+	for key, value := range r.params {
+		vars[key] = value
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, value := range r.registered {
+		vars[key] = value
+	}
+	return vars
+}
+
+// registerVar stores the output of a step run on host as the variable
+// name, for the steps which follow it in the same script file.
+func (r *runner) registerVar(host, name, output string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.hostVars == nil {
+		r.hostVars = make(map[string]map[string]string)
+	}
+	if r.hostVars[host] == nil {
+		r.hostVars[host] = make(map[string]string)
+	}
+	// output of a pty ends its lines with \r\n
+	r.hostVars[host][name] = strings.Replace(output, "\r\n", "\n", -1)
+}
+
+// forgetVars drops the variables registered on host, once the script file
+// which registered them completes.
+func (r *runner) forgetVars(host string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.hostVars, host)
+}
+
+// expandVars replaces the placeholders of s with the values of vars.
+// Placeholders of unknown variables are left as they are, so that
+// commands with templates of their own (e.g. docker --format) still work.
+func expandVars(s string, vars map[string]string) string {
+	return placeholderRe.ReplaceAllStringFunc(s, func(placeholder string) string {
+		key := placeholderRe.FindStringSubmatch(placeholder)[1]
+		if value, exists := vars[key]; exists {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// withVars returns sc with the variables of host expanded in its
+// command, stdin, uploaded script, guards and working directory.
+func (r *runner) withVars(host string, sc script) script {
+	vars := r.vars(host)
+	sc.command = expandVars(sc.command, vars)
+	sc.upload = expandVars(sc.upload, vars)
+
+	stdin := make([]string, 0, len(sc.stdin))
+	for _, line := range sc.stdin {
+		stdin = append(stdin, expandVars(line, vars))
+	}
+	sc.stdin = stdin
+
+	guards := make([]guard, 0, len(sc.guards))
+	for _, g := range sc.guards {
+		guards = append(guards, guard{directive: g.directive, value: expandVars(g.value, vars)})
+	}
+	sc.guards = guards
+	sc.cwd = expandVars(sc.cwd, vars)
+	return sc
+}
+
+// indexes returns the position of each host.
+func indexes(hosts []string) map[string]int {
+	index := make(map[string]int, len(hosts))
+	for i, host := range hosts {
+		if _, exists := index[host]; !exists {
+			index[host] = i
+		}
+	}
+	return index
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runner_withVars(t *testing.T) {
+	inv, err := parseInventory("web{1..2}.ams1.example.com dc=ams1 node-id=7\n")
+	require.NoError(t, err)
+
+	r := &runner{inventory: inv, index: indexes(inv.hosts())}
+	sc := r.withVars("web2.ams1.example.com", script{
+		command: "echo {{.hostname_short}} {{ .index }} {{.dc}} {{.node-id}} > /etc/node",
+		stdin:   []string{"{{.host}}", "PASSWORD"},
+		guards:  []guard{{directive: "creates", value: "/etc/node-{{.node-id}}"}},
+	})
+	require.Equal(t, "echo web2 1 ams1 7 > /etc/node", sc.command)
+	require.Equal(t, []string{"web2.ams1.example.com", "PASSWORD"}, sc.stdin)
+	require.Equal(t, "/etc/node-7", sc.guards[0].value)
+}
+
+func Test_expandVars_unknown(t *testing.T) {
+	command := `docker ps --format '{{.Names}}' | grep {{.host}}`
+	require.Equal(t, `docker ps --format '{{.Names}}' | grep web1`, expandVars(command, map[string]string{"host": "web1"}))
+}
+
+func Test_runner_registerVar(t *testing.T) {
+	r := &runner{params: map[string]string{"version": "1.0"}}
+	r.registerVar("web1", "version", "1.4.2\r\nbuilt today")
+
+	require.Equal(t, "1.4.2\nbuilt today", r.vars("web1")["version"])
+	require.Equal(t, "1.0", r.vars("web2")["version"])
+
+	r.forgetVars("web1")
+	require.Equal(t, "1.0", r.vars("web1")["version"])
+}
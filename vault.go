@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errNoSecret is returned when there is no secret at a vault path.
+var errNoSecret = errors.New("no secret at path")
+
+// A vault fetches credentials from HashiCorp Vault, so that they need
+// not be typed in or stored on the operator's machine.
+//
+// The address of vault is read from $VAULT_ADDR, and vault is authenticated
+// against using $VAULT_TOKEN (or ~/.vault-token), or approle authentication
+// using $VAULT_ROLE_ID and $VAULT_SECRET_ID.
+//
+// The secret at path may contain a "password" and/or a "private_key", and
+// a secret at path/<host> takes precedence for that host.
+type vault struct {
+	address string
+	path    string
+	client  *http.Client
+
+	lock  sync.Mutex
+	token string
+	cache map[string]map[string]string
+}
+
+func newVault(path string) (*vault, error) {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		return nil, errors.Errorf("$VAULT_ADDR must be set to use vault")
+	}
+
+	return &vault{
+		address: strings.TrimSuffix(address, "/"),
+		path:    strings.Trim(path, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+		cache:   make(map[string]map[string]string),
+	}, nil
+}
+
+func (v *vault) String() string {
+	return "vault"
+}
+
+// secret returns the credentials for host, which are those at path/<host>
+// if that secret exists, otherwise those at path.
+func (v *vault) secret(host string) (map[string]string, error) {
+	secret, err := v.read(v.path + "/" + host)
+	if err == errNoSecret {
+		secret, err = v.read(v.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (v *vault) read(path string) (map[string]string, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if secret, exists := v.cache[path]; exists {
+		if secret == nil {
+			return nil, errNoSecret
+		}
+		return secret, nil
+	}
+
+	secret, err := v.get(path)
+	if err == errNoSecret && !strings.Contains(path, "/data/") {
+		// kv version 2 secret engines nest secrets under <mount>/data/
+		if idx := strings.Index(path, "/"); idx > 0 {
+			secret, err = v.get(path[:idx] + "/data" + path[idx:])
+		}
+	}
+
+	switch err {
+	case nil, errNoSecret:
+		v.cache[path] = secret
+	}
+	return secret, err
+}
+
+// get the secret at path; v must be locked.
+func (v *vault) get(path string) (map[string]string, error) {
+	if err := v.login(); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodGet, v.address+"/v1/"+path, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create vault request")
+	}
+	request.Header.Set("X-Vault-Token", v.token)
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.do(request, &response); err != nil {
+		return nil, err
+	}
+
+	data := response.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			// kv version 2 secret
+			data = nested
+		}
+	}
+
+	secret := make(map[string]string, len(data))
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			secret[key] = s
+		}
+	}
+	return secret, nil
+}
+
+// login acquires a vault token; v must be locked.
+func (v *vault) login() error {
+	if v.token != "" {
+		return nil
+	}
+
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		v.token = token
+		return nil
+	}
+
+	if roleID := os.Getenv("VAULT_ROLE_ID"); roleID != "" {
+		body, _ := json.Marshal(map[string]string{
+			"role_id":   roleID,
+			"secret_id": os.Getenv("VAULT_SECRET_ID"),
+		})
+		request, err := http.NewRequest(http.MethodPost, v.address+"/v1/auth/approle/login", bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, "failed to create vault login request")
+		}
+
+		var response struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		if err := v.do(request, &response); err != nil {
+			return errors.Wrap(err, "failed to login to vault with approle")
+		}
+		v.token = response.Auth.ClientToken
+		return nil
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		if bs, err := ioutil.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+			v.token = strings.TrimSpace(string(bs))
+			return nil
+		}
+	}
+
+	return errors.Errorf("no vault token, set $VAULT_TOKEN or $VAULT_ROLE_ID and $VAULT_SECRET_ID")
+}
+
+func (v *vault) do(request *http.Request, into interface{}) error {
+	response, err := v.client.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "failed to reach vault")
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return errNoSecret
+	case response.StatusCode >= 300:
+		bs, _ := ioutil.ReadAll(response.Body)
+		return errors.Errorf("vault returned %s: %s", response.Status, strings.TrimSpace(string(bs)))
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(into); err != nil {
+		return errors.Wrap(err, "failed to decode vault response")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_vault_secret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "t0ken", r.Header.Get("X-Vault-Token"))
+		switch r.URL.Path {
+		case "/v1/secret/data/ssh/prod":
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "hunter2"}, "metadata": {}}}`))
+		case "/v1/secret/data/ssh/prod/db1":
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "db-hunter2"}, "metadata": {}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("VAULT_ADDR", server.URL)
+	os.Setenv("VAULT_TOKEN", "t0ken")
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	v, err := newVault("secret/ssh/prod")
+	require.NoError(t, err)
+
+	secret, err := v.secret("web1")
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", secret["password"])
+
+	secret, err = v.secret("db1")
+	require.NoError(t, err)
+	require.Equal(t, "db-hunter2", secret["password"])
+}
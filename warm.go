@@ -0,0 +1,88 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// A flusher caches secrets, which flush forgets so that they are renewed.
+type flusher interface {
+	flush()
+}
+
+// flush forgets the cached secrets and token of vault, so that they
+// are read (and logged in for) again.
+func (v *vault) flush() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.cache = make(map[string]map[string]string)
+	v.token = ""
+}
+
+// expiry returns when the certificate of signer expires, if it has one.
+func expiry(signer ssh.Signer) (time.Time, bool) {
+	cert, ok := signer.PublicKey().(*ssh.Certificate)
+	if !ok || cert.ValidBefore == ssh.CertTimeInfinity {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(cert.ValidBefore), 0), true
+}
+
+// refresh renews the credentials of the pool every interval, and dials
+// every host with them again in the background, so that the first command
+// of an incident does not stall on cold or expired credentials.
+func (p *pool) refresh(args args, hosts []string, interval time.Duration) {
+	for range time.Tick(interval) {
+		p.renew(args, interval)
+		for _, host := range hosts {
+			p.redial(host, interval)
+		}
+	}
+}
+
+// renew reloads the key and certificate (which may have been renewed on
+// disk, e.g. signed again by vault), and flushes cached secrets.
+func (p *pool) renew(args args, interval time.Duration) {
+	if f, ok := p.r.source.(flusher); ok {
+		f.flush()
+	}
+
+	if args.key == "" {
+		return
+	}
+
+	signer, err := loadSigner(args.key, args.cert)
+	if err != nil {
+		colors.failure.println("failed to reload key: %v", err)
+		return
+	}
+
+	if expires, ok := expiry(signer); ok && time.Until(expires) < interval {
+		colors.failure.println("certificate %s expires at %s, before the next refresh", args.cert, expires.Format(time.RFC3339))
+	}
+
+	p.r.lock.Lock()
+	p.r.signers = []ssh.Signer{signer}
+	p.r.lock.Unlock()
+}
+
+// redial host with the current credentials, replacing its pooled connection
+// only if that succeeds. The replaced connection is closed after a grace
+// period, so that commands in flight on it can complete.
+func (p *pool) redial(host string, grace time.Duration) {
+	client, err := p.r.dial(host)
+	if err != nil {
+		colors.failure.println("%s: failed to refresh connection: %v", host, err)
+		return
+	}
+
+	p.lock.Lock()
+	old := p.clients[host]
+	p.clients[host] = client
+	p.lock.Unlock()
+
+	if old != nil {
+		time.AfterFunc(grace, func() { _ = old.Close() })
+	}
+}
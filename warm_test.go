@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_expiry(t *testing.T) {
+	_, ca := newTestKey(t)
+	_, signer := newTestKey(t)
+
+	_, ok := expiry(signer)
+	require.False(t, ok)
+
+	validBefore := time.Date(2019, 10, 14, 12, 0, 0, 0, time.UTC)
+	cert := &ssh.Certificate{
+		Key:         signer.PublicKey(),
+		CertType:    ssh.UserCert,
+		ValidBefore: uint64(validBefore.Unix()),
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	require.NoError(t, err)
+
+	expires, ok := expiry(certSigner)
+	require.True(t, ok)
+	require.True(t, validBefore.Equal(expires))
+}
+
+func Test_vault_flush(t *testing.T) {
+	v := &vault{token: "t", cache: map[string]map[string]string{"secret/ssh": {"password": "p"}}}
+	v.flush()
+	require.Empty(t, v.cache)
+	require.Empty(t, v.token)
+}
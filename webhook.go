@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// webhook events which may be subscribed to with --webhook
+const (
+	eventRunStarted    = "run-started"
+	eventBatchStarted  = "batch-started"
+	eventHostFailed    = "host-failed"
+	eventScriptChanged = "script-changed"
+	eventRunCompleted  = "run-completed"
+)
+
+var webhookEvents = []string{eventRunStarted, eventBatchStarted, eventHostFailed, eventScriptChanged, eventRunCompleted}
+
+// An event is the payload posted to the webhooks subscribed to it, either
+// as JSON or rendered with the template configured for the event.
+type event struct {
+	Event  string   `json:"event"`
+	RunID  string   `json:"run_id"`
+	Hosts  []string `json:"hosts,omitempty"`
+	Batch  *batch   `json:"batch,omitempty"`
+	Result *result  `json:"result,omitempty"`
+	Report *report  `json:"report,omitempty"`
+}
+
+// A hook is a webhook, or a notifier plugin, subscribed to an event.
+type hook struct {
+	url      string
+	template *template.Template
+	plugin   *plugin
+}
+
+// splitEvent parses a value of --webhook or --webhook-template, which
+// is of the form EVENT=VALUE.
+func splitEvent(kv string) (string, string, error) {
+	parts := strings.SplitN(kv, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", errors.Errorf("malformed %q, must be EVENT=VALUE", kv)
+	}
+	for _, name := range webhookEvents {
+		if parts[0] == name {
+			return parts[0], parts[1], nil
+		}
+	}
+	return "", "", errors.Errorf("unknown event %q, must be one of %s", parts[0], strings.Join(webhookEvents, ", "))
+}
+
+// loadHooks parses the --webhook, --webhook-template and --notify flags.
+func loadHooks(urls, templates, notifiers []string) (map[string][]hook, error) {
+	tmpls := make(map[string]*template.Template)
+	for _, kv := range templates {
+		name, path, err := splitEvent(kv)
+		if err != nil {
+			return nil, err
+		}
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read template of %s", name)
+		}
+		if tmpls[name], err = template.New(name).Parse(string(bs)); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse template of %s", name)
+		}
+	}
+
+	hooks := make(map[string][]hook)
+	for _, kv := range urls {
+		name, url, err := splitEvent(kv)
+		if err != nil {
+			return nil, err
+		}
+		hooks[name] = append(hooks[name], hook{url: url, template: tmpls[name]})
+	}
+
+	for _, kv := range notifiers {
+		name, plugin, err := splitEvent(kv)
+		if err != nil {
+			return nil, err
+		}
+		p, err := findPlugin(plugin)
+		if err != nil {
+			return nil, err
+		}
+		hooks[name] = append(hooks[name], hook{plugin: p})
+	}
+	return hooks, nil
+}
+
+// webhooks wraps another renderer, posting events of the run to the
+// webhooks subscribed to them as they happen. Requests are made in the
+// background, and are waited for before the summary is rendered.
+type webhooks struct {
+	lock   sync.Mutex
+	inner  renderer
+	id     string
+	hooks  map[string][]hook
+	client *http.Client
+	failed map[string]bool
+	wg     sync.WaitGroup
+}
+
+func newWebhooks(inner renderer, id string, hooks map[string][]hook) *webhooks {
+	return &webhooks{
+		inner:  inner,
+		id:     id,
+		hooks:  hooks,
+		client: &http.Client{Timeout: 10 * time.Second},
+		failed: make(map[string]bool),
+	}
+}
+
+func (w *webhooks) plan(kind string, items []string, hosts []string) {
+	w.inner.plan(kind, items, hosts)
+	w.post(event{Event: eventRunStarted, Hosts: hosts})
+}
+
+// batch posts the start of a batch of hosts, e.g. so that exactly its
+// hosts can be silenced in monitoring.
+func (w *webhooks) batch(b batch) {
+	w.post(event{Event: eventBatchStarted, Batch: &b})
+}
+
+func (w *webhooks) message(format string, args ...interface{}) { w.inner.message(format, args...) }
+func (w *webhooks) warning(format string, args ...interface{}) { w.inner.warning(format, args...) }
+func (w *webhooks) begin(host, file string)                    { w.inner.begin(host, file) }
+func (w *webhooks) command(host, command string)               { w.inner.command(host, command) }
+func (w *webhooks) output(host, text string)                   { w.inner.output(host, text) }
+func (w *webhooks) end(host, file string)                      { w.inner.end(host, file) }
+
+func (w *webhooks) result(res result) {
+	w.inner.result(res)
+
+	if !res.ok() {
+		// only the first failure of each host is posted
+		w.lock.Lock()
+		first := !w.failed[res.Host]
+		w.failed[res.Host] = true
+		w.lock.Unlock()
+		if first {
+			w.post(event{Event: eventHostFailed, Result: &res})
+		}
+	}
+
+	if changed(res.Output) {
+		w.post(event{Event: eventScriptChanged, Result: &res})
+	}
+}
+
+func (w *webhooks) summary(rpt report) {
+	w.post(event{Event: eventRunCompleted, Report: &rpt})
+	w.wg.Wait()
+	w.inner.summary(rpt)
+}
+
+// changed returns whether a script reported making a change, by
+// printing a changed=true (or "changed": true) field.
+func changed(output string) bool {
+	switch strings.ToLower(fields(output)["changed"]) {
+	case "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+func (w *webhooks) post(e event) {
+	e.RunID = w.id
+	for _, h := range w.hooks[e.Event] {
+		w.wg.Add(1)
+		go func(h hook) {
+			defer w.wg.Done()
+			if err := w.send(h, e); err != nil {
+				w.inner.warning("webhook for %s failed: %v", e.Event, err)
+			}
+		}(h)
+	}
+}
+
+func (w *webhooks) send(h hook, e event) error {
+	if h.plugin != nil {
+		return h.plugin.notify(e)
+	}
+
+	var body bytes.Buffer
+	if h.template != nil {
+		if err := h.template.Execute(&body, e); err != nil {
+			return errors.Wrap(err, "failed to render template")
+		}
+	} else if err := json.NewEncoder(&body).Encode(e); err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+
+	response, err := w.client.Post(h.url, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode >= 300 {
+		return errors.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
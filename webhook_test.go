@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_webhooks(t *testing.T) {
+	var lock sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		bodies = append(bodies, string(bs))
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "webhook")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	tmpl := filepath.Join(dir, "failed.tmpl")
+	require.NoError(t, ioutil.WriteFile(tmpl, []byte(`{{.RunID}} {{.Result.Host}}: {{.Result.Error}}`), 0600))
+
+	hooks, err := loadHooks(
+		[]string{"host-failed=" + server.URL, "run-completed=" + server.URL},
+		[]string{"host-failed=" + tmpl},
+		nil,
+	)
+	require.NoError(t, err)
+
+	w := newWebhooks(&quiet{}, "run1", hooks)
+	w.plan("command", []string{"uptime"}, []string{"h1", "h2"})
+	w.result(result{Host: "h1", Error: "exit 1"})
+	w.result(result{Host: "h1", Error: "exit 2"})
+	w.result(result{Host: "h2"})
+	w.summary(report{ID: "run1", Status: "completed"})
+
+	require.Len(t, bodies, 2)
+	require.Contains(t, bodies, "run1 h1: exit 1")
+
+	var completed event
+	for _, body := range bodies {
+		if body != "run1 h1: exit 1" {
+			require.NoError(t, json.Unmarshal([]byte(body), &completed))
+		}
+	}
+	require.Equal(t, "run-completed", completed.Event)
+	require.Equal(t, "completed", completed.Report.Status)
+}
+
+func Test_splitEvent(t *testing.T) {
+	_, _, err := splitEvent("host-failed")
+	require.Error(t, err)
+
+	_, _, err = splitEvent("host-exploded=http://example.com")
+	require.Error(t, err)
+
+	name, url, err := splitEvent("run-started=http://example.com/?a=b")
+	require.NoError(t, err)
+	require.Equal(t, "run-started", name)
+	require.Equal(t, "http://example.com/?a=b", url)
+}
+
+func Test_changed(t *testing.T) {
+	require.True(t, changed("changed=true"))
+	require.True(t, changed(`{"changed": true}`))
+	require.False(t, changed("changed=false"))
+	require.False(t, changed("nothing to do"))
+}
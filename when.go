@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+var conditionRe = regexp.MustCompile(`^(.+?)\s*(==|!=|<=|>=|<|>)\s*(.+)$`)
+
+// A condition is when a step applies to a host, declared in a script file
+// like `# when: {{.facts.distro}} == "centos"`. Its sides are compared
+// with the variables of the host substituted, including its facts (see
+// facts) as facts.NAME, as numbers if both are, or else as strings.
+type condition struct {
+	left     string
+	operator string
+	right    string
+}
+
+func (c condition) String() string {
+	return c.left + " " + c.operator + " " + c.right
+}
+
+func parseCondition(s string) (condition, error) {
+	matches := conditionRe.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return condition{}, errors.Errorf("malformed condition %q, expected e.g. {{.facts.os}} == linux", s)
+	}
+	return condition{left: matches[1], operator: matches[2], right: strings.TrimSpace(matches[3])}, nil
+}
+
+// holds evaluates c with vars, returning the value of its left side.
+func (c condition) holds(vars map[string]string) (string, bool) {
+	left := strings.Trim(expandVars(c.left, vars), `"`)
+	right := strings.Trim(expandVars(c.right, vars), `"`)
+
+	x, xErr := number(left)
+	y, yErr := number(right)
+	if xErr == nil && yErr == nil {
+		return left, compare(c.operator, x, y)
+	}
+
+	switch c.operator {
+	case "==":
+		return left, left == right
+	case "!=":
+		return left, left != right
+	}
+	// ordering of non-numeric values is meaningless
+	return left, false
+}
+
+// usesFacts returns whether any of conditions refers to facts of the host.
+func usesFacts(conditions []condition) bool {
+	for _, c := range conditions {
+		for _, name := range placeholders([]string{c.left, c.right}) {
+			if strings.HasPrefix(name, "facts.") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unmet returns why sc does not apply to host, i.e. which of its
+// conditions does not hold, or "" if they all do.
+func (r *runner) unmet(client *ssh.Client, host string, sc script) (string, error) {
+	if len(sc.when) == 0 {
+		return "", nil
+	}
+
+	vars := r.vars(host)
+	if usesFacts(sc.when) {
+		facts, err := r.facts(client, host)
+		if err != nil {
+			return "", err
+		}
+		for key, value := range facts {
+			vars["facts."+key] = value
+		}
+	}
+
+	for _, c := range sc.when {
+		if got, holds := c.holds(vars); !holds {
+			return fmt.Sprintf("condition %s does not hold (got %s)", c, got), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseCondition(t *testing.T) {
+	c, err := parseCondition(`{{.facts.distro}} == "centos"`)
+	require.NoError(t, err)
+	require.Equal(t, condition{left: "{{.facts.distro}}", operator: "==", right: `"centos"`}, c)
+
+	c, err = parseCondition("{{.facts.release}}<=5.4")
+	require.NoError(t, err)
+	require.Equal(t, "<=", c.operator)
+
+	_, err = parseCondition("{{.facts.os}}")
+	require.Error(t, err)
+}
+
+func Test_condition_holds(t *testing.T) {
+	vars := map[string]string{"facts.distro": "debian", "cores": "16"}
+	tests := []struct {
+		condition string
+		holds     bool
+	}{
+		{`{{.facts.distro}} == "debian"`, true},
+		{`{{.facts.distro}} != debian`, false},
+		{`{{.cores}} >= 8`, true},
+		{`{{.cores}} < 8`, false},
+		{`{{.facts.distro}} > centos`, false},
+		{`{{.role}} == db`, false},
+	}
+	for _, test := range tests {
+		c, err := parseCondition(test.condition)
+		require.NoError(t, err)
+		_, holds := c.holds(vars)
+		require.Equal(t, test.holds, holds, test.condition)
+	}
+}
+
+func Test_runner_unmet(t *testing.T) {
+	sf, err := parse("1-ntp", "# when: {{.facts.distro}} == centos\n# when: {{.dc}} != lab\nyum install -y chrony\n---\n# when: {{.dc}} != lab\napt-get install -y chrony")
+	require.NoError(t, err)
+
+	inv, err := parseInventory("web1 dc=ams1\nweb2 dc=lab\n")
+	require.NoError(t, err)
+	r := &runner{inventory: inv, hostFacts: map[string]map[string]string{
+		"web1": {"os": "linux", "distro": "centos"},
+		"web2": {"os": "linux", "distro": "centos"},
+	}}
+
+	reason, err := r.unmet(nil, "web1", sf.scripts[0])
+	require.NoError(t, err)
+	require.Empty(t, reason)
+
+	reason, err = r.unmet(nil, "web2", sf.scripts[0])
+	require.NoError(t, err)
+	require.Equal(t, "condition {{.dc}} != lab does not hold (got lab)", reason)
+
+	_, err = parse("bad", "# when: {{.dc}} == lab\n@local true")
+	require.Error(t, err)
+}
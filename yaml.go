@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A yamlNode is a JSON value, whose objects keep the order of their keys.
+type yamlNode struct {
+	scalar string // JSON of a string, number, bool or null
+	keys   []string
+	values []yamlNode
+	object bool
+	array  bool
+}
+
+var plainKeyRe = regexp.MustCompile(`^[[:word:].-]+$`)
+
+// writeYAML writes v as a YAML document, in block style, of the same
+// content as v marshalled to JSON. Strings are written as JSON strings,
+// which YAML reads as double-quoted scalars.
+func writeYAML(w io.Writer, v interface{}) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.UseNumber()
+	n, err := decodeYAMLNode(decoder)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if n.object || n.array {
+		n.write(&b, "")
+	} else {
+		b.WriteString(n.scalar + "\n")
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+func decodeYAMLNode(decoder *json.Decoder) (yamlNode, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return yamlNode{}, err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		n := yamlNode{object: t == '{', array: t == '['}
+		for decoder.More() {
+			if n.object {
+				key, err := decoder.Token()
+				if err != nil {
+					return n, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+			value, err := decodeYAMLNode(decoder)
+			if err != nil {
+				return n, err
+			}
+			n.values = append(n.values, value)
+		}
+		_, err := decoder.Token() // the closing delimiter
+		return n, err
+	case string:
+		bs, err := json.Marshal(t)
+		return yamlNode{scalar: string(bs)}, err
+	case json.Number:
+		return yamlNode{scalar: t.String()}, nil
+	case bool:
+		if t {
+			return yamlNode{scalar: "true"}, nil
+		}
+		return yamlNode{scalar: "false"}, nil
+	case nil:
+		return yamlNode{scalar: "null"}, nil
+	}
+	return yamlNode{}, errors.Errorf("unexpected JSON token %v", token)
+}
+
+// inline returns n as written on the line of its key or dash, if it is
+// a scalar or empty.
+func (n yamlNode) inline() (string, bool) {
+	switch {
+	case n.object && len(n.values) == 0:
+		return "{}", true
+	case n.array && len(n.values) == 0:
+		return "[]", true
+	case !n.object && !n.array:
+		return n.scalar, true
+	}
+	return "", false
+}
+
+// write writes the entries of object or array n, each line indented.
+func (n yamlNode) write(b *bytes.Buffer, indent string) {
+	for i, value := range n.values {
+		prefix := "- "
+		if n.object {
+			key := n.keys[i]
+			if !plainKeyRe.MatchString(key) {
+				bs, _ := json.Marshal(key)
+				key = string(bs)
+			}
+			prefix = key + ":"
+		}
+
+		if s, ok := value.inline(); ok {
+			b.WriteString(indent + strings.TrimSuffix(prefix, " ") + " " + s + "\n")
+			continue
+		}
+		if n.object {
+			b.WriteString(indent + prefix + "\n")
+			value.write(b, indent+"  ")
+			continue
+		}
+
+		// the first entry of an item of an array goes on the line of its dash
+		var item bytes.Buffer
+		value.write(&item, indent+"  ")
+		b.WriteString(indent + "- " + strings.TrimPrefix(item.String(), indent+"  "))
+	}
+}
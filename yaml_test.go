@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeYAML(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, writeYAML(&b, struct {
+		Name    string              `json:"name"`
+		Count   int                 `json:"count"`
+		Empty   []string            `json:"empty"`
+		Batches [][]string          `json:"batches"`
+		Steps   []map[string]string `json:"steps"`
+		Labels  map[string]string   `json:"labels"`
+	}{
+		Name:    "say \"hi\"\nbye",
+		Count:   2,
+		Empty:   []string{},
+		Batches: [][]string{{"web1", "web2"}, {"web3"}},
+		Steps:   []map[string]string{{"file": "01-check", "command": "uptime"}},
+		Labels:  map[string]string{"env": "prod", "two words": "x"},
+	}))
+
+	require.Equal(t, `name: "say \"hi\"\nbye"
+count: 2
+empty: []
+batches:
+  - - "web1"
+    - "web2"
+  - - "web3"
+steps:
+  - command: "uptime"
+    file: "01-check"
+labels:
+  env: "prod"
+  "two words": "x"
+`, b.String())
+}